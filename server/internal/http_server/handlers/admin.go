@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/nguyen/allycat/internal/places"
+)
+
+// AdminHandler serves the endpoints for whoever runs the server, rather than
+// the riders.
+type AdminHandler struct {
+	searchCache *places.TextSearchCache
+	legCache    *places.LegCache
+	meter       *places.Meter
+}
+
+// AdminHandlerOption customises an AdminHandler.
+type AdminHandlerOption func(*AdminHandler)
+
+// WithAdminSearchCache lets the handler purge the text search cache.
+func WithAdminSearchCache(c *places.TextSearchCache) AdminHandlerOption {
+	return func(h *AdminHandler) { h.searchCache = c }
+}
+
+// WithAdminLegCache lets the handler report the leg cache's counters.
+func WithAdminLegCache(c *places.LegCache) AdminHandlerOption {
+	return func(h *AdminHandler) { h.legCache = c }
+}
+
+// WithAdminMeter lets the handler report what Google has cost.
+func WithAdminMeter(m *places.Meter) AdminHandlerOption {
+	return func(h *AdminHandler) { h.meter = m }
+}
+
+func NewAdminHandler(opts ...AdminHandlerOption) AdminHandler {
+	h := AdminHandler{}
+
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	return h
+}
+
+type purgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// HandlePurgeSearchCache drops cached text search results: those for the
+// "query" parameter, wherever they were biased to, or every one without it.
+// It is for when Google has since learnt a checkpoint's right address.
+func (h AdminHandler) HandlePurgeSearchCache(w http.ResponseWriter, r *http.Request) {
+	if h.searchCache == nil {
+		WriteJSONResponse(w, NewResponse().WithMessage("Text search is not cached"), http.StatusNotFound)
+		return
+	}
+
+	n, err := h.searchCache.Purge(r.URL.Query().Get("query"))
+
+	if err != nil {
+		// The entries are gone from memory either way; only the file is
+		// behind, and it catches up on the next write.
+		log.Printf("purging search cache: %v", err)
+		WriteJSONResponse(w, NewResponse().WithMessage("Purged, but could not save the cache").WithData(purgeResult{n}), http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponse(w, NewResponse().WithData(purgeResult{n}), http.StatusOK)
+}
+
+// HandleLegCacheStats reports how often RouteLegs found a leg in the cache
+// rather than asking Google, and how many legs the cache holds.
+func (h AdminHandler) HandleLegCacheStats(w http.ResponseWriter, _ *http.Request) {
+	if h.legCache == nil {
+		WriteJSONResponse(w, NewResponse().WithMessage("Route legs are not cached"), http.StatusNotFound)
+		return
+	}
+
+	WriteJSONResponse(w, NewResponse().WithData(h.legCache.Stats()), http.StatusOK)
+}
+
+// HandleUsage reports the estimated Google spend today and this month, by
+// SKU, against the budgets.
+func (h AdminHandler) HandleUsage(w http.ResponseWriter, _ *http.Request) {
+	if h.meter == nil {
+		WriteJSONResponse(w, NewResponse().WithMessage("Google usage is not metered"), http.StatusNotFound)
+		return
+	}
+
+	WriteJSONResponse(w, NewResponse().WithData(h.meter.Usage()), http.StatusOK)
+}
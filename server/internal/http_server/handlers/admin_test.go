@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nguyen/allycat/internal/places"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlePurgeSearchCache(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1"}]}`))
+	}))
+	defer ts.Close()
+
+	cache, err := places.NewTextSearchCache()
+	require.NoError(t, err)
+
+	api, err := places.NewPlacesApi("test-key",
+		places.WithSearchTextURL(ts.URL),
+		places.WithHTTPClient(ts.Client()),
+		places.WithTextSearchCache(cache),
+	)
+	require.NoError(t, err)
+
+	for _, q := range []string{"city hall", "lemon hill"} {
+		_, err := api.CachedTextSearch(t.Context(), places.TextSearchOptions{Query: q})
+		require.NoError(t, err)
+	}
+
+	h := NewAdminHandler(WithAdminSearchCache(cache))
+
+	purge := func(target string) int {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.HandlePurgeSearchCache(rec, httptest.NewRequest(http.MethodDelete, target, nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		_, data := decodeBody(t, rec)
+
+		var got struct {
+			Purged int `json:"purged"`
+		}
+		require.NoError(t, json.Unmarshal(data, &got))
+
+		return got.Purged
+	}
+
+	assert.Equal(t, 1, purge("/cache/search?query=City+Hall"))
+	assert.Equal(t, 0, purge("/cache/search?query=City+Hall"))
+	assert.Equal(t, 1, purge("/cache/search"))
+	assert.Zero(t, cache.Len())
+}
+
+func TestHandlePurgeSearchCacheWithoutACache(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	NewAdminHandler().HandlePurgeSearchCache(rec, httptest.NewRequest(http.MethodDelete, "/cache/search", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandleLegCacheStats(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"routes":[{"distanceMeters":1000,"legs":[{"distanceMeters":1000}]}]}`))
+	}))
+	defer ts.Close()
+
+	cache, err := places.NewLegCache()
+	require.NoError(t, err)
+
+	api, err := places.NewPlacesApi("test-key",
+		places.WithComputeRoutesURL(ts.URL),
+		places.WithHTTPClient(ts.Client()),
+		places.WithLegCache(cache),
+	)
+	require.NoError(t, err)
+
+	for range 3 {
+		_, err := api.RouteLegs(t.Context(), places.RouteLegsOptions{Origin: "a", Destination: "b"})
+		require.NoError(t, err)
+	}
+
+	rec := httptest.NewRecorder()
+	NewAdminHandler(WithAdminLegCache(cache)).HandleLegCacheStats(rec, httptest.NewRequest(http.MethodGet, "/cache/legs", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+	assert.JSONEq(t, `{"hits":2,"misses":1,"entries":1}`, string(data))
+}
+
+func TestHandleLegCacheStatsWithoutACache(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	NewAdminHandler().HandleLegCacheStats(rec, httptest.NewRequest(http.MethodGet, "/cache/legs", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandleUsage(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1"}]}`))
+	}))
+	defer ts.Close()
+
+	meter, err := places.NewMeter(places.WithDailyBudget(5))
+	require.NoError(t, err)
+
+	api, err := places.NewPlacesApi("test-key",
+		places.WithSearchTextURL(ts.URL),
+		places.WithHTTPClient(ts.Client()),
+		places.WithMeter(meter),
+	)
+	require.NoError(t, err)
+
+	_, err = api.TextSearch(t.Context(), places.TextSearchOptions{Query: "city hall"})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	NewAdminHandler(WithAdminMeter(meter)).HandleUsage(rec, httptest.NewRequest(http.MethodGet, "/usage", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got places.Usage
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, map[places.SKU]int64{places.SKUTextSearchPro: 1}, got.Today.Units)
+	assert.InDelta(t, 0.032, got.Today.CostUsd, 1e-9)
+	assert.InDelta(t, 5, got.Today.BudgetUsd, 1e-9)
+	assert.Zero(t, got.ThisMonth.BudgetUsd)
+}
+
+func TestHandleUsageWithoutAMeter(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	NewAdminHandler().HandleUsage(rec, httptest.NewRequest(http.MethodGet, "/usage", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
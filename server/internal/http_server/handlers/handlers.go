@@ -2,4 +2,5 @@ package handlers
 
 type Handlers struct {
 	Places PlacesHandler
+	Admin  AdminHandler
 }
@@ -6,11 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/nguyen/allycat/internal/places"
+	"github.com/nguyen/allycat/internal/roads"
 	"github.com/nguyen/allycat/internal/tsp"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -19,28 +24,81 @@ const (
 	// rather than showing "no results" for what is really a timeout.
 	textSearchTimeout = 5 * time.Second
 
-	// Route optimization fans out one Google request per candidate finish, per
-	// vehicle — a ten-stop sheet with no fixed end is twenty concurrent calls.
+	// Route optimization is one Google request per vehicle: waypoint
+	// optimization with a fixed finish, or a road-distance matrix without one.
 	// The local solver already answers instantly, so this budget only decides
 	// how long to wait before falling back to solver-only.
 	optimizeRouteTimeout = 8 * time.Second
 
+	// The local solver normally answers well inside a second. A route that
+	// keeps it busy this long has gone wrong somewhere, and the rider is
+	// better off with an error than a spinner.
+	solveTimeout = 10 * time.Second
+
+	// prizeAlternatives is how many cheaper, lower-scoring subsets prize mode
+	// shows next to the best one.
+	prizeAlternatives = 2
+
+	// maxAlternatives caps how many runner-up orders a request may ask for.
+	// Each one multiplies the solver's table, and past a handful they stop
+	// being meaningfully different rides.
+	maxAlternatives = 4
+
 	// Measuring an already-decided order is a single upstream call. It only
 	// enriches a route the rider already has, so it fails fast rather than
 	// holding the connection open.
 	routeLegsTimeout = 8 * time.Second
+
+	// cacheHeader says whether a response was served from a cache, HIT or
+	// MISS.
+	cacheHeader = "X-Cache"
+
+	// providerHeader names the provider that answered a search.
+	providerHeader = "X-Provider"
+
+	// offlineProvider is what routes measured over the offline road graph
+	// record as their provider.
+	offlineProvider = "osm"
 )
 
 type PlacesHandler struct {
-	api *places.PlacesApi
+	// api is whichever geocoding and routing backend is configured:
+	// Google, a self-hosted one, or the fake.
+	api places.Provider
+
+	// roads, when set, measures routes whenever Google cannot, or whenever
+	// a request names one of its profiles. profiles are the ones added on
+	// top of the built-in profiles.
+	roads    *roads.Graph
+	profiles map[string]roads.Profile
+
+	// exactStopLimit is how many stops the local solver solves exactly
+	// before switching to its heuristic, or zero for the solver's default.
+	exactStopLimit int
+}
+
+// PlacesHandlerOption customises a PlacesHandler.
+type PlacesHandlerOption func(*PlacesHandler)
+
+// WithExactStopLimit sets how many stops the local solver solves exactly,
+// trading memory and time for a proven best order. Zero keeps its default.
+func WithExactStopLimit(n int) PlacesHandlerOption {
+	return func(h *PlacesHandler) { h.exactStopLimit = n }
 }
 
-// NewPlacesHandler takes the constructed client rather than an API key so the
-// handler has no opinion on how that client is built or pointed.
-func NewPlacesHandler(api *places.PlacesApi) PlacesHandler {
-	return PlacesHandler{
+// NewPlacesHandler takes the constructed provider rather than an API key so
+// the handler has no opinion on which backend it is, or how it is built or
+// pointed.
+func NewPlacesHandler(api places.Provider, opts ...PlacesHandlerOption) PlacesHandler {
+	h := PlacesHandler{
 		api: api,
 	}
+
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	return h
 }
 
 func (h PlacesHandler) HandleTextSearch(w http.ResponseWriter, r *http.Request) {
@@ -48,6 +106,7 @@ func (h PlacesHandler) HandleTextSearch(w http.ResponseWriter, r *http.Request)
 
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 		WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("Error decoding request body: %v", err)), http.StatusBadRequest)
+		return
 	}
 
 	if len(reqBody.Query) < 4 {
@@ -57,19 +116,52 @@ func (h PlacesHandler) HandleTextSearch(w http.ResponseWriter, r *http.Request)
 
 	googleMethodContext, cancel := context.WithTimeout(r.Context(), textSearchTimeout)
 	defer cancel()
-	res, err := h.api.TextSearch(googleMethodContext, reqBody)
+	res, err := h.api.Search(googleMethodContext, reqBody)
+
+	// A result that could not be cached is still a result.
+	if errors.Is(err, places.ErrCacheWrite) {
+		log.Printf("text search: %v", err)
+		err = nil
+	}
+
+	if msg, ok := refusedMessage(err, "Place search"); ok {
+		WriteJSONResponse(w, NewResponse().WithMessage(msg), http.StatusServiceUnavailable)
+		return
+	}
 
 	if err != nil {
 		WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("Error searching places: %v", err)), http.StatusInternalServerError)
+		return
+	}
+
+	// The places stay the whole of the data, so where they came from goes
+	// in a header.
+	w.Header().Set(providerHeader, h.api.Name())
+
+	if res.FromCache {
+		w.Header().Set(cacheHeader, "HIT")
 	} else {
-		WriteJSONResponse(w, NewResponse().WithData(res), http.StatusOK)
+		w.Header().Set(cacheHeader, "MISS")
 	}
+
+	WriteJSONResponse(w, NewResponse().WithData(res.Places), http.StatusOK)
 }
 
 type optimizeRoutePayloadPlace struct {
 	Id   string   `json:"id"`
 	Long *float64 `json:"longitude"` // using * because 0 is a valid value
 	Lat  *float64 `json:"latitude"`
+
+	// Checkpoint hours, either of which may be left out.
+	OpensAt  *time.Time `json:"opensAt"`
+	ClosesAt *time.Time `json:"closesAt"`
+
+	// Points is what the checkpoint scores when the route has a budget.
+	Points *int `json:"points"`
+
+	// DwellMinutes is how long the rider spends here, in place of the
+	// route's own. The origin never dwells: the clock starts as they leave.
+	DwellMinutes *float64 `json:"dwellMinutes"`
 }
 
 func (o optimizeRoutePayloadPlace) validate() error {
@@ -85,49 +177,609 @@ func (o optimizeRoutePayloadPlace) validate() error {
 		return errors.New("'longitude' is required")
 	}
 
+	if o.OpensAt != nil && o.ClosesAt != nil && o.ClosesAt.Before(*o.OpensAt) {
+		return errors.New("'closesAt' is before 'opensAt'")
+	}
+
+	if o.Points != nil && *o.Points < 0 {
+		return errors.New("'points' cannot be negative")
+	}
+
+	if o.DwellMinutes != nil && *o.DwellMinutes < 0 {
+		return errors.New("'dwellMinutes' cannot be negative")
+	}
+
 	return nil
 }
 
-func (h PlacesHandler) HandleOptimizeRoute(w http.ResponseWriter, r *http.Request) {
-	var b struct {
-		Start optimizeRoutePayloadPlace   `json:"origin"`
-		Stops []optimizeRoutePayloadPlace `json:"stops"`
-		End   *optimizeRoutePayloadPlace  `json:"destination"`
+func (o optimizeRoutePayloadPlace) hasWindow() bool {
+	return o.OpensAt != nil || o.ClosesAt != nil
+}
+
+func (o optimizeRoutePayloadPlace) window() (opensAt, closesAt time.Time) {
+	if o.OpensAt != nil {
+		opensAt = *o.OpensAt
 	}
 
-	// if err := json.Unmarshal([]byte(testStr), &reqBody); err != nil {
-	// 	writeJSONResponse(w, newResponse().message("Invalid payload"), http.StatusBadRequest)
-	// 	return
-	// }
-	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-		WriteJSONResponse(w, NewResponse().WithMessage("Invalid payload"), http.StatusBadRequest)
-		return
+	if o.ClosesAt != nil {
+		closesAt = *o.ClosesAt
 	}
 
+	return opensAt, closesAt
+}
+
+type optimizeRouteDelivery struct {
+	Pickup  string `json:"pickup"`
+	Dropoff string `json:"dropoff"`
+}
+
+type optimizeRoutePrecedence struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// optimizeRouteRequest is the body HandleOptimizeRoute takes.
+type optimizeRouteRequest struct {
+	Start  optimizeRoutePayloadPlace   `json:"origin"`
+	Stops  []optimizeRoutePayloadPlace `json:"stops"`
+	End    *optimizeRoutePayloadPlace  `json:"destination"`
+	Metric string                      `json:"metric"`
+
+	// Loop brings the ride back to the origin instead of finishing at a
+	// destination or the last stop.
+	Loop bool `json:"loop"`
+
+	// StartTime gives the route a clock, so checkpoint hours apply and
+	// each stop gets an arrival time. SpeedKph is the rider's pace.
+	StartTime *time.Time `json:"startTime"`
+	SpeedKph  *float64   `json:"speedKph"`
+
+	// Mode is how the local solver's routes are ridden, "bike", "car" or
+	// "foot", which sets their pace and where they appear in the response.
+	// Bike when left out.
+	Mode string `json:"mode"`
+
+	// DwellMinutes is how long the rider spends at each stop that does
+	// not say otherwise. The destination only dwells if it says so.
+	DwellMinutes *float64 `json:"dwellMinutes"`
+
+	// Deliveries are manifest items collected at one stop and dropped at
+	// another; Precedence is any other "this before that" rule.
+	Deliveries []optimizeRouteDelivery   `json:"deliveries"`
+	Precedence []optimizeRoutePrecedence `json:"precedence"`
+
+	// Either budget switches to prize mode: rather than visiting every
+	// stop, ride to the set that scores the most points within it.
+	BudgetMeters  *float64 `json:"budgetMeters"`
+	BudgetMinutes *float64 `json:"budgetMinutes"`
+
+	// Alternatives asks for that many runner-up orders from the local
+	// solver after its best one.
+	Alternatives int `json:"alternatives"`
+
+	// Riders splits the stops between a team who meet at the finish.
+	// RiderStarts are their own starting points, in rider order; riders
+	// without one leave from the origin.
+	Riders      int                         `json:"riders"`
+	RiderStarts []optimizeRoutePayloadPlace `json:"riderStarts"`
+
+	// Profile measures the bike route over the offline road graph under
+	// that profile, rather than asking Google.
+	Profile string `json:"profile"`
+}
+
+// all is every place the route visits: the origin, the stops, and the
+// destination if there is one.
+func (b optimizeRouteRequest) all() []optimizeRoutePayloadPlace {
+	all := append([]optimizeRoutePayloadPlace{b.Start}, b.Stops...)
+	if b.End != nil {
+		all = append(all, *b.End)
+	}
+
+	return all
+}
+
+func (b optimizeRouteRequest) hasWindows() bool {
+	return slices.ContainsFunc(b.all(), optimizeRoutePayloadPlace.hasWindow)
+}
+
+func (b optimizeRouteRequest) hasPrecedence() bool {
+	return len(b.Deliveries)+len(b.Precedence) > 0
+}
+
+// travelMode is the mode the request names, which validate has checked.
+func (b optimizeRouteRequest) travelMode() tsp.TravelMode {
+	if b.Mode == "" {
+		return tsp.Bike
+	}
+
+	return tsp.TravelMode(b.Mode)
+}
+
+// solverMetric is the metric the request names. Planar is plenty inside one
+// city; regional races can ask for a geodesic metric instead.
+func (b optimizeRouteRequest) solverMetric() (tsp.Metric, error) {
+	if b.Metric == "" {
+		return tsp.Planar, nil
+	}
+
+	return tsp.MetricByName(b.Metric)
+}
+
+func (b optimizeRouteRequest) prize() bool {
+	return b.BudgetMeters != nil || b.BudgetMinutes != nil
+}
+
+func (b optimizeRouteRequest) team() bool {
+	return b.Riders > 1 || len(b.RiderStarts) > 0
+}
+
+// validate returns what is wrong with the request, worded for the caller.
+func (b optimizeRouteRequest) validate() error {
 	if err := b.Start.validate(); err != nil {
-		WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("start %s", err.Error())), http.StatusBadRequest)
-		return
+		return fmt.Errorf("start %s", err.Error())
+	}
+
+	if b.End != nil && b.Loop {
+		return errors.New("A loop finishes at the origin, so it cannot have a destination")
 	}
 
 	if b.End != nil {
 		if err := b.End.validate(); err != nil {
-			WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("end %s", err.Error())), http.StatusBadRequest)
-			return
+			return fmt.Errorf("end %s", err.Error())
 		}
 	}
 
 	for i, s := range b.Stops {
 		if err := s.validate(); err != nil {
-			WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("stop at index %d %s", i, err.Error())), http.StatusBadRequest)
-			return
+			return fmt.Errorf("stop at index %d %s", i, err.Error())
 		}
 	}
 
 	if len(b.Stops) < 2 {
-		WriteJSONResponse(w, NewResponse().WithMessage("At least two stops are required"), http.StatusBadRequest)
+		return errors.New("At least two stops are required")
+	}
+
+	if b.hasWindows() && b.StartTime == nil {
+		return errors.New("'startTime' is required when any place has opening hours")
+	}
+
+	if b.SpeedKph != nil && *b.SpeedKph <= 0 {
+		return errors.New("'speedKph' must be positive")
+	}
+
+	if b.Mode != "" {
+		if _, err := tsp.TravelModeByName(b.Mode); err != nil {
+			return err
+		}
+	}
+
+	if b.DwellMinutes != nil && *b.DwellMinutes < 0 {
+		return errors.New("'dwellMinutes' cannot be negative")
+	}
+
+	if b.Alternatives < 0 || b.Alternatives > maxAlternatives {
+		return fmt.Errorf("'alternatives' must be between 0 and %d", maxAlternatives)
+	}
+
+	if (b.BudgetMeters != nil && *b.BudgetMeters <= 0) || (b.BudgetMinutes != nil && *b.BudgetMinutes <= 0) {
+		return errors.New("A budget must be positive")
+	}
+
+	if b.prize() && b.hasWindows() {
+		return errors.New("A budget cannot be combined with opening hours")
+	}
+
+	if b.Riders < 0 {
+		return errors.New("'riders' cannot be negative")
+	}
+
+	if b.Riders > 0 && b.Riders < len(b.RiderStarts) {
+		return errors.New("'riders' is fewer than 'riderStarts'")
+	}
+
+	for i, s := range b.RiderStarts {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("rider start at index %d %s", i, err.Error())
+		}
+	}
+
+	switch {
+	case b.team() && b.prize():
+		return errors.New("A budget cannot be combined with a team")
+	case b.team() && b.hasWindows():
+		return errors.New("Opening hours cannot be combined with a team")
+	case b.team() && b.Alternatives > 0:
+		return errors.New("Alternatives cannot be combined with a team")
+	case b.hasWindows() && b.Alternatives > 0:
+		return errors.New("Alternatives cannot be combined with opening hours")
+	}
+
+	for _, p := range b.all() {
+		if p.Points != nil && !b.prize() {
+			return errors.New("'points' only count with 'budgetMeters' or 'budgetMinutes'")
+		}
+	}
+
+	for i, d := range b.Deliveries {
+		if d.Pickup == "" || d.Dropoff == "" {
+			return fmt.Errorf("delivery at index %d needs both 'pickup' and 'dropoff'", i)
+		}
+	}
+
+	for i, p := range b.Precedence {
+		if p.Before == "" || p.After == "" {
+			return fmt.Errorf("precedence at index %d needs both 'before' and 'after'", i)
+		}
+	}
+
+	return nil
+}
+
+// stopDwell is how long the rider spends at each place that dwells at all.
+// Google only knows riding time, so the routes it measures add this
+// themselves. The origin never dwells, as with the local solver.
+func (b optimizeRouteRequest) stopDwell() map[string]time.Duration {
+	out := make(map[string]time.Duration, len(b.Stops))
+
+	if b.DwellMinutes != nil {
+		for _, s := range b.Stops {
+			out[s.Id] = time.Duration(*b.DwellMinutes * float64(time.Minute))
+		}
+	}
+
+	for _, p := range b.all()[1:] {
+		if p.DwellMinutes != nil {
+			out[p.Id] = time.Duration(*p.DwellMinutes * float64(time.Minute))
+		}
+	}
+
+	return out
+}
+
+// HandleOptimizeRoute orders a route's stops. What the rider asked for picks
+// one of four modes, each with its own helper:
+//
+//   - team, with riders or riderStarts: the stops split between riders
+//   - prize, with a budget: the best-scoring stops that fit it
+//   - opening hours: one order that keeps to every checkpoint's hours
+//   - otherwise: the local solver's order, plus Google's or the offline
+//     road graph's
+//
+// Google optimizes one vehicle over every stop, with no clock, so the first
+// three modes never ask it: their routes are the local solver's alone, over
+// its own distances. The same goes for ordering rules with a fixed finish,
+// which Google's optimization would ignore.
+func (h PlacesHandler) HandleOptimizeRoute(w http.ResponseWriter, r *http.Request) {
+	var b optimizeRouteRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		WriteJSONResponse(w, NewResponse().WithMessage("Invalid payload"), http.StatusBadRequest)
+		return
+	}
+
+	if err := b.validate(); err != nil {
+		WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	metric, err := b.solverMetric()
+	if err != nil {
+		WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	bikeProfile := roads.Bike
+
+	if b.Profile != "" {
+		p, err := h.roadProfile(b.Profile)
+
+		if err != nil {
+			WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		bikeProfile = p
+	}
+
+	tb := h.solverRoute(b, metric)
+
+	solveCtx, cancelSolve := context.WithTimeout(r.Context(), solveTimeout)
+	defer cancelSolve()
+
+	switch {
+	case b.team():
+		writeTeamRoutes(solveCtx, w, b, tb)
+	case b.prize():
+		writePrizeRoutes(solveCtx, w, b, tb)
+	case b.hasWindows():
+		writeWindowedRoutes(solveCtx, w, b, tb)
+	default:
+		// Google, or the road graph, is asked while the local solver works,
+		// so the rider waits for the slower of the two rather than both.
+		roadCtx, cancelRoad := context.WithCancel(r.Context())
+		defer cancelRoad()
+
+		road := make(chan []places.OptimalRoute, 1)
+
+		go func() {
+			road <- h.roadRoutes(roadCtx, b, tb, bikeProfile)
+		}()
+
+		solved, _, err := rankedRoutes(solveCtx, b, tb)
+
+		if err != nil {
+			writeSolverError(w, err)
+			return
+		}
+
+		routes := append(solved, <-road...)
+
+		WriteJSONResponse(w, NewResponse().WithData(routes), http.StatusOK)
+	}
+}
+
+// solverRoute is the request as the local solver sees it: every place, and
+// the clock, pace, dwell and ordering rules that apply in any mode.
+func (h PlacesHandler) solverRoute(b optimizeRouteRequest, metric tsp.Metric) tsp.TspRouteBuilder {
+	tb := tsp.NewTspRouteBuilder().WithMetric(metric).WithExactStopLimit(h.exactStopLimit).WithTravelMode(b.travelMode())
+	tb = tb.WithStart(b.Start.Id, *b.Start.Lat, *b.Start.Long)
+
+	if b.End != nil {
+		tb = tb.WithEnd(b.End.Id, *b.End.Lat, *b.End.Long)
+	}
+
+	if b.Loop {
+		tb = tb.AsLoop()
+	}
+
+	for _, s := range b.Stops {
+		tb = tb.AddStop(s.Id, *s.Lat, *s.Long)
+	}
+
+	if b.StartTime != nil {
+		tb = tb.WithStartTime(*b.StartTime)
+
+		for _, p := range b.all() {
+			if p.hasWindow() {
+				opensAt, closesAt := p.window()
+				tb = tb.WithTimeWindow(p.Id, opensAt, closesAt)
+			}
+		}
+	}
+
+	if b.SpeedKph != nil {
+		tb = tb.WithSpeed(*b.SpeedKph / 3.6)
+	}
+
+	if b.DwellMinutes != nil {
+		tb = tb.WithDefaultDwell(time.Duration(*b.DwellMinutes * float64(time.Minute)))
+	}
+
+	for _, p := range b.all() {
+		if p.DwellMinutes != nil {
+			tb = tb.WithDwell(p.Id, time.Duration(*p.DwellMinutes*float64(time.Minute)))
+		}
+	}
+
+	for _, d := range b.Deliveries {
+		tb = tb.WithPrecedence(d.Pickup, d.Dropoff)
+	}
+
+	for _, p := range b.Precedence {
+		tb = tb.WithPrecedence(p.Before, p.After)
+	}
+
+	return tb
+}
+
+// writeTeamRoutes answers team mode: one route per rider, all meeting at the
+// finish. Google routes one vehicle at a time, so splitting the stops between
+// riders is the local solver's alone.
+func writeTeamRoutes(ctx context.Context, w http.ResponseWriter, b optimizeRouteRequest, tb tsp.TspRouteBuilder) {
+	tb = tb.WithRiders(b.Riders)
+
+	for _, s := range b.RiderStarts {
+		tb = tb.WithRiderStart(s.Id, *s.Lat, *s.Long)
+	}
+
+	found, err := tb.Build().TeamRoutes(ctx)
+
+	if err != nil {
+		writeSolverError(w, err)
+		return
+	}
+
+	routes := make([]places.OptimalRoute, 0, len(found))
+
+	for _, or := range found {
+		stopIds := make([]string, 0, len(or.Stops))
+		for _, s := range or.Stops {
+			stopIds = append(stopIds, s.Id)
+		}
+
+		routes = append(routes, inMode(places.OptimalRoute{
+			Method:     "tsp",
+			Algorithm:  or.Algorithm,
+			Optimality: optimality(or.Bounded, or.OptimalityGap),
+			End:        or.End.Id,
+			Rider:      or.Rider,
+		}, b.travelMode(), &places.OptimizeRouteResponse{
+			Meters:          int64(or.Meters),
+			DisplayDistance: places.DisplayMiles(or.Meters),
+			DisplayDuration: places.DisplayDuration(or.Duration.Seconds()),
+			Seconds:         int64(math.Round(or.Duration.Seconds())),
+			Order:           stopIds,
+			Etas:            stopEtas(stopIds, or.End.Id, or.Elapsed),
+		}))
+	}
+
+	WriteJSONResponse(w, NewResponse().WithData(routes), http.StatusOK)
+}
+
+// writePrizeRoutes answers prize mode: the set of checkpoints that scores the
+// most within the budget, and a few cheaper ones. Google has no notion of
+// skipping stops, so this is the local solver's alone, and the budget is held
+// against its own distances: straight lines unless the rider asked otherwise.
+func writePrizeRoutes(ctx context.Context, w http.ResponseWriter, b optimizeRouteRequest, tb tsp.TspRouteBuilder) {
+	for _, s := range b.Stops {
+		if s.Points != nil {
+			tb = tb.WithPoints(s.Id, *s.Points)
+		}
+	}
+
+	if b.BudgetMeters != nil {
+		tb = tb.WithDistanceBudget(*b.BudgetMeters)
+	}
+
+	if b.BudgetMinutes != nil {
+		tb = tb.WithTimeBudget(time.Duration(*b.BudgetMinutes * float64(time.Minute)))
+	}
+
+	found, err := tb.Build().PrizeRoutes(ctx, prizeAlternatives)
+
+	if err != nil {
+		writeSolverError(w, err)
 		return
 	}
 
+	if len(found) == 0 {
+		WriteJSONResponse(w, NewResponse().WithMessage("No set of checkpoints fits within the budget"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	routes := make([]places.OptimalRoute, 0, len(found))
+
+	for i, or := range found {
+		stopIds := make([]string, 0, len(or.Stops))
+		for _, s := range or.Stops {
+			stopIds = append(stopIds, s.Id)
+		}
+
+		skipped := make([]string, 0, len(or.Skipped))
+		for _, s := range or.Skipped {
+			skipped = append(skipped, s.Id)
+		}
+
+		routes = append(routes, inMode(places.OptimalRoute{
+			Method:     "tsp",
+			Algorithm:  or.Algorithm,
+			Optimality: optimality(or.Bounded, or.OptimalityGap),
+			End:        or.End.Id,
+			Rank:       i + 1,
+			Points:     &or.Points,
+			Skipped:    skipped,
+		}, b.travelMode(), &places.OptimizeRouteResponse{
+			Meters:          int64(or.Meters),
+			DisplayDistance: places.DisplayMiles(or.Meters),
+			DisplayDuration: places.DisplayDuration(or.Duration.Seconds()),
+			Seconds:         int64(math.Round(or.Duration.Seconds())),
+			Order:           stopIds,
+			Etas:            stopEtas(stopIds, or.End.Id, or.Elapsed),
+		}))
+	}
+
+	WriteJSONResponse(w, NewResponse().WithData(routes), http.StatusOK)
+}
+
+// writeWindowedRoutes answers a route with opening hours. Google knows nothing
+// about checkpoint hours, so only the local solver's answer is worth showing.
+// When no order reaches every checkpoint while it is open, the rider still
+// gets the least late attempt, but under 422 so the client cannot mistake it
+// for a plan that works.
+func writeWindowedRoutes(ctx context.Context, w http.ResponseWriter, b optimizeRouteRequest, tb tsp.TspRouteBuilder) {
+	solved, late, err := rankedRoutes(ctx, b, tb)
+
+	if err != nil {
+		writeSolverError(w, err)
+		return
+	}
+
+	if len(late) > 0 {
+		msg := fmt.Sprintf("No order reaches every checkpoint while it is open; late at %s", strings.Join(late, ", "))
+		WriteJSONResponse(w, NewResponse().WithMessage(msg).WithData(solved), http.StatusUnprocessableEntity)
+		return
+	}
+
+	WriteJSONResponse(w, NewResponse().WithData(solved), http.StatusOK)
+}
+
+// rankedRoutes has the local solver find its best order and the runners-up
+// the request asks for. late lists the checkpoints the best order reaches
+// after they close, which only happens when no order reaches them all in
+// time.
+func rankedRoutes(ctx context.Context, b optimizeRouteRequest, tb tsp.TspRouteBuilder) ([]places.OptimalRoute, []string, error) {
+	ranked, err := tb.Build().RankedRoutes(ctx, 1+b.Alternatives)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	solved := make([]places.OptimalRoute, 0, len(ranked))
+
+	var late []string
+
+	for i, or := range ranked {
+		stopIds := make([]string, 0, len(or.Stops))
+
+		for _, s := range or.Stops {
+			stopIds = append(stopIds, s.Id)
+		}
+
+		arrivals, lateHere := stopArrivals(or.Arrivals)
+
+		if i == 0 && or.Infeasible {
+			late = lateHere
+		}
+
+		route := inMode(places.OptimalRoute{
+			Method:     "tsp",
+			Algorithm:  or.Algorithm,
+			Optimality: optimality(or.Bounded, or.OptimalityGap),
+			End:        or.End.Id,
+		}, b.travelMode(), &places.OptimizeRouteResponse{
+			Meters:          int64(or.Meters),
+			DisplayDistance: places.DisplayMiles(or.Meters),
+			DisplayDuration: places.DisplayDuration(or.Duration.Seconds()),
+			Seconds:         int64(math.Round(or.Duration.Seconds())),
+			Order:           stopIds,
+			Etas:            stopEtas(stopIds, or.End.Id, or.Elapsed),
+			Arrivals:        arrivals,
+		})
+
+		if b.Alternatives > 0 {
+			route.Rank = or.Rank
+			route.GapMeters = int64(math.Round(or.Gap))
+		}
+
+		solved = append(solved, route)
+	}
+
+	return solved, late, nil
+}
+
+// roadRoutes measures the route over real roads, to show next to the local
+// solver's: Google optimizes it when the finish is fixed, its road-distance
+// matrix is measured when it is not, and the offline graph stands in when a
+// profile asks for it or Google fails. It returns nothing when no road route
+// is worth showing or none came back in time, which leaves the rider the
+// solver's routes alone.
+func (h PlacesHandler) roadRoutes(ctx context.Context, b optimizeRouteRequest, tb tsp.TspRouteBuilder, bikeProfile roads.Profile) []places.OptimalRoute {
+	// With a fixed finish Google optimizes the order itself, one request per
+	// vehicle. Without one that becomes a request per candidate finish, so
+	// measure the road network once instead and let the local solver pick
+	// both the order and the finish over it. A loop's finish is the origin,
+	// so it is a fixed finish too.
+	useMatrix := b.End == nil && !b.Loop && len(b.Stops)+1 <= places.MaxRouteMatrixPlaces
+
+	// Google's own optimization has no notion of pickups and drop-offs and
+	// would happily deliver before collecting, so only orders the local
+	// solver chose are worth showing. The offline graph only measures, so
+	// a profile still gets its road route.
+	if b.hasPrecedence() && !useMatrix && b.Profile == "" {
+		return nil
+	}
+
 	builder := places.
 		NewOptimizeRoutePayloadBuilder().
 		WithStart(b.Start.Id, *b.Start.Lat, *b.Start.Long)
@@ -136,78 +788,373 @@ func (h PlacesHandler) HandleOptimizeRoute(w http.ResponseWriter, r *http.Reques
 		builder = builder.WithEnd(b.End.Id, *b.End.Lat, *b.End.Long)
 	}
 
+	if b.Loop {
+		builder = builder.AsLoop()
+	}
+
 	for _, s := range b.Stops {
 		builder = builder.AddStop(s.Id, *s.Lat, *s.Long)
 	}
 
+	// The request has been validated, so this only fails if the two
+	// disagree about what a route needs.
 	payload, err := builder.Build()
 
 	if err != nil {
-		WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
-		return
+		log.Printf("route optimization skipped, falling back to solver only: %v", err)
+		return nil
 	}
 
-	googleMethodContext, cancel := context.WithTimeout(r.Context(), optimizeRouteTimeout)
+	googleMethodContext, cancel := context.WithTimeout(ctx, optimizeRouteTimeout)
 	defer cancel()
 
+	all := b.all()
+	stopDwell := b.stopDwell()
+
+	solveOver := func(m *places.RouteMatrix) (optimizedOrder, error) {
+		or, err := tsp.Solve(googleMethodContext, tb.WithDistanceMatrix(m.Ids, m.Meters).Build())
+
+		if err != nil {
+			return optimizedOrder{}, err
+		}
+
+		stopIds := make([]string, 0, len(or.Stops))
+		var dwell time.Duration
+		for _, s := range or.Stops {
+			stopIds = append(stopIds, s.Id)
+			dwell += stopDwell[s.Id]
+		}
+
+		// Without a destination the route ends at a stop, and the rider is
+		// not done until they have been there too.
+		dwell += stopDwell[or.End.Id]
+
+		return optimizedOrder{Algorithm: or.Algorithm, Stops: stopIds, End: or.End.Id, Meters: or.Meters, Dwell: dwell}, nil
+	}
+
 	type apiRes struct {
 		Result []places.OptimalRoute
 		Err    error
 	}
+
 	ch := make(chan apiRes, 1)
 	go func() {
-		routes, err := h.api.OptimizeRoute(googleMethodContext, payload)
-		ch <- apiRes{routes, err}
-	}()
-
-	tspRoute := func() places.OptimalRoute {
-		// test manual tsp
-		tb := tsp.NewTspRouteBuilder()
-		tb = tb.WithStart(b.Start.Id, *b.Start.Lat, *b.Start.Long)
+		var routes []places.OptimalRoute
+		var err error
 
-		if b.End != nil {
-			tb = tb.WithEnd(b.End.Id, *b.End.Lat, *b.End.Long)
-		}
-
-		for _, s := range b.Stops {
-			tb = tb.AddStop(s.Id, *s.Lat, *s.Long)
+		// A rider who asked for a profile wants the offline graph's idea of
+		// a good road, not Google's.
+		if b.Profile != "" {
+			routes, err = h.roadMatrixRoutes(googleMethodContext, "osm", h.offlineMatrix(all, bikeProfile), solveOver)
+			ch <- apiRes{withProvider(routes, offlineProvider), err}
+			return
 		}
 
-		or := tb.Build().OptimalRoutes()
+		provider := h.api.Name()
 
-		stopIds := make([]string, 0, len(or.Stops))
+		// While the breaker is open or the budget spent Google would only
+		// refuse, so the rider need not wait to hear it.
+		if down := h.api.Available(); down != nil {
+			err = down
+		} else if useMatrix {
+			ids := make([]string, 0, len(b.Stops)+1)
+			ids = append(ids, b.Start.Id)
+			for _, s := range b.Stops {
+				ids = append(ids, s.Id)
+			}
 
-		for _, s := range or.Stops {
-			stopIds = append(stopIds, s.Id)
+			routes, err = h.roadMatrixRoutes(googleMethodContext, "matrix", h.providerMatrix(ids, coordsOf(all)), solveOver)
+		} else if routes, err = h.api.OptimizeRoute(googleMethodContext, payload); err == nil {
+			withDwell(routes, stopDwell)
 		}
 
-		return places.OptimalRoute{
-			Method: "tsp",
-			End:    or.End.Id,
-			BikeRoute: &places.OptimizeRouteResponse{
-				Meters:          int64(or.Meters),
-				DisplayDistance: fmt.Sprintf("%.1f mi", or.Meters/1609.344),
-				DisplayDuration: "idk2",
-				Order:           stopIds,
-			},
-			CarRoute: nil,
+		// The offline graph has no quota and needs no network, so it
+		// stands in for whichever of Google's answers failed. It only
+		// measures; the local solver picks the order, finish or not.
+		if err != nil && h.roads != nil {
+			log.Printf("route optimization failed, measuring the offline road graph instead: %v", err)
+
+			routes, err = h.roadMatrixRoutes(googleMethodContext, "osm", h.offlineMatrix(all, roads.Bike), solveOver)
+			provider = offlineProvider
 		}
-	}()
 
-	allRoutes := []places.OptimalRoute{tspRoute}
+		ch <- apiRes{withProvider(routes, provider), err}
+	}()
 
 	select {
 	case result := <-ch:
 		if err := result.Err; err != nil {
 			log.Printf("route optimization failed, falling back to solver only: %v", err)
-		} else {
-			allRoutes = append(allRoutes, result.Result...)
+			return nil
 		}
+
+		return result.Result
 	case <-googleMethodContext.Done():
 		log.Println("route optimization timed out, falling back to solver only")
+		return nil
+	}
+}
+
+// inMode files a local solver's route under the travel mode it was timed
+// for. Routes on foot go under their own key, as Google measures none.
+func inMode(route places.OptimalRoute, mode tsp.TravelMode, r *places.OptimizeRouteResponse) places.OptimalRoute {
+	switch mode {
+	case tsp.Car:
+		route.CarRoute = r
+	case tsp.Foot:
+		route.FootRoute = r
+	default:
+		route.BikeRoute = r
 	}
 
-	WriteJSONResponse(w, NewResponse().WithData(allRoutes), http.StatusOK)
+	return route
+}
+
+// withDwell adds the time spent at each stop to routes a provider optimized,
+// which only count riding, so their durations agree with the solver's.
+func withDwell(routes []places.OptimalRoute, stopDwell map[string]time.Duration) {
+	for _, route := range routes {
+		for _, r := range []*places.OptimizeRouteResponse{route.BikeRoute, route.CarRoute} {
+			if r == nil {
+				continue
+			}
+
+			dwell := stopDwell[route.End]
+			for _, id := range r.Order {
+				dwell += stopDwell[id]
+			}
+
+			if dwell == 0 {
+				continue
+			}
+
+			r.Seconds += int64(math.Round(dwell.Seconds()))
+			r.DisplayDuration = places.DisplayDuration(float64(r.Seconds))
+		}
+	}
+}
+
+// withProvider records on every route which provider measured it.
+func withProvider(routes []places.OptimalRoute, provider string) []places.OptimalRoute {
+	for i := range routes {
+		routes[i].Provider = provider
+	}
+
+	return routes
+}
+
+// coordsOf locates places for providers that route by coordinates. Every
+// place has been validated, so none is missing its latitude or longitude.
+func coordsOf(all []optimizeRoutePayloadPlace) map[string]places.Coord {
+	out := make(map[string]places.Coord, len(all))
+
+	for _, p := range all {
+		out[p.Id] = places.Coord{Lat: *p.Lat, Long: *p.Long}
+	}
+
+	return out
+}
+
+// refusedMessage tells the rider why Google was never asked, when err says
+// that it was not. what names the feature that needed it.
+func refusedMessage(err error, what string) (string, bool) {
+	switch {
+	case errors.Is(err, places.ErrCircuitOpen):
+		return what + " is unavailable for now, try again shortly", true
+	case errors.Is(err, places.ErrBudgetExceeded):
+		return what + " is over its Google budget, so only saved answers are available", true
+	}
+
+	return "", false
+}
+
+// writeSolverError answers for an error from the local solver. A route it
+// refuses is the caller's to fix; running out of time is a timeout; anything
+// else is ours.
+func writeSolverError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tsp.ErrTooFewStops),
+		errors.Is(err, tsp.ErrTooManyStops),
+		errors.Is(err, tsp.ErrDuplicateId),
+		errors.Is(err, tsp.ErrInvalidCoordinates),
+		errors.Is(err, tsp.ErrInvalidPrecedence),
+		errors.Is(err, tsp.ErrPrizeTooManyStops),
+		errors.Is(err, tsp.ErrRankedTooManyStops),
+		errors.Is(err, tsp.ErrRankedWithWindows),
+		errors.Is(err, tsp.ErrTooManyRiders),
+		errors.Is(err, tsp.ErrTeamUnsupported):
+		WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("route solve timed out: %v", err)
+		WriteJSONResponse(w, NewResponse().WithMessage("Timed out optimizing the route"), http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		// The client has gone; nobody will read this.
+		WriteJSONResponse(w, NewResponse().WithMessage("Route optimization was cancelled"), http.StatusServiceUnavailable)
+	default:
+		log.Printf("route solve failed: %v", err)
+		WriteJSONResponse(w, NewResponse().WithMessage("Could not optimize the route"), http.StatusInternalServerError)
+	}
+}
+
+// stopArrivals converts the solver's arrival times for the response, and
+// returns the ids of any places reached after they close.
+func stopArrivals(arrivals []tsp.Arrival) ([]places.StopArrival, []string) {
+	var out []places.StopArrival
+	var late []string
+
+	for _, a := range arrivals {
+		out = append(out, places.StopArrival{
+			Id:          a.Id,
+			At:          a.At,
+			WaitSeconds: int64(a.Wait.Seconds()),
+			Late:        a.Late,
+		})
+
+		if a.Late {
+			late = append(late, a.Id)
+		}
+	}
+
+	return out, late
+}
+
+// stopEtas pairs the solver's elapsed times with the stops they belong to
+// and then the end, for the response.
+func stopEtas(stopIds []string, end string, elapsed []time.Duration) []places.StopEta {
+	ids := append(slices.Clone(stopIds), end)
+	out := make([]places.StopEta, 0, len(elapsed))
+
+	for i, e := range elapsed[:min(len(elapsed), len(ids))] {
+		out = append(out, places.StopEta{Id: ids[i], Seconds: int64(math.Round(e.Seconds()))})
+	}
+
+	return out
+}
+
+// optimizedOrder is a solved route reduced to ids, which is all
+// roadMatrixRoutes needs back from the solver.
+type optimizedOrder struct {
+	Algorithm string
+	Stops     []string
+	End       string
+	Meters    float64
+
+	// Dwell is the time spent at the stops along the way, which no
+	// matrix measures.
+	Dwell time.Duration
+}
+
+// errNoRoadRoute means a matrix left some stop unreachable, so it has no
+// ride to report.
+var errNoRoadRoute = errors.New("no road route reaches every stop")
+
+// matrixSource measures the road distance between every pair of a fixed set
+// of places, by bike or by car.
+type matrixSource func(ctx context.Context, byCar bool) (*places.RouteMatrix, error)
+
+// providerMatrix measures ids with the provider's route matrix. coords
+// locates them for providers that need it.
+func (h PlacesHandler) providerMatrix(ids []string, coords map[string]places.Coord) matrixSource {
+	return func(ctx context.Context, byCar bool) (*places.RouteMatrix, error) {
+		return h.api.RouteMatrix(ctx, places.RouteMatrixOptions{Places: ids, ByCar: byCar, Coords: coords})
+	}
+}
+
+// roadMatrixRoutes measures every pair of places by bike and by car, has
+// solve order the stops over each matrix, and reports the results in the
+// same shape Google's own optimization uses — one entry per finish, under
+// method. The origin is the first place the matrices hold.
+func (h PlacesHandler) roadMatrixRoutes(ctx context.Context, method string, source matrixSource, solve func(*places.RouteMatrix) (optimizedOrder, error)) ([]places.OptimalRoute, error) {
+	var bike, car *places.RouteMatrix
+
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		m, err := source(egCtx, false)
+		bike = m
+		return err
+	})
+
+	eg.Go(func() error {
+		m, err := source(egCtx, true)
+		car = m
+		return err
+	})
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	measure := func(m *places.RouteMatrix) (string, string, *places.OptimizeRouteResponse, error) {
+		o, err := solve(m)
+
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		index := make(map[string]int, len(m.Ids))
+		for i, id := range m.Ids {
+			index[id] = i
+		}
+
+		seconds := o.Dwell.Seconds()
+		prev := 0
+		for _, id := range append(o.Stops, o.End) {
+			seconds += m.Seconds[prev][index[id]]
+			prev = index[id]
+		}
+
+		// A pair with no road between them is +Inf, and a tour the solver
+		// could only close through one is no route at all.
+		if math.IsInf(o.Meters, 0) || math.IsNaN(o.Meters) || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+			return "", "", nil, errNoRoadRoute
+		}
+
+		return o.End, o.Algorithm, &places.OptimizeRouteResponse{
+			Order:           o.Stops,
+			Meters:          int64(o.Meters),
+			DisplayDistance: places.DisplayMiles(o.Meters),
+			DisplayDuration: places.DisplayDuration(seconds),
+			Seconds:         int64(math.Round(seconds)),
+			Profile:         m.Profile,
+		}, nil
+	}
+
+	bikeEnd, algorithm, bikeRoute, err := measure(bike)
+	if err != nil {
+		return nil, err
+	}
+
+	carEnd, _, carRoute, err := measure(car)
+	if err != nil {
+		return nil, err
+	}
+
+	bikeResult := places.OptimalRoute{Method: method, Algorithm: algorithm, End: bikeEnd, BikeRoute: bikeRoute}
+
+	if carEnd == bikeEnd {
+		bikeResult.CarRoute = carRoute
+		return []places.OptimalRoute{bikeResult}, nil
+	}
+
+	return []places.OptimalRoute{
+		bikeResult,
+		{Method: method, Algorithm: algorithm, End: carEnd, CarRoute: carRoute},
+	}, nil
+}
+
+// optimality says how close to the shortest order a solved route is, or
+// nothing when the solver could not tell.
+func optimality(bounded bool, gap float64) string {
+	switch {
+	case !bounded:
+		return ""
+	case gap < 0.0005:
+		// Anything that would round to 0.0% reads better as what it is.
+		return "optimal"
+	default:
+		return fmt.Sprintf("within %.1f%% of optimal", gap*100)
+	}
 }
 
 // HandleRouteLegs measures a route whose order the caller already decided, and
@@ -223,6 +1170,17 @@ func (h PlacesHandler) HandleRouteLegs(w http.ResponseWriter, r *http.Request) {
 		Stops       []string `json:"stops"`
 		Destination string   `json:"destination"`
 		ByCar       bool     `json:"byCar"`
+
+		// Places optionally locates the waypoints, so the offline road graph
+		// can measure the route when Google cannot.
+		Places []optimizeRoutePayloadPlace `json:"places"`
+
+		// Profile measures the route over the offline road graph under that
+		// profile, rather than asking Google. It needs Places.
+		Profile string `json:"profile"`
+
+		// Cues asks for a printable cue sheet with every leg.
+		Cues bool `json:"cues"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
@@ -230,17 +1188,86 @@ func (h PlacesHandler) HandleRouteLegs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	at := make(map[string]roads.Point, len(b.Places))
+
+	for i, p := range b.Places {
+		if err := p.validate(); err != nil {
+			WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("place at index %d %s", i, err.Error())), http.StatusBadRequest)
+			return
+		}
+
+		at[p.Id] = roads.Point{Lat: *p.Lat, Long: *p.Long}
+	}
+
 	opts := places.RouteLegsOptions{
 		Origin:      b.Origin,
 		Stops:       b.Stops,
 		Destination: b.Destination,
 		ByCar:       b.ByCar,
+		Cues:        b.Cues,
+		Coords:      coordsOf(b.Places),
 	}
 
-	googleMethodContext, cancel := context.WithTimeout(r.Context(), routeLegsTimeout)
-	defer cancel()
+	var res *places.RouteLegsResult
+	var provider string
+	var err error
+
+	if b.Profile != "" {
+		if b.ByCar {
+			WriteJSONResponse(w, NewResponse().WithMessage("'profile' cannot be combined with 'byCar'"), http.StatusBadRequest)
+			return
+		}
+
+		profile, profileErr := h.roadProfile(b.Profile)
+
+		if profileErr != nil {
+			WriteJSONResponse(w, NewResponse().WithMessage(profileErr.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if len(at) == 0 {
+			WriteJSONResponse(w, NewResponse().WithMessage("'profile' needs 'places' to route between"), http.StatusBadRequest)
+			return
+		}
+
+		offlineCtx, cancelOffline := context.WithTimeout(r.Context(), routeLegsTimeout)
+		defer cancelOffline()
+
+		res, err = h.offlineLegs(offlineCtx, opts, at, profile)
+		provider = offlineProvider
+	} else {
+		googleMethodContext, cancel := context.WithTimeout(r.Context(), routeLegsTimeout)
+		defer cancel()
+
+		res, err = h.api.RouteLegs(googleMethodContext, opts)
+		provider = h.api.Name()
+
+		if errors.Is(err, places.ErrCacheWrite) {
+			log.Printf("route legs: %v", err)
+			err = nil
+		}
 
-	res, err := h.api.RouteLegs(googleMethodContext, opts)
+		if err != nil && h.roads != nil && len(at) > 0 {
+			// Google may have used up the whole timeout, so the graph gets
+			// its own.
+			offlineCtx, cancelOffline := context.WithTimeout(r.Context(), routeLegsTimeout)
+			defer cancelOffline()
+
+			profile := roads.Bike
+			if opts.ByCar {
+				profile = roads.Car
+			}
+
+			offline, offlineErr := h.offlineLegs(offlineCtx, opts, at, profile)
+
+			if offlineErr == nil {
+				log.Printf("route legs failed, measured the offline road graph instead: %v", err)
+				res, err, provider = offline, nil, offlineProvider
+			} else {
+				log.Printf("route legs failed, and so did the offline road graph: %v", offlineErr)
+			}
+		}
+	}
 
 	if err != nil {
 		// Validation problems are the caller's fault; anything else is ours or
@@ -252,10 +1279,17 @@ func (h PlacesHandler) HandleRouteLegs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if msg, ok := refusedMessage(err, "Route measuring"); ok {
+			WriteJSONResponse(w, NewResponse().WithMessage(msg), http.StatusServiceUnavailable)
+			return
+		}
+
 		log.Printf("route legs failed: %v", err)
 		WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
 		return
 	}
 
+	res.Provider = provider
+
 	WriteJSONResponse(w, NewResponse().WithData(res), http.StatusOK)
 }
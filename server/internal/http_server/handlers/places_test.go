@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/nguyen/allycat/internal/places"
+	"github.com/nguyen/allycat/internal/tsp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -33,17 +40,22 @@ func decodeBody(t *testing.T, rec *httptest.ResponseRecorder) (message string, d
 	return message, body.Data
 }
 
-// handlerWith builds a handler whose Google calls are served by fn.
-func handlerWith(t *testing.T, fn http.HandlerFunc) (PlacesHandler, func()) {
+// handlerWith builds a handler whose Google calls are served by fn. Each
+// call is sent once, so fn sees exactly the requests the handler makes.
+func handlerWith(t *testing.T, fn http.HandlerFunc, opts ...places.Option) (PlacesHandler, func()) {
 	t.Helper()
 
 	ts := httptest.NewServer(fn)
 
 	api, err := places.NewPlacesApi(
 		"test-key",
-		places.WithSearchTextURL(ts.URL),
-		places.WithComputeRoutesURL(ts.URL),
-		places.WithHTTPClient(ts.Client()),
+		append([]places.Option{
+			places.WithSearchTextURL(ts.URL),
+			places.WithComputeRoutesURL(ts.URL),
+			places.WithComputeRouteMatrixURL(ts.URL),
+			places.WithHTTPClient(ts.Client()),
+			places.WithRetries(0),
+		}, opts...)...,
 	)
 	require.NoError(t, err)
 
@@ -127,6 +139,11 @@ func TestOptimizeRoutePayloadPlaceValidate(t *testing.T) {
 			name:  "zero coordinates are valid",
 			place: optimizeRoutePayloadPlace{Id: "x", Lat: ptr(0.0), Long: ptr(0.0)},
 		},
+		{
+			name:    "negative dwell",
+			place:   optimizeRoutePayloadPlace{Id: "x", Lat: ptr(1.0), Long: ptr(2.0), DwellMinutes: ptr(-1.0)},
+			wantErr: "'dwellMinutes' cannot be negative",
+		},
 	}
 
 	for _, tt := range tests {
@@ -266,6 +283,96 @@ func TestHandleTextSearchReportsUpstreamFailure(t *testing.T) {
 	assert.Contains(t, msg, "API key expired")
 }
 
+func TestHandleTextSearchReportsCacheHits(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1","displayName":{"text":"City Hall"}}]}`))
+	}))
+	defer ts.Close()
+
+	cache, err := places.NewTextSearchCache()
+	require.NoError(t, err)
+
+	api, err := places.NewPlacesApi(
+		"test-key",
+		places.WithSearchTextURL(ts.URL),
+		places.WithHTTPClient(ts.Client()),
+		places.WithTextSearchCache(cache),
+	)
+	require.NoError(t, err)
+
+	h := NewPlacesHandler(api)
+
+	for _, want := range []string{"MISS", "HIT"} {
+		rec := httptest.NewRecorder()
+		h.HandleTextSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query":"city hall"}`)))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, want, rec.Header().Get("X-Cache"))
+
+		_, data := decodeBody(t, rec)
+		assert.Contains(t, string(data), `"p1"`, "the places are the data either way")
+	}
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestHandleTextSearchWhileGoogleIsDown(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, places.WithCircuitBreaker(1, time.Hour))
+	defer closeFn()
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		h.HandleTextSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query":"city hall"}`)))
+
+		assert.Equal(t, want, rec.Code)
+	}
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestHandleTextSearchOverBudgetServesOnlyTheCache(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	cache, err := places.NewTextSearchCache()
+	require.NoError(t, err)
+
+	// Room for one search.
+	meter, err := places.NewMeter(places.WithDailyBudget(0.04))
+	require.NoError(t, err)
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1"}]}`))
+	}, places.WithTextSearchCache(cache), places.WithMeter(meter))
+	defer closeFn()
+
+	search := func(q string) int {
+		rec := httptest.NewRecorder()
+		h.HandleTextSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query":"`+q+`"}`)))
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, search("city hall"))
+	assert.Equal(t, http.StatusServiceUnavailable, search("lemon hill"))
+	assert.Equal(t, http.StatusOK, search("city hall"), "from the cache")
+	assert.Equal(t, int32(1), calls.Load())
+}
+
 // --- HandleOptimizeRoute --------------------------------------------------
 
 const validOptimizeBody = `{
@@ -327,11 +434,126 @@ func TestHandleOptimizeRouteValidation(t *testing.T) {
 			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2}]}`,
 			wantMsg: "At least two stops are required",
 		},
+		{
+			name:    "unknown metric",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"metric":"manhattan"}`,
+			wantMsg: `unknown distance metric "manhattan"`,
+		},
+		{
+			name:    "unknown travel mode",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"mode":"skateboard"}`,
+			wantMsg: `unknown travel mode "skateboard"`,
+		},
 		{
 			name:    "no stops",
 			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[]}`,
 			wantMsg: "At least two stops are required",
 		},
+		{
+			name:    "checkpoint closes before it opens",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2,"opensAt":"2026-05-02T19:00:00Z","closesAt":"2026-05-02T18:00:00Z"}]}`,
+			wantMsg: "stop at index 1 'closesAt' is before 'opensAt'",
+		},
+		{
+			name:    "opening hours without a start time",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2,"closesAt":"2026-05-02T18:00:00Z"}]}`,
+			wantMsg: "'startTime' is required when any place has opening hours",
+		},
+		{
+			name:    "delivery without a dropoff",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"deliveries":[{"pickup":"a"}]}`,
+			wantMsg: "delivery at index 0 needs both 'pickup' and 'dropoff'",
+		},
+		{
+			name:    "precedence on an unknown stop",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"precedence":[{"before":"a","after":"z"}]}`,
+			wantMsg: `invalid precedence constraint: "a" before "z": no place "z" on the route`,
+		},
+		{
+			name:    "cyclic precedence",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"deliveries":[{"pickup":"a","dropoff":"b"}],"precedence":[{"before":"b","after":"a"}]}`,
+			wantMsg: "invalid precedence constraint: cycle a -> b -> a",
+		},
+		{
+			name:    "negative points",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2,"points":-1},{"id":"b","latitude":1,"longitude":2}],"budgetMeters":1000}`,
+			wantMsg: "stop at index 0 'points' cannot be negative",
+		},
+		{
+			name:    "points without a budget",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2,"points":3},{"id":"b","latitude":1,"longitude":2}]}`,
+			wantMsg: "'points' only count with 'budgetMeters' or 'budgetMinutes'",
+		},
+		{
+			name:    "empty budget",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"budgetMinutes":0}`,
+			wantMsg: "A budget must be positive",
+		},
+		{
+			name:    "budget with opening hours",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2,"closesAt":"2026-05-02T18:00:00Z"},{"id":"b","latitude":1,"longitude":2}],"startTime":"2026-05-02T17:00:00Z","budgetMeters":1000}`,
+			wantMsg: "A budget cannot be combined with opening hours",
+		},
+		{
+			name:    "too many alternatives",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"alternatives":5}`,
+			wantMsg: "'alternatives' must be between 0 and 4",
+		},
+		{
+			name:    "loop with a destination",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"destination":{"id":"e","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"loop":true}`,
+			wantMsg: "A loop finishes at the origin, so it cannot have a destination",
+		},
+		{
+			name:    "standing still",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"speedKph":0}`,
+			wantMsg: "'speedKph' must be positive",
+		},
+		{
+			name:    "negative dwell",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"dwellMinutes":-2}`,
+			wantMsg: "'dwellMinutes' cannot be negative",
+		},
+		{
+			name:    "negative riders",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"riders":-1}`,
+			wantMsg: "'riders' cannot be negative",
+		},
+		{
+			name:    "fewer riders than starts",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"riders":1,"riderStarts":[{"id":"r1","latitude":1,"longitude":2},{"id":"r2","latitude":1,"longitude":2}]}`,
+			wantMsg: "'riders' is fewer than 'riderStarts'",
+		},
+		{
+			name:    "rider start without coordinates",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"riderStarts":[{"id":"r1","latitude":1}]}`,
+			wantMsg: "rider start at index 0 'longitude' is required",
+		},
+		{
+			name:    "team with a budget",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"riders":2,"budgetMeters":1000}`,
+			wantMsg: "A budget cannot be combined with a team",
+		},
+		{
+			name:    "team with opening hours",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2,"closesAt":"2026-05-02T18:00:00Z"},{"id":"b","latitude":1,"longitude":2}],"startTime":"2026-05-02T17:00:00Z","riders":2}`,
+			wantMsg: "Opening hours cannot be combined with a team",
+		},
+		{
+			name:    "team with alternatives",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"riders":2,"alternatives":1}`,
+			wantMsg: "Alternatives cannot be combined with a team",
+		},
+		{
+			name:    "opening hours with alternatives",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2,"closesAt":"2026-05-02T18:00:00Z"},{"id":"b","latitude":1,"longitude":2}],"startTime":"2026-05-02T17:00:00Z","alternatives":2}`,
+			wantMsg: "Alternatives cannot be combined with opening hours",
+		},
+		{
+			name:    "too many riders",
+			body:    `{"origin":{"id":"s","latitude":1,"longitude":2},"stops":[{"id":"a","latitude":1,"longitude":2},{"id":"b","latitude":1,"longitude":2}],"riders":40}`,
+			wantMsg: "too many riders: at most 6, got 40",
+		},
 	}
 
 	for _, tt := range tests {
@@ -356,6 +578,127 @@ func TestHandleOptimizeRouteValidation(t *testing.T) {
 	}
 }
 
+func TestHandleOptimizeRouteRejectsDuplicateIds(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("upstream must not be called for a route the solver refuses")
+	})
+	defer closeFn()
+
+	body := `{"origin":{"id":"s","latitude":39.95,"longitude":-75.18},"stops":[{"id":"a","latitude":39.96,"longitude":-75.18},{"id":"a","latitude":39.97,"longitude":-75.18}]}`
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	msg, _ := decodeBody(t, rec)
+	assert.Equal(t, `duplicate place id: "a"`, msg)
+}
+
+func TestHandleOptimizeRouteStopsWithTheRequest(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("a cancelled request must not reach Google")
+	})
+	defer closeFn()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody)).WithContext(ctx)
+
+	h.HandleOptimizeRoute(rec, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestWriteSolverError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err     error
+		want    int
+		wantMsg string
+	}{
+		{fmt.Errorf("%w: at most 250, got 300", tsp.ErrTooManyStops), http.StatusBadRequest, "too many stops: at most 250, got 300"},
+		{fmt.Errorf("%w: place %q", tsp.ErrInvalidCoordinates, "a"), http.StatusBadRequest, `invalid coordinates: place "a"`},
+		{tsp.ErrPrizeTooManyStops, http.StatusBadRequest, "too many stops for prize mode"},
+		{fmt.Errorf("%w: a budget", tsp.ErrTeamUnsupported), http.StatusBadRequest, "not supported for a team: a budget"},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, "Timed out optimizing the route"},
+		{tsp.ErrNoRoute, http.StatusInternalServerError, "Could not optimize the route"},
+		{errors.New("boom"), http.StatusInternalServerError, "Could not optimize the route"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		writeSolverError(rec, tt.err)
+
+		assert.Equal(t, tt.want, rec.Code, "%v", tt.err)
+
+		msg, _ := decodeBody(t, rec)
+		assert.Equal(t, tt.wantMsg, msg)
+	}
+}
+
+func TestHandleOptimizeRouteSplitsATeam(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("Google routes one vehicle at a time, so a team is never sent there")
+	})
+	defer closeFn()
+
+	// Two stops east of the start and two west, and the team meets back at
+	// the start.
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+		"stops":[
+			{"id":"e1","latitude":39.95,"longitude":-75.15},
+			{"id":"w1","latitude":39.95,"longitude":-75.17},
+			{"id":"e2","latitude":39.951,"longitude":-75.149},
+			{"id":"w2","latitude":39.951,"longitude":-75.171}
+		],
+		"loop":true,
+		"riders":2
+	}`
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method string `json:"method"`
+		Rider  int    `json:"rider"`
+		End    string `json:"destination"`
+		Bike   *struct {
+			Order []string `json:"order"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2)
+
+	for i, route := range got {
+		assert.Equal(t, "tsp", route.Method)
+		assert.Equal(t, i+1, route.Rider)
+		assert.Equal(t, "start", route.End)
+		require.NotNil(t, route.Bike)
+		require.Len(t, route.Bike.Order, 2)
+		assert.Equal(t, route.Bike.Order[0][:1], route.Bike.Order[1][:1], "one rider per side")
+	}
+}
+
 func TestHandleOptimizeRouteReturnsSolverResultEvenWhenGoogleFails(t *testing.T) {
 	t.Parallel()
 
@@ -397,6 +740,66 @@ func TestHandleOptimizeRouteReturnsSolverResultEvenWhenGoogleFails(t *testing.T)
 	assert.Nil(t, got[0].Car, "the solver models bikes only")
 }
 
+func TestHandleOptimizeRouteReportsSolverDurations(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	// A kilometre or so between each place at 18 km/h is 200s a leg, and two
+	// minutes at a but none at b.
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+		"stops":[
+			{"id":"a","latitude":39.959,"longitude":-75.16},
+			{"id":"b","latitude":39.968,"longitude":-75.16,"dwellMinutes":0}
+		],
+		"destination":{"id":"end","latitude":39.977,"longitude":-75.16},
+		"speedKph":18,
+		"dwellMinutes":2
+	}`
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Bike *struct {
+			Order           []string `json:"order"`
+			Seconds         int64    `json:"seconds"`
+			DisplayDuration string   `json:"displayDuration"`
+			Etas            []struct {
+				Id      string `json:"id"`
+				Seconds int64  `json:"seconds"`
+			} `json:"etas"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	require.NotNil(t, got[0].Bike)
+
+	bike := got[0].Bike
+	assert.Equal(t, []string{"a", "b"}, bike.Order)
+	assert.InDelta(t, 720, bike.Seconds, 5)
+	assert.Equal(t, "12 mins", bike.DisplayDuration)
+
+	require.Len(t, bike.Etas, 3, "one per stop and then the destination")
+	assert.Equal(t, "a", bike.Etas[0].Id)
+	assert.InDelta(t, 200, bike.Etas[0].Seconds, 5)
+	assert.Equal(t, "b", bike.Etas[1].Id)
+	assert.InDelta(t, 520, bike.Etas[1].Seconds, 5)
+	assert.Equal(t, "end", bike.Etas[2].Id)
+	assert.Equal(t, bike.Seconds, bike.Etas[2].Seconds, "the destination only dwells if it says so")
+}
+
 func TestHandleOptimizeRouteIncludesGoogleRoutesOnSuccess(t *testing.T) {
 	t.Parallel()
 
@@ -496,58 +899,992 @@ func TestHandleOptimizeRouteWorksWithoutDestination(t *testing.T) {
 	assert.NotContains(t, got[0].Bike.Order, got[0].End)
 }
 
-// --- HandleRouteLegs ------------------------------------------------------
+func TestHandleOptimizeRouteLoopFinishesAtOrigin(t *testing.T) {
+	t.Parallel()
 
-func legsUpstream(meters []int64) http.HandlerFunc {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		legs := make([]map[string]any, 0, len(meters))
-		var total int64
+	var destinations []string
+	var mu sync.Mutex
 
-		for _, m := range meters {
-			total += m
-			legs = append(legs, map[string]any{
-				"distanceMeters": m,
-				"localizedValues": map[string]any{
-					"distance": map[string]any{"text": "1.0 mi"},
-					"duration": map[string]any{"text": "5 mins"},
-				},
-			})
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Destination struct {
+				Id string `json:"placeId"`
+			} `json:"destination"`
 		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
 
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"routes": []map[string]any{{
-				"distanceMeters": total,
-				"localizedValues": map[string]any{
-					"distance": map[string]any{"text": "3.0 mi"},
-					"duration": map[string]any{"text": "15 mins"},
-				},
-				"legs": legs,
-			}},
-		})
-	}
-}
-
-func TestHandleRouteLegsReturnsPerHopDistances(t *testing.T) {
-	t.Parallel()
+		mu.Lock()
+		destinations = append(destinations, body.Destination.Id)
+		mu.Unlock()
 
-	h, closeFn := handlerWith(t, legsUpstream([]int64{100, 200, 300}))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
 	defer closeFn()
 
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"b","latitude":39.97,"longitude":-75.20},
+			{"id":"c","latitude":39.98,"longitude":-75.21}
+		],
+		"loop":true
+	}`
+
 	rec := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(
-		`{"origin":"start","stops":["a","b"],"destination":"end"}`))
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
 
-	h.HandleRouteLegs(rec, req)
+	h.HandleOptimizeRoute(rec, req)
 
 	require.Equal(t, http.StatusOK, rec.Code)
 
+	// One closed tour per vehicle, not a request per candidate finish.
+	assert.Equal(t, []string{"start", "start"}, destinations)
+
 	_, data := decodeBody(t, rec)
 
-	var got struct {
-		Legs []struct {
-			FromId string `json:"fromId"`
-			ToId   string `json:"toId"`
-			Meters int64  `json:"meters"`
+	var got []struct {
+		End  string `json:"destination"`
+		Bike *struct {
+			Order  []string `json:"order"`
+			Meters int64    `json:"meters"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "start", got[0].End)
+	require.NotNil(t, got[0].Bike)
+	// The stops lie on a line, so the tour runs along it one way or the
+	// other; either way the origin is not listed again.
+	require.Len(t, got[0].Bike.Order, 3)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, got[0].Bike.Order)
+	assert.Equal(t, "b", got[0].Bike.Order[1])
+
+	// Out along the diagonal and straight back: twice the way out.
+	assert.InDelta(t, 2*4222, got[0].Bike.Meters, 50)
+}
+
+func TestHandleOptimizeRouteReportsSolverAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method     string `json:"method"`
+		Algorithm  string `json:"algorithm"`
+		Optimality string `json:"optimality"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "exact", got[0].Algorithm, "two stops are well inside the exact solver's reach")
+	assert.Equal(t, "optimal", got[0].Optimality)
+}
+
+func TestHandleOptimizeRouteHonoursExactStopLimit(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	WithExactStopLimit(1)(&h)
+
+	rec := httptest.NewRecorder()
+	h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Algorithm string `json:"algorithm"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "heuristic", got[0].Algorithm, "two stops are past a limit of one")
+}
+
+func TestOptimality(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		bounded bool
+		gap     float64
+		want    string
+	}{
+		{"unbounded", false, 0, ""},
+		{"proven", true, 0, "optimal"},
+		{"rounds to nothing", true, 0.0004, "optimal"},
+		{"a few percent", true, 0.0312, "within 3.1% of optimal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, optimality(tt.bounded, tt.gap))
+		})
+	}
+}
+
+func TestHandleOptimizeRouteReturnsRankedAlternatives(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	// All four places in validOptimizeBody sit on one line, where both
+	// orders tie, so finish somewhere off it.
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"b","latitude":39.97,"longitude":-75.20}
+		],
+		"destination":{"id":"end","latitude":39.97,"longitude":-75.17},
+		"alternatives":3
+	}`
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method    string `json:"method"`
+		Rank      int    `json:"rank"`
+		GapMeters int64  `json:"gapMeters"`
+		Bike      struct {
+			Order  []string `json:"order"`
+			Meters int64    `json:"meters"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	// Two stops between a fixed start and end can only be ridden two ways,
+	// however many alternatives are asked for.
+	require.Len(t, got, 2)
+
+	for i, r := range got {
+		assert.Equal(t, "tsp", r.Method)
+		assert.Equal(t, i+1, r.Rank)
+	}
+
+	assert.Zero(t, got[0].GapMeters)
+	assert.Positive(t, got[1].GapMeters)
+	assert.InDelta(t, got[1].Bike.Meters-got[0].Bike.Meters, got[1].GapMeters, 1)
+	assert.Equal(t, []string{got[0].Bike.Order[1], got[0].Bike.Order[0]}, got[1].Bike.Order)
+}
+
+func TestHandleOptimizeRouteWithoutAlternativesLeavesRankOut(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []map[string]any
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	assert.NotContains(t, got[0], "rank")
+	assert.NotContains(t, got[0], "gapMeters")
+}
+
+func TestHandleOptimizeRouteRefusesAlternativesOverTooManyStops(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	stops := make([]string, 0, 15)
+	for i := range 15 {
+		stops = append(stops, fmt.Sprintf(`{"id":"p%d","latitude":%f,"longitude":-75.16}`, i, 39.95+float64(i)/1000))
+	}
+
+	body := `{"origin":{"id":"start","latitude":39.94,"longitude":-75.17},"alternatives":2,"stops":[` + strings.Join(stops, ",") + `]}`
+
+	rec := httptest.NewRecorder()
+	h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	msg, _ := decodeBody(t, rec)
+	assert.Equal(t, "too many stops for alternatives: at most 14, got 15", msg)
+}
+
+func TestHandleOptimizeRouteAcceptsGeodesicMetric(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	body := strings.Replace(validOptimizeBody, `"stops"`, `"metric":"vincenty","stops"`, 1)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Bike *struct {
+			Order  []string `json:"order"`
+			Meters int64    `json:"meters"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	require.NotNil(t, got[0].Bike)
+	assert.ElementsMatch(t, []string{"a", "b"}, got[0].Bike.Order)
+	assert.Positive(t, got[0].Bike.Meters)
+}
+
+// matrixUpstream answers computeRouteMatrix requests with a line of places
+// where each hop costs 100m by bike and 300m by car, and fails anything else.
+func matrixUpstream(t *testing.T, computeRoutesCalls *atomic.Int32) http.HandlerFunc {
+	t.Helper()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+
+		var body struct {
+			Origins []json.RawMessage `json:"origins"`
+			Vehicle string            `json:"travelMode"`
+		}
+		_ = json.Unmarshal(raw, &body)
+
+		if body.Origins == nil {
+			computeRoutesCalls.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		hop := int64(100)
+		if body.Vehicle == "DRIVE" {
+			hop = 300
+		}
+
+		elements := make([]map[string]any, 0)
+		for i := range body.Origins {
+			for j := range body.Origins {
+				gap := int64(i - j)
+				if gap < 0 {
+					gap = -gap
+				}
+
+				elements = append(elements, map[string]any{
+					"originIndex":      i,
+					"destinationIndex": j,
+					"condition":        "ROUTE_EXISTS",
+					"distanceMeters":   gap * hop,
+					"duration":         fmt.Sprintf("%ds", gap*60),
+				})
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(elements)
+	}
+}
+
+func TestHandleOptimizeRouteWithoutDestinationSolvesOverRoadMatrix(t *testing.T) {
+	t.Parallel()
+
+	var computeRoutesCalls atomic.Int32
+
+	h, closeFn := handlerWith(t, matrixUpstream(t, &computeRoutesCalls))
+	defer closeFn()
+
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"b","latitude":39.97,"longitude":-75.20},
+			{"id":"c","latitude":39.98,"longitude":-75.21}
+		]
+	}`
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Zero(t, computeRoutesCalls.Load(), "no per-finish fan-out when the matrix answers")
+
+	_, data := decodeBody(t, rec)
+
+	type leg struct {
+		Order           []string `json:"order"`
+		Meters          int64    `json:"meters"`
+		DisplayDuration string   `json:"displayDuration"`
+	}
+
+	var got []struct {
+		Method    string `json:"method"`
+		Algorithm string `json:"algorithm"`
+		End       string `json:"destination"`
+		Bike      *leg   `json:"bike"`
+		Car       *leg   `json:"car"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2, "solver result plus the road-matrix result")
+
+	matrix := got[1]
+	assert.Equal(t, "matrix", matrix.Method)
+	assert.Equal(t, "exact", matrix.Algorithm)
+
+	// The stub lays the places out on a line, so the only sensible ride is
+	// straight along it.
+	assert.Equal(t, "c", matrix.End)
+	require.NotNil(t, matrix.Bike)
+	require.NotNil(t, matrix.Car)
+	assert.Equal(t, []string{"a", "b"}, matrix.Bike.Order)
+	assert.Equal(t, int64(300), matrix.Bike.Meters)
+	assert.Equal(t, int64(900), matrix.Car.Meters)
+	assert.Equal(t, "3 mins", matrix.Bike.DisplayDuration)
+}
+
+func TestHandleOptimizeRouteIgnoresAMatrixWithNoRoute(t *testing.T) {
+	t.Parallel()
+
+	var computeRoutesCalls atomic.Int32
+
+	line := matrixUpstream(t, &computeRoutesCalls)
+
+	// The same line, but nothing can reach the last place.
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		line(rec, r)
+
+		var elements []map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &elements))
+
+		for _, e := range elements {
+			if e["destinationIndex"] == float64(3) {
+				e["condition"] = "ROUTE_NOT_FOUND"
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(elements)
+	})
+	defer closeFn()
+
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"b","latitude":39.97,"longitude":-75.20},
+			{"id":"c","latitude":39.98,"longitude":-75.21}
+		]
+	}`
+
+	rec := httptest.NewRecorder()
+	h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "Inf")
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method string `json:"method"`
+		Bike   *struct {
+			Meters int64 `json:"meters"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1, "only the solver's own route")
+	assert.Equal(t, "tsp", got[0].Method)
+	require.NotNil(t, got[0].Bike)
+	assert.Positive(t, got[0].Bike.Meters)
+}
+
+func TestHandleOptimizeRouteRoadMatrixCountsDwell(t *testing.T) {
+	t.Parallel()
+
+	var computeRoutesCalls atomic.Int32
+
+	h, closeFn := handlerWith(t, matrixUpstream(t, &computeRoutesCalls))
+	defer closeFn()
+
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"b","latitude":39.97,"longitude":-75.20,"dwellMinutes":5},
+			{"id":"c","latitude":39.98,"longitude":-75.21}
+		],
+		"dwellMinutes":1
+	}`
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method string `json:"method"`
+		Bike   *struct {
+			Seconds         int64  `json:"seconds"`
+			DisplayDuration string `json:"displayDuration"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2)
+	assert.Equal(t, "matrix", got[1].Method)
+	require.NotNil(t, got[1].Bike)
+
+	// Three minutes of riding, then a minute at a, five at b and one at c.
+	assert.Equal(t, int64(600), got[1].Bike.Seconds)
+	assert.Equal(t, "10 mins", got[1].Bike.DisplayDuration)
+}
+
+func TestHandleOptimizeRouteGoogleCountsDwell(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"routes":[{"distanceMeters":4242,"duration":"180s","optimizedIntermediateWaypointIndex":[1,0],
+			"localizedValues":{"duration":{"text":"3 mins"}}}]}`))
+	})
+	defer closeFn()
+
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"b","latitude":39.97,"longitude":-75.20,"dwellMinutes":5}
+		],
+		"destination":{"id":"end","latitude":39.94,"longitude":-75.17,"dwellMinutes":2},
+		"dwellMinutes":1
+	}`
+
+	rec := httptest.NewRecorder()
+	h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []places.OptimalRoute
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2)
+	require.NotNil(t, got[1].BikeRoute)
+	require.NotNil(t, got[1].CarRoute)
+
+	// Three minutes of riding, then five at b, one at a and two at the end,
+	// as the solver would count them.
+	assert.Equal(t, []string{"b", "a"}, got[1].BikeRoute.Order)
+	assert.Equal(t, int64(660), got[1].BikeRoute.Seconds)
+	assert.Equal(t, "11 mins", got[1].BikeRoute.DisplayDuration)
+	assert.Equal(t, int64(660), got[1].CarRoute.Seconds)
+}
+
+func TestHandleOptimizeRouteTimesTheSolverInTheTravelMode(t *testing.T) {
+	t.Parallel()
+
+	h := NewPlacesHandler(places.NewFake())
+
+	solved := func(mode string) places.OptimalRoute {
+		t.Helper()
+
+		body := strings.Replace(validOptimizeBody, `"origin"`, `"mode":"`+mode+`","origin"`, 1)
+
+		rec := httptest.NewRecorder()
+		h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body)))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		_, data := decodeBody(t, rec)
+
+		var got []places.OptimalRoute
+		require.NoError(t, json.Unmarshal(data, &got))
+		require.NotEmpty(t, got)
+		require.Equal(t, "tsp", got[0].Method)
+
+		return got[0]
+	}
+
+	bike, car, foot := solved("bike"), solved("car"), solved("foot")
+
+	require.NotNil(t, bike.BikeRoute)
+	assert.Nil(t, bike.CarRoute)
+
+	require.NotNil(t, car.CarRoute)
+	assert.Nil(t, car.BikeRoute, "a car route is not a bike route")
+	assert.Equal(t, bike.BikeRoute.Meters, car.CarRoute.Meters)
+	assert.Less(t, car.CarRoute.Seconds, bike.BikeRoute.Seconds)
+
+	require.NotNil(t, foot.FootRoute)
+	assert.Greater(t, foot.FootRoute.Seconds, bike.BikeRoute.Seconds)
+}
+
+func TestHandleOptimizeRouteSkipsGoogleWhileItIsDown(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, places.WithCircuitBreaker(1, time.Hour))
+	defer closeFn()
+
+	optimize := func() []places.OptimalRoute {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody)))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		_, data := decodeBody(t, rec)
+
+		var got []places.OptimalRoute
+		require.NoError(t, json.Unmarshal(data, &got))
+
+		return got
+	}
+
+	optimize()
+	require.ErrorIs(t, h.api.Available(), places.ErrCircuitOpen)
+
+	opened := calls.Load()
+
+	got := optimize()
+	require.Len(t, got, 1)
+	assert.Equal(t, "tsp", got[0].Method)
+
+	assert.Equal(t, opened, calls.Load(), "Google was not asked again")
+}
+
+func TestHandleOptimizeRouteSkipsGoogleOverBudget(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	// Enough for the bike's and the car's optimized route, once.
+	meter, err := places.NewMeter(places.WithDailyBudget(0.02))
+	require.NoError(t, err)
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"routes":[{"distanceMeters":4242,"optimizedIntermediateWaypointIndex":[0,1]}]}`))
+	}, places.WithMeter(meter))
+	defer closeFn()
+
+	for _, want := range []int{2, 1} {
+		rec := httptest.NewRecorder()
+		h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody)))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		_, data := decodeBody(t, rec)
+
+		var got []places.OptimalRoute
+		require.NoError(t, json.Unmarshal(data, &got))
+		assert.Len(t, got, want)
+	}
+
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestHandleOptimizeRouteWithDestinationSkipsRoadMatrix(t *testing.T) {
+	t.Parallel()
+
+	var computeRoutesCalls atomic.Int32
+
+	h, closeFn := handlerWith(t, matrixUpstream(t, &computeRoutesCalls))
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// One waypoint optimization per vehicle is already the cheap option.
+	assert.Equal(t, int32(2), computeRoutesCalls.Load())
+}
+
+// timedOptimizeBody has a checkpoint that opens late and one that closes
+// early. Riding at 18 km/h, a is about 220s from the start and b about 445s,
+// so b has to come first even though a is on the way.
+const timedOptimizeBody = `{
+	"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+	"stops":[
+		{"id":"a","latitude":39.96,"longitude":-75.18,"opensAt":"2026-05-02T18:30:00Z"},
+		{"id":"b","latitude":39.97,"longitude":-75.18,"closesAt":"2026-05-02T18:%s:00Z"}
+	],
+	"startTime":"2026-05-02T18:00:00Z",
+	"speedKph":18
+}`
+
+type timedRoute struct {
+	Method string `json:"method"`
+	End    string `json:"destination"`
+	Bike   struct {
+		Order    []string `json:"order"`
+		Arrivals []struct {
+			Id          string    `json:"id"`
+			At          time.Time `json:"arrivesAt"`
+			WaitSeconds int64     `json:"waitSeconds"`
+			Late        bool      `json:"late"`
+		} `json:"arrivals"`
+	} `json:"bike"`
+}
+
+func TestHandleOptimizeRouteHonoursCheckpointHours(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("Google cannot honour checkpoint hours, so it must not be asked")
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(fmt.Sprintf(timedOptimizeBody, "10")))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []timedRoute
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "tsp", got[0].Method)
+	assert.Equal(t, []string{"b"}, got[0].Bike.Order)
+	assert.Equal(t, "a", got[0].End)
+
+	arrivals := got[0].Bike.Arrivals
+	require.Len(t, arrivals, 2, "the finish is the last stop, so it is not repeated")
+
+	start := time.Date(2026, 5, 2, 18, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "b", arrivals[0].Id)
+	assert.WithinDuration(t, start.Add(445*time.Second), arrivals[0].At, 5*time.Second)
+	assert.False(t, arrivals[0].Late)
+
+	assert.Equal(t, "a", arrivals[1].Id)
+	assert.WithinDuration(t, start.Add(667*time.Second), arrivals[1].At, 5*time.Second)
+	assert.InDelta(t, 1800-667, arrivals[1].WaitSeconds, 5, "waits outside until 18:30")
+}
+
+func TestHandleOptimizeRouteReportsUnreachableCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("Google cannot honour checkpoint hours, so it must not be asked")
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(fmt.Sprintf(timedOptimizeBody, "05")))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	msg, data := decodeBody(t, rec)
+	assert.Equal(t, "No order reaches every checkpoint while it is open; late at b", msg)
+
+	var got []timedRoute
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1, "the least late attempt still comes back")
+	require.Len(t, got[0].Bike.Arrivals, 2)
+	assert.Equal(t, "b", got[0].Bike.Arrivals[0].Id)
+	assert.True(t, got[0].Bike.Arrivals[0].Late)
+}
+
+func TestHandleOptimizeRouteWithStartTimeOnlyStillAsksGoogle(t *testing.T) {
+	t.Parallel()
+
+	var computeRoutesCalls atomic.Int32
+
+	h, closeFn := handlerWith(t, matrixUpstream(t, &computeRoutesCalls))
+	defer closeFn()
+
+	body := strings.Replace(validOptimizeBody, `"stops"`, `"startTime":"2026-05-02T18:00:00Z","stops"`, 1)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(2), computeRoutesCalls.Load(), "no checkpoint hours, so Google's answer is still useful")
+
+	_, data := decodeBody(t, rec)
+
+	var got []timedRoute
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.NotEmpty(t, got)
+	assert.Len(t, got[0].Bike.Arrivals, 3, "two stops and the destination")
+}
+
+func TestHandleOptimizeRouteWithDeliveriesSkipsGoogleOptimization(t *testing.T) {
+	t.Parallel()
+
+	var computeRoutesCalls atomic.Int32
+
+	h, closeFn := handlerWith(t, matrixUpstream(t, &computeRoutesCalls))
+	defer closeFn()
+
+	body := strings.Replace(validOptimizeBody, `"stops"`, `"deliveries":[{"pickup":"b","dropoff":"a"}],"stops"`, 1)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Zero(t, computeRoutesCalls.Load(), "Google would ignore the delivery")
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method string `json:"method"`
+		Bike   struct {
+			Order []string `json:"order"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "tsp", got[0].Method)
+	assert.Equal(t, []string{"b", "a"}, got[0].Bike.Order)
+}
+
+func TestHandleOptimizeRouteRoadMatrixKeepsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	var computeRoutesCalls atomic.Int32
+
+	h, closeFn := handlerWith(t, matrixUpstream(t, &computeRoutesCalls))
+	defer closeFn()
+
+	// Straight along the line would finish at c; the item for a is picked up
+	// at c, so the ride has to come back. Passing b on the way out or back
+	// costs the same.
+	body := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"b","latitude":39.97,"longitude":-75.20},
+			{"id":"c","latitude":39.98,"longitude":-75.21}
+		],
+		"deliveries":[{"pickup":"c","dropoff":"a"}]
+	}`
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method string `json:"method"`
+		End    string `json:"destination"`
+		Bike   *struct {
+			Order []string `json:"order"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2, "solver result plus the road-matrix result")
+
+	for _, r := range got {
+		assert.Equal(t, "a", r.End, r.Method)
+		require.NotNil(t, r.Bike)
+		assert.ElementsMatch(t, []string{"b", "c"}, r.Bike.Order, r.Method)
+	}
+}
+
+// prizeOptimizeBody scores b highest but puts c the other way from a and b,
+// 1.1km south of the start where a is 1.1km north and b 2.2km north.
+const prizeOptimizeBody = `{
+	"origin":{"id":"start","latitude":39.95,"longitude":-75.18},
+	"stops":[
+		{"id":"a","latitude":39.96,"longitude":-75.18,"points":1},
+		{"id":"b","latitude":39.97,"longitude":-75.18,"points":5},
+		{"id":"c","latitude":39.94,"longitude":-75.18,"points":3}
+	],
+	"budgetMeters":%d
+}`
+
+func TestHandleOptimizeRoutePrizeModeReturnsRankedSubsets(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("Google cannot skip stops, so it must not be asked")
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(fmt.Sprintf(prizeOptimizeBody, 2500)))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method  string   `json:"method"`
+		End     string   `json:"destination"`
+		Rank    int      `json:"rank"`
+		Points  int      `json:"points"`
+		Skipped []string `json:"skipped"`
+		Bike    struct {
+			Order  []string `json:"order"`
+			Meters int64    `json:"meters"`
+		} `json:"bike"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	// a alone is as far as c for fewer points, so it is not a trade-off.
+	require.Len(t, got, 2)
+
+	assert.Equal(t, 1, got[0].Rank)
+	assert.Equal(t, 6, got[0].Points)
+	assert.Equal(t, []string{"a"}, got[0].Bike.Order)
+	assert.Equal(t, "b", got[0].End)
+	assert.Equal(t, []string{"c"}, got[0].Skipped)
+
+	assert.Equal(t, 2, got[1].Rank)
+	assert.Equal(t, 3, got[1].Points)
+	assert.Equal(t, "c", got[1].End)
+	assert.ElementsMatch(t, []string{"a", "b"}, got[1].Skipped)
+	assert.Less(t, got[1].Bike.Meters, got[0].Bike.Meters)
+}
+
+func TestHandleOptimizeRoutePrizeModeWithNothingInReach(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(fmt.Sprintf(prizeOptimizeBody, 500)))
+
+	h.HandleOptimizeRoute(rec, req)
+
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	msg, _ := decodeBody(t, rec)
+	assert.Equal(t, "No set of checkpoints fits within the budget", msg)
+}
+
+// --- HandleRouteLegs ------------------------------------------------------
+
+func legsUpstream(meters []int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		legs := make([]map[string]any, 0, len(meters))
+		var total int64
+
+		for _, m := range meters {
+			total += m
+			legs = append(legs, map[string]any{
+				"distanceMeters": m,
+				"localizedValues": map[string]any{
+					"distance": map[string]any{"text": "1.0 mi"},
+					"duration": map[string]any{"text": "5 mins"},
+				},
+			})
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"routes": []map[string]any{{
+				"distanceMeters": total,
+				"localizedValues": map[string]any{
+					"distance": map[string]any{"text": "3.0 mi"},
+					"duration": map[string]any{"text": "15 mins"},
+				},
+				"legs": legs,
+			}},
+		})
+	}
+}
+
+func TestHandleRouteLegsReturnsPerHopDistances(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, legsUpstream([]int64{100, 200, 300}))
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(
+		`{"origin":"start","stops":["a","b"],"destination":"end"}`))
+
+	h.HandleRouteLegs(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got struct {
+		Legs []struct {
+			FromId string `json:"fromId"`
+			ToId   string `json:"toId"`
+			Meters int64  `json:"meters"`
 		} `json:"legs"`
 		Meters int64 `json:"meters"`
 	}
@@ -623,3 +1960,85 @@ func TestHandleRouteLegsReportsUpstreamFailure(t *testing.T) {
 	msg, _ := decodeBody(t, rec)
 	assert.Contains(t, msg, "Routes API has not been used")
 }
+
+// --- providers ------------------------------------------------------------
+
+func TestHandleTextSearchReportsProvider(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1"}]}`))
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	h.HandleTextSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query":"city hall"}`)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "google", rec.Header().Get("X-Provider"))
+
+	h = NewPlacesHandler(places.NewFake(places.FakePlace{Id: "hall", Name: "City Hall"}))
+
+	rec = httptest.NewRecorder()
+	h.HandleTextSearch(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query":"city hall"}`)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "fake", rec.Header().Get("X-Provider"))
+}
+
+func TestHandleRouteLegsRecordsProvider(t *testing.T) {
+	t.Parallel()
+
+	h := NewPlacesHandler(places.NewFake())
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(`{
+		"origin":"start","stops":["a"],"destination":"end",
+		"places":[
+			{"id":"start","latitude":39.95,"longitude":-75.18},
+			{"id":"a","latitude":39.96,"longitude":-75.19},
+			{"id":"end","latitude":39.94,"longitude":-75.17}
+		]
+	}`))
+
+	h.HandleRouteLegs(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got struct {
+		Legs     []json.RawMessage `json:"legs"`
+		Provider string            `json:"provider"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	assert.Len(t, got.Legs, 2)
+	assert.Equal(t, "fake", got.Provider, "the places' coordinates were enough for the fake")
+}
+
+func TestHandleOptimizeRouteRecordsProvider(t *testing.T) {
+	t.Parallel()
+
+	h := NewPlacesHandler(places.NewFake())
+
+	rec := httptest.NewRecorder()
+	h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(validOptimizeBody)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method   string `json:"method"`
+		Provider string `json:"provider"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	providers := make(map[string]string, len(got))
+	for _, r := range got {
+		providers[r.Method] = r.Provider
+	}
+
+	assert.Equal(t, map[string]string{"tsp": "", "": "fake"}, providers, "the local solver answers for itself")
+}
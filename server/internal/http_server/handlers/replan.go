@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nguyen/allycat/internal/places"
+	"github.com/nguyen/allycat/internal/tsp"
+)
+
+const (
+	// The client re-plans at every checkpoint, so the answer has to come
+	// back while the rider is still standing there. Held-Karp stays well
+	// under a tenth of a second up to this many stops; past it the heuristic
+	// and its bound take over.
+	replanExactStopLimit = 16
+
+	// The local solver is asked first, so anything this slow has gone
+	// wrong.
+	replanTimeout = 2 * time.Second
+
+	// A refined re-plan waits this long on top for the provider's own
+	// optimization, and answers with the solver's alone if it is late.
+	replanRefineTimeout = 3 * time.Second
+)
+
+type replanPosition struct {
+	Lat  *float64 `json:"latitude"`
+	Long *float64 `json:"longitude"`
+}
+
+// replanExactStopLimit is the server's exact-solve limit, held under the
+// re-plan's own so a generous setting cannot slow a rider down.
+func (h PlacesHandler) replanExactStopLimit() int {
+	if h.exactStopLimit > 0 {
+		return min(h.exactStopLimit, replanExactStopLimit)
+	}
+
+	return replanExactStopLimit
+}
+
+// replanRequest is the body HandleReplanRoute takes.
+type replanRequest struct {
+	Position replanPosition `json:"position"`
+	Visited  []string       `json:"visited"`
+
+	// At is the time now, for the route's clock. It defaults to the
+	// server's.
+	At *time.Time `json:"at"`
+
+	// Manifest is the route as first planned, as HandleOptimizeRoute took
+	// it.
+	Manifest optimizeRouteRequest `json:"manifest"`
+
+	// Refine also asks the provider to optimize what remains, next to the
+	// local solver's answer. Only a manifest with a fixed finish and no
+	// opening hours or ordering rules is refined: anything else is either a
+	// request per candidate finish or rules the provider cannot keep.
+	Refine bool `json:"refine"`
+}
+
+// HandleReplanRoute re-optimizes a route the rider is partway round: from
+// where they are now, through whatever on the original manifest they have
+// not yet visited, to the same finish. The manifest is checked and built for
+// the solver just as HandleOptimizeRoute would, so it is ridden in the same
+// mode, at the same pace and under the same rules.
+func (h PlacesHandler) HandleReplanRoute(w http.ResponseWriter, r *http.Request) {
+	var b replanRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		WriteJSONResponse(w, NewResponse().WithMessage("Invalid payload"), http.StatusBadRequest)
+		return
+	}
+
+	if b.Position.Lat == nil || b.Position.Long == nil {
+		WriteJSONResponse(w, NewResponse().WithMessage("'position' needs both 'latitude' and 'longitude'"), http.StatusBadRequest)
+		return
+	}
+
+	m := b.Manifest
+
+	if err := m.validate(); err != nil {
+		WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	metric, err := m.solverMetric()
+	if err != nil {
+		WriteJSONResponse(w, NewResponse().WithMessage(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	onManifest := make(map[string]bool, len(m.Stops))
+	for _, s := range m.Stops {
+		onManifest[s.Id] = true
+	}
+
+	// The position has no place id of its own, so it gets one that says
+	// where it is, which providers route to by its coordinates.
+	positionId := places.PositionId(*b.Position.Lat, *b.Position.Long)
+
+	if onManifest[positionId] || m.Start.Id == positionId || (m.End != nil && m.End.Id == positionId) {
+		WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("id %q is reserved for the rider's position", positionId)), http.StatusBadRequest)
+		return
+	}
+
+	done := make(map[string]bool, len(b.Visited))
+
+	for _, id := range b.Visited {
+		if !onManifest[id] {
+			WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("visited stop %q is not on the manifest", id)), http.StatusBadRequest)
+			return
+		}
+
+		done[id] = true
+	}
+
+	if len(done) == len(m.Stops) && m.End == nil && !m.Loop {
+		WriteJSONResponse(w, NewResponse().WithMessage("Every stop has been visited").WithData([]places.OptimalRoute{}), http.StatusOK)
+		return
+	}
+
+	// The race's clock is already running, so arrivals count from now
+	// rather than from the start time the manifest was planned with.
+	if m.StartTime != nil {
+		now := time.Now()
+		if b.At != nil {
+			now = *b.At
+		}
+
+		m.StartTime = &now
+	}
+
+	tb := h.solverRoute(m, metric).
+		WithExactStopLimit(h.replanExactStopLimit()).
+		FromPosition(positionId, *b.Position.Lat, *b.Position.Long, b.Visited...)
+
+	solveCtx, cancel := context.WithTimeout(r.Context(), replanTimeout)
+	defer cancel()
+
+	or, err := tsp.Solve(solveCtx, tb.Build())
+
+	if err != nil {
+		writeSolverError(w, err)
+		return
+	}
+
+	stopIds := make([]string, 0, len(or.Stops))
+	for _, s := range or.Stops {
+		stopIds = append(stopIds, s.Id)
+	}
+
+	arrivals, late := stopArrivals(or.Arrivals)
+
+	routes := []places.OptimalRoute{inMode(places.OptimalRoute{
+		Method:     "tsp",
+		Algorithm:  or.Algorithm,
+		Optimality: optimality(or.Bounded, or.OptimalityGap),
+		End:        or.End.Id,
+	}, m.travelMode(), &places.OptimizeRouteResponse{
+		Meters:          int64(or.Meters),
+		DisplayDistance: places.DisplayMiles(or.Meters),
+		DisplayDuration: places.DisplayDuration(or.Duration.Seconds()),
+		Seconds:         int64(math.Round(or.Duration.Seconds())),
+		Order:           stopIds,
+		Etas:            stopEtas(stopIds, or.End.Id, or.Elapsed),
+		Arrivals:        arrivals,
+	})}
+
+	if b.Refine && (m.End != nil || m.Loop) && !m.hasWindows() && !m.hasPrecedence() {
+		routes = append(routes, h.refineReplan(r.Context(), positionId, *b.Position.Lat, *b.Position.Long, m.Start, m.Stops, m.End, done)...)
+	}
+
+	if or.Infeasible {
+		msg := fmt.Sprintf("No order reaches every checkpoint while it is open; late at %s", strings.Join(late, ", "))
+		WriteJSONResponse(w, NewResponse().WithMessage(msg).WithData(routes), http.StatusUnprocessableEntity)
+		return
+	}
+
+	WriteJSONResponse(w, NewResponse().WithData(routes), http.StatusOK)
+}
+
+// refineReplan asks the provider to optimize the stops not yet done, from
+// the rider's position to the finish, which is the origin again on a loop.
+// It is only ever extra: a provider that is down, slow or failing leaves the
+// rider with the local solver's answer and nothing else.
+func (h PlacesHandler) refineReplan(ctx context.Context, positionId string, lat, long float64, start optimizeRoutePayloadPlace, stops []optimizeRoutePayloadPlace, end *optimizeRoutePayloadPlace, done map[string]bool) []places.OptimalRoute {
+	if err := h.api.Available(); err != nil {
+		return nil
+	}
+
+	if end == nil {
+		end = &start
+	}
+
+	builder := places.
+		NewOptimizeRoutePayloadBuilder().
+		WithStart(positionId, lat, long).
+		WithEnd(end.Id, *end.Lat, *end.Long)
+
+	remaining := 0
+
+	for _, s := range stops {
+		if !done[s.Id] {
+			builder = builder.AddStop(s.Id, *s.Lat, *s.Long)
+			remaining++
+		}
+	}
+
+	// A stop or none left has only the one order.
+	if remaining < 2 {
+		return nil
+	}
+
+	payload, err := builder.Build()
+	if err != nil {
+		log.Printf("re-plan refinement: %v", err)
+		return nil
+	}
+
+	refineCtx, cancel := context.WithTimeout(ctx, replanRefineTimeout)
+	defer cancel()
+
+	routes, err := h.api.OptimizeRoute(refineCtx, payload)
+	if err != nil {
+		log.Printf("re-plan refinement failed, answering with the solver only: %v", err)
+		return nil
+	}
+
+	return withProvider(routes, h.api.Name())
+}
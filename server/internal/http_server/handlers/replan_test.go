@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nguyen/allycat/internal/places"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replanManifest rides north from start through a, b and c, one hundredth of
+// a degree apart.
+const replanManifest = `{
+	"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+	"stops":[
+		{"id":"a","latitude":39.96,"longitude":-75.16},
+		{"id":"b","latitude":39.97,"longitude":-75.16},
+		{"id":"c","latitude":39.98,"longitude":-75.16}
+	]
+}`
+
+type replannedRoute struct {
+	Method     string        `json:"method"`
+	Optimality string        `json:"optimality"`
+	End        string        `json:"destination"`
+	Bike       *replannedLeg `json:"bike"`
+	Car        *replannedLeg `json:"car"`
+}
+
+type replannedLeg struct {
+	Order    []string `json:"order"`
+	Meters   int64    `json:"meters"`
+	Seconds  int64    `json:"seconds"`
+	Arrivals []struct {
+		Id   string `json:"id"`
+		Late bool   `json:"late"`
+	} `json:"arrivals"`
+}
+
+// replan posts body and fails the test if the handler reaches for Google.
+func replan(t *testing.T, body string) (*httptest.ResponseRecorder, string, []replannedRoute) {
+	t.Helper()
+
+	var calls atomic.Int32
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/replan", strings.NewReader(body))
+
+	h.HandleReplanRoute(rec, req)
+
+	assert.Zero(t, calls.Load(), "re-planning is local only")
+
+	msg, data := decodeBody(t, rec)
+
+	var got []replannedRoute
+	if len(data) > 0 && string(data) != "null" {
+		require.NoError(t, json.Unmarshal(data, &got))
+	}
+
+	return rec, msg, got
+}
+
+func TestHandleReplanRouteRejectsBadRequests(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		body    string
+		wantMsg string
+	}{
+		{
+			name:    "invalid JSON",
+			body:    `{`,
+			wantMsg: "Invalid payload",
+		},
+		{
+			name:    "no position",
+			body:    `{"visited":["a"],"manifest":` + replanManifest + `}`,
+			wantMsg: "'position' needs both 'latitude' and 'longitude'",
+		},
+		{
+			name:    "half a position",
+			body:    `{"position":{"latitude":39.96},"manifest":` + replanManifest + `}`,
+			wantMsg: "'position' needs both 'latitude' and 'longitude'",
+		},
+		{
+			name:    "visited stop not on the manifest",
+			body:    `{"position":{"latitude":39.96,"longitude":-75.16},"visited":["z"],"manifest":` + replanManifest + `}`,
+			wantMsg: `visited stop "z" is not on the manifest`,
+		},
+		{
+			name: "loop with a destination",
+			body: `{"position":{"latitude":39.96,"longitude":-75.16},"manifest":{
+				"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+				"stops":[{"id":"a","latitude":39.96,"longitude":-75.16}],
+				"destination":{"id":"end","latitude":39.99,"longitude":-75.16},
+				"loop":true
+			}}`,
+			wantMsg: "A loop finishes at the origin, so it cannot have a destination",
+		},
+		{
+			name: "stop without coordinates",
+			body: `{"position":{"latitude":39.96,"longitude":-75.16},"manifest":{
+				"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+				"stops":[{"id":"a","latitude":39.96}]
+			}}`,
+			wantMsg: "stop at index 0 'longitude' is required",
+		},
+		{
+			name:    "unknown mode",
+			body:    `{"position":{"latitude":39.96,"longitude":-75.16},"manifest":` + strings.Replace(replanManifest, `"stops"`, `"mode":"unicycle","stops"`, 1) + `}`,
+			wantMsg: `unknown travel mode "unicycle"`,
+		},
+		{
+			name: "stop named like the position",
+			body: `{"position":{"latitude":39.96,"longitude":-75.16},"manifest":{
+				"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+				"stops":[
+					{"id":"position:39.960000,-75.160000","latitude":39.96,"longitude":-75.16},
+					{"id":"b","latitude":39.97,"longitude":-75.16}
+				]
+			}}`,
+			wantMsg: `id "position:39.960000,-75.160000" is reserved for the rider's position`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec, msg, _ := replan(t, tt.body)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, tt.wantMsg, msg)
+		})
+	}
+}
+
+func TestHandleReplanRouteSolvesWhatIsLeft(t *testing.T) {
+	t.Parallel()
+
+	// Halfway between a and b, with a done.
+	rec, _, got := replan(t, `{"position":{"latitude":39.965,"longitude":-75.16},"visited":["a"],"manifest":`+replanManifest+`}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, got, 1)
+
+	assert.Equal(t, "tsp", got[0].Method)
+	assert.Equal(t, "optimal", got[0].Optimality)
+	assert.Equal(t, "c", got[0].End)
+	require.NotNil(t, got[0].Bike)
+	assert.Equal(t, []string{"b"}, got[0].Bike.Order)
+	assert.InDelta(t, 1665, got[0].Bike.Meters, 20)
+	assert.InDelta(t, 333, got[0].Bike.Seconds, 5, "at the default bike pace")
+}
+
+func TestHandleReplanRouteKeepsTheMode(t *testing.T) {
+	t.Parallel()
+
+	manifest := strings.Replace(replanManifest, `"stops"`, `"mode":"car","stops"`, 1)
+
+	rec, _, got := replan(t, `{"position":{"latitude":39.965,"longitude":-75.16},"visited":["a"],"manifest":`+manifest+`}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, got, 1)
+
+	assert.Nil(t, got[0].Bike)
+	require.NotNil(t, got[0].Car)
+	assert.Equal(t, []string{"b"}, got[0].Car.Order)
+	assert.InDelta(t, 238, got[0].Car.Seconds, 5, "at the default car pace")
+}
+
+func TestHandleReplanRouteAllowsAStopCalledPosition(t *testing.T) {
+	t.Parallel()
+
+	manifest := strings.Replace(replanManifest, `"id":"b"`, `"id":"position"`, 1)
+
+	rec, _, got := replan(t, `{"position":{"latitude":39.965,"longitude":-75.16},"visited":["a"],"manifest":`+manifest+`}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"position"}, got[0].Bike.Order)
+}
+
+// refineManifest rides north from start through a, b and c to a fixed
+// finish.
+const refineManifest = `{
+	"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+	"stops":[
+		{"id":"a","latitude":39.96,"longitude":-75.16},
+		{"id":"b","latitude":39.97,"longitude":-75.16},
+		{"id":"c","latitude":39.98,"longitude":-75.16}
+	],
+	"destination":{"id":"end","latitude":39.99,"longitude":-75.16}
+}`
+
+func TestHandleReplanRouteRefinesWithTheProvider(t *testing.T) {
+	t.Parallel()
+
+	h := NewPlacesHandler(places.NewFake())
+
+	rec := httptest.NewRecorder()
+	h.HandleReplanRoute(rec, httptest.NewRequest(http.MethodPost, "/replan", strings.NewReader(
+		`{"position":{"latitude":39.955,"longitude":-75.16},"visited":["a"],"refine":true,"manifest":`+refineManifest+`}`)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		replannedRoute
+		Provider string `json:"provider"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2, "the solver's route and the provider's")
+	assert.Equal(t, "tsp", got[0].Method)
+	assert.Equal(t, "fake", got[1].Provider)
+	assert.Equal(t, "end", got[1].End)
+	require.NotNil(t, got[1].Bike)
+	assert.Equal(t, []string{"b", "c"}, got[1].Bike.Order, "only what is left")
+}
+
+func TestHandleReplanRouteRefinementFailingLeavesTheSolver(t *testing.T) {
+	t.Parallel()
+
+	var sent []byte
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, r *http.Request) {
+		sent, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	h.HandleReplanRoute(rec, httptest.NewRequest(http.MethodPost, "/replan", strings.NewReader(
+		`{"position":{"latitude":39.955,"longitude":-75.16},"visited":["a"],"refine":true,"manifest":`+refineManifest+`}`)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []replannedRoute
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "tsp", got[0].Method)
+
+	// Google has no place id for where the rider is, so it is sent there by
+	// coordinates.
+	assert.Contains(t, string(sent), `"origin":{"location":{"latLng":{"latitude":39.955,"longitude":-75.16}}}`)
+}
+
+func TestHandleReplanRouteKeepsTheFinish(t *testing.T) {
+	t.Parallel()
+
+	loop := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.16},
+			{"id":"b","latitude":39.97,"longitude":-75.16}
+		],
+		"loop":true
+	}`
+
+	t.Run("loop", func(t *testing.T) {
+		t.Parallel()
+
+		rec, _, got := replan(t, `{"position":{"latitude":39.965,"longitude":-75.16},"visited":["a"],"manifest":`+loop+`}`)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, got, 1)
+		assert.Equal(t, "start", got[0].End, "the loop still goes home")
+		assert.Equal(t, []string{"b"}, got[0].Bike.Order)
+	})
+
+	t.Run("every stop visited", func(t *testing.T) {
+		t.Parallel()
+
+		rec, _, got := replan(t, `{"position":{"latitude":39.97,"longitude":-75.16},"visited":["a","b"],"manifest":`+loop+`}`)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, got, 1)
+		assert.Equal(t, "start", got[0].End)
+		assert.Empty(t, got[0].Bike.Order)
+	})
+
+	t.Run("nothing left at all", func(t *testing.T) {
+		t.Parallel()
+
+		rec, msg, got := replan(t, `{"position":{"latitude":39.98,"longitude":-75.16},"visited":["a","b","c"],"manifest":`+replanManifest+`}`)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "Every stop has been visited", msg)
+		assert.Empty(t, got)
+	})
+}
+
+func TestHandleReplanRouteCountsFromNow(t *testing.T) {
+	t.Parallel()
+
+	// b closes at 10:08, and the last kilometre from a takes a little over
+	// three minutes at 20 km/h: leave a at 10:02 and b is open, leave at
+	// 10:05 and it has closed, whatever time the race began.
+	manifest := `{
+		"origin":{"id":"start","latitude":39.95,"longitude":-75.16},
+		"stops":[
+			{"id":"a","latitude":39.96,"longitude":-75.16},
+			{"id":"b","latitude":39.97,"longitude":-75.16,"closesAt":"2026-05-01T10:08:00Z"}
+		],
+		"startTime":"2026-05-01T10:00:00Z",
+		"speedKph":20
+	}`
+
+	rec, msg, got := replan(t, `{"position":{"latitude":39.96,"longitude":-75.16},"visited":["a"],"at":"2026-05-01T10:05:00Z","manifest":`+manifest+`}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, msg, "late at b")
+	require.Len(t, got, 1)
+	require.Len(t, got[0].Bike.Arrivals, 1)
+	assert.Equal(t, "b", got[0].Bike.Arrivals[0].Id)
+	assert.True(t, got[0].Bike.Arrivals[0].Late)
+
+	rec, _, _ = replan(t, `{"position":{"latitude":39.96,"longitude":-75.16},"visited":["a"],"at":"2026-05-01T10:02:00Z","manifest":`+manifest+`}`)
+	assert.Equal(t, http.StatusOK, rec.Code, "with time to spare b is reached while open")
+}
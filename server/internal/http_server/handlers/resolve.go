@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nguyen/allycat/internal/places"
+)
+
+// A batch is a few rounds of searches at a time, each normally well under
+// a second and given textSearchTimeout at most. Lines still waiting their
+// turn after this long come back failed, next to the ones that resolved,
+// for the rider to retry.
+const resolveTimeout = 20 * time.Second
+
+// HandleResolve resolves a whole race sheet in one go: every checkpoint line
+// is searched for near the race, and each comes back with its best match,
+// the alternatives, and how sure the match is. Lines with nothing found, or
+// no clear winner, are flagged so the rider only fixes those.
+func (h PlacesHandler) HandleResolve(w http.ResponseWriter, r *http.Request) {
+	var b places.ResolveOptions
+
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("Error decoding request body: %v", err)), http.StatusBadRequest)
+		return
+	}
+
+	if len(b.Lines) == 0 {
+		WriteJSONResponse(w, NewResponse().WithMessage("At least one line is required"), http.StatusBadRequest)
+		return
+	}
+
+	if len(b.Lines) > places.MaxResolveLines {
+		WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("At most %d lines can be resolved at once", places.MaxResolveLines)), http.StatusBadRequest)
+		return
+	}
+
+	for i, line := range b.Lines {
+		b.Lines[i] = strings.TrimSpace(line)
+
+		// The same floor the search dialog has.
+		if len(b.Lines[i]) < 4 {
+			WriteJSONResponse(w, NewResponse().WithMessage(fmt.Sprintf("line at index %d must be at least 4 characters long", i)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), resolveTimeout)
+	defer cancel()
+
+	res := places.Resolve(ctx, h.api, b, textSearchTimeout)
+
+	for i := range res {
+		if msg, ok := refusedMessage(res[i].Err, "Place search"); ok {
+			res[i].Error = msg
+		} else if errors.Is(res[i].Err, context.DeadlineExceeded) {
+			log.Printf("resolve: %q timed out: %v", res[i].Line, res[i].Err)
+			res[i].Error = "Timed out searching for this line"
+		}
+	}
+
+	w.Header().Set(providerHeader, h.api.Name())
+
+	WriteJSONResponse(w, NewResponse().WithData(res), http.StatusOK)
+}
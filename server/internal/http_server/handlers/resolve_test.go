@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nguyen/allycat/internal/places"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleResolveValidation(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]string, places.MaxResolveLines+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%q", "city hall")
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"invalid json", `{`, "Error decoding request body"},
+		{"no lines", `{"lines":[]}`, "At least one line is required"},
+		{"too many lines", `{"lines":[` + strings.Join(tooMany, ",") + `]}`, "At most 50 lines"},
+		{"short line", `{"lines":["city hall","  cp "]}`, "line at index 1 must be at least 4 characters long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewPlacesHandler(places.NewFake())
+
+			rec := httptest.NewRecorder()
+			h.HandleResolve(rec, httptest.NewRequest(http.MethodPost, "/resolve", strings.NewReader(tt.body)))
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			msg, _ := decodeBody(t, rec)
+			assert.Contains(t, msg, tt.want)
+		})
+	}
+}
+
+func TestHandleResolveFlagsLinesToFix(t *testing.T) {
+	t.Parallel()
+
+	h := NewPlacesHandler(places.NewFake(
+		places.FakePlace{Id: "boat", Name: "Malta Boat Club", Address: "9 Boathouse Row", Lat: 39.97, Long: -75.18},
+	))
+
+	rec := httptest.NewRecorder()
+	h.HandleResolve(rec, httptest.NewRequest(http.MethodPost, "/resolve", strings.NewReader(
+		`{"lines":["Malta Boat Club","Nowhere Special"],"locationBias":{"latitude":39.95,"longitude":-75.16}}`)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "fake", rec.Header().Get("X-Provider"))
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Line   string `json:"line"`
+		Status string `json:"status"`
+		Best   *struct {
+			Id string `json:"id"`
+		} `json:"best"`
+		Alternatives []json.RawMessage `json:"alternatives"`
+		Confidence   float64           `json:"confidence"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2)
+
+	assert.Equal(t, "resolved", got[0].Status)
+	require.NotNil(t, got[0].Best)
+	assert.Equal(t, "boat", got[0].Best.Id)
+	assert.Equal(t, 1.0, got[0].Confidence)
+
+	assert.Equal(t, "not_found", got[1].Status)
+	assert.Nil(t, got[1].Best)
+	assert.NotNil(t, got[1].Alternatives, "always a list, if an empty one")
+}
+
+func TestHandleResolveWhileGoogleIsDown(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, places.WithCircuitBreaker(1, time.Hour))
+	defer closeFn()
+
+	resolve := func() []resolvedLine {
+		rec := httptest.NewRecorder()
+		h.HandleResolve(rec, httptest.NewRequest(http.MethodPost, "/resolve", strings.NewReader(`{"lines":["city hall"]}`)))
+
+		require.Equal(t, http.StatusOK, rec.Code, "a sheet's failures are reported line by line")
+
+		_, data := decodeBody(t, rec)
+
+		var got []resolvedLine
+		require.NoError(t, json.Unmarshal(data, &got))
+		require.Len(t, got, 1)
+
+		return got
+	}
+
+	// The first failure opens the breaker, and the next line is refused.
+	got := resolve()
+	assert.Equal(t, "failed", got[0].Status)
+	assert.Contains(t, got[0].Error, "status 503")
+
+	got = resolve()
+	assert.Equal(t, "failed", got[0].Status)
+	assert.Equal(t, "Place search is unavailable for now, try again shortly", got[0].Error)
+}
+
+func TestHandleResolveReportsTimedOutLines(t *testing.T) {
+	t.Parallel()
+
+	h := NewPlacesHandler(places.NewFake())
+
+	ctx, cancel := context.WithDeadline(t.Context(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	rec := httptest.NewRecorder()
+	h.HandleResolve(rec, httptest.NewRequestWithContext(ctx, http.MethodPost, "/resolve", strings.NewReader(`{"lines":["city hall"]}`)))
+
+	require.Equal(t, http.StatusOK, rec.Code, "lines that time out fail alone")
+
+	_, data := decodeBody(t, rec)
+
+	var got []resolvedLine
+	require.NoError(t, json.Unmarshal(data, &got))
+	require.Len(t, got, 1)
+
+	assert.Equal(t, "failed", got[0].Status)
+	assert.Equal(t, "Timed out searching for this line", got[0].Error)
+}
+
+type resolvedLine struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
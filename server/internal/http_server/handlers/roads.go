@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+
+	"github.com/nguyen/allycat/internal/places"
+	"github.com/nguyen/allycat/internal/roads"
+)
+
+// WithRoadGraph gives the handler an offline road graph to measure routes
+// over when Google fails, times out or is out of quota.
+func WithRoadGraph(g *roads.Graph) PlacesHandlerOption {
+	return func(h *PlacesHandler) { h.roads = g }
+}
+
+// WithRoadProfiles adds profiles a request can ask the offline road graph
+// for by name, next to the built-in ones. One named like a built-in
+// profile replaces it.
+func WithRoadProfiles(ps ...roads.Profile) PlacesHandlerOption {
+	return func(h *PlacesHandler) {
+		profiles := maps.Clone(h.profiles)
+		if profiles == nil {
+			profiles = make(map[string]roads.Profile, len(ps))
+		}
+
+		for _, p := range ps {
+			profiles[p.Name] = p
+		}
+
+		h.profiles = profiles
+	}
+}
+
+// roadProfile looks up the profile a request named.
+func (h PlacesHandler) roadProfile(name string) (roads.Profile, error) {
+	if h.roads == nil {
+		return roads.Profile{}, errors.New("'profile' needs the offline road graph, which this server has not loaded")
+	}
+
+	if p, ok := h.profiles[name]; ok {
+		return p, nil
+	}
+
+	if p, ok := roads.ProfileByName(name); ok {
+		return p, nil
+	}
+
+	return roads.Profile{}, fmt.Errorf("unknown profile %q", name)
+}
+
+// offlineMatrix measures the given places over the offline road graph, by
+// bike under the given profile. The graph routes by coordinates, so unlike
+// Google it needs no place ids.
+func (h PlacesHandler) offlineMatrix(all []optimizeRoutePayloadPlace, bike roads.Profile) matrixSource {
+	ids := make([]string, len(all))
+	points := make([]roads.Point, len(all))
+
+	for i, p := range all {
+		ids[i] = p.Id
+		points[i] = roads.Point{Lat: *p.Lat, Long: *p.Long}
+	}
+
+	return func(ctx context.Context, byCar bool) (*places.RouteMatrix, error) {
+		profile := bike
+		if byCar {
+			profile = roads.Car
+		}
+
+		m, err := h.roads.Matrix(ctx, profile, points)
+		if err != nil {
+			return nil, fmt.Errorf("offline %s matrix: %w", profile.Name, err)
+		}
+
+		return &places.RouteMatrix{Ids: ids, Meters: m.Meters, Seconds: m.Seconds, Profile: profile.Name}, nil
+	}
+}
+
+// offlineLegs measures opts over the offline road graph under profile, one
+// leg per hop, in the same shape RouteLegs gives. at has the coordinates of
+// every place.
+func (h PlacesHandler) offlineLegs(ctx context.Context, opts places.RouteLegsOptions, at map[string]roads.Point, profile roads.Profile) (*places.RouteLegsResult, error) {
+	ids := append(append([]string{opts.Origin}, opts.Stops...), opts.Destination)
+
+	for _, id := range ids {
+		if _, ok := at[id]; !ok {
+			return nil, fmt.Errorf("no coordinates for %q", id)
+		}
+	}
+
+	res := &places.RouteLegsResult{Legs: make([]places.RouteLeg, 0, len(ids)-1)}
+
+	var meters, seconds float64
+
+	for i := 1; i < len(ids); i++ {
+		p, err := h.roads.Route(ctx, profile, at[ids[i-1]], at[ids[i]])
+		if err != nil {
+			return nil, fmt.Errorf("offline leg %s to %s: %w", ids[i-1], ids[i], err)
+		}
+
+		leg := places.RouteLeg{
+			FromId:          ids[i-1],
+			ToId:            ids[i],
+			Meters:          int64(math.Round(p.Meters)),
+			DisplayDistance: places.DisplayMiles(p.Meters),
+			DisplayDuration: places.DisplayDuration(p.Seconds),
+			Profile:         p.Profile,
+		}
+
+		if opts.Cues {
+			for _, c := range p.Cues {
+				leg.Cues = append(leg.Cues, places.Cue{
+					Maneuver:        c.Maneuver,
+					Instruction:     c.Instruction,
+					Meters:          int64(math.Round(c.Meters)),
+					DisplayDistance: places.DisplayCueDistance(c.Meters),
+				})
+			}
+		}
+
+		res.Legs = append(res.Legs, leg)
+
+		meters += p.Meters
+		seconds += p.Seconds
+	}
+
+	res.Meters = int64(math.Round(meters))
+	res.DisplayDistance = places.DisplayMiles(meters)
+	res.DisplayDuration = places.DisplayDuration(seconds)
+
+	return res, nil
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyen/allycat/internal/places"
+	"github.com/nguyen/allycat/internal/roads"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// offlineHandler has the roads package's test grid to fall back on, and a
+// Google that always fails.
+func offlineHandler(t *testing.T) (PlacesHandler, func()) {
+	t.Helper()
+
+	g, err := roads.Load("../../roads/testdata/fairmount.osm")
+	require.NoError(t, err)
+
+	h, closeFn := handlerWith(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"message":"Quota exceeded"}}`))
+	})
+
+	WithRoadGraph(g)(&h)
+
+	return h, closeFn
+}
+
+type offlineRoute struct {
+	Method string `json:"method"`
+	End    string `json:"destination"`
+	Bike   *struct {
+		Order  []string `json:"order"`
+		Meters int64    `json:"meters"`
+	} `json:"bike"`
+	Car *struct {
+		Order  []string `json:"order"`
+		Meters int64    `json:"meters"`
+	} `json:"car"`
+}
+
+func TestHandleOptimizeRouteFallsBackToTheRoadGraph(t *testing.T) {
+	t.Parallel()
+
+	// Corners of the grid: 22nd & Green, 19th & Green, 21st & Fairmount.
+	stops := `"stops":[
+		{"id":"green19","latitude":39.961,"longitude":-75.1661},
+		{"id":"fairmount21","latitude":39.963,"longitude":-75.1687}
+	]`
+
+	tests := []struct {
+		name string
+		body string
+		end  string
+	}{
+		{
+			name: "with a destination",
+			body: `{"origin":{"id":"green22","latitude":39.961,"longitude":-75.17},` + stops + `,
+				"destination":{"id":"sg22","latitude":39.96,"longitude":-75.17}}`,
+			end: "sg22",
+		},
+		{
+			name: "without one",
+			body: `{"origin":{"id":"green22","latitude":39.961,"longitude":-75.17},` + stops + `}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, closeFn := offlineHandler(t)
+			defer closeFn()
+
+			rec := httptest.NewRecorder()
+			h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(tt.body)))
+
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			_, data := decodeBody(t, rec)
+
+			var got []offlineRoute
+			require.NoError(t, json.Unmarshal(data, &got))
+
+			require.GreaterOrEqual(t, len(got), 2, "the solver's estimate and the road graph's")
+			assert.Equal(t, "tsp", got[0].Method)
+
+			offline := got[1]
+			assert.Equal(t, "osm", offline.Method)
+			require.NotNil(t, offline.Bike)
+			assert.Positive(t, offline.Bike.Meters)
+			assert.GreaterOrEqual(t, offline.Bike.Meters, got[0].Bike.Meters-5, "roads are never shorter than straight lines")
+
+			if tt.end != "" {
+				assert.Equal(t, tt.end, offline.End)
+				require.NotNil(t, offline.Car)
+			}
+		})
+	}
+}
+
+func TestHandleRouteLegsFallsBackToTheRoadGraph(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := offlineHandler(t)
+	defer closeFn()
+
+	// Green Street runs west, so a bike heading east along it goes round
+	// both blocks: by Spring Garden to 21st, then up 21st and along the Mount
+	// Vernon cycleway. Seven blocks, about 777m.
+	body := `{"origin":"green22","stops":["green21"],"destination":"green19","places":[
+		{"id":"green22","latitude":39.961,"longitude":-75.17},
+		{"id":"green21","latitude":39.961,"longitude":-75.1687},
+		{"id":"green19","latitude":39.961,"longitude":-75.1661}
+	]}`
+
+	rec := httptest.NewRecorder()
+	h.HandleRouteLegs(rec, httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got places.RouteLegsResult
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got.Legs, 2)
+	assert.Equal(t, "green22", got.Legs[0].FromId)
+	assert.Equal(t, "green19", got.Legs[1].ToId)
+	assert.InDelta(t, 777, got.Meters, 10)
+	assert.Equal(t, got.Meters, got.Legs[0].Meters+got.Legs[1].Meters)
+	assert.Equal(t, "bike", got.Legs[0].Profile)
+	assert.NotEmpty(t, got.DisplayDuration)
+}
+
+func TestHandleRouteLegsNeedsPlacesForTheRoadGraph(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := offlineHandler(t)
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	h.HandleRouteLegs(rec, httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(
+		`{"origin":"green22","stops":[],"destination":"green19"}`)))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	msg, _ := decodeBody(t, rec)
+	assert.Contains(t, msg, "Quota exceeded", "without coordinates Google's answer stands")
+}
+
+func TestHandleRouteLegsRejectsBadPlaces(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := offlineHandler(t)
+	defer closeFn()
+
+	rec := httptest.NewRecorder()
+	h.HandleRouteLegs(rec, httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(
+		`{"origin":"a","destination":"b","places":[{"id":"a","latitude":39.96}]}`)))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	msg, _ := decodeBody(t, rec)
+	assert.Equal(t, "place at index 0 'longitude' is required", msg)
+}
+
+func TestHandleRouteLegsRidesTheAskedProfile(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := offlineHandler(t)
+	defer closeFn()
+
+	// A profile of the rider's own, that pays one-ways no mind at all.
+	WithRoadProfiles(roads.Profile{Name: "scofflaw", Speeds: map[string]float64{"residential": 5}})(&h)
+
+	between := `"places":[
+		{"id":"green22","latitude":39.961,"longitude":-75.17},
+		{"id":"green19","latitude":39.961,"longitude":-75.1661}
+	]`
+
+	tests := []struct {
+		profile string
+		meters  float64
+	}{
+		// The wrong way down Green Street, three short blocks.
+		{"alleycat", 333},
+		// Green Street only runs west, so round by the Mount Vernon
+		// cycleway, which a calm rider prefers to Spring Garden.
+		{"calm", 555},
+		{"scofflaw", 333},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			t.Parallel()
+
+			body := `{"origin":"green22","stops":[],"destination":"green19","profile":"` + tt.profile + `",` + between + `}`
+
+			rec := httptest.NewRecorder()
+			h.HandleRouteLegs(rec, httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(body)))
+
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			_, data := decodeBody(t, rec)
+
+			var got places.RouteLegsResult
+			require.NoError(t, json.Unmarshal(data, &got))
+
+			require.Len(t, got.Legs, 1)
+			assert.Equal(t, tt.profile, got.Legs[0].Profile)
+			assert.InDelta(t, tt.meters, got.Meters, 10)
+		})
+	}
+}
+
+func TestHandleRouteLegsRejectsBadProfiles(t *testing.T) {
+	t.Parallel()
+
+	between := `"places":[{"id":"a","latitude":39.961,"longitude":-75.17},{"id":"b","latitude":39.961,"longitude":-75.1661}]`
+
+	tests := []struct {
+		name    string
+		offline bool
+		body    string
+		want    string
+	}{
+		{"unknown", true, `{"origin":"a","destination":"b","profile":"tandem",` + between + `}`, `unknown profile "tandem"`},
+		{"by car", true, `{"origin":"a","destination":"b","profile":"calm","byCar":true,` + between + `}`, "'profile' cannot be combined with 'byCar'"},
+		{"no places", true, `{"origin":"a","destination":"b","profile":"calm"}`, "'profile' needs 'places' to route between"},
+		{"no graph", false, `{"origin":"a","destination":"b","profile":"calm",` + between + `}`, "'profile' needs the offline road graph, which this server has not loaded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, closeFn := offlineHandler(t)
+			defer closeFn()
+
+			if !tt.offline {
+				h.roads = nil
+			}
+
+			rec := httptest.NewRecorder()
+			h.HandleRouteLegs(rec, httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(tt.body)))
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			msg, _ := decodeBody(t, rec)
+			assert.Equal(t, tt.want, msg)
+		})
+	}
+}
+
+func TestHandleOptimizeRouteMeasuresTheAskedProfile(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := offlineHandler(t)
+	defer closeFn()
+
+	// Collect at 19th & Green before dropping at 21st & Fairmount, which
+	// Google's own optimization could not be trusted with.
+	body := `{"origin":{"id":"green22","latitude":39.961,"longitude":-75.17},"stops":[
+		{"id":"green19","latitude":39.961,"longitude":-75.1661},
+		{"id":"fairmount21","latitude":39.963,"longitude":-75.1687}
+	],"destination":{"id":"sg22","latitude":39.96,"longitude":-75.17},
+	"deliveries":[{"pickup":"green19","dropoff":"fairmount21"}],"profile":"calm"}`
+
+	rec := httptest.NewRecorder()
+	h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	_, data := decodeBody(t, rec)
+
+	var got []struct {
+		Method string                        `json:"method"`
+		Bike   *places.OptimizeRouteResponse `json:"bike"`
+		Car    *places.OptimizeRouteResponse `json:"car"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	require.Len(t, got, 2)
+	assert.Equal(t, "tsp", got[0].Method)
+	assert.Empty(t, got[0].Bike.Profile)
+
+	assert.Equal(t, "osm", got[1].Method)
+	require.NotNil(t, got[1].Bike)
+	assert.Equal(t, "calm", got[1].Bike.Profile)
+	assert.Equal(t, []string{"green19", "fairmount21"}, got[1].Bike.Order)
+	require.NotNil(t, got[1].Car)
+	assert.Equal(t, "car", got[1].Car.Profile)
+}
+
+func TestHandleOptimizeRouteRejectsUnknownProfiles(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := offlineHandler(t)
+	defer closeFn()
+
+	body := `{"origin":{"id":"a","latitude":39.961,"longitude":-75.17},"stops":[
+		{"id":"b","latitude":39.961,"longitude":-75.1661},
+		{"id":"c","latitude":39.963,"longitude":-75.1687}
+	],"profile":"tandem"}`
+
+	rec := httptest.NewRecorder()
+	h.HandleOptimizeRoute(rec, httptest.NewRequest(http.MethodPost, "/optimize", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	msg, _ := decodeBody(t, rec)
+	assert.Equal(t, `unknown profile "tandem"`, msg)
+}
+
+func TestHandleRouteLegsCues(t *testing.T) {
+	t.Parallel()
+
+	h, closeFn := offlineHandler(t)
+	defer closeFn()
+
+	between := `"places":[
+		{"id":"green22","latitude":39.961,"longitude":-75.17},
+		{"id":"green19","latitude":39.961,"longitude":-75.1661}
+	]`
+
+	legs := func(extra string) []places.RouteLeg {
+		rec := httptest.NewRecorder()
+		h.HandleRouteLegs(rec, httptest.NewRequest(http.MethodPost, "/legs", strings.NewReader(
+			`{"origin":"green22","destination":"green19","profile":"bike",`+extra+between+`}`)))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		_, data := decodeBody(t, rec)
+
+		var got places.RouteLegsResult
+		require.NoError(t, json.Unmarshal(data, &got))
+		require.Len(t, got.Legs, 1)
+
+		return got.Legs
+	}
+
+	assert.Nil(t, legs("")[0].Cues, "cues are opt-in")
+
+	got := legs(`"cues":true,`)[0].Cues
+
+	require.Len(t, got, 3)
+
+	assert.Equal(t, "Head north on North 22nd Street", got[0].Instruction)
+	assert.Equal(t, "360 ft", got[0].DisplayDistance)
+
+	assert.Equal(t, "TURN_RIGHT", got[1].Maneuver)
+	assert.Equal(t, "Turn right onto Mount Vernon Street", got[1].Instruction)
+	assert.InDelta(t, 333, got[1].Meters, 5)
+	assert.Equal(t, "0.2 mi", got[1].DisplayDistance)
+
+	assert.Equal(t, "Turn right onto North 19th Street", got[2].Instruction)
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"github.com/nguyen/allycat/internal/http_server/handlers"
+)
+
+// InitializeAdminRoutes mounts the operator's endpoints behind their own
+// password, so sharing the app password with riders does not share these.
+func InitializeAdminRoutes(r *chi.Mux, hs handlers.Handlers, pwHash string) {
+	adminHandler := hs.Admin
+
+	adminRouter := chi.NewRouter()
+
+	adminRouter.Use(passwordAuth(pwHash))
+
+	adminRouter.Delete("/cache/search", adminHandler.HandlePurgeSearchCache)
+	adminRouter.Get("/cache/legs", adminHandler.HandleLegCacheStats)
+	adminRouter.Get("/usage", adminHandler.HandleUsage)
+
+	r.Mount("/admin", adminRouter)
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nguyen/allycat/internal/http_server/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const adminPassword = "staple-of-the-admins"
+
+// adminRouter wires the admin routes behind their own password, next to the
+// places routes behind the app's.
+func adminRouter(t *testing.T) http.Handler {
+	t.Helper()
+
+	hash := func(raw string) string {
+		pw, err := newPassword(raw)
+		require.NoError(t, err)
+
+		h, err := pw.HashPassword()
+		require.NoError(t, err)
+
+		return h
+	}
+
+	mux := chi.NewRouter()
+	hs := handlers.Handlers{Admin: handlers.NewAdminHandler()}
+
+	InitializePlacesRoutes(mux, hs, hash(testPassword))
+	InitializeAdminRoutes(mux, hs, hash(adminPassword))
+
+	return mux
+}
+
+func TestAdminRoutesTakeTheirOwnPassword(t *testing.T) {
+	t.Parallel()
+
+	r := adminRouter(t)
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodDelete, "/admin/cache/search"},
+		{http.MethodGet, "/admin/cache/legs"},
+		{http.MethodGet, "/admin/usage"},
+	} {
+		t.Run(route.path, func(t *testing.T) {
+			t.Parallel()
+
+			for _, pw := range []string{"", testPassword} {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(route.method, route.path, nil)
+				req.Header.Set("x-app-password", pw)
+
+				r.ServeHTTP(rec, req)
+
+				assertForbidden(t, rec)
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(route.method, route.path, nil)
+			req.Header.Set("x-app-password", adminPassword)
+
+			r.ServeHTTP(rec, req)
+
+			// The handler has nothing to serve: reaching it is the point.
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		})
+	}
+}
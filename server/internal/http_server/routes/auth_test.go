@@ -37,6 +37,7 @@ func routerWithPassword(t *testing.T, plaintext string) http.Handler {
 		"test-key",
 		places.WithSearchTextURL(upstream.URL),
 		places.WithComputeRoutesURL(upstream.URL),
+		places.WithComputeRouteMatrixURL(upstream.URL),
 		places.WithHTTPClient(upstream.Client()),
 	)
 	require.NoError(t, err)
@@ -220,6 +221,19 @@ func TestAuthGuardsOptimizeRouteToo(t *testing.T) {
 	assertForbidden(t, rec)
 }
 
+func TestAuthGuardsReplanRoute(t *testing.T) {
+	t.Parallel()
+
+	r := routerWithPassword(t, testPassword)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/places/replan", strings.NewReader(`{}`))
+
+	r.ServeHTTP(rec, req)
+
+	assertForbidden(t, rec)
+}
+
 func TestAuthDoesNotLeakWhetherPasswordWasClose(t *testing.T) {
 	t.Parallel()
 
@@ -241,3 +255,16 @@ func TestAuthDoesNotLeakWhetherPasswordWasClose(t *testing.T) {
 
 	assert.Equal(t, bodies[0], bodies[1])
 }
+
+func TestAuthGuardsResolve(t *testing.T) {
+	t.Parallel()
+
+	r := routerWithPassword(t, testPassword)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/places/resolve", strings.NewReader(`{}`))
+
+	r.ServeHTTP(rec, req)
+
+	assertForbidden(t, rec)
+}
@@ -48,12 +48,10 @@ func (p password) ComparePasswordAndHash(hash string) (bool, error) {
 	return match, nil
 }
 
-func InitializePlacesRoutes(r *chi.Mux, hs handlers.Handlers, pwHash string) {
-	placesHandler := hs.Places
-
-	placesRouter := chi.NewRouter()
-
-	auth := func(next http.Handler) http.Handler {
+// passwordAuth only lets through requests whose x-app-password header
+// matches pwHash.
+func passwordAuth(pwHash string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
 		f := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 			// Never log the submitted value itself — this header is the
@@ -86,12 +84,20 @@ func InitializePlacesRoutes(r *chi.Mux, hs handlers.Handlers, pwHash string) {
 		})
 		return f
 	}
+}
+
+func InitializePlacesRoutes(r *chi.Mux, hs handlers.Handlers, pwHash string) {
+	placesHandler := hs.Places
+
+	placesRouter := chi.NewRouter()
 
-	placesRouter.Use(auth)
+	placesRouter.Use(passwordAuth(pwHash))
 
 	placesRouter.Post("/search", placesHandler.HandleTextSearch)
 	placesRouter.Post("/optimize", placesHandler.HandleOptimizeRoute)
+	placesRouter.Post("/replan", placesHandler.HandleReplanRoute)
 	placesRouter.Post("/legs", placesHandler.HandleRouteLegs)
+	placesRouter.Post("/resolve", placesHandler.HandleResolve)
 
 	// Mounting the new Sub Router on the main router
 	r.Mount("/places", placesRouter)
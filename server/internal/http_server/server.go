@@ -31,6 +31,12 @@ func (s *Server) RegisterRoutes(hs handlers.Handlers, pw string) {
 	s.initialized = true
 }
 
+// RegisterAdminRoutes adds the operator's endpoints, behind their own
+// password hash. They are optional, so this is separate from RegisterRoutes.
+func (s *Server) RegisterAdminRoutes(hs handlers.Handlers, pw string) {
+	routes.InitializeAdminRoutes(s.mux, hs, pw)
+}
+
 // Handler builds the outer router: CORS, logging, healthcheck, and the API
 // mounted under /api. Separate from Start so it can be exercised without
 // binding a port.
@@ -49,7 +55,7 @@ func (s *Server) Handler(allowedOrigin string) (http.Handler, error) {
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-App-Password"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "X-Cache", "X-Provider"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
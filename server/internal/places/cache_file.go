@@ -0,0 +1,87 @@
+package places
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// saveJSON writes v to path by way of a temporary file, so a crash
+// mid-write never leaves half a cache behind.
+func saveJSON(path string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// loadJSON reads path into v. A missing file leaves v alone and is not an
+// error: the cache is just empty.
+func loadJSON(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// snapshotFile writes a cache's snapshots to path without the cache's own
+// lock held, so a hit never waits on a miss's disk write. A cache numbers
+// each snapshot as it takes it, under its lock; writes may then finish in
+// any order, and one older than what the file already holds is dropped
+// rather than written over it.
+type snapshotFile struct {
+	path string
+
+	mu      sync.Mutex
+	written uint64
+}
+
+// save writes v, the snapshot numbered seq, unless a later one is already
+// on disk.
+func (f *snapshotFile) save(seq uint64, v any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if seq <= f.written {
+		return nil
+	}
+
+	if err := saveJSON(f.path, v); err != nil {
+		return err
+	}
+
+	f.written = seq
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package places
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFileDropsOlderSnapshots(t *testing.T) {
+	t.Parallel()
+
+	f := &snapshotFile{path: filepath.Join(t.TempDir(), "cache.json")}
+
+	require.NoError(t, f.save(2, []string{"newer"}))
+	require.NoError(t, f.save(1, []string{"older"}))
+
+	var got []string
+	require.NoError(t, loadJSON(f.path, &got))
+	assert.Equal(t, []string{"newer"}, got)
+
+	require.NoError(t, f.save(3, []string{"newest"}))
+	require.NoError(t, loadJSON(f.path, &got))
+	assert.Equal(t, []string{"newest"}, got)
+}
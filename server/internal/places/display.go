@@ -0,0 +1,48 @@
+package places
+
+import (
+	"fmt"
+	"math"
+)
+
+// Routes measured anywhere but Google still read the way Google's localized
+// values do, so the client cannot tell them apart.
+
+// DisplayMiles formats metres the way Google's localized values do.
+func DisplayMiles(meters float64) string {
+	return fmt.Sprintf("%.1f mi", meters/1609.344)
+}
+
+// DisplayCueDistance formats the distance to the next turn the way Google's
+// localized values do, which switch to feet for the short ones.
+func DisplayCueDistance(meters float64) string {
+	if miles := meters / 1609.344; miles >= 0.1 {
+		return fmt.Sprintf("%.1f mi", miles)
+	}
+
+	return fmt.Sprintf("%d ft", int(math.Round(meters/0.3048/10))*10)
+}
+
+// DisplayDuration formats seconds the way Google's localized values do.
+func DisplayDuration(seconds float64) string {
+	mins := int(math.Round(seconds / 60))
+
+	switch {
+	case mins < 1:
+		return "1 min"
+	case mins < 60:
+		return pluralize(mins, "min")
+	case mins%60 == 0:
+		return pluralize(mins/60, "hour")
+	default:
+		return pluralize(mins/60, "hour") + " " + pluralize(mins%60, "min")
+	}
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,28 @@
+package places
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisplayDuration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{seconds: 0, want: "1 min"},
+		{seconds: 60, want: "1 min"},
+		{seconds: 15 * 60, want: "15 mins"},
+		{seconds: 60 * 60, want: "1 hour"},
+		{seconds: 2 * 60 * 60, want: "2 hours"},
+		{seconds: 61 * 60, want: "1 hour 1 min"},
+		{seconds: 125 * 60, want: "2 hours 5 mins"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, DisplayDuration(tt.seconds), "%v seconds", tt.seconds)
+	}
+}
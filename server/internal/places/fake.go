@@ -0,0 +1,240 @@
+package places
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Fake is an in-memory provider for local development and tests: it knows
+// only the places it is given, and every distance is a straight line. It
+// never refuses and never costs anything.
+type Fake struct {
+	places []FakePlace
+}
+
+// FakePlace is a place the Fake can find.
+type FakePlace struct {
+	Id      string  `json:"id"`
+	Name    string  `json:"name"`
+	Address string  `json:"address"`
+	Lat     float64 `json:"lat"`
+	Long    float64 `json:"long"`
+}
+
+const (
+	// Straight-line speeds, a brisk ride and a city drive.
+	fakeBikeMetersPerSecond = 5.0
+	fakeCarMetersPerSecond  = 11.0
+
+	earthRadiusMeters = 6_371_008.8
+)
+
+func NewFake(places ...FakePlace) *Fake {
+	return &Fake{places: slices.Clone(places)}
+}
+
+// Name is "fake".
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// Available is always nil.
+func (f *Fake) Available() error {
+	return nil
+}
+
+// Search finds every place whose name or address contains the query,
+// ignoring case. The bias is ignored.
+func (f *Fake) Search(_ context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+	q := strings.ToLower(strings.TrimSpace(opts.Query))
+	out := make([]place, 0)
+
+	for _, p := range f.places {
+		if !strings.Contains(strings.ToLower(p.Name), q) && !strings.Contains(strings.ToLower(p.Address), q) {
+			continue
+		}
+
+		out = append(out, place{
+			Id:               p.Id,
+			FormattedAddress: p.Address,
+			DisplayName:      displayName{Name: p.Name},
+			Coordinates:      coordinates{Lat: p.Lat, Long: p.Long},
+		})
+	}
+
+	return TextSearchResult{Places: out}, nil
+}
+
+// coords locates ids from given, then from the Fake's own places.
+func (f *Fake) coords(ids []string, given map[string]Coord) ([]Coord, error) {
+	known := make(map[string]Coord, len(f.places)+len(given))
+
+	for _, p := range f.places {
+		known[p.Id] = Coord{Lat: p.Lat, Long: p.Long}
+	}
+
+	for id, c := range given {
+		known[id] = c
+	}
+
+	return coordsOf(ids, known)
+}
+
+// RouteLegs measures each leg as the crow flies. There are no cues.
+func (f *Fake) RouteLegs(_ context.Context, opts RouteLegsOptions) (*RouteLegsResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	points := opts.waypoints()
+
+	coords, err := f.coords(points, opts.Coords)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RouteLegsResult{Legs: make([]RouteLeg, 0, len(points)-1)}
+
+	var meters, seconds float64
+
+	for i := range len(points) - 1 {
+		m := straightMeters(coords[i], coords[i+1])
+		s := fakeSeconds(m, opts.ByCar)
+
+		meters += m
+		seconds += s
+
+		res.Legs = append(res.Legs, RouteLeg{
+			FromId:          points[i],
+			ToId:            points[i+1],
+			Meters:          int64(math.Round(m)),
+			Seconds:         int64(math.Round(s)),
+			DisplayDistance: DisplayMiles(m),
+			DisplayDuration: DisplayDuration(s),
+		})
+	}
+
+	res.Meters = int64(math.Round(meters))
+	res.DisplayDistance = DisplayMiles(meters)
+	res.DisplayDuration = DisplayDuration(seconds)
+
+	return res, nil
+}
+
+// RouteMatrix measures every pair as the crow flies, so it is symmetric.
+func (f *Fake) RouteMatrix(_ context.Context, opts RouteMatrixOptions) (*RouteMatrix, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	coords, err := f.coords(opts.Places, opts.Coords)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(opts.Places)
+
+	out := &RouteMatrix{
+		Ids:     slices.Clone(opts.Places),
+		Meters:  make([][]float64, n),
+		Seconds: make([][]float64, n),
+	}
+
+	for i := range n {
+		out.Meters[i] = make([]float64, n)
+		out.Seconds[i] = make([]float64, n)
+
+		for j := range n {
+			m := straightMeters(coords[i], coords[j])
+			out.Meters[i][j] = m
+			out.Seconds[i][j] = fakeSeconds(m, opts.ByCar)
+		}
+	}
+
+	return out, nil
+}
+
+// OptimizeRoute does not optimize: the stops are visited in the order
+// given, once for every finish the options allow, so a test knows what it
+// will get back.
+func (f *Fake) OptimizeRoute(_ context.Context, opts optimizeRouteOptions) ([]OptimalRoute, error) {
+	var ends []*optimizeRouteLocation
+
+	switch {
+	case opts.loop:
+		home := opts.start
+		ends = []*optimizeRouteLocation{&home}
+	case opts.end != nil:
+		ends = []*optimizeRouteLocation{opts.end}
+	default:
+		for i := range opts.stops {
+			ends = append(ends, &opts.stops[i])
+		}
+	}
+
+	out := make([]OptimalRoute, 0, len(ends))
+
+	for _, end := range ends {
+		path := []optimizeRouteLocation{opts.start}
+		order := make([]string, 0, len(opts.stops))
+
+		for _, st := range opts.stops {
+			if opts.loop || opts.end != nil || st.id != end.id {
+				path = append(path, st)
+				order = append(order, st.id)
+			}
+		}
+
+		path = append(path, *end)
+
+		var meters float64
+		for i := range len(path) - 1 {
+			meters += straightMeters(
+				Coord{Lat: path[i].lat, Long: path[i].long},
+				Coord{Lat: path[i+1].lat, Long: path[i+1].long},
+			)
+		}
+
+		measure := func(byCar bool) *OptimizeRouteResponse {
+			return &OptimizeRouteResponse{
+				Order:           slices.Clone(order),
+				Meters:          int64(math.Round(meters)),
+				DisplayDistance: DisplayMiles(meters),
+				DisplayDuration: DisplayDuration(fakeSeconds(meters, byCar)),
+				Seconds:         int64(math.Round(fakeSeconds(meters, byCar))),
+				end:             end.id,
+			}
+		}
+
+		out = append(out, OptimalRoute{
+			End:       end.id,
+			BikeRoute: measure(false),
+			CarRoute:  measure(true),
+		})
+	}
+
+	return out, nil
+}
+
+func fakeSeconds(meters float64, byCar bool) float64 {
+	if byCar {
+		return meters / fakeCarMetersPerSecond
+	}
+
+	return meters / fakeBikeMetersPerSecond
+}
+
+// straightMeters is the great-circle distance between a and b.
+func straightMeters(a, b Coord) float64 {
+	const rad = math.Pi / 180
+
+	dLat := (b.Lat - a.Lat) * rad
+	dLong := (b.Long - a.Long) * rad
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,89 @@
+package places
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFake() *Fake {
+	return NewFake(
+		FakePlace{Id: "hall", Name: "City Hall", Address: "1400 John F Kennedy Blvd", Lat: 39.9526, Long: -75.1635},
+		FakePlace{Id: "bell", Name: "Liberty Bell", Address: "526 Market St", Lat: 39.9496, Long: -75.1503},
+		FakePlace{Id: "zoo", Name: "Philadelphia Zoo", Address: "3400 W Girard Ave", Lat: 39.9714, Long: -75.1955},
+	)
+}
+
+func TestFakeSearch(t *testing.T) {
+	t.Parallel()
+
+	got, err := newTestFake().Search(context.Background(), TextSearchOptions{Query: "market st"})
+	require.NoError(t, err)
+
+	require.Len(t, got.Places, 1)
+	assert.Equal(t, "bell", got.Places[0].Id)
+	assert.Equal(t, "Liberty Bell", got.Places[0].DisplayName.Name)
+
+	got, err = newTestFake().Search(context.Background(), TextSearchOptions{Query: "nowhere"})
+	require.NoError(t, err)
+	assert.NotNil(t, got.Places)
+	assert.Empty(t, got.Places)
+}
+
+func TestFakeRouteLegsMeasuresStraightLines(t *testing.T) {
+	t.Parallel()
+
+	got, err := newTestFake().RouteLegs(context.Background(), RouteLegsOptions{
+		Origin:      "hall",
+		Stops:       []string{"bell"},
+		Destination: "home",
+		Coords:      map[string]Coord{"home": {Lat: 39.9526, Long: -75.1635}},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, got.Legs, 2)
+	assert.InDelta(t, 1180, got.Legs[0].Meters, 20)
+	assert.Equal(t, got.Legs[0].Meters, got.Legs[1].Meters, "there and back")
+	assert.InDelta(t, got.Legs[0].Meters+got.Legs[1].Meters, got.Meters, 1, "give or take rounding")
+
+	_, err = newTestFake().RouteLegs(context.Background(), RouteLegsOptions{Origin: "hall", Destination: "mars"})
+	assert.ErrorIs(t, err, ErrNoCoordinates)
+}
+
+func TestFakeRouteMatrixIsSymmetric(t *testing.T) {
+	t.Parallel()
+
+	got, err := newTestFake().RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"hall", "bell", "zoo"}})
+	require.NoError(t, err)
+
+	for i := range got.Ids {
+		assert.Zero(t, got.Meters[i][i])
+
+		for j := range got.Ids {
+			assert.Equal(t, got.Meters[i][j], got.Meters[j][i])
+		}
+	}
+}
+
+func TestFakeOptimizeRouteKeepsTheOrderGiven(t *testing.T) {
+	t.Parallel()
+
+	opts, err := NewOptimizeRoutePayloadBuilder().
+		WithStart("hall", 39.9526, -75.1635).
+		AddStop("zoo", 39.9714, -75.1955).
+		AddStop("bell", 39.9496, -75.1503).
+		Build()
+	require.NoError(t, err)
+
+	got, err := newTestFake().OptimizeRoute(context.Background(), opts)
+	require.NoError(t, err)
+
+	require.Len(t, got, 2, "one route for each stop it could finish at")
+	assert.Equal(t, "zoo", got[0].End)
+	assert.Equal(t, []string{"bell"}, got[0].BikeRoute.Order)
+	assert.Equal(t, "bell", got[1].End)
+	assert.Equal(t, []string{"zoo"}, got[1].CarRoute.Order)
+	assert.Equal(t, got[1].BikeRoute.Meters, got[1].CarRoute.Meters)
+}
@@ -0,0 +1,109 @@
+package places
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/nguyen/allycat/internal/roads"
+	"github.com/nguyen/allycat/internal/sheet"
+)
+
+// intersectionPrefix starts the id of a place that is a corner found in the
+// road extract. No provider knows such a place, so its id carries where it
+// is: "intersection:39.962300,-75.174100".
+const intersectionPrefix = "intersection:"
+
+func intersectionId(c Coord) string {
+	return locatedId(intersectionPrefix, c)
+}
+
+// IntersectionGeocoder finds a cross-street checkpoint, like "Broad &
+// Spring Garden", at the corner itself. With a road extract it looks the
+// corner up there, as a place of its own no provider needs to know. Without
+// one, or when the extract does not have the streets meeting, it asks the
+// provider for the corner as "Broad & Spring Garden" and puts first any
+// intersection that comes back; with none, the results stand as they are.
+// Any other query goes to the provider unchanged.
+type IntersectionGeocoder struct {
+	Provider
+
+	graph *roads.Graph
+}
+
+var _ Provider = (*IntersectionGeocoder)(nil)
+
+// NewIntersectionGeocoder wraps p. g may be nil, leaving only the search.
+func NewIntersectionGeocoder(p Provider, g *roads.Graph) *IntersectionGeocoder {
+	return &IntersectionGeocoder{Provider: p, graph: g}
+}
+
+// Search finds a corner, or anything else the provider can.
+func (g *IntersectionGeocoder) Search(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+	x, ok := sheet.ParseIntersection(opts.Query)
+	if !ok {
+		return g.Provider.Search(ctx, opts)
+	}
+
+	if g.graph != nil {
+		// Streets that meet more than once meet nearest the bias, or
+		// anywhere without one.
+		var near roads.Point
+		if opts.LongLat != nil {
+			near = roads.Point{Lat: opts.LongLat.Lat, Long: opts.LongLat.Long}
+		}
+
+		j, err := g.graph.Intersection(x.Streets[0], x.Streets[1], near)
+
+		switch {
+		case err == nil:
+			return TextSearchResult{Places: []place{junctionPlace(j)}}, nil
+		case !errors.Is(err, roads.ErrNoIntersection):
+			return TextSearchResult{}, err
+		}
+	}
+
+	opts.Query = x.String()
+
+	res, err := g.Provider.Search(ctx, opts)
+	if err != nil {
+		return res, err
+	}
+
+	res.Places = slices.Clone(res.Places)
+	slices.SortStableFunc(res.Places, func(a, b place) int {
+		switch {
+		case isIntersection(a) && !isIntersection(b):
+			return -1
+		case isIntersection(b) && !isIntersection(a):
+			return 1
+		}
+
+		return 0
+	})
+
+	return res, nil
+}
+
+// isIntersection reports whether the provider itself says p is a corner.
+func isIntersection(p place) bool {
+	return slices.Contains(p.Types, "intersection")
+}
+
+// junctionPlace is a corner from the extract as a search result, named for
+// its streets as the extract has them.
+func junctionPlace(j roads.Junction) place {
+	c := Coord{Lat: j.Point.Lat, Long: j.Point.Long}
+	name := j.Streets[0] + " & " + j.Streets[1]
+	at := fmt.Sprintf("%f,%f", c.Lat, c.Long)
+
+	return place{
+		Id:               intersectionId(c),
+		FormattedAddress: name,
+		GoogleMapsUri:    "https://www.google.com/maps/search/?api=1&query=" + at,
+		DisplayName:      displayName{Name: name},
+		Links:            googleMapsLinks{Directions: "https://www.google.com/maps/dir/?api=1&destination=" + at},
+		Coordinates:      coordinates{Lat: c.Lat, Long: c.Long},
+	}
+}
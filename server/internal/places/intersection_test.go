@@ -0,0 +1,117 @@
+package places
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nguyen/allycat/internal/roads"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntersectionGeocoderFindsTheCornerInTheExtract(t *testing.T) {
+	t.Parallel()
+
+	g, err := roads.Load("../roads/testdata/fairmount.osm")
+	require.NoError(t, err)
+
+	geo := NewIntersectionGeocoder(NewFake(), g)
+
+	res, err := geo.Search(context.Background(), TextSearchOptions{Query: "22nd and Spring Garden"})
+	require.NoError(t, err)
+	require.Len(t, res.Places, 1)
+
+	got := res.Places[0]
+	assert.Equal(t, "North 22nd Street & Spring Garden Street", got.DisplayName.Name)
+	assert.Equal(t, coordinates{Lat: 39.96, Long: -75.17}, got.Coordinates)
+	assert.Equal(t, intersectionId(Coord{Lat: 39.96, Long: -75.17}), got.Id)
+
+	// The corner routes like any other place, though only its id says where
+	// it is.
+	legs, err := geo.RouteLegs(context.Background(), RouteLegsOptions{
+		Origin:      got.Id,
+		Destination: "boat",
+		Coords:      map[string]Coord{"boat": {Lat: 39.97, Long: -75.18}},
+	})
+	require.NoError(t, err)
+	assert.Positive(t, legs.Meters)
+}
+
+func TestIntersectionGeocoderSearchesForCornersTheExtractLacks(t *testing.T) {
+	t.Parallel()
+
+	g, err := roads.Load("../roads/testdata/fairmount.osm")
+	require.NoError(t, err)
+
+	var asked []string
+
+	search := searchFunc(func(_ context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+		asked = append(asked, opts.Query)
+
+		return TextSearchResult{Places: []place{
+			{Id: "pier", DisplayName: displayName{Name: "Washington Ave Pier"}, FormattedAddress: "Columbus Blvd, Philadelphia"},
+			{Id: "corner", DisplayName: displayName{Name: "S Broad St & Washington Ave"}, Types: []string{"intersection"}, Coordinates: coordinates{Lat: 39.9382, Long: -75.1674}},
+			{Id: "diner", DisplayName: displayName{Name: "Broad Street Diner"}, FormattedAddress: "1135 S Broad St, Philadelphia"},
+		}}, nil
+	})
+
+	for _, graph := range []*roads.Graph{g, nil} {
+		geo := NewIntersectionGeocoder(searchProvider{Fake: NewFake(), searchFunc: search}, graph)
+
+		res, err := geo.Search(context.Background(), TextSearchOptions{Query: "corner of Broad + Washington"})
+		require.NoError(t, err)
+
+		ids := make([]string, 0, len(res.Places))
+		for _, p := range res.Places {
+			ids = append(ids, p.Id)
+		}
+
+		assert.Equal(t, []string{"corner", "pier", "diner"}, ids, "the provider's intersection first")
+	}
+
+	assert.Equal(t, []string{"Broad & Washington", "Broad & Washington"}, asked, "asked for the corner, not the rider's words")
+}
+
+func TestIntersectionGeocoderNeverPlacesACornerAtABusiness(t *testing.T) {
+	t.Parallel()
+
+	found := []place{
+		{Id: "pier", DisplayName: displayName{Name: "Washington Ave Pier"}, FormattedAddress: "Columbus Blvd, Philadelphia"},
+		{Id: "cafe", DisplayName: displayName{Name: "Cafe"}, FormattedAddress: "1100 Washington Ave, Philadelphia, on Broad"},
+	}
+
+	search := searchFunc(func(_ context.Context, _ TextSearchOptions) (TextSearchResult, error) {
+		return TextSearchResult{Places: found}, nil
+	})
+
+	geo := NewIntersectionGeocoder(searchProvider{Fake: NewFake(), searchFunc: search}, nil)
+
+	// The cafe mentions both streets, but it is not the corner.
+	res, err := geo.Search(context.Background(), TextSearchOptions{Query: "Broad & Washington"})
+	require.NoError(t, err)
+	assert.Equal(t, found, res.Places)
+}
+
+// searchProvider is the Fake with another search.
+type searchProvider struct {
+	*Fake
+	searchFunc
+}
+
+func (p searchProvider) Search(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+	return p.searchFunc(ctx, opts)
+}
+
+func TestIntersectionGeocoderPassesOtherQueriesThrough(t *testing.T) {
+	t.Parallel()
+
+	geo := NewIntersectionGeocoder(NewFake(
+		FakePlace{Id: "noble", Name: "Barnes & Noble", Address: "1805 Walnut St", Lat: 39.95, Long: -75.17},
+	), nil)
+
+	res, err := geo.Search(context.Background(), TextSearchOptions{Query: "Barnes & Noble"})
+	require.NoError(t, err)
+	require.Len(t, res.Places, 1)
+	assert.Equal(t, "noble", res.Places[0].Id)
+	assert.Equal(t, "fake", geo.Name())
+}
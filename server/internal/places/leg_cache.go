@@ -0,0 +1,193 @@
+package places
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Road closures for a race weekend come and go within the week, so legs are
+// measured again after one.
+const defaultLegCacheTTL = 7 * 24 * time.Hour
+
+// LegCache keeps the legs RouteLegs measures, so a pair of checkpoints ridden
+// in one route is not billed again when the next route rides it too. Legs
+// are keyed by both place ids, the travel mode and the route modifiers, and
+// expire after a TTL. With a file the cache is written to it after every
+// change and read back on start.
+//
+// A LegCache is safe for concurrent use.
+type LegCache struct {
+	ttl  time.Duration
+	file *snapshotFile
+	now  func() time.Time
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	mu      sync.Mutex
+	entries map[string]legCacheEntry
+
+	// seq numbers the snapshots taken for the file, so an older one never
+	// lands over a newer one.
+	seq uint64
+}
+
+type legCacheEntry struct {
+	Leg     RouteLeg  `json:"leg"`
+	Expires time.Time `json:"expires"`
+
+	// HasCues is whether the leg was measured with its cue sheet, which a
+	// request for cues needs.
+	HasCues bool `json:"hasCues"`
+}
+
+// LegCacheStats are a leg cache's counters since the server started.
+type LegCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+// LegCacheOption customises a LegCache.
+type LegCacheOption func(*LegCache)
+
+// WithLegCacheTTL sets how long a leg is served before Google is asked to
+// measure it again.
+func WithLegCacheTTL(ttl time.Duration) LegCacheOption {
+	return func(c *LegCache) { c.ttl = ttl }
+}
+
+// WithLegCacheFile keeps the cache in a JSON file at path, so it survives
+// restarts.
+func WithLegCacheFile(path string) LegCacheOption {
+	return func(c *LegCache) { c.file = &snapshotFile{path: path} }
+}
+
+// NewLegCache builds a cache, loading whatever its file already holds that
+// has not expired since.
+func NewLegCache(opts ...LegCacheOption) (*LegCache, error) {
+	c := &LegCache{
+		ttl:     defaultLegCacheTTL,
+		now:     time.Now,
+		entries: make(map[string]legCacheEntry),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.ttl <= 0 {
+		return nil, errors.New("leg cache TTL must be positive")
+	}
+
+	if c.file != nil {
+		var saved map[string]legCacheEntry
+		if err := loadJSON(c.file.path, &saved); err != nil {
+			return nil, fmt.Errorf("reading leg cache %s: %w", c.file.path, err)
+		}
+
+		now := c.now()
+		for k, e := range saved {
+			if now.Before(e.Expires) {
+				c.entries[k] = e
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// Stats reports the cache's hits and misses, and how many legs it holds.
+func (c *LegCache) Stats() LegCacheStats {
+	c.mu.Lock()
+	n := len(c.entries)
+	c.mu.Unlock()
+
+	return LegCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: n}
+}
+
+// legKey is what makes two legs the same: where they run between, and how
+// they are ridden.
+func legKey(from, to, mode string, avoids *optimizePayloadAvoids) string {
+	key := from + ">" + to + "|" + mode
+
+	if avoids != nil {
+		key += fmt.Sprintf("|tolls=%t,highways=%t", avoids.Tolls, avoids.Highways)
+	}
+
+	return key
+}
+
+func (c *LegCache) get(key string, cues bool) (RouteLeg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+
+	if ok && !c.now().Before(e.Expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+
+	if !ok || (cues && !e.HasCues) {
+		c.misses.Add(1)
+		return RouteLeg{}, false
+	}
+
+	c.hits.Add(1)
+
+	leg := e.Leg
+	if !cues {
+		leg.Cues = nil
+	}
+
+	return leg, true
+}
+
+// put keeps legs by key, and saves once for the lot. A leg measured without
+// its cue sheet does not replace one still fresh that has it: that sheet was
+// paid for, and the leg it came with is just as good.
+func (c *LegCache) put(legs map[string]RouteLeg, cues bool) error {
+	c.mu.Lock()
+
+	now := c.now()
+
+	for k, leg := range legs {
+		if old, ok := c.entries[k]; ok && !cues && old.HasCues && now.Before(old.Expires) {
+			continue
+		}
+
+		c.entries[k] = legCacheEntry{Leg: leg, Expires: now.Add(c.ttl), HasCues: cues}
+	}
+
+	// Writing is the time to forget what has expired, or the file would only
+	// ever grow.
+	for k, e := range c.entries {
+		if !now.Before(e.Expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	if c.file == nil {
+		c.mu.Unlock()
+		return nil
+	}
+
+	// Entries are values, so a copy of the map is a snapshot the file can
+	// be written from once the lock is released.
+	snapshot := maps.Clone(c.entries)
+	c.seq++
+	seq := c.seq
+
+	c.mu.Unlock()
+
+	if err := c.file.save(seq, snapshot); err != nil {
+		return fmt.Errorf("writing leg cache: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package places
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legsGoogle measures every leg asked of it as 1000m and 300s, and records
+// the waypoints of each request, joined, so a test can see what was asked.
+func legsGoogle(t *testing.T, cache *LegCache) (*PlacesApi, func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var asked []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Origin struct {
+				Id string `json:"placeId"`
+			} `json:"origin"`
+			Destination struct {
+				Id string `json:"placeId"`
+			} `json:"destination"`
+			Intermediates []struct {
+				Id string `json:"placeId"`
+			} `json:"intermediates"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		points := body.Origin.Id
+		for _, s := range body.Intermediates {
+			points += "," + s.Id
+		}
+		points += "," + body.Destination.Id
+
+		mu.Lock()
+		asked = append(asked, points)
+		mu.Unlock()
+
+		legs := make([]map[string]any, len(body.Intermediates)+1)
+		for i := range legs {
+			legs[i] = map[string]any{"distanceMeters": 1000, "duration": "300s"}
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"routes": []map[string]any{{
+				"distanceMeters":  1000 * len(legs),
+				"localizedValues": map[string]any{"distance": map[string]any{"text": "google's total"}},
+				"legs":            legs,
+			}},
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	api := newTestApi(t, ts)
+	WithLegCache(cache)(api)
+
+	return api, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), asked...)
+	}
+}
+
+func newLegCache(t *testing.T, opts ...LegCacheOption) *LegCache {
+	t.Helper()
+
+	c, err := NewLegCache(opts...)
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestRouteLegsOnlyAsksForLegsItHasNotCached(t *testing.T) {
+	t.Parallel()
+
+	cache := newLegCache(t)
+	api, asked := legsGoogle(t, cache)
+	ctx := context.Background()
+
+	first, err := api.RouteLegs(ctx, RouteLegsOptions{Origin: "a", Stops: []string{"b"}, Destination: "c"})
+	require.NoError(t, err)
+	assert.Equal(t, "google's total", first.DisplayDistance)
+
+	// a>b and b>c are known, so only the two runs either side of them are
+	// asked for.
+	got, err := api.RouteLegs(ctx, RouteLegsOptions{Origin: "x", Stops: []string{"a", "b", "c", "d"}, Destination: "e"})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"a,b,c", "x,a", "c,d,e"}, asked())
+
+	require.Len(t, got.Legs, 5)
+	for i, want := range [][2]string{{"x", "a"}, {"a", "b"}, {"b", "c"}, {"c", "d"}, {"d", "e"}} {
+		assert.Equal(t, want[0], got.Legs[i].FromId)
+		assert.Equal(t, want[1], got.Legs[i].ToId)
+	}
+
+	// Pieced together, the totals are added up rather than taken from any
+	// one of Google's answers.
+	assert.Equal(t, int64(5000), got.Meters)
+	assert.Equal(t, "3.1 mi", got.DisplayDistance)
+	assert.Equal(t, "25 mins", got.DisplayDuration)
+
+	// Once everything is known, Google is not asked at all.
+	_, err = api.RouteLegs(ctx, RouteLegsOptions{Origin: "a", Stops: []string{"b", "c"}, Destination: "d"})
+	require.NoError(t, err)
+	assert.Len(t, asked(), 3)
+
+	assert.Equal(t, LegCacheStats{Hits: 2 + 3, Misses: 2 + 3, Entries: 5}, cache.Stats())
+}
+
+func TestRouteLegsCachesBikeAndCarApart(t *testing.T) {
+	t.Parallel()
+
+	api, asked := legsGoogle(t, newLegCache(t))
+	ctx := context.Background()
+
+	for _, byCar := range []bool{false, true, false, true} {
+		_, err := api.RouteLegs(ctx, RouteLegsOptions{Origin: "a", Destination: "b", ByCar: byCar})
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, asked(), 2)
+}
+
+func TestRouteLegsOnlyServesCuesFromLegsMeasuredWithThem(t *testing.T) {
+	t.Parallel()
+
+	cache := newLegCache(t)
+	api, asked := legsGoogle(t, cache)
+	ctx := context.Background()
+
+	opts := RouteLegsOptions{Origin: "a", Destination: "b"}
+
+	_, err := api.RouteLegs(ctx, opts)
+	require.NoError(t, err)
+
+	opts.Cues = true
+	_, err = api.RouteLegs(ctx, opts)
+	require.NoError(t, err)
+	assert.Len(t, asked(), 2, "the first leg was kept without its cues")
+
+	// Measured with cues, it does for both.
+	for _, cues := range []bool{true, false} {
+		opts.Cues = cues
+		_, err = api.RouteLegs(ctx, opts)
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, asked(), 2)
+}
+
+func TestLegCacheKeepsCuesWhenALegComesBackWithout(t *testing.T) {
+	t.Parallel()
+
+	cache := newLegCache(t)
+	cues := []Cue{{Instruction: "Turn left onto Spring Garden St"}}
+
+	require.NoError(t, cache.put(map[string]RouteLeg{"a>b|BICYCLE": {Meters: 1000, Cues: cues}}, true))
+
+	// Another request measured the same leg at the same time, without cues.
+	require.NoError(t, cache.put(map[string]RouteLeg{"a>b|BICYCLE": {Meters: 1000}}, false))
+
+	got, ok := cache.get("a>b|BICYCLE", true)
+	require.True(t, ok, "the cue sheet was kept")
+	assert.Equal(t, cues, got.Cues)
+}
+
+func TestLegCacheFileKeepsUpWithConcurrentPuts(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "leg-cache.json")
+	cache := newLegCache(t, WithLegCacheFile(path))
+
+	var wg sync.WaitGroup
+
+	for i := range 32 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cache.put(map[string]RouteLeg{legKey(fmt.Sprint(i), "z", "BICYCLE", nil): {Meters: 1000}}, false))
+		}()
+	}
+
+	wg.Wait()
+
+	// Writes finish in any order, but the last to land is the newest.
+	assert.Equal(t, 32, newLegCache(t, WithLegCacheFile(path)).Stats().Entries)
+}
+
+func TestLegCacheExpiresLegs(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cache := newLegCache(t, WithLegCacheTTL(time.Hour))
+	cache.now = func() time.Time { return now }
+
+	api, asked := legsGoogle(t, cache)
+	opts := RouteLegsOptions{Origin: "a", Destination: "b"}
+
+	for _, step := range []time.Duration{0, 59 * time.Minute, time.Minute} {
+		now = now.Add(step)
+
+		_, err := api.RouteLegs(context.Background(), opts)
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, asked(), 2)
+}
+
+func TestLegCacheSurvivesRestarts(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "leg-cache.json")
+
+	api, _ := legsGoogle(t, newLegCache(t, WithLegCacheFile(path)))
+	_, err := api.RouteLegs(context.Background(), RouteLegsOptions{Origin: "a", Stops: []string{"b"}, Destination: "c"})
+	require.NoError(t, err)
+
+	restarted := newLegCache(t, WithLegCacheFile(path))
+	assert.Equal(t, 2, restarted.Stats().Entries)
+
+	api, asked := legsGoogle(t, restarted)
+	got, err := api.RouteLegs(context.Background(), RouteLegsOptions{Origin: "b", Destination: "c"})
+	require.NoError(t, err)
+	assert.Empty(t, asked())
+	assert.Equal(t, int64(1000), got.Meters)
+
+	// Loading drops whatever expired while the server was down.
+	stale := newLegCache(t, WithLegCacheFile(path), WithLegCacheTTL(time.Hour))
+	stale.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+
+	api, _ = legsGoogle(t, stale)
+	_, err = api.RouteLegs(context.Background(), RouteLegsOptions{Origin: "c", Destination: "d"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, newLegCache(t, WithLegCacheFile(path)).Stats().Entries)
+}
+
+func TestLegCacheRejectsACorruptFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "leg-cache.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+
+	_, err := NewLegCache(WithLegCacheFile(path))
+	assert.ErrorContains(t, err, "reading leg cache")
+}
+
+func TestRouteLegsReturnsLegsItCouldNotCache(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "missing", "leg-cache.json")
+
+	api, _ := legsGoogle(t, newLegCache(t, WithLegCacheFile(path)))
+
+	got, err := api.RouteLegs(context.Background(), RouteLegsOptions{Origin: "a", Destination: "b"})
+	require.ErrorIs(t, err, ErrCacheWrite)
+	require.NotNil(t, got)
+	assert.Len(t, got.Legs, 1)
+}
+
+func TestParseGoogleDuration(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, int64(384), parseGoogleDuration("384s"))
+	assert.Equal(t, int64(2), parseGoogleDuration("1.5s"))
+	assert.Zero(t, parseGoogleDuration(""))
+}
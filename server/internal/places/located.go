@@ -0,0 +1,79 @@
+package places
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Some places have no id any provider knows: a corner found in the road
+// extract, or where a rider is right now. Their ids carry their coordinates
+// instead, after a prefix saying what they are, and every provider routes
+// to them by those.
+
+// positionPrefix starts the id of a rider's position.
+const positionPrefix = "position:"
+
+// locatedPrefixes are every prefix of an id that says where it is.
+var locatedPrefixes = []string{intersectionPrefix, positionPrefix}
+
+// PositionId is the id of a place known only by its coordinates, like a
+// rider's position mid-race: "position:39.962300,-75.174100".
+func PositionId(lat, long float64) string {
+	return locatedId(positionPrefix, Coord{Lat: lat, Long: long})
+}
+
+func locatedId(prefix string, c Coord) string {
+	return prefix + strconv.FormatFloat(c.Lat, 'f', 6, 64) + "," + strconv.FormatFloat(c.Long, 'f', 6, 64)
+}
+
+// parseLocatedId reads back where a place is from its id, if the id says.
+func parseLocatedId(id string) (Coord, bool) {
+	for _, prefix := range locatedPrefixes {
+		rest, ok := strings.CutPrefix(id, prefix)
+		if !ok {
+			continue
+		}
+
+		lat, long, ok := strings.Cut(rest, ",")
+		if !ok {
+			return Coord{}, false
+		}
+
+		c := Coord{}
+		var errLat, errLong error
+
+		c.Lat, errLat = strconv.ParseFloat(lat, 64)
+		c.Long, errLong = strconv.ParseFloat(long, 64)
+
+		if errLat != nil || errLong != nil {
+			return Coord{}, false
+		}
+
+		return c, true
+	}
+
+	return Coord{}, false
+}
+
+// MarshalJSON sends Google a place whose id says where it is by those
+// coordinates, since it has no place id for one, and any other place by its
+// id.
+func (p optimizePayloadPlace) MarshalJSON() ([]byte, error) {
+	c, ok := parseLocatedId(p.Id)
+	if !ok {
+		return json.Marshal(struct {
+			Id string `json:"placeId"`
+		}{p.Id})
+	}
+
+	var w struct {
+		Location struct {
+			LatLng coordinates `json:"latLng"`
+		} `json:"location"`
+	}
+
+	w.Location.LatLng = coordinates{Lat: c.Lat, Long: c.Long}
+
+	return json.Marshal(w)
+}
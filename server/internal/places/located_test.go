@@ -0,0 +1,48 @@
+package places
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocatedIdsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	corner := intersectionId(Coord{Lat: 39.9623, Long: -75.1741})
+	assert.Equal(t, "intersection:39.962300,-75.174100", corner)
+
+	rider := PositionId(39.95, -75.16)
+	assert.Equal(t, "position:39.950000,-75.160000", rider)
+
+	c, ok := parseLocatedId(corner)
+	require.True(t, ok)
+	assert.Equal(t, Coord{Lat: 39.9623, Long: -75.1741}, c)
+
+	c, ok = parseLocatedId(rider)
+	require.True(t, ok)
+	assert.Equal(t, Coord{Lat: 39.95, Long: -75.16}, c)
+
+	for _, id := range []string{"ChIJ123", "intersection:39.9", "position:a,b", "osm:W42"} {
+		_, ok := parseLocatedId(id)
+		assert.False(t, ok, id)
+	}
+}
+
+func TestOptimizePayloadPlaceSendsLocatedPlacesByLocation(t *testing.T) {
+	t.Parallel()
+
+	got, err := json.Marshal(routeMatrixWaypoint{Waypoint: optimizePayloadPlace{Id: "intersection:39.962300,-75.174100"}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"waypoint":{"location":{"latLng":{"latitude":39.9623,"longitude":-75.1741}}}}`, string(got))
+
+	got, err = json.Marshal(optimizePayloadPlace{Id: PositionId(39.95, -75.16)})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"location":{"latLng":{"latitude":39.95,"longitude":-75.16}}}`, string(got))
+
+	got, err = json.Marshal(optimizePayloadPlace{Id: "ChIJ123"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"placeId":"ChIJ123"}`, string(got))
+}
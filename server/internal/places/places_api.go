@@ -7,10 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -26,8 +29,26 @@ type PlacesApi struct {
 
 	// Endpoints are fields rather than constants so tests in this package can
 	// point them at an httptest server instead of calling Google for real.
-	searchTextURL    string
-	computeRoutesURL string
+	searchTextURL         string
+	computeRoutesURL      string
+	computeRouteMatrixURL string
+
+	// searchCache, when set, answers CachedTextSearch before Google does.
+	searchCache *TextSearchCache
+
+	// legCache, when set, answers RouteLegs for the legs it has.
+	legCache *LegCache
+
+	// Every request goes through do, which retries with backoff and stops
+	// asking at all while the breaker is open.
+	retries     int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	breaker     breaker
+
+	// meter, when set, adds up what every call costs and refuses those
+	// over budget.
+	meter *Meter
 }
 
 type longLat struct {
@@ -53,26 +74,69 @@ func WithComputeRoutesURL(url string) Option {
 	return func(p *PlacesApi) { p.computeRoutesURL = url }
 }
 
+func WithComputeRouteMatrixURL(url string) Option {
+	return func(p *PlacesApi) { p.computeRouteMatrixURL = url }
+}
+
 func WithHTTPClient(c *http.Client) Option {
 	return func(p *PlacesApi) { p.httpCli = c }
 }
 
+func WithTextSearchCache(c *TextSearchCache) Option {
+	return func(p *PlacesApi) { p.searchCache = c }
+}
+
+func WithLegCache(c *LegCache) Option {
+	return func(p *PlacesApi) { p.legCache = c }
+}
+
+func WithMeter(m *Meter) Option {
+	return func(p *PlacesApi) { p.meter = m }
+}
+
+// OverBudget is whether the meter's budget is spent, so Google would refuse
+// anything that costs.
+func (p *PlacesApi) OverBudget() bool {
+	return p.meter != nil && p.meter.Spent()
+}
+
 func NewPlacesApi(apiKey string, opts ...Option) (*PlacesApi, error) {
 	if apiKey == "" {
 		return nil, errors.New("api key is required for Google Maps")
 	}
 
 	p := &PlacesApi{
-		apiKey:           apiKey,
-		httpCli:          &http.Client{},
-		searchTextURL:    defaultSearchTextURL,
-		computeRoutesURL: defaultComputeRoutesURL,
+		apiKey:                apiKey,
+		httpCli:               &http.Client{},
+		searchTextURL:         defaultSearchTextURL,
+		computeRoutesURL:      defaultComputeRoutesURL,
+		computeRouteMatrixURL: defaultComputeRouteMatrixURL,
+		retries:               defaultRetries,
+		backoffBase:           defaultBackoffBase,
+		backoffMax:            defaultBackoffMax,
+		breaker: breaker{
+			threshold: defaultBreakerThreshold,
+			cooldown:  defaultBreakerCooldown,
+			now:       time.Now,
+		},
 	}
 
 	for _, opt := range opts {
 		opt(p)
 	}
 
+	if p.retries < 0 {
+		return nil, errors.New("retries cannot be negative")
+	}
+
+	if p.backoffBase < 0 || p.backoffMax < p.backoffBase {
+		return nil, errors.New("backoff must be positive and no more than its max")
+	}
+
+	if p.breaker.threshold > 0 && p.breaker.cooldown <= 0 {
+		return nil, errors.New("circuit breaker cooldown must be positive")
+	}
+
 	return p, nil
 }
 
@@ -141,11 +205,12 @@ func (p *PlacesApi) TextSearch(ctx context.Context, opts TextSearchOptions) ([]p
 		"places.location",
 		"places.displayName.text",
 		"places.googleMapsLinks.directionsUri",
+		"places.types",
 	}
 
 	req.Header.Set("X-Goog-FieldMask", strings.Join(masks, ","))
 
-	resp, err := p.httpCli.Do(req)
+	resp, err := p.do(req, billing{sku: textSearchSKU(masks), units: 1})
 
 	if err != nil {
 		return nil, err
@@ -189,6 +254,9 @@ type optimizeRouteOptions struct {
 	start optimizeRouteLocation
 	stops []optimizeRouteLocation
 	end   *optimizeRouteLocation
+
+	// loop brings the ride back to the start, which stands in for the end.
+	loop bool
 }
 
 type optimizeRouteOptionsBuilder struct {
@@ -219,6 +287,12 @@ func (b optimizeRouteOptionsBuilder) WithEnd(id string, lat, long float64) optim
 	return b
 }
 
+// AsLoop makes the route a closed tour that finishes back at the start.
+func (b optimizeRouteOptionsBuilder) AsLoop() optimizeRouteOptionsBuilder {
+	b.options.loop = true
+	return b
+}
+
 func (b optimizeRouteOptionsBuilder) Build() (optimizeRouteOptions, error) {
 	s := b.options.start
 
@@ -232,6 +306,10 @@ func (b optimizeRouteOptionsBuilder) Build() (optimizeRouteOptions, error) {
 		return optimizeRouteOptions{}, errors.New("end id is required")
 	}
 
+	if e != nil && b.options.loop {
+		return optimizeRouteOptions{}, errors.New("a loop finishes at the start, so it cannot have an end")
+	}
+
 	for i, s := range b.options.stops {
 		if s.id == "" {
 			return optimizeRouteOptions{}, fmt.Errorf("stop at index %d: id is required", i)
@@ -265,6 +343,13 @@ type optimizePayload struct {
 }
 
 func optimizePayloadFromOptions(opts optimizeRouteOptions) ([]optimizePayload, error) {
+	if opts.loop {
+		// A closed tour is a fixed-end route whose end is the start: one
+		// request, and Google counts the ride home exactly once.
+		home := opts.start
+		opts.end = &home
+	}
+
 	if opts.end == nil {
 		// we need to set a couple ends and try them all out
 
@@ -343,10 +428,33 @@ func (p optimizePayload) asBike() optimizePayload {
 }
 
 type OptimalRoute struct {
-	Method    string                 `json:"method,omitempty"`
-	End       string                 `json:"destination"`
-	BikeRoute *OptimizeRouteResponse `json:"bike,omitempty"`
-	CarRoute  *OptimizeRouteResponse `json:"car,omitempty"`
+	Method     string                 `json:"method,omitempty"`
+	Algorithm  string                 `json:"algorithm,omitempty"`  // locally solved routes only: "exact" or "heuristic"
+	Optimality string                 `json:"optimality,omitempty"` // locally solved routes only: "optimal" or "within X% of optimal"
+	End        string                 `json:"destination"`
+	BikeRoute  *OptimizeRouteResponse `json:"bike,omitempty"`
+	CarRoute   *OptimizeRouteResponse `json:"car,omitempty"`
+
+	// FootRoute is only ever the local solver's, for a route asked for on
+	// foot.
+	FootRoute *OptimizeRouteResponse `json:"foot,omitempty"`
+
+	// Rank orders alternatives from the same solve, best first at 1, and
+	// GapMeters is how much further than the best this one rides.
+	Rank      int   `json:"rank,omitempty"`
+	GapMeters int64 `json:"gapMeters,omitempty"`
+
+	// Rider says which of a team rides this route, from 1.
+	Rider int `json:"rider,omitempty"`
+
+	// Points and Skipped are set in prize mode: what the route scores, and
+	// the stops it leaves out to stay within budget.
+	Points  *int     `json:"points,omitempty"`
+	Skipped []string `json:"skipped,omitempty"`
+
+	// Provider is the backend that measured the route, set by the handler:
+	// "osm" for the offline router, otherwise the configured provider's name.
+	Provider string `json:"provider,omitempty"`
 }
 
 func (p *PlacesApi) OptimizeRoute(ctx context.Context, opts optimizeRouteOptions) ([]OptimalRoute, error) {
@@ -391,13 +499,13 @@ func (p *PlacesApi) OptimizeRoute(ctx context.Context, opts optimizeRouteOptions
 		req.Header.Set("X-Goog-FieldMask", strings.Join([]string{
 			// "routes.legs",
 			"routes.distanceMeters",
-			// "routes.duration",
+			"routes.duration",
 			// "routes.staticDuration",
 			"routes.optimizedIntermediateWaypointIndex",
 			"routes.localizedValues",
 		}, ","))
 
-		resp, err := p.httpCli.Do(req)
+		resp, err := p.do(req, billing{sku: computeRoutesSKU(len(body.Stops), body.Optimize == "true"), units: 1})
 
 		if err != nil {
 			return nil, fmt.Errorf(".Do: %w", err)
@@ -417,9 +525,10 @@ func (p *PlacesApi) OptimizeRoute(ctx context.Context, opts optimizeRouteOptions
 
 		var respData struct {
 			Routes []struct {
-				Order   []int `json:"optimizedIntermediateWaypointIndex"`
-				Meters  int64 `json:"distanceMeters"`
-				Display struct {
+				Order    []int  `json:"optimizedIntermediateWaypointIndex"`
+				Meters   int64  `json:"distanceMeters"`
+				Duration string `json:"duration"`
+				Display  struct {
 					Distance struct {
 						Text string `json:"text"`
 					} `json:"distance"`
@@ -460,6 +569,7 @@ func (p *PlacesApi) OptimizeRoute(ctx context.Context, opts optimizeRouteOptions
 				end:             body.End.Id,
 				DisplayDistance: route.Display.Distance.Text,
 				DisplayDuration: route.Display.Duration.Text,
+				Seconds:         parseGoogleDuration(route.Duration),
 			})
 		}
 
@@ -500,12 +610,16 @@ func (p *PlacesApi) OptimizeRoute(ctx context.Context, opts optimizeRouteOptions
 		})
 	}
 
-	if err := bikeEg.Wait(); err != nil {
-		return nil, err
+	// Wait for both before failing, so no request is left in flight once
+	// this returns.
+	bikeErr, carErr := bikeEg.Wait(), carEg.Wait()
+
+	if bikeErr != nil {
+		return nil, bikeErr
 	}
 
-	if err := carEg.Wait(); err != nil {
-		return nil, err
+	if carErr != nil {
+		return nil, carErr
 	}
 
 	bikeKeyByEnd := make(map[string][]OptimizeRouteResponse)
@@ -573,9 +687,41 @@ type OptimizeRouteResponse struct {
 	DisplayDistance string   `json:"displayDistance"`
 	DisplayDuration string   `json:"displayDuration"`
 
+	// Seconds is DisplayDuration as a number, counting the time spent at
+	// each stop as well as riding. Providers only know the riding, so the
+	// handler adds the stops' time to the routes they optimize.
+	Seconds int64 `json:"seconds,omitempty"`
+
+	// Etas are only set on routes the local solver timed, one entry per
+	// stop and then the destination.
+	Etas []StopEta `json:"etas,omitempty"`
+
+	// Arrivals is only set on routes solved against a race start time, one
+	// entry per stop and then the destination.
+	Arrivals []StopArrival `json:"arrivals,omitempty"`
+
+	// Profile is the offline router's profile the route was measured under,
+	// and empty for Google's.
+	Profile string `json:"profile,omitempty"`
+
 	end string
 }
 
+// StopEta is how long after leaving the origin a rider following a route
+// reaches one place.
+type StopEta struct {
+	Id      string `json:"id"`
+	Seconds int64  `json:"seconds"`
+}
+
+// StopArrival is when a rider following a route reaches one place.
+type StopArrival struct {
+	Id          string    `json:"id"`
+	At          time.Time `json:"arrivesAt"`
+	WaitSeconds int64     `json:"waitSeconds,omitempty"` // standing outside until it opens
+	Late        bool      `json:"late,omitempty"`        // arrives after it closes
+}
+
 type place struct {
 	Id               string          `json:"id"`
 	FormattedAddress string          `json:"formattedAddress"`
@@ -583,6 +729,7 @@ type place struct {
 	DisplayName      displayName     `json:"displayName"`
 	Links            googleMapsLinks `json:"googleMapsLinks"`
 	Coordinates      coordinates     `json:"location"`
+	Types            []string        `json:"types,omitempty"`
 }
 
 type coordinates struct {
@@ -648,6 +795,14 @@ type RouteLegsOptions struct {
 	Stops       []string
 	Destination string
 	ByCar       bool
+
+	// Cues asks for a cue sheet with every leg. It is off by default: the
+	// steps are most of a response's size, and the map has no use for them.
+	Cues bool
+
+	// Coords locates the waypoints for providers that route by coordinates.
+	// Google goes by place id and ignores it.
+	Coords map[string]Coord
 }
 
 // RouteLeg is one hop between consecutive waypoints.
@@ -657,6 +812,26 @@ type RouteLeg struct {
 	Meters          int64  `json:"meters"`
 	DisplayDistance string `json:"displayDistance"`
 	DisplayDuration string `json:"displayDuration"`
+
+	// Seconds is DisplayDuration as a number.
+	Seconds int64 `json:"seconds,omitempty"`
+
+	// Profile is the offline router's profile the leg was measured under,
+	// and empty for Google's.
+	Profile string `json:"profile,omitempty"`
+
+	// Cues are only set when asked for, one per turn along the leg.
+	Cues []Cue `json:"cues,omitempty"`
+}
+
+// Cue is one line of a cue sheet: the turn onto a street, and how far to
+// ride before the next. Maneuver is one of the Routes API's maneuver names,
+// like TURN_RIGHT, whichever router measured the leg.
+type Cue struct {
+	Maneuver        string `json:"maneuver"`
+	Instruction     string `json:"instruction"`
+	Meters          int64  `json:"meters"`
+	DisplayDistance string `json:"displayDistance"`
 }
 
 type RouteLegsResult struct {
@@ -664,6 +839,9 @@ type RouteLegsResult struct {
 	Meters          int64      `json:"meters"`
 	DisplayDistance string     `json:"displayDistance"`
 	DisplayDuration string     `json:"displayDuration"`
+
+	// Provider is the backend that measured the route, set by the handler.
+	Provider string `json:"provider,omitempty"`
 }
 
 func (o RouteLegsOptions) validate() error {
@@ -694,12 +872,122 @@ func (o RouteLegsOptions) waypoints() []string {
 }
 
 // RouteLegs measures a fixed sequence of waypoints and returns the road
-// distance of each hop. One upstream request covers every leg.
+// distance of each hop. Legs the leg cache already has are not asked for
+// again; each unbroken run of the rest is one upstream request.
 func (p *PlacesApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*RouteLegsResult, error) {
 	if err := opts.validate(); err != nil {
 		return nil, err
 	}
 
+	points := opts.waypoints()
+	mode, avoids := legsTravelMode(opts.ByCar)
+
+	legs := make([]RouteLeg, len(points)-1)
+	keys := make([]string, len(legs))
+
+	// runs are the hops [start, end) still to measure.
+	type run struct{ start, end int }
+	var runs []run
+
+	for i := range legs {
+		keys[i] = legKey(points[i], points[i+1], mode, avoids)
+
+		if p.legCache != nil {
+			if leg, ok := p.legCache.get(keys[i], opts.Cues); ok {
+				legs[i] = leg
+				continue
+			}
+		}
+
+		if n := len(runs); n > 0 && runs[n-1].end == i {
+			runs[n-1].end++
+		} else {
+			runs = append(runs, run{i, i + 1})
+		}
+	}
+
+	// Measured in one go, Google's own totals stand; pieced together from
+	// the cache, they are added up here.
+	var whole *measuredLegs
+
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	for _, r := range runs {
+		eg.Go(func() error {
+			m, err := p.measureLegs(egCtx, opts, points[r.start:r.end+1])
+			if err != nil {
+				return err
+			}
+
+			copy(legs[r.start:r.end], m.legs)
+
+			if r.start == 0 && r.end == len(legs) {
+				whole = m
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	res := &RouteLegsResult{Legs: legs}
+
+	if whole != nil {
+		res.Meters = whole.meters
+		res.DisplayDistance = whole.displayDistance
+		res.DisplayDuration = whole.displayDuration
+	} else {
+		var seconds int64
+		for _, leg := range legs {
+			res.Meters += leg.Meters
+			seconds += leg.Seconds
+		}
+
+		res.DisplayDistance = DisplayMiles(float64(res.Meters))
+		res.DisplayDuration = DisplayDuration(float64(seconds))
+	}
+
+	if p.legCache != nil && len(runs) > 0 {
+		fresh := make(map[string]RouteLeg)
+		for _, r := range runs {
+			for i := r.start; i < r.end; i++ {
+				fresh[keys[i]] = legs[i]
+			}
+		}
+
+		if err := p.legCache.put(fresh, opts.Cues); err != nil {
+			return res, fmt.Errorf("%w: %w", ErrCacheWrite, err)
+		}
+	}
+
+	return res, nil
+}
+
+// legsTravelMode is how RouteLegs asks Google to ride: by bike, or by car
+// off the tolls and highways a courier's car keeps off.
+func legsTravelMode(byCar bool) (string, *optimizePayloadAvoids) {
+	if byCar {
+		return "DRIVE", &optimizePayloadAvoids{Tolls: true, Highways: true}
+	}
+
+	return "BICYCLE", nil
+}
+
+// measuredLegs is one computeRoutes request's answer: its legs in order,
+// and Google's totals for the lot.
+type measuredLegs struct {
+	legs            []RouteLeg
+	meters          int64
+	displayDistance string
+	displayDuration string
+}
+
+// measureLegs asks Google for the legs between consecutive points, which
+// start and end the route and stop at every point between.
+func (p *PlacesApi) measureLegs(ctx context.Context, opts RouteLegsOptions, points []string) (*measuredLegs, error) {
 	var body struct {
 		Start    optimizePayloadPlace   `json:"origin"`
 		End      optimizePayloadPlace   `json:"destination"`
@@ -709,22 +997,17 @@ func (p *PlacesApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*Rout
 		Optimize bool                   `json:"optimizeWaypointOrder"`
 	}
 
-	body.Start = optimizePayloadPlace{Id: opts.Origin}
-	body.End = optimizePayloadPlace{Id: opts.Destination}
+	body.Start = optimizePayloadPlace{Id: points[0]}
+	body.End = optimizePayloadPlace{Id: points[len(points)-1]}
 	// Explicitly false: the order is the answer being measured, not a question
 	// for Google to re-answer.
 	body.Optimize = false
 
-	for _, s := range opts.Stops {
+	for _, s := range points[1 : len(points)-1] {
 		body.Stops = append(body.Stops, optimizePayloadPlace{Id: s})
 	}
 
-	if opts.ByCar {
-		body.Vehicle = "DRIVE"
-		body.Avoids = &optimizePayloadAvoids{Tolls: true, Highways: true}
-	} else {
-		body.Vehicle = "BICYCLE"
-	}
+	body.Vehicle, body.Avoids = legsTravelMode(opts.ByCar)
 
 	jsonData, err := json.Marshal(body)
 
@@ -738,14 +1021,25 @@ func (p *PlacesApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*Rout
 		return nil, fmt.Errorf("building req: %w", err)
 	}
 
-	req.Header.Set("X-Goog-FieldMask", strings.Join([]string{
+	masks := []string{
 		"routes.distanceMeters",
 		"routes.localizedValues",
 		"routes.legs.distanceMeters",
+		"routes.legs.duration",
 		"routes.legs.localizedValues",
-	}, ","))
+	}
 
-	resp, err := p.httpCli.Do(req)
+	if opts.Cues {
+		masks = append(masks,
+			"routes.legs.steps.distanceMeters",
+			"routes.legs.steps.localizedValues",
+			"routes.legs.steps.navigationInstruction",
+		)
+	}
+
+	req.Header.Set("X-Goog-FieldMask", strings.Join(masks, ","))
+
+	resp, err := p.do(req, billing{sku: computeRoutesSKU(len(body.Stops), body.Optimize), units: 1})
 
 	if err != nil {
 		return nil, fmt.Errorf(".Do: %w", err)
@@ -775,8 +1069,9 @@ func (p *PlacesApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*Rout
 				} `json:"duration"`
 			} `json:"localizedValues"`
 			Legs []struct {
-				Meters  int64 `json:"distanceMeters"`
-				Display struct {
+				Meters   int64  `json:"distanceMeters"`
+				Duration string `json:"duration"`
+				Display  struct {
 					Distance struct {
 						Text string `json:"text"`
 					} `json:"distance"`
@@ -784,6 +1079,18 @@ func (p *PlacesApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*Rout
 						Text string `json:"text"`
 					} `json:"duration"`
 				} `json:"localizedValues"`
+				Steps []struct {
+					Meters  int64 `json:"distanceMeters"`
+					Display struct {
+						Distance struct {
+							Text string `json:"text"`
+						} `json:"distance"`
+					} `json:"localizedValues"`
+					Navigation struct {
+						Maneuver     string `json:"maneuver"`
+						Instructions string `json:"instructions"`
+					} `json:"navigationInstruction"`
+				} `json:"steps"`
 			} `json:"legs"`
 		} `json:"routes"`
 	}
@@ -797,7 +1104,6 @@ func (p *PlacesApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*Rout
 	}
 
 	route := respData.Routes[0]
-	points := opts.waypoints()
 
 	// One leg per hop. A mismatch means the response does not describe the
 	// order that was asked for, so pairing ids to legs would be a guess.
@@ -808,19 +1114,45 @@ func (p *PlacesApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*Rout
 	legs := make([]RouteLeg, 0, len(route.Legs))
 
 	for i, leg := range route.Legs {
+		var cues []Cue
+
+		// A step's instruction is the turn that starts it, and its distance
+		// the riding after, which is already a cue sheet line.
+		for _, step := range leg.Steps {
+			cues = append(cues, Cue{
+				Maneuver:        step.Navigation.Maneuver,
+				Instruction:     step.Navigation.Instructions,
+				Meters:          step.Meters,
+				DisplayDistance: step.Display.Distance.Text,
+			})
+		}
+
 		legs = append(legs, RouteLeg{
 			FromId:          points[i],
 			ToId:            points[i+1],
 			Meters:          leg.Meters,
+			Seconds:         parseGoogleDuration(leg.Duration),
 			DisplayDistance: leg.Display.Distance.Text,
 			DisplayDuration: leg.Display.Duration.Text,
+			Cues:            cues,
 		})
 	}
 
-	return &RouteLegsResult{
-		Legs:            legs,
-		Meters:          route.Meters,
-		DisplayDistance: route.Display.Distance.Text,
-		DisplayDuration: route.Display.Duration.Text,
+	return &measuredLegs{
+		legs:            legs,
+		meters:          route.Meters,
+		displayDistance: route.Display.Distance.Text,
+		displayDuration: route.Display.Duration.Text,
 	}, nil
 }
+
+// parseGoogleDuration reads a protobuf duration, like "384s", in whole
+// seconds. Anything else is 0, which only costs a leg its number.
+func parseGoogleDuration(d string) int64 {
+	secs, err := strconv.ParseFloat(strings.TrimSuffix(d, "s"), 64)
+	if err != nil {
+		return 0
+	}
+
+	return int64(math.Round(secs))
+}
@@ -13,7 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// newTestApi returns a client pointed at ts for both Google endpoints, so no
+// newTestApi returns a client pointed at ts for every Google endpoint, so no
 // test in this package reaches the network.
 func newTestApi(t *testing.T, ts *httptest.Server) *PlacesApi {
 	t.Helper()
@@ -23,6 +23,7 @@ func newTestApi(t *testing.T, ts *httptest.Server) *PlacesApi {
 
 	api.searchTextURL = ts.URL
 	api.computeRoutesURL = ts.URL
+	api.computeRouteMatrixURL = ts.URL
 	api.httpCli = ts.Client()
 
 	return api
@@ -47,6 +48,7 @@ func TestNewPlacesApiDefaults(t *testing.T) {
 	assert.NotNil(t, api.httpCli)
 	assert.Equal(t, defaultSearchTextURL, api.searchTextURL)
 	assert.Equal(t, defaultComputeRoutesURL, api.computeRoutesURL)
+	assert.Equal(t, defaultComputeRouteMatrixURL, api.computeRouteMatrixURL)
 }
 
 func TestTextSearchOptionsJson(t *testing.T) {
@@ -177,6 +179,7 @@ func TestTextSearchSendsExpectedRequest(t *testing.T) {
 		"places.location",
 		"places.displayName.text",
 		"places.googleMapsLinks.directionsUri",
+		"places.types",
 	} {
 		assert.Contains(t, got.fieldMask, want)
 	}
@@ -366,6 +369,30 @@ func TestOptimizePayloadFromOptionsWithFixedEnd(t *testing.T) {
 	)
 }
 
+func TestOptimizePayloadFromOptionsLoopReturnsToStart(t *testing.T) {
+	t.Parallel()
+
+	opts := optimizeRouteOptions{
+		start: optimizeRouteLocation{id: "start"},
+		stops: []optimizeRouteLocation{{id: "a"}, {id: "b"}, {id: "c"}},
+		loop:  true,
+	}
+
+	payloads, err := optimizePayloadFromOptions(opts)
+	require.NoError(t, err)
+
+	require.Len(t, payloads, 1, "a loop has only one finish")
+
+	p := payloads[0]
+	assert.Equal(t, "start", p.Start.Id)
+	assert.Equal(t, "start", p.End.Id)
+	assert.Equal(t,
+		[]optimizePayloadPlace{{Id: "a"}, {Id: "b"}, {Id: "c"}},
+		p.Stops,
+		"every stop is an intermediate",
+	)
+}
+
 func TestOptimizePayloadFromOptionsWithoutEndTriesEveryStop(t *testing.T) {
 	t.Parallel()
 
@@ -535,6 +562,24 @@ func TestOptimizeRouteBuilder(t *testing.T) {
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "end")
 	})
+
+	t.Run("loop", func(t *testing.T) {
+		t.Parallel()
+
+		opts, err := valid().AsLoop().Build()
+		require.NoError(t, err)
+		assert.True(t, opts.loop)
+		assert.Nil(t, opts.end)
+	})
+
+	t.Run("loop with end", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := valid().WithEnd("end", 7, 8).AsLoop().Build()
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "loop")
+	})
 }
 
 func TestBuilderStoresLatLongInCorrectFields(t *testing.T) {
@@ -584,6 +629,7 @@ func routesHandler(t *testing.T, distanceByMode map[string]int64) http.HandlerFu
 		resp := map[string]any{
 			"routes": []map[string]any{{
 				"distanceMeters":                    distanceByMode[body.Vehicle],
+				"duration":                          "600s",
 				"optimizedIntermediateWaypointIndex": order,
 				"localizedValues": map[string]any{
 					"distance": map[string]any{"text": "1.0 mi"},
@@ -631,6 +677,7 @@ func TestOptimizeRouteMapsWaypointIndexesToPlaceIds(t *testing.T) {
 	assert.Equal(t, int64(2000), got.CarRoute.Meters)
 	assert.Equal(t, "1.0 mi", got.BikeRoute.DisplayDistance)
 	assert.Equal(t, "10 mins", got.BikeRoute.DisplayDuration)
+	assert.Equal(t, int64(600), got.BikeRoute.Seconds)
 }
 
 func TestOptimizeRouteWithoutEndReturnsOnePerCandidate(t *testing.T) {
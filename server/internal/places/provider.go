@@ -0,0 +1,102 @@
+package places
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// A provider is a geocoding and routing backend: Google, a self-hosted
+// OSRM and Nominatim, or the in-memory fake. The handlers only ever talk to
+// one through these interfaces, so which answers is configuration.
+
+// Searcher finds places from free text.
+type Searcher interface {
+	Search(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error)
+}
+
+// LegRouter measures a route whose order is already decided.
+type LegRouter interface {
+	RouteLegs(ctx context.Context, opts RouteLegsOptions) (*RouteLegsResult, error)
+}
+
+// Optimizer orders a route's stops itself, or measures every pair of them
+// for the local solver to order.
+type Optimizer interface {
+	OptimizeRoute(ctx context.Context, opts optimizeRouteOptions) ([]OptimalRoute, error)
+	RouteMatrix(ctx context.Context, opts RouteMatrixOptions) (*RouteMatrix, error)
+}
+
+// Provider is everything the handlers need from a backend.
+type Provider interface {
+	Searcher
+	LegRouter
+	Optimizer
+
+	// Name is what responses record the provider as.
+	Name() string
+
+	// Available is nil while the provider is worth asking, and otherwise
+	// why not, so a caller with another way to answer need not wait to be
+	// refused.
+	Available() error
+}
+
+var (
+	_ Provider = (*PlacesApi)(nil)
+	_ Provider = (*SelfHostedApi)(nil)
+	_ Provider = (*Fake)(nil)
+)
+
+// ErrNoCoordinates means a provider that routes by coordinates was asked
+// about a place it was given no coordinates for.
+var ErrNoCoordinates = errors.New("no coordinates for place")
+
+// Coord is where a place is.
+type Coord struct {
+	Lat  float64
+	Long float64
+}
+
+// coordsOf looks up every id in coords, for providers that cannot route by
+// place id alone. A place whose id says where it is needs no lookup.
+func coordsOf(ids []string, coords map[string]Coord) ([]Coord, error) {
+	out := make([]Coord, 0, len(ids))
+
+	for _, id := range ids {
+		c, ok := coords[id]
+		if !ok {
+			c, ok = parseLocatedId(id)
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrNoCoordinates, id)
+		}
+
+		out = append(out, c)
+	}
+
+	return out, nil
+}
+
+// Name is "google".
+func (p *PlacesApi) Name() string {
+	return "google"
+}
+
+// Available says whether Google's breaker is open or its budget spent.
+func (p *PlacesApi) Available() error {
+	switch {
+	case p.CircuitOpen():
+		return ErrCircuitOpen
+	case p.OverBudget():
+		return ErrBudgetExceeded
+	}
+
+	return nil
+}
+
+// Search is CachedTextSearch.
+func (p *PlacesApi) Search(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+	return p.CachedTextSearch(ctx, opts)
+}
@@ -0,0 +1,258 @@
+package places
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRetries is how many more times a request is sent after a
+	// failure Google may get over. Every retry eats into the handler's
+	// timeout, and the local solver is always there to fall back on, so
+	// this stays small.
+	defaultRetries = 2
+
+	defaultBackoffBase = 250 * time.Millisecond
+	defaultBackoffMax  = 4 * time.Second
+
+	// Five failures in a row is no blip; half a minute is long enough for
+	// a rate limit to reset without leaving Google out of a whole race.
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen means Google has been failing, so the request was not sent.
+var ErrCircuitOpen = errors.New("google is unavailable: circuit breaker open")
+
+// WithRetries sets how many times a request is retried after a transport
+// error, a 429 or a 5xx. Zero sends every request once.
+func WithRetries(n int) Option {
+	return func(p *PlacesApi) { p.retries = n }
+}
+
+// WithBackoff sets the wait before the first retry, which doubles with every
+// retry up to max. A Retry-After longer than max is not waited out.
+func WithBackoff(base, max time.Duration) Option {
+	return func(p *PlacesApi) {
+		p.backoffBase = base
+		p.backoffMax = max
+	}
+}
+
+// WithCircuitBreaker opens the breaker after threshold failures in a row, and
+// tries Google again once cooldown has passed. A threshold of zero never
+// opens it.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(p *PlacesApi) {
+		p.breaker.threshold = threshold
+		p.breaker.cooldown = cooldown
+	}
+}
+
+// CircuitOpen is whether Google has been failing lately, so that callers
+// with another way to answer can skip it rather than wait to be refused.
+func (p *PlacesApi) CircuitOpen() bool {
+	return p.breaker.isOpen()
+}
+
+// do sends req, retrying after failures Google may get over and refusing
+// while the breaker is open or when b would go over budget. When the retries
+// run out it returns the last response, so the caller still sees Google's
+// reason. Only an answer Google bills for, a 2xx, is charged to the meter;
+// whatever the meter held for any other outcome is refunded.
+func (p *PlacesApi) do(req *http.Request, b billing) (*http.Response, error) {
+	ctx := req.Context()
+
+	billed := false
+
+	if p.meter != nil {
+		if err := p.meter.allow(b); err != nil {
+			return nil, err
+		}
+
+		defer func() {
+			if !billed {
+				p.meter.refund(b)
+			}
+		}()
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !p.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		try := req.Clone(ctx)
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				// Google was never asked, but the breaker let this attempt
+				// through and may be waiting on it as its probe.
+				p.breaker.record(outcomeIgnored)
+				return nil, fmt.Errorf("rewinding body: %w", err)
+			}
+
+			try.Body = body
+		}
+
+		resp, err := p.httpCli.Do(try)
+
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// Giving up on Google is not Google failing.
+			p.breaker.record(outcomeIgnored)
+			return nil, err
+		case err != nil || retryableStatus(resp.StatusCode):
+			p.breaker.record(outcomeFailed)
+		default:
+			p.breaker.record(outcomeOK)
+
+			if p.meter != nil && resp.StatusCode/100 == 2 {
+				p.meter.charge(b)
+				billed = true
+			}
+
+			return resp, nil
+		}
+
+		wait := p.backoff(attempt)
+
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				wait = max(wait, after)
+			}
+		}
+
+		deadline, hasDeadline := ctx.Deadline()
+
+		if attempt >= p.retries || wait > p.backoffMax || (hasDeadline && time.Until(deadline) < wait) || p.breaker.isOpen() {
+			return resp, err
+		}
+
+		if resp != nil {
+			drainAndClose(resp.Body)
+		}
+
+		t := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// backoff is a random wait up to base doubled attempt times, so callers
+// that failed together do not all retry together.
+func (p *PlacesApi) backoff(attempt int) time.Duration {
+	ceiling := min(p.backoffBase<<attempt, p.backoffMax)
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return rand.N(ceiling) + 1
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// retryAfter reads a Retry-After header, which is either a number of seconds
+// or a date.
+func retryAfter(h string, now time.Time) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(h); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+type outcome int
+
+const (
+	outcomeOK outcome = iota
+	outcomeFailed
+	outcomeIgnored
+)
+
+// breaker counts Google's failures in a row. At threshold it opens, and
+// every request is refused until cooldown has passed; then one request is
+// let through to see whether Google is back, and its outcome closes the
+// breaker or opens it again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+
+	if b.now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+
+	return true
+}
+
+func (b *breaker) record(o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	switch o {
+	case outcomeOK:
+		b.failures = 0
+	case outcomeFailed:
+		b.failures++
+
+		if b.threshold > 0 && b.failures >= b.threshold {
+			b.openUntil = b.now().Add(b.cooldown)
+		}
+	}
+}
+
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.threshold > 0 && b.failures >= b.threshold && b.now().Before(b.openUntil)
+}
@@ -0,0 +1,271 @@
+package places
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyGoogle answers each search with the next of statuses, then with 200
+// and one place once they run out. It records every body it was sent.
+func flakyGoogle(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32, *[]string) {
+	t.Helper()
+
+	var calls atomic.Int32
+	var bodies []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+
+		raw, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(raw))
+
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			_, _ = w.Write([]byte(`{"error":{"message":"try later"}}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1"}]}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &calls, &bodies
+}
+
+// resilientApi is newTestApi with backoff short enough not to slow tests.
+func resilientApi(t *testing.T, ts *httptest.Server, opts ...Option) *PlacesApi {
+	t.Helper()
+
+	api := newTestApi(t, ts)
+	WithBackoff(time.Millisecond, 10*time.Millisecond)(api)
+
+	for _, opt := range opts {
+		opt(api)
+	}
+
+	return api
+}
+
+func TestDoRetriesWhatGoogleMayGetOver(t *testing.T) {
+	t.Parallel()
+
+	for _, status := range []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			t.Parallel()
+
+			ts, calls, bodies := flakyGoogle(t, status, status)
+
+			got, err := resilientApi(t, ts).TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+			require.NoError(t, err)
+			assert.Len(t, got, 1)
+
+			assert.Equal(t, int32(3), calls.Load())
+			for _, b := range *bodies {
+				assert.JSONEq(t, `{"textQuery":"city hall"}`, b, "every retry resends the body")
+			}
+		})
+	}
+}
+
+func TestDoDoesNotRetryTheCallersMistakes(t *testing.T) {
+	t.Parallel()
+
+	ts, calls, _ := flakyGoogle(t, http.StatusBadRequest)
+
+	_, err := resilientApi(t, ts).TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestDoReturnsTheLastResponseOnceRetriesRunOut(t *testing.T) {
+	t.Parallel()
+
+	ts, calls, _ := flakyGoogle(t, 503, 503, 503, 503)
+
+	_, err := resilientApi(t, ts).TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+	assert.Contains(t, err.Error(), "try later")
+	assert.Equal(t, int32(1+defaultRetries), calls.Load())
+}
+
+func TestDoDoesNotWaitOutALongRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+
+	_, err := resilientApi(t, ts).TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDoDoesNotRetryPastTheDeadline(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	// Retry-After sets the wait, which the jittered backoff alone only
+	// usually makes longer than the deadline.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	api := resilientApi(t, ts, WithBackoff(time.Second, 2*time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := api.TextSearch(ctx, TextSearchOptions{Query: "city hall"})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"Sun, 01 Jun 2025 12:00:10 GMT", 10 * time.Second, true},
+		{"Sun, 01 Jun 2025 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+		{"-1", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header, now)
+		assert.Equal(t, tt.ok, ok, tt.header)
+		assert.Equal(t, tt.want, got, tt.header)
+	}
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	t.Parallel()
+
+	ts, calls, _ := flakyGoogle(t, 503, 503, 503, 503)
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	api := resilientApi(t, ts, WithRetries(0), WithCircuitBreaker(2, time.Minute))
+	api.breaker.now = func() time.Time { return now }
+
+	search := func() error {
+		_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+		return err
+	}
+
+	require.Error(t, search())
+	assert.False(t, api.CircuitOpen())
+
+	require.Error(t, search())
+	assert.True(t, api.CircuitOpen())
+
+	// Refused without asking.
+	assert.ErrorIs(t, search(), ErrCircuitOpen)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Past the cooldown one request goes through, and its failing opens
+	// the breaker again.
+	now = now.Add(time.Minute)
+	assert.False(t, api.CircuitOpen())
+	require.Error(t, search())
+	assert.ErrorIs(t, search(), ErrCircuitOpen)
+	assert.Equal(t, int32(3), calls.Load())
+
+	now = now.Add(time.Minute)
+	require.Error(t, search())
+
+	// The first success closes it.
+	now = now.Add(time.Minute)
+	require.NoError(t, search())
+	assert.False(t, api.CircuitOpen())
+	require.NoError(t, search())
+	assert.Equal(t, int32(6), calls.Load())
+}
+
+func TestCircuitBreakerStopsRetries(t *testing.T) {
+	t.Parallel()
+
+	ts, calls, _ := flakyGoogle(t, 503, 503, 503, 503)
+
+	api := resilientApi(t, ts, WithRetries(5), WithCircuitBreaker(2, time.Minute))
+
+	_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	require.Error(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+	assert.True(t, api.CircuitOpen())
+}
+
+func TestDoFreesTheProbeWhenTheBodyCannotBeRewound(t *testing.T) {
+	t.Parallel()
+
+	ts, calls, _ := flakyGoogle(t, 503)
+
+	// The first failure opens the breaker, and the cooldown is over by the
+	// retry, which makes the retry the breaker's probe.
+	api := resilientApi(t, ts, WithRetries(1), WithCircuitBreaker(1, time.Nanosecond))
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, ts.URL, strings.NewReader(`{}`))
+	require.NoError(t, err)
+
+	req.GetBody = func() (io.ReadCloser, error) {
+		return nil, errors.New("body gone")
+	}
+
+	_, err = api.do(req, billing{})
+	require.ErrorContains(t, err, "rewinding body")
+	assert.Equal(t, int32(1), calls.Load())
+
+	assert.True(t, api.breaker.allow(), "the probe that never went out is not still pending")
+}
+
+func TestNewPlacesApiValidatesResilience(t *testing.T) {
+	t.Parallel()
+
+	for name, opt := range map[string]Option{
+		"negative retries": WithRetries(-1),
+		"backoff over max": WithBackoff(time.Second, time.Millisecond),
+		"no cooldown":      WithCircuitBreaker(3, 0),
+	} {
+		_, err := NewPlacesApi("test-key", opt)
+		assert.Error(t, err, name)
+	}
+
+	_, err := NewPlacesApi("test-key", WithCircuitBreaker(0, 0))
+	assert.NoError(t, err, "a threshold of zero turns the breaker off")
+}
@@ -0,0 +1,186 @@
+package places
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// MaxResolveLines caps one batch. No race sheet has more checkpoints,
+	// and every line is a search.
+	MaxResolveLines = 50
+
+	// resolveConcurrency is how many of a batch's searches are in flight at
+	// once: quick enough for a whole sheet, gentle enough on the quota.
+	resolveConcurrency = 4
+
+	// resolveAlternatives is how many runners-up come back with each line,
+	// for the rider to pick from when the best is wrong.
+	resolveAlternatives = 4
+
+	// confidentAbove is the confidence a match needs to stand without the
+	// rider checking it.
+	confidentAbove = 0.5
+)
+
+// Resolution statuses. Anything but resolved wants the rider's attention.
+const (
+	StatusResolved  = "resolved"
+	StatusAmbiguous = "ambiguous"
+	StatusNotFound  = "not_found"
+	StatusFailed    = "failed"
+)
+
+// ResolveOptions is a race sheet's checkpoint lines, and where the race is.
+type ResolveOptions struct {
+	Lines   []string `json:"lines"`
+	LongLat *longLat `json:"locationBias,omitempty"`
+}
+
+// Resolution is what one line of a sheet resolved to.
+type Resolution struct {
+	Line         string  `json:"line"`
+	Status       string  `json:"status"`
+	Best         *place  `json:"best,omitempty"`
+	Alternatives []place `json:"alternatives"`
+
+	// Confidence, from 0 to 1, is how much of the line the best match's
+	// name and address account for, less however close the runner-up
+	// comes.
+	Confidence float64 `json:"confidence"`
+
+	// Error is why a failed line's search did not answer, and Err the
+	// same for errors.Is.
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-"`
+}
+
+// Resolve searches for every line of a sheet, a few at a time, and says how
+// sure it is of each. Every search gets lineTimeout to itself, so one slow
+// line cannot use up the batch. A line whose search fails or times out is
+// reported as failed rather than failing the batch, and so is every line
+// still waiting its turn once ctx is done: whatever did resolve always comes
+// back.
+func Resolve(ctx context.Context, s Searcher, opts ResolveOptions, lineTimeout time.Duration) []Resolution {
+	out := make([]Resolution, len(opts.Lines))
+
+	var eg errgroup.Group
+	eg.SetLimit(resolveConcurrency)
+
+	for i, line := range opts.Lines {
+		eg.Go(func() error {
+			out[i] = resolveLine(ctx, s, TextSearchOptions{Query: line, LongLat: opts.LongLat}, lineTimeout)
+			return nil
+		})
+	}
+
+	// No line fails the batch, so there is no error to wait for.
+	_ = eg.Wait()
+
+	return out
+}
+
+// resolveLine searches for one line, giving up after timeout.
+func resolveLine(ctx context.Context, s Searcher, opts TextSearchOptions, timeout time.Duration) Resolution {
+	if err := ctx.Err(); err != nil {
+		return failedResolution(opts.Query, err)
+	}
+
+	lineCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := s.Search(lineCtx, opts)
+
+	// A result that could not be cached is still a result.
+	if err != nil && !errors.Is(err, ErrCacheWrite) {
+		return failedResolution(opts.Query, err)
+	}
+
+	return resolution(opts.Query, res.Places)
+}
+
+func failedResolution(line string, err error) Resolution {
+	return Resolution{Line: line, Status: StatusFailed, Alternatives: []place{}, Error: err.Error(), Err: err}
+}
+
+// resolution takes the search's first place as the best match, as the
+// search dialog does, and scores it against the line.
+func resolution(line string, found []place) Resolution {
+	r := Resolution{Line: line, Status: StatusNotFound, Alternatives: []place{}}
+
+	if len(found) == 0 {
+		return r
+	}
+
+	best := found[0]
+	r.Best = &best
+	r.Alternatives = append(r.Alternatives, found[1:min(len(found), resolveAlternatives+1)]...)
+
+	want := words(line)
+	r.Confidence = matchScore(want, best)
+
+	// A runner-up nearly as good as the best means the search could as
+	// well have meant either.
+	if len(found) > 1 && r.Confidence > 0 {
+		closeness := min(matchScore(want, found[1])/r.Confidence, 1)
+		r.Confidence *= 1 - closeness/2
+	}
+
+	if r.Confidence > confidentAbove {
+		r.Status = StatusResolved
+	} else {
+		r.Status = StatusAmbiguous
+	}
+
+	return r
+}
+
+// matchScore is the share of want found among p's name and address words.
+func matchScore(want []string, p place) float64 {
+	if len(want) == 0 {
+		return 0
+	}
+
+	have := make(map[string]bool)
+	for _, w := range words(p.DisplayName.Name + " " + p.FormattedAddress) {
+		have[w] = true
+	}
+
+	n := 0
+
+	for _, w := range want {
+		if have[w] {
+			n++
+		}
+	}
+
+	return float64(n) / float64(len(want))
+}
+
+// fillerWords say nothing about where a place is.
+var fillerWords = map[string]bool{
+	"the": true, "and": true, "at": true, "of": true, "on": true, "in": true,
+}
+
+// words lowercases s and splits it into the words that could pick out a
+// place, dropping punctuation and filler.
+func words(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	out := fields[:0]
+
+	for _, f := range fields {
+		if !fillerWords[f] {
+			out = append(out, f)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,150 @@
+package places
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// searchFunc is a Searcher from a function, for failures the Fake cannot
+// have.
+type searchFunc func(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error)
+
+func (f searchFunc) Search(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+	return f(ctx, opts)
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	fake := NewFake(
+		FakePlace{Id: "boat", Name: "Malta Boat Club", Address: "9 Boathouse Row, Philadelphia"},
+		FakePlace{Id: "rink", Name: "Penn Ice Rink", Address: "3130 Walnut St, Philadelphia"},
+		FakePlace{Id: "wawa1", Name: "Wawa", Address: "1500 Broad St, Philadelphia"},
+		FakePlace{Id: "wawa2", Name: "Wawa", Address: "2000 Hamilton St, Philadelphia"},
+	)
+
+	got := Resolve(context.Background(), fake, ResolveOptions{Lines: []string{
+		"Malta Boat Club",
+		"wawa",
+		"Spruce Street Harbor Park",
+		"ice rink",
+	}}, time.Second)
+	require.Len(t, got, 4)
+
+	assert.Equal(t, StatusResolved, got[0].Status)
+	assert.Equal(t, "boat", got[0].Best.Id)
+	assert.Equal(t, 1.0, got[0].Confidence)
+	assert.Empty(t, got[0].Alternatives)
+
+	assert.Equal(t, StatusAmbiguous, got[1].Status, "either Wawa will do as well")
+	assert.Equal(t, "wawa1", got[1].Best.Id)
+	require.Len(t, got[1].Alternatives, 1)
+	assert.Equal(t, "wawa2", got[1].Alternatives[0].Id)
+	assert.Equal(t, 0.5, got[1].Confidence)
+
+	assert.Equal(t, StatusNotFound, got[2].Status)
+	assert.Nil(t, got[2].Best)
+	assert.Zero(t, got[2].Confidence)
+
+	assert.Equal(t, StatusResolved, got[3].Status)
+	assert.Equal(t, "Spruce Street Harbor Park", got[2].Line, "lines come back in order")
+}
+
+func TestResolveReportsEachFailedLine(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("boom")
+
+	s := searchFunc(func(_ context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+		switch opts.Query {
+		case "broken line":
+			return TextSearchResult{}, boom
+		case "uncached":
+			return TextSearchResult{Places: []place{{Id: "p1", DisplayName: displayName{Name: "Uncached"}}}}, ErrCacheWrite
+		}
+
+		return TextSearchResult{Places: []place{{Id: "p2", DisplayName: displayName{Name: "Fine Line"}}}}, nil
+	})
+
+	got := Resolve(context.Background(), s, ResolveOptions{Lines: []string{"broken line", "uncached", "fine line"}}, time.Second)
+
+	assert.Equal(t, StatusFailed, got[0].Status)
+	assert.ErrorIs(t, got[0].Err, boom)
+	assert.Equal(t, "boom", got[0].Error)
+
+	assert.Equal(t, StatusResolved, got[1].Status, "a result that could not be cached is still a result")
+	assert.Equal(t, StatusResolved, got[2].Status)
+}
+
+func TestResolveBoundsConcurrency(t *testing.T) {
+	t.Parallel()
+
+	var inFlight, most atomic.Int32
+
+	s := searchFunc(func(_ context.Context, _ TextSearchOptions) (TextSearchResult, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		return TextSearchResult{}, nil
+	})
+
+	lines := make([]string, 20)
+	for i := range lines {
+		lines[i] = "city hall"
+	}
+
+	got := Resolve(context.Background(), s, ResolveOptions{Lines: lines}, time.Second)
+
+	assert.Len(t, got, 20)
+	assert.LessOrEqual(t, most.Load(), int32(resolveConcurrency))
+}
+
+func TestResolveGivesEachLineItsOwnTimeout(t *testing.T) {
+	t.Parallel()
+
+	s := searchFunc(func(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+		if opts.Query == "slow line" {
+			<-ctx.Done()
+			return TextSearchResult{}, ctx.Err()
+		}
+
+		return TextSearchResult{Places: []place{{Id: "p1", DisplayName: displayName{Name: "Fine Line"}}}}, nil
+	})
+
+	got := Resolve(context.Background(), s, ResolveOptions{Lines: []string{"slow line", "fine line"}}, 10*time.Millisecond)
+	require.Len(t, got, 2)
+
+	assert.Equal(t, StatusFailed, got[0].Status)
+	assert.ErrorIs(t, got[0].Err, context.DeadlineExceeded)
+	assert.Equal(t, StatusResolved, got[1].Status)
+}
+
+func TestResolveFailsTheLinesLeftWhenTheContextIsDone(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got := Resolve(ctx, NewFake(), ResolveOptions{Lines: []string{"city hall", "the art museum"}}, time.Second)
+	require.Len(t, got, 2)
+
+	for _, r := range got {
+		assert.Equal(t, StatusFailed, r.Status)
+		assert.ErrorIs(t, r.Err, context.Canceled)
+	}
+}
@@ -306,3 +306,40 @@ func TestRouteLegsSupportsNoIntermediates(t *testing.T) {
 	_, hasIntermediates := body["intermediates"]
 	assert.False(t, hasIntermediates)
 }
+
+func TestRouteLegsCues(t *testing.T) {
+	t.Parallel()
+
+	var masks []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		masks = append(masks, r.Header.Get("X-Goog-FieldMask"))
+		_, _ = w.Write([]byte(`{"routes":[{"distanceMeters":900,"legs":[{"distanceMeters":900,"steps":[
+			{"distanceMeters":600,"localizedValues":{"distance":{"text":"0.4 mi"}},
+			 "navigationInstruction":{"maneuver":"DEPART","instructions":"Head east on Spring Garden St"}},
+			{"distanceMeters":300,"localizedValues":{"distance":{"text":"0.2 mi"}},
+			 "navigationInstruction":{"maneuver":"TURN_LEFT","instructions":"Turn left onto N 22nd St"}}
+		]}]}]}`))
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+	opts := RouteLegsOptions{Origin: "start", Destination: "end"}
+
+	_, err := api.RouteLegs(context.Background(), opts)
+	require.NoError(t, err)
+
+	opts.Cues = true
+	got, err := api.RouteLegs(context.Background(), opts)
+	require.NoError(t, err)
+
+	require.Len(t, masks, 2)
+	assert.NotContains(t, masks[0], "steps", "cues are opt-in")
+	assert.Contains(t, masks[1], "routes.legs.steps.navigationInstruction")
+
+	require.Len(t, got.Legs, 1)
+	assert.Equal(t, []Cue{
+		{Maneuver: "DEPART", Instruction: "Head east on Spring Garden St", Meters: 600, DisplayDistance: "0.4 mi"},
+		{Maneuver: "TURN_LEFT", Instruction: "Turn left onto N 22nd St", Meters: 300, DisplayDistance: "0.2 mi"},
+	}, got.Legs[0].Cues)
+}
@@ -0,0 +1,219 @@
+package places
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- pairwise road distances for the local solver --------------------------
+
+const (
+	defaultComputeRouteMatrixURL = "https://routes.googleapis.com/distanceMatrix/v2:computeRouteMatrix"
+
+	// MaxRouteMatrixPlaces is the most places one matrix request can cover:
+	// Google caps a request at 625 origin-destination elements.
+	MaxRouteMatrixPlaces = 25
+)
+
+// RouteMatrixOptions asks for the road distance between every ordered pair of
+// places. Unlike OptimizeRoute, nothing is decided upstream: the caller gets
+// the raw numbers and solves the order itself.
+type RouteMatrixOptions struct {
+	Places []string
+	ByCar  bool
+
+	// Coords locates the places for providers that route by coordinates.
+	// Google goes by place id and ignores it.
+	Coords map[string]Coord
+}
+
+// RouteMatrix holds road distances indexed the same way as Ids, so
+// Meters[i][j] is the trip from Ids[i] to Ids[j]. Roads are one-way often
+// enough that the matrix is not symmetric. Pairs with no route are +Inf.
+type RouteMatrix struct {
+	Ids     []string
+	Meters  [][]float64
+	Seconds [][]float64
+
+	// Profile is the offline router's profile that measured the matrix, and
+	// empty for Google's.
+	Profile string
+}
+
+func (o RouteMatrixOptions) validate() error {
+	if len(o.Places) < 2 {
+		return errors.New("at least two places are required")
+	}
+
+	if len(o.Places) > MaxRouteMatrixPlaces {
+		return fmt.Errorf("at most %d places fit in one route matrix, got %d", MaxRouteMatrixPlaces, len(o.Places))
+	}
+
+	seen := make(map[string]bool, len(o.Places))
+
+	for i, id := range o.Places {
+		if id == "" {
+			return fmt.Errorf("place at index %d: id is required", i)
+		}
+
+		if seen[id] {
+			return fmt.Errorf("place at index %d: duplicate id %q", i, id)
+		}
+
+		seen[id] = true
+	}
+
+	return nil
+}
+
+type routeMatrixWaypoint struct {
+	Waypoint       optimizePayloadPlace   `json:"waypoint"`
+	RouteModifiers *optimizePayloadAvoids `json:"routeModifiers,omitempty"`
+}
+
+// RouteMatrix measures every ordered pair of places in one upstream request.
+func (p *PlacesApi) RouteMatrix(ctx context.Context, opts RouteMatrixOptions) (*RouteMatrix, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Origins      []routeMatrixWaypoint `json:"origins"`
+		Destinations []routeMatrixWaypoint `json:"destinations"`
+		Vehicle      string                `json:"travelMode"`
+	}
+
+	// Route modifiers hang off each origin in this API rather than the
+	// request as a whole.
+	var avoids *optimizePayloadAvoids
+
+	if opts.ByCar {
+		body.Vehicle = "DRIVE"
+		avoids = &optimizePayloadAvoids{Tolls: true, Highways: true}
+	} else {
+		body.Vehicle = "BICYCLE"
+	}
+
+	for _, id := range opts.Places {
+		body.Origins = append(body.Origins, routeMatrixWaypoint{
+			Waypoint:       optimizePayloadPlace{Id: id},
+			RouteModifiers: avoids,
+		})
+		body.Destinations = append(body.Destinations, routeMatrixWaypoint{
+			Waypoint: optimizePayloadPlace{Id: id},
+		})
+	}
+
+	jsonData, err := json.Marshal(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("marshaling body: %w", err)
+	}
+
+	req, err := p.buildRequest(ctx, "POST", p.computeRouteMatrixURL, bytes.NewBuffer(jsonData))
+
+	if err != nil {
+		return nil, fmt.Errorf("building req: %w", err)
+	}
+
+	req.Header.Set("X-Goog-FieldMask", strings.Join([]string{
+		"originIndex",
+		"destinationIndex",
+		"status",
+		"condition",
+		"distanceMeters",
+		"duration",
+	}, ","))
+
+	// The matrix is billed by the element, one per ordered pair.
+	billed := int64(len(body.Origins) * len(body.Destinations))
+
+	resp, err := p.do(req, billing{sku: SKURouteMatrixBasic, units: billed})
+
+	if err != nil {
+		return nil, fmt.Errorf(".Do: %w", err)
+	}
+
+	defer drainAndClose(resp.Body)
+
+	respBody, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("computeRouteMatrix failed with status %d: %s", resp.StatusCode, googleAPIError(respBody))
+	}
+
+	// Zero indexes are left out of the JSON entirely, which decodes to the
+	// right answer anyway.
+	var elements []struct {
+		Origin      int    `json:"originIndex"`
+		Destination int    `json:"destinationIndex"`
+		Condition   string `json:"condition"`
+		Meters      int64  `json:"distanceMeters"`
+		Duration    string `json:"duration"`
+		Status      struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+
+	if err := json.Unmarshal(respBody, &elements); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+	}
+
+	n := len(opts.Places)
+	out := &RouteMatrix{
+		Ids:     append([]string(nil), opts.Places...),
+		Meters:  make([][]float64, n),
+		Seconds: make([][]float64, n),
+	}
+
+	// Anything Google leaves out is as good as unreachable.
+	for i := range n {
+		out.Meters[i] = make([]float64, n)
+		out.Seconds[i] = make([]float64, n)
+
+		for j := range n {
+			if i != j {
+				out.Meters[i][j] = math.Inf(1)
+				out.Seconds[i][j] = math.Inf(1)
+			}
+		}
+	}
+
+	for _, e := range elements {
+		if e.Origin < 0 || e.Origin >= n || e.Destination < 0 || e.Destination >= n {
+			return nil, fmt.Errorf("matrix has %d places, but API returned element (%d, %d)", n, e.Origin, e.Destination)
+		}
+
+		if e.Origin == e.Destination || e.Status.Code != 0 || e.Condition != "ROUTE_EXISTS" {
+			continue
+		}
+
+		var d time.Duration
+
+		if e.Duration != "" {
+			d, err = time.ParseDuration(e.Duration)
+
+			if err != nil {
+				return nil, fmt.Errorf("element (%d, %d): parsing duration %q: %w", e.Origin, e.Destination, e.Duration, err)
+			}
+		}
+
+		out.Meters[e.Origin][e.Destination] = float64(e.Meters)
+		out.Seconds[e.Origin][e.Destination] = d.Seconds()
+	}
+
+	return out, nil
+}
@@ -0,0 +1,243 @@
+package places
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// matrixResponse answers computeRouteMatrix with meters[i][j] for every pair,
+// the way Google does: zero indexes omitted, self-pairs included.
+func matrixResponse(meters [][]int64) string {
+	elements := make([]map[string]any, 0, len(meters)*len(meters))
+
+	for i := range meters {
+		for j, m := range meters[i] {
+			e := map[string]any{
+				"status":         map[string]any{},
+				"condition":      "ROUTE_EXISTS",
+				"distanceMeters": m,
+				"duration":       fmt.Sprintf("%ds", m/5),
+			}
+
+			if i != 0 {
+				e["originIndex"] = i
+			}
+			if j != 0 {
+				e["destinationIndex"] = j
+			}
+
+			elements = append(elements, e)
+		}
+	}
+
+	body, _ := json.Marshal(elements)
+
+	return string(body)
+}
+
+func TestRouteMatrixSendsEveryPlaceBothWays(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Origins []struct {
+			Waypoint struct {
+				Id string `json:"placeId"`
+			} `json:"waypoint"`
+			RouteModifiers map[string]any `json:"routeModifiers"`
+		} `json:"origins"`
+		Destinations []struct {
+			Waypoint struct {
+				Id string `json:"placeId"`
+			} `json:"waypoint"`
+		} `json:"destinations"`
+		Vehicle string `json:"travelMode"`
+	}
+	var mask string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &body)
+		mask = r.Header.Get("X-Goog-FieldMask")
+		_, _ = w.Write([]byte(matrixResponse([][]int64{{0, 1, 2}, {1, 0, 3}, {2, 3, 0}})))
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+
+	_, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"s", "a", "b"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "BICYCLE", body.Vehicle)
+	require.Len(t, body.Origins, 3)
+	require.Len(t, body.Destinations, 3)
+
+	for i, id := range []string{"s", "a", "b"} {
+		assert.Equal(t, id, body.Origins[i].Waypoint.Id)
+		assert.Equal(t, id, body.Destinations[i].Waypoint.Id)
+		assert.Nil(t, body.Origins[i].RouteModifiers, "bikes are not affected by tolls or highways")
+	}
+
+	for _, want := range []string{"originIndex", "destinationIndex", "condition", "distanceMeters", "duration"} {
+		assert.Contains(t, mask, want)
+	}
+}
+
+func TestRouteMatrixCarAvoidsTollsAndHighways(t *testing.T) {
+	t.Parallel()
+
+	var body struct {
+		Origins []struct {
+			RouteModifiers struct {
+				Tolls    bool `json:"avoidTolls"`
+				Highways bool `json:"avoidHighways"`
+			} `json:"routeModifiers"`
+		} `json:"origins"`
+		Vehicle string `json:"travelMode"`
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &body)
+		_, _ = w.Write([]byte(matrixResponse([][]int64{{0, 1}, {1, 0}})))
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+
+	_, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"s", "a"}, ByCar: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, "DRIVE", body.Vehicle)
+	for _, o := range body.Origins {
+		assert.True(t, o.RouteModifiers.Tolls)
+		assert.True(t, o.RouteModifiers.Highways)
+	}
+}
+
+func TestRouteMatrixParsesAsymmetricDistances(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(matrixResponse([][]int64{
+			{0, 100, 200},
+			{150, 0, 300},
+			{250, 350, 0},
+		})))
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+
+	got, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"s", "a", "b"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"s", "a", "b"}, got.Ids)
+	assert.Equal(t, [][]float64{{0, 100, 200}, {150, 0, 300}, {250, 350, 0}}, got.Meters)
+	assert.Equal(t, 20.0, got.Seconds[0][1])
+	assert.Equal(t, 30.0, got.Seconds[1][0], "one-way streets make the return trip differ")
+}
+
+func TestRouteMatrixMarksMissingRoutesUnreachable(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"destinationIndex":1,"condition":"ROUTE_NOT_FOUND"},
+			{"originIndex":1,"status":{"code":5,"message":"NOT_FOUND"}},
+			{"originIndex":1,"destinationIndex":2,"condition":"ROUTE_EXISTS","distanceMeters":42,"duration":"9s"}
+		]`))
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+
+	got, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"s", "a", "b"}})
+	require.NoError(t, err)
+
+	assert.True(t, math.IsInf(got.Meters[0][1], 1), "no route found")
+	assert.True(t, math.IsInf(got.Meters[1][0], 1), "element-level error")
+	assert.True(t, math.IsInf(got.Meters[2][0], 1), "left out of the response")
+	assert.Equal(t, 42.0, got.Meters[1][2])
+	assert.Zero(t, got.Meters[2][2], "a place is no distance from itself")
+}
+
+func TestRouteMatrixRejectsOutOfRangeIndex(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[{"originIndex":5,"condition":"ROUTE_EXISTS"}]`))
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+
+	_, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"s", "a"}})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "(5, 0)")
+}
+
+func TestRouteMatrixSurfacesGoogleErrorMessage(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":{"message":"Routes API has not been used"}}`))
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+
+	_, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"s", "a"}})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+	assert.Contains(t, err.Error(), "Routes API has not been used")
+}
+
+func TestRouteMatrixValidation(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]string, MaxRouteMatrixPlaces+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("p%d", i)
+	}
+
+	tests := []struct {
+		name    string
+		places  []string
+		wantErr string
+	}{
+		{name: "one place", places: []string{"s"}, wantErr: "at least two"},
+		{name: "empty id", places: []string{"s", ""}, wantErr: "index 1"},
+		{name: "duplicate", places: []string{"s", "a", "s"}, wantErr: `duplicate id "s"`},
+		{name: "over the element cap", places: tooMany, wantErr: "at most 25"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				t.Error("an invalid matrix must not be billed upstream")
+			}))
+			defer ts.Close()
+
+			api := newTestApi(t, ts)
+
+			_, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: tt.places})
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package places
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Google's terms let coordinates be kept for 30 days, and a checkpoint
+	// address does not move in that time anyway.
+	defaultSearchCacheTTL = 30 * 24 * time.Hour
+
+	// defaultSearchCacheEntries is a few seasons of race sheets. An entry is
+	// a handful of places, so the whole cache stays around a few megabytes.
+	defaultSearchCacheEntries = 5000
+)
+
+// ErrCacheWrite means a request to Google worked but its result could not
+// be cached. The result still comes back alongside it.
+var ErrCacheWrite = errors.New("caching result")
+
+// TextSearchCache keeps text search results, so the same checkpoint typed
+// into a second race sheet is not billed twice. Entries expire after a TTL,
+// the least recently used go first once it is full, and with a file the
+// cache is written to it after every change and read back on start.
+//
+// A TextSearchCache is safe for concurrent use.
+type TextSearchCache struct {
+	ttl        time.Duration
+	maxEntries int
+	file       *snapshotFile
+	now        func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // of *searchCacheEntry, most recently used first
+	entries map[string]*list.Element
+
+	// seq numbers the snapshots taken for the file, so an older one never
+	// lands over a newer one.
+	seq uint64
+}
+
+// searchCacheEntry is never changed once it is in the cache, so a snapshot
+// can share it with the list.
+type searchCacheEntry struct {
+	Key     string    `json:"key"`
+	Query   string    `json:"query"`
+	Places  []place   `json:"places"`
+	Expires time.Time `json:"expires"`
+}
+
+// TextSearchCacheOption customises a TextSearchCache.
+type TextSearchCacheOption func(*TextSearchCache)
+
+// WithSearchCacheTTL sets how long a result is served before Google is
+// asked again.
+func WithSearchCacheTTL(ttl time.Duration) TextSearchCacheOption {
+	return func(c *TextSearchCache) { c.ttl = ttl }
+}
+
+// WithSearchCacheMaxEntries caps how many results are kept.
+func WithSearchCacheMaxEntries(n int) TextSearchCacheOption {
+	return func(c *TextSearchCache) { c.maxEntries = n }
+}
+
+// WithSearchCacheFile keeps the cache in a JSON file at path, so it
+// survives restarts.
+func WithSearchCacheFile(path string) TextSearchCacheOption {
+	return func(c *TextSearchCache) { c.file = &snapshotFile{path: path} }
+}
+
+// NewTextSearchCache builds a cache, loading whatever its file already holds.
+// A missing file is an empty cache; an unreadable one is an error, rather
+// than something to quietly overwrite.
+func NewTextSearchCache(opts ...TextSearchCacheOption) (*TextSearchCache, error) {
+	c := &TextSearchCache{
+		ttl:        defaultSearchCacheTTL,
+		maxEntries: defaultSearchCacheEntries,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.ttl <= 0 {
+		return nil, errors.New("search cache TTL must be positive")
+	}
+
+	if c.maxEntries <= 0 {
+		return nil, errors.New("search cache size must be positive")
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Len is how many results the cache holds, expired or not.
+func (c *TextSearchCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *TextSearchCache) get(opts TextSearchOptions) ([]place, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[searchCacheKey(opts)]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*searchCacheEntry)
+
+	if !c.now().Before(e.Expires) {
+		c.order.Remove(el)
+		delete(c.entries, e.Key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+
+	return e.Places, true
+}
+
+func (c *TextSearchCache) put(opts TextSearchOptions, found []place) error {
+	c.mu.Lock()
+
+	key := searchCacheKey(opts)
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+	}
+
+	c.entries[key] = c.order.PushFront(&searchCacheEntry{
+		Key:     key,
+		Query:   normalizeQuery(opts.Query),
+		Places:  found,
+		Expires: c.now().Add(c.ttl),
+	})
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*searchCacheEntry).Key)
+	}
+
+	seq, snapshot := c.snapshot()
+	c.mu.Unlock()
+
+	return c.save(seq, snapshot)
+}
+
+// Purge drops every result for query, wherever the search was biased to,
+// or every result when query is empty. It returns how many went.
+func (c *TextSearchCache) Purge(query string) (int, error) {
+	c.mu.Lock()
+
+	query = normalizeQuery(query)
+	purged := 0
+
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+
+		if e := el.Value.(*searchCacheEntry); query == "" || e.Query == query {
+			c.order.Remove(el)
+			delete(c.entries, e.Key)
+			purged++
+		}
+
+		el = next
+	}
+
+	if purged == 0 {
+		c.mu.Unlock()
+		return 0, nil
+	}
+
+	seq, snapshot := c.snapshot()
+	c.mu.Unlock()
+
+	return purged, c.save(seq, snapshot)
+}
+
+// snapshot lists the entries most recently used first, for save to write
+// once mu is released, and numbers the list. Without a file there is
+// nothing to write. The caller holds mu.
+func (c *TextSearchCache) snapshot() (uint64, []*searchCacheEntry) {
+	if c.file == nil {
+		return 0, nil
+	}
+
+	out := make([]*searchCacheEntry, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		out = append(out, el.Value.(*searchCacheEntry))
+	}
+
+	c.seq++
+
+	return c.seq, out
+}
+
+// save writes a snapshot to the cache's file, if it has one.
+func (c *TextSearchCache) save(seq uint64, snapshot []*searchCacheEntry) error {
+	if c.file == nil {
+		return nil
+	}
+
+	if err := c.file.save(seq, snapshot); err != nil {
+		return fmt.Errorf("writing search cache: %w", err)
+	}
+
+	return nil
+}
+
+func (c *TextSearchCache) load() error {
+	if c.file == nil {
+		return nil
+	}
+
+	var saved []*searchCacheEntry
+	if err := loadJSON(c.file.path, &saved); err != nil {
+		return fmt.Errorf("reading search cache %s: %w", c.file.path, err)
+	}
+
+	now := c.now()
+
+	// Saved most recently used first, so pushing each to the back keeps the
+	// order. Whatever no longer fits, or has expired since, is dropped.
+	for _, e := range saved {
+		if c.order.Len() >= c.maxEntries {
+			break
+		}
+
+		if _, dup := c.entries[e.Key]; dup || !now.Before(e.Expires) {
+			continue
+		}
+
+		c.entries[e.Key] = c.order.PushBack(e)
+	}
+
+	return nil
+}
+
+// searchCacheKey is the normalised query and, when there is one, the bias
+// rounded to about a kilometre. The bias is a 25km circle, so moving it a
+// few streets does not change the answer.
+func searchCacheKey(opts TextSearchOptions) string {
+	key := normalizeQuery(opts.Query)
+
+	if opts.LongLat != nil {
+		key += fmt.Sprintf("@%.2f,%.2f", opts.LongLat.Lat, opts.LongLat.Long)
+	}
+
+	return key
+}
+
+// normalizeQuery folds the differences in how the same address gets typed:
+// case and runs of spaces.
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// TextSearchResult is a text search's places, and whether they were served
+// from the cache rather than Google.
+type TextSearchResult struct {
+	Places    []place
+	FromCache bool
+}
+
+// CachedTextSearch answers from the cache when it can, and otherwise asks
+// Google and keeps the answer. Searches that find nothing are not kept, so a
+// place Google learns about later turns up without waiting out the TTL.
+// Without a cache it is TextSearch.
+func (p *PlacesApi) CachedTextSearch(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+	if p.searchCache != nil {
+		if found, ok := p.searchCache.get(opts); ok {
+			return TextSearchResult{Places: found, FromCache: true}, nil
+		}
+	}
+
+	found, err := p.TextSearch(ctx, opts)
+	if err != nil {
+		return TextSearchResult{}, err
+	}
+
+	if p.searchCache != nil && len(found) > 0 {
+		if err := p.searchCache.put(opts, found); err != nil {
+			return TextSearchResult{Places: found}, fmt.Errorf("%w: %w", ErrCacheWrite, err)
+		}
+	}
+
+	return TextSearchResult{Places: found}, nil
+}
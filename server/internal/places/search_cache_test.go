@@ -0,0 +1,276 @@
+package places
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachedApi has a Google that answers every search with one place named
+// after the query, and counts how often it is asked.
+func cachedApi(t *testing.T, cache *TextSearchCache) (*PlacesApi, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		var body struct {
+			Query string `json:"textQuery"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		if body.Query == "nowhere" {
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"places": []map[string]any{{"id": "id-" + body.Query, "displayName": map[string]any{"text": body.Query}}},
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	api := newTestApi(t, ts)
+	WithTextSearchCache(cache)(api)
+
+	return api, &calls
+}
+
+func newCache(t *testing.T, opts ...TextSearchCacheOption) *TextSearchCache {
+	t.Helper()
+
+	c, err := NewTextSearchCache(opts...)
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestCachedTextSearchServesRepeatsFromTheCache(t *testing.T) {
+	t.Parallel()
+
+	api, calls := cachedApi(t, newCache(t))
+	ctx := context.Background()
+
+	first, err := api.CachedTextSearch(ctx, TextSearchOptions{Query: "City Hall"})
+	require.NoError(t, err)
+	assert.False(t, first.FromCache)
+
+	// The same address, typed the way a second race sheet has it.
+	again, err := api.CachedTextSearch(ctx, TextSearchOptions{Query: "  city   HALL "})
+	require.NoError(t, err)
+	assert.True(t, again.FromCache)
+	assert.Equal(t, first.Places, again.Places)
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestCachedTextSearchKeysOnTheLocationBias(t *testing.T) {
+	t.Parallel()
+
+	api, calls := cachedApi(t, newCache(t))
+	ctx := context.Background()
+
+	philly := TextSearchOptions{Query: "city hall", LongLat: &longLat{Long: -75.1635, Lat: 39.9526}}
+	nearby := TextSearchOptions{Query: "city hall", LongLat: &longLat{Long: -75.1630, Lat: 39.9530}}
+	nyc := TextSearchOptions{Query: "city hall", LongLat: &longLat{Long: -74.0060, Lat: 40.7128}}
+
+	for _, opts := range []TextSearchOptions{philly, nearby, nyc, {Query: "city hall"}} {
+		_, err := api.CachedTextSearch(ctx, opts)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int32(3), calls.Load(), "a few streets over is the same search")
+}
+
+func TestCachedTextSearchExpiresEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cache := newCache(t, WithSearchCacheTTL(time.Hour))
+	cache.now = func() time.Time { return now }
+
+	api, calls := cachedApi(t, cache)
+	opts := TextSearchOptions{Query: "city hall"}
+
+	_, err := api.CachedTextSearch(context.Background(), opts)
+	require.NoError(t, err)
+
+	now = now.Add(59 * time.Minute)
+	got, err := api.CachedTextSearch(context.Background(), opts)
+	require.NoError(t, err)
+	assert.True(t, got.FromCache)
+
+	now = now.Add(time.Minute)
+	got, err = api.CachedTextSearch(context.Background(), opts)
+	require.NoError(t, err)
+	assert.False(t, got.FromCache)
+
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCachedTextSearchEvictsTheLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	cache := newCache(t, WithSearchCacheMaxEntries(2))
+	api, _ := cachedApi(t, cache)
+	ctx := context.Background()
+
+	search := func(q string) bool {
+		t.Helper()
+
+		got, err := api.CachedTextSearch(ctx, TextSearchOptions{Query: q})
+		require.NoError(t, err)
+
+		return got.FromCache
+	}
+
+	search("a")
+	search("b")
+	search("a")
+	search("c") // b has gone longest without a search
+
+	assert.Equal(t, 2, cache.Len())
+	assert.True(t, search("a"))
+	assert.True(t, search("c"))
+	assert.False(t, search("b"))
+}
+
+func TestCachedTextSearchDoesNotKeepEmptyResults(t *testing.T) {
+	t.Parallel()
+
+	cache := newCache(t)
+	api, calls := cachedApi(t, cache)
+
+	for range 2 {
+		got, err := api.CachedTextSearch(context.Background(), TextSearchOptions{Query: "nowhere"})
+		require.NoError(t, err)
+		assert.Empty(t, got.Places)
+		assert.False(t, got.FromCache)
+	}
+
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Zero(t, cache.Len())
+}
+
+func TestCachedTextSearchWithoutACache(t *testing.T) {
+	t.Parallel()
+
+	api, calls := cachedApi(t, nil)
+
+	for range 2 {
+		got, err := api.CachedTextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+		require.NoError(t, err)
+		assert.False(t, got.FromCache)
+	}
+
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestTextSearchCacheSurvivesRestarts(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "search-cache.json")
+
+	api, _ := cachedApi(t, newCache(t, WithSearchCacheFile(path)))
+
+	for _, q := range []string{"city hall", "lemon hill"} {
+		_, err := api.CachedTextSearch(context.Background(), TextSearchOptions{Query: q})
+		require.NoError(t, err)
+	}
+
+	restarted := newCache(t, WithSearchCacheFile(path))
+	assert.Equal(t, 2, restarted.Len())
+
+	api, calls := cachedApi(t, restarted)
+
+	got, err := api.CachedTextSearch(context.Background(), TextSearchOptions{Query: "City Hall"})
+	require.NoError(t, err)
+	assert.True(t, got.FromCache)
+	require.Len(t, got.Places, 1)
+	assert.Equal(t, "id-city hall", got.Places[0].Id)
+	assert.Zero(t, calls.Load())
+
+	// Loading drops whatever expired while the server was down.
+	stale := newCache(t, WithSearchCacheFile(path), WithSearchCacheTTL(time.Hour))
+	stale.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+
+	api, _ = cachedApi(t, stale)
+	_, err = api.CachedTextSearch(context.Background(), TextSearchOptions{Query: "boathouse row"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, newCache(t, WithSearchCacheFile(path)).Len())
+}
+
+func TestTextSearchCacheFileKeepsUpWithConcurrentSearches(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "search-cache.json")
+	cache := newCache(t, WithSearchCacheFile(path))
+
+	var wg sync.WaitGroup
+
+	for i := range 32 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cache.put(TextSearchOptions{Query: fmt.Sprintf("checkpoint %d", i)}, []place{{Id: "p"}}))
+		}()
+	}
+
+	wg.Wait()
+
+	// Writes finish in any order, but the last to land is the newest.
+	assert.Equal(t, 32, newCache(t, WithSearchCacheFile(path)).Len())
+}
+
+func TestTextSearchCacheRejectsACorruptFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "search-cache.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+
+	_, err := NewTextSearchCache(WithSearchCacheFile(path))
+	assert.ErrorContains(t, err, "reading search cache")
+}
+
+func TestTextSearchCachePurge(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "search-cache.json")
+	cache := newCache(t, WithSearchCacheFile(path))
+	api, _ := cachedApi(t, cache)
+
+	for _, opts := range []TextSearchOptions{
+		{Query: "city hall"},
+		{Query: "city hall", LongLat: &longLat{Long: -75.16, Lat: 39.95}},
+		{Query: "lemon hill"},
+	} {
+		_, err := api.CachedTextSearch(context.Background(), opts)
+		require.NoError(t, err)
+	}
+
+	n, err := cache.Purge("City Hall")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "wherever it was biased to")
+	assert.Equal(t, 1, newCache(t, WithSearchCacheFile(path)).Len(), "and on disk")
+
+	n, err = cache.Purge("")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Zero(t, cache.Len())
+}
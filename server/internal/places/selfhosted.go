@@ -0,0 +1,518 @@
+package places
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nguyen/allycat/internal/roads"
+)
+
+// SelfHostedApi answers from servers the race runs itself: OSRM for routing
+// and Nominatim for search, or anything that speaks their HTTP APIs. There
+// is no quota to spend, but OSRM routes by coordinates, so every place it
+// is asked about needs them.
+type SelfHostedApi struct {
+	httpCli *http.Client
+
+	// bikeURL and carURL are each an osrm-routed, since one only ever
+	// serves the profile its data was built for. searchURL is Nominatim.
+	bikeURL   string
+	carURL    string
+	searchURL string
+}
+
+// SelfHostedOption customises a SelfHostedApi.
+type SelfHostedOption func(*SelfHostedApi)
+
+// WithOSRMCarURL routes by car through a second OSRM. Without it, car
+// routes come from the bike's.
+func WithOSRMCarURL(url string) SelfHostedOption {
+	return func(s *SelfHostedApi) { s.carURL = url }
+}
+
+// WithSelfHostedHTTPClient sets the client both servers are called with.
+func WithSelfHostedHTTPClient(c *http.Client) SelfHostedOption {
+	return func(s *SelfHostedApi) { s.httpCli = c }
+}
+
+// NewSelfHostedApi takes the base URLs of an OSRM routing by bike and of a
+// Nominatim.
+func NewSelfHostedApi(osrmURL, nominatimURL string, opts ...SelfHostedOption) (*SelfHostedApi, error) {
+	if osrmURL == "" {
+		return nil, errors.New("an OSRM URL is required")
+	}
+
+	if nominatimURL == "" {
+		return nil, errors.New("a Nominatim URL is required")
+	}
+
+	s := &SelfHostedApi{
+		httpCli:   &http.Client{},
+		bikeURL:   strings.TrimSuffix(osrmURL, "/"),
+		searchURL: strings.TrimSuffix(nominatimURL, "/"),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.carURL == "" {
+		s.carURL = s.bikeURL
+	}
+
+	s.carURL = strings.TrimSuffix(s.carURL, "/")
+
+	return s, nil
+}
+
+// Name is "selfhosted".
+func (s *SelfHostedApi) Name() string {
+	return "selfhosted"
+}
+
+// Available is always nil: the servers are the race's own, and a failure is
+// only known by asking.
+func (s *SelfHostedApi) Available() error {
+	return nil
+}
+
+// get fetches u and decodes its JSON into v. what names the call in errors.
+func (s *SelfHostedApi) get(ctx context.Context, what, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpCli.Do(req)
+	if err != nil {
+		return fmt.Errorf(".Do: %w", err)
+	}
+
+	defer drainAndClose(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed with status %d: %s", what, resp.StatusCode, osrmError(body))
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error unmarshaling %s response: %w", what, err)
+	}
+
+	return nil
+}
+
+// osrmError pulls the message out of an OSRM error, falling back to the raw
+// body.
+func osrmError(body []byte) string {
+	var e struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(body, &e) == nil && e.Message != "" {
+		return e.Code + ": " + e.Message
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// osrmURL builds an OSRM request for service over coords, with query.
+func (s *SelfHostedApi) osrmURL(service string, byCar bool, coords []Coord, query url.Values) string {
+	base, profile := s.bikeURL, "cycling"
+	if byCar {
+		base, profile = s.carURL, "driving"
+	}
+
+	points := make([]string, 0, len(coords))
+	for _, c := range coords {
+		points = append(points, strconv.FormatFloat(c.Long, 'f', 6, 64)+","+strconv.FormatFloat(c.Lat, 'f', 6, 64))
+	}
+
+	return fmt.Sprintf("%s/%s/v1/%s/%s?%s", base, service, profile, strings.Join(points, ";"), query.Encode())
+}
+
+// Search asks Nominatim, preferring places within the same 25km of the
+// bias that Google's search does. Ids are OSM's, like "osm:W1234".
+func (s *SelfHostedApi) Search(ctx context.Context, opts TextSearchOptions) (TextSearchResult, error) {
+	q := url.Values{
+		"q":      {opts.Query},
+		"format": {"jsonv2"},
+		"limit":  {"10"},
+	}
+
+	if opts.LongLat != nil {
+		dLat := 25_000 / 111_320.0
+		dLong := dLat / math.Cos(opts.LongLat.Lat*math.Pi/180)
+
+		q.Set("viewbox", fmt.Sprintf("%f,%f,%f,%f",
+			opts.LongLat.Long-dLong, opts.LongLat.Lat+dLat,
+			opts.LongLat.Long+dLong, opts.LongLat.Lat-dLat,
+		))
+	}
+
+	var found []struct {
+		OsmType     string `json:"osm_type"`
+		OsmId       int64  `json:"osm_id"`
+		Lat         string `json:"lat"`
+		Long        string `json:"lon"`
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+	}
+
+	if err := s.get(ctx, "nominatim search", s.searchURL+"/search?"+q.Encode(), &found); err != nil {
+		return TextSearchResult{}, err
+	}
+
+	out := make([]place, 0, len(found))
+
+	for _, f := range found {
+		lat, errLat := strconv.ParseFloat(f.Lat, 64)
+		long, errLong := strconv.ParseFloat(f.Long, 64)
+
+		if errLat != nil || errLong != nil || f.OsmType == "" {
+			continue
+		}
+
+		name := f.Name
+		if name == "" {
+			name, _, _ = strings.Cut(f.DisplayName, ",")
+		}
+
+		out = append(out, place{
+			Id:               fmt.Sprintf("osm:%s%d", strings.ToUpper(f.OsmType[:1]), f.OsmId),
+			FormattedAddress: f.DisplayName,
+			DisplayName:      displayName{Name: name},
+			Coordinates:      coordinates{Lat: lat, Long: long},
+		})
+	}
+
+	return TextSearchResult{Places: out}, nil
+}
+
+type osrmStep struct {
+	Meters   float64 `json:"distance"`
+	Name     string  `json:"name"`
+	Ref      string  `json:"ref"`
+	Maneuver struct {
+		Type         string  `json:"type"`
+		Modifier     string  `json:"modifier"`
+		BearingAfter float64 `json:"bearing_after"`
+	} `json:"maneuver"`
+}
+
+// RouteLegs asks OSRM for one route through every waypoint. opts.Coords
+// must locate each of them.
+func (s *SelfHostedApi) RouteLegs(ctx context.Context, opts RouteLegsOptions) (*RouteLegsResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	points := opts.waypoints()
+
+	coords, err := coordsOf(points, opts.Coords)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{"overview": {"false"}, "steps": {strconv.FormatBool(opts.Cues)}}
+
+	var resp struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Routes  []struct {
+			Meters  float64 `json:"distance"`
+			Seconds float64 `json:"duration"`
+			Legs    []struct {
+				Meters  float64    `json:"distance"`
+				Seconds float64    `json:"duration"`
+				Steps   []osrmStep `json:"steps"`
+			} `json:"legs"`
+		} `json:"routes"`
+	}
+
+	if err := s.get(ctx, "osrm route", s.osrmURL("route", opts.ByCar, coords, q), &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Code != "Ok" || len(resp.Routes) == 0 {
+		return nil, fmt.Errorf("osrm route: %s %s", resp.Code, resp.Message)
+	}
+
+	route := resp.Routes[0]
+
+	if len(route.Legs) != len(points)-1 {
+		return nil, fmt.Errorf("expected %d legs for %d waypoints but got %d", len(points)-1, len(points), len(route.Legs))
+	}
+
+	res := &RouteLegsResult{
+		Legs:            make([]RouteLeg, 0, len(route.Legs)),
+		Meters:          int64(math.Round(route.Meters)),
+		DisplayDistance: DisplayMiles(route.Meters),
+		DisplayDuration: DisplayDuration(route.Seconds),
+	}
+
+	for i, leg := range route.Legs {
+		out := RouteLeg{
+			FromId:          points[i],
+			ToId:            points[i+1],
+			Meters:          int64(math.Round(leg.Meters)),
+			Seconds:         int64(math.Round(leg.Seconds)),
+			DisplayDistance: DisplayMiles(leg.Meters),
+			DisplayDuration: DisplayDuration(leg.Seconds),
+		}
+
+		if opts.Cues {
+			out.Cues = osrmCues(leg.Steps)
+		}
+
+		res.Legs = append(res.Legs, out)
+	}
+
+	return res, nil
+}
+
+// osrmModifiers name OSRM's turn modifiers the way Google does.
+var osrmModifiers = map[string]string{
+	"uturn":        roads.UturnLeft,
+	"sharp right":  roads.TurnSharpRight,
+	"right":        roads.TurnRight,
+	"slight right": roads.TurnSlightRight,
+	"straight":     roads.NameChange,
+	"slight left":  roads.TurnSlightLeft,
+	"left":         roads.TurnLeft,
+	"sharp left":   roads.TurnSharpLeft,
+}
+
+// osrmCues turns OSRM's steps into a cue sheet. Arriving is not a cue, and
+// nor is anything OSRM has no direction for.
+func osrmCues(steps []osrmStep) []Cue {
+	var out []Cue
+
+	for _, st := range steps {
+		street := st.Name
+		if street == "" {
+			street = st.Ref
+		}
+
+		var m, instruction string
+
+		switch st.Maneuver.Type {
+		case "depart":
+			m, instruction = roads.Depart, roads.DepartInstruction(st.Maneuver.BearingAfter, street)
+		case "arrive":
+			continue
+		default:
+			var ok bool
+			if m, ok = osrmModifiers[st.Maneuver.Modifier]; !ok {
+				continue
+			}
+
+			instruction = roads.TurnInstruction(m, street)
+		}
+
+		out = append(out, Cue{
+			Maneuver:        m,
+			Instruction:     instruction,
+			Meters:          int64(math.Round(st.Meters)),
+			DisplayDistance: DisplayCueDistance(st.Meters),
+		})
+	}
+
+	return out
+}
+
+// RouteMatrix asks OSRM's table service. opts.Coords must locate every
+// place.
+func (s *SelfHostedApi) RouteMatrix(ctx context.Context, opts RouteMatrixOptions) (*RouteMatrix, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	coords, err := coordsOf(opts.Places, opts.Coords)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Code      string       `json:"code"`
+		Message   string       `json:"message"`
+		Distances [][]*float64 `json:"distances"`
+		Durations [][]*float64 `json:"durations"`
+	}
+
+	q := url.Values{"annotations": {"distance,duration"}}
+
+	if err := s.get(ctx, "osrm table", s.osrmURL("table", opts.ByCar, coords, q), &resp); err != nil {
+		return nil, err
+	}
+
+	n := len(opts.Places)
+
+	if resp.Code != "Ok" || len(resp.Distances) != n || len(resp.Durations) != n {
+		return nil, fmt.Errorf("osrm table: %s %s", resp.Code, resp.Message)
+	}
+
+	out := &RouteMatrix{
+		Ids:     slices.Clone(opts.Places),
+		Meters:  make([][]float64, n),
+		Seconds: make([][]float64, n),
+	}
+
+	// OSRM answers null for pairs it cannot route between.
+	value := func(row []*float64, j int) float64 {
+		if j >= len(row) || row[j] == nil {
+			return math.Inf(1)
+		}
+
+		return *row[j]
+	}
+
+	for i := range n {
+		out.Meters[i] = make([]float64, n)
+		out.Seconds[i] = make([]float64, n)
+
+		for j := range n {
+			if i != j {
+				out.Meters[i][j] = value(resp.Distances[i], j)
+				out.Seconds[i][j] = value(resp.Durations[i], j)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// OptimizeRoute asks OSRM's trip service to order the stops, by bike and by
+// car, once for every finish the options allow, as Google is asked.
+func (s *SelfHostedApi) OptimizeRoute(ctx context.Context, opts optimizeRouteOptions) ([]OptimalRoute, error) {
+	var ends []*optimizeRouteLocation
+
+	switch {
+	case opts.loop:
+		ends = []*optimizeRouteLocation{nil}
+	case opts.end != nil:
+		ends = []*optimizeRouteLocation{opts.end}
+	default:
+		for i := range opts.stops {
+			ends = append(ends, &opts.stops[i])
+		}
+	}
+
+	out := make([]OptimalRoute, 0, len(ends))
+
+	for _, end := range ends {
+		var route OptimalRoute
+
+		for _, byCar := range []bool{false, true} {
+			r, err := s.trip(ctx, opts, end, byCar)
+			if err != nil {
+				return nil, err
+			}
+
+			route.End = r.end
+
+			if byCar {
+				route.CarRoute = r
+			} else {
+				route.BikeRoute = r
+			}
+		}
+
+		out = append(out, route)
+	}
+
+	return out, nil
+}
+
+// trip is one OSRM trip from the start through every stop but end, to end,
+// or back to the start when end is nil.
+func (s *SelfHostedApi) trip(ctx context.Context, opts optimizeRouteOptions, end *optimizeRouteLocation, byCar bool) (*OptimizeRouteResponse, error) {
+	stops := make([]optimizeRouteLocation, 0, len(opts.stops)+1)
+	stops = append(stops, opts.start)
+
+	for _, st := range opts.stops {
+		if end == nil || st.id != end.id {
+			stops = append(stops, st)
+		}
+	}
+
+	q := url.Values{"source": {"first"}, "overview": {"false"}, "roundtrip": {"true"}}
+	endId := opts.start.id
+
+	if end != nil {
+		stops = append(stops, *end)
+		q.Set("destination", "last")
+		q.Set("roundtrip", "false")
+		endId = end.id
+	}
+
+	coords := make([]Coord, 0, len(stops))
+	for _, st := range stops {
+		coords = append(coords, Coord{Lat: st.lat, Long: st.long})
+	}
+
+	var resp struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		Waypoints []struct {
+			Index int `json:"waypoint_index"`
+		} `json:"waypoints"`
+		Trips []struct {
+			Meters  float64 `json:"distance"`
+			Seconds float64 `json:"duration"`
+		} `json:"trips"`
+	}
+
+	if err := s.get(ctx, "osrm trip", s.osrmURL("trip", byCar, coords, q), &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Code != "Ok" || len(resp.Trips) == 0 || len(resp.Waypoints) != len(stops) {
+		return nil, fmt.Errorf("osrm trip: %s %s", resp.Code, resp.Message)
+	}
+
+	// Waypoints come back in the order they were sent, each saying where
+	// in the trip it falls.
+	order := make([]string, len(stops))
+
+	for i, w := range resp.Waypoints {
+		if w.Index < 0 || w.Index >= len(stops) || order[w.Index] != "" {
+			return nil, fmt.Errorf("osrm trip: bad waypoint index %d", w.Index)
+		}
+
+		order[w.Index] = stops[i].id
+	}
+
+	// The start and any finish are not the stops' to order.
+	order = order[1:]
+	if end != nil {
+		order = order[:len(order)-1]
+	}
+
+	trip := resp.Trips[0]
+
+	return &OptimizeRouteResponse{
+		Order:           order,
+		Meters:          int64(math.Round(trip.Meters)),
+		DisplayDistance: DisplayMiles(trip.Meters),
+		DisplayDuration: DisplayDuration(trip.Seconds),
+		Seconds:         int64(math.Round(trip.Seconds)),
+		end:             endId,
+	}, nil
+}
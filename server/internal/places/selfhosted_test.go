@@ -0,0 +1,212 @@
+package places
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nguyen/allycat/internal/roads"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfHosted points a SelfHostedApi at one server standing in for both OSRM
+// and Nominatim, which records every URL it is asked for.
+func selfHosted(t *testing.T, fn http.HandlerFunc) (*SelfHostedApi, func() []*url.URL) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var seen []*url.URL
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL)
+		mu.Unlock()
+
+		fn(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	api, err := NewSelfHostedApi(ts.URL, ts.URL+"/nominatim", WithSelfHostedHTTPClient(ts.Client()))
+	require.NoError(t, err)
+
+	return api, func() []*url.URL {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return seen
+	}
+}
+
+var selfHostedCoords = map[string]Coord{
+	"start": {Lat: 39.95, Long: -75.18},
+	"a":     {Lat: 39.96, Long: -75.19},
+	"end":   {Lat: 39.94, Long: -75.17},
+}
+
+func TestNewSelfHostedApiRequiresBothURLs(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewSelfHostedApi("", "http://nominatim")
+	assert.Error(t, err)
+
+	_, err = NewSelfHostedApi("http://osrm", "")
+	assert.Error(t, err)
+}
+
+func TestSelfHostedSearch(t *testing.T) {
+	t.Parallel()
+
+	api, seen := selfHosted(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[
+			{"osm_type":"way","osm_id":42,"lat":"39.9526","lon":"-75.1635","name":"City Hall","display_name":"City Hall, Philadelphia"},
+			{"osm_type":"node","osm_id":7,"lat":"39.95","lon":"-75.16","name":"","display_name":"Dilworth Park, Philadelphia"}
+		]`))
+	})
+
+	got, err := api.Search(context.Background(), TextSearchOptions{
+		Query:   "city hall",
+		LongLat: &longLat{Lat: 39.95, Long: -75.16},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, got.Places, 2)
+	assert.Equal(t, "osm:W42", got.Places[0].Id)
+	assert.Equal(t, "City Hall", got.Places[0].DisplayName.Name)
+	assert.Equal(t, "City Hall, Philadelphia", got.Places[0].FormattedAddress)
+	assert.InDelta(t, 39.9526, got.Places[0].Coordinates.Lat, 1e-9)
+	assert.Equal(t, "Dilworth Park", got.Places[1].DisplayName.Name, "a nameless result is named from its address")
+
+	req := seen()[0]
+	assert.Equal(t, "/nominatim/search", req.Path)
+	assert.Equal(t, "city hall", req.Query().Get("q"))
+	assert.NotEmpty(t, req.Query().Get("viewbox"), "the bias narrows the search")
+}
+
+func TestSelfHostedRouteLegs(t *testing.T) {
+	t.Parallel()
+
+	api, seen := selfHosted(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"code":"Ok","routes":[{"distance":3000,"duration":600,"legs":[
+			{"distance":1000,"duration":200,"steps":[
+				{"distance":800,"name":"Market St","maneuver":{"type":"depart","bearing_after":90}},
+				{"distance":100,"name":"Broad St","maneuver":{"type":"turn","modifier":"left"}},
+				{"distance":0,"name":"Broad St","maneuver":{"type":"arrive"}}
+			]},
+			{"distance":2000,"duration":400,"steps":[]}
+		]}]}`))
+	})
+
+	got, err := api.RouteLegs(context.Background(), RouteLegsOptions{
+		Origin:      "start",
+		Stops:       []string{"a"},
+		Destination: "end",
+		Cues:        true,
+		Coords:      selfHostedCoords,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, got.Legs, 2)
+	assert.Equal(t, "start", got.Legs[0].FromId)
+	assert.Equal(t, "end", got.Legs[1].ToId)
+	assert.Equal(t, int64(2000), got.Legs[1].Meters)
+	assert.Equal(t, int64(3000), got.Meters)
+
+	assert.Equal(t, []Cue{
+		{Maneuver: roads.Depart, Instruction: "Head east on Market St", Meters: 800, DisplayDistance: "0.5 mi"},
+		{Maneuver: roads.TurnLeft, Instruction: "Turn left onto Broad St", Meters: 100, DisplayDistance: "330 ft"},
+	}, got.Legs[0].Cues)
+
+	req := seen()[0]
+	assert.Equal(t, "/route/v1/cycling/-75.180000,39.950000;-75.190000,39.960000;-75.170000,39.940000", req.Path)
+	assert.Equal(t, "true", req.Query().Get("steps"))
+}
+
+func TestSelfHostedNeedsCoordinates(t *testing.T) {
+	t.Parallel()
+
+	api, seen := selfHosted(t, func(http.ResponseWriter, *http.Request) {})
+
+	_, err := api.RouteLegs(context.Background(), RouteLegsOptions{Origin: "start", Destination: "nowhere", Coords: selfHostedCoords})
+	assert.ErrorIs(t, err, ErrNoCoordinates)
+
+	_, err = api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"start", "a"}})
+	assert.ErrorIs(t, err, ErrNoCoordinates)
+
+	assert.Empty(t, seen(), "nothing is asked without coordinates")
+}
+
+func TestSelfHostedRouteMatrix(t *testing.T) {
+	t.Parallel()
+
+	api, seen := selfHosted(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"code":"Ok",
+			"distances":[[0,100],[null,0]],
+			"durations":[[0,20],[null,0]]}`))
+	})
+
+	got, err := api.RouteMatrix(context.Background(), RouteMatrixOptions{
+		Places: []string{"start", "a"},
+		ByCar:  true,
+		Coords: selfHostedCoords,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"start", "a"}, got.Ids)
+	assert.Equal(t, 100.0, got.Meters[0][1])
+	assert.Equal(t, 20.0, got.Seconds[0][1])
+	assert.True(t, got.Meters[1][0] > 1e300, "no route is +Inf")
+
+	assert.True(t, strings.HasPrefix(seen()[0].Path, "/table/v1/driving/"))
+}
+
+func TestSelfHostedOptimizeRoute(t *testing.T) {
+	t.Parallel()
+
+	// OSRM visits the two stops the other way round.
+	api, seen := selfHosted(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"code":"Ok",
+			"waypoints":[{"waypoint_index":0},{"waypoint_index":2},{"waypoint_index":1},{"waypoint_index":3}],
+			"trips":[{"distance":5000,"duration":900}]}`))
+	})
+
+	opts, err := NewOptimizeRoutePayloadBuilder().
+		WithStart("start", 1, 1).
+		AddStop("a", 2, 2).
+		AddStop("b", 3, 3).
+		WithEnd("end", 4, 4).
+		Build()
+	require.NoError(t, err)
+
+	got, err := api.OptimizeRoute(context.Background(), opts)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "end", got[0].End)
+	assert.Equal(t, []string{"b", "a"}, got[0].BikeRoute.Order)
+	assert.Equal(t, int64(5000), got[0].CarRoute.Meters)
+
+	urls := seen()
+	require.Len(t, urls, 2, "once by bike and once by car")
+
+	q := urls[0].Query()
+	assert.Equal(t, "first", q.Get("source"))
+	assert.Equal(t, "last", q.Get("destination"))
+	assert.Equal(t, "false", q.Get("roundtrip"))
+}
+
+func TestSelfHostedReportsOSRMErrors(t *testing.T) {
+	t.Parallel()
+
+	api, _ := selfHosted(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":"NoRoute","message":"Impossible route between points"}`))
+	})
+
+	_, err := api.RouteLegs(context.Background(), RouteLegsOptions{Origin: "start", Destination: "end", Coords: selfHostedCoords})
+	assert.ErrorContains(t, err, "NoRoute: Impossible route between points")
+}
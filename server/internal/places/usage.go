@@ -0,0 +1,327 @@
+package places
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SKU is what Google bills a call as. Which one depends on the request: the
+// fields asked for, and for routes whether Google is asked to optimize.
+type SKU string
+
+const (
+	SKUTextSearchIDsOnly     SKU = "searchText.idsOnly"
+	SKUTextSearchPro         SKU = "searchText.pro"
+	SKUTextSearchEnterprise  SKU = "searchText.enterprise"
+	SKUComputeRoutesBasic    SKU = "computeRoutes.basic"
+	SKUComputeRoutesAdvanced SKU = "computeRoutes.advanced"
+	SKURouteMatrixBasic      SKU = "computeRouteMatrix.basic"
+)
+
+// skuMicros is Google's list price for one unit of each SKU, in millionths
+// of a dollar: a call, or for the matrix an element. These are estimates to
+// budget against, before any volume discount or free allowance.
+var skuMicros = map[SKU]int64{
+	SKUTextSearchIDsOnly:     0,
+	SKUTextSearchPro:         32_000,
+	SKUTextSearchEnterprise:  35_000,
+	SKUComputeRoutesBasic:    5_000,
+	SKUComputeRoutesAdvanced: 10_000,
+	SKURouteMatrixBasic:      5_000,
+}
+
+// ErrBudgetExceeded means a call would take Google's spend past its budget,
+// so it was not sent.
+var ErrBudgetExceeded = errors.New("google budget is spent")
+
+// enterpriseSearchFields are the place fields that bill a text search as
+// Enterprise rather than Pro.
+var enterpriseSearchFields = []string{
+	"places.rating",
+	"places.userRatingCount",
+	"places.websiteUri",
+	"places.nationalPhoneNumber",
+	"places.internationalPhoneNumber",
+	"places.regularOpeningHours",
+	"places.currentOpeningHours",
+	"places.priceLevel",
+}
+
+// textSearchSKU is how a text search asking for masks is billed: the
+// dearest tier any of its fields falls in.
+func textSearchSKU(masks []string) SKU {
+	sku := SKUTextSearchIDsOnly
+
+	for _, m := range masks {
+		switch {
+		case slices.Contains(enterpriseSearchFields, m):
+			return SKUTextSearchEnterprise
+		case m != "places.id" && !strings.HasPrefix(m, "places.attributions"):
+			sku = SKUTextSearchPro
+		}
+	}
+
+	return sku
+}
+
+// computeRoutesSKU is how a computeRoutes call is billed. Asking Google to
+// order the waypoints, or more than ten of them, is Advanced.
+func computeRoutesSKU(intermediates int, optimize bool) SKU {
+	if optimize || intermediates > 10 {
+		return SKUComputeRoutesAdvanced
+	}
+
+	return SKUComputeRoutesBasic
+}
+
+// billing is what one call to Google costs: units of a SKU.
+type billing struct {
+	sku   SKU
+	units int64
+}
+
+func (b billing) micros() int64 {
+	return skuMicros[b.sku] * b.units
+}
+
+// Meter adds up what the calls to Google cost, today and this month, and
+// refuses any call that would take either past its budget. Days and months
+// are UTC. With a file the totals are written to it after every call and
+// read back on start, so a restart does not reset the budget.
+//
+// A Meter is safe for concurrent use.
+type Meter struct {
+	dailyMicros   int64
+	monthlyMicros int64
+	file          *snapshotFile
+	now           func() time.Time
+
+	mu    sync.Mutex
+	usage meterUsage
+
+	// held is what the calls allowed but not yet charged or refunded may
+	// cost. It counts against both budgets, so calls made at once cannot
+	// all squeeze into the same headroom.
+	held int64
+
+	// seq numbers the snapshots taken for the file, so an older one never
+	// lands over a newer one.
+	seq     uint64
+	saveErr error
+}
+
+// meterUsage is the Meter's state, as it is saved.
+type meterUsage struct {
+	Day   usagePeriod `json:"day"`
+	Month usagePeriod `json:"month"`
+}
+
+type usagePeriod struct {
+	Period string        `json:"period"`
+	Units  map[SKU]int64 `json:"units"`
+	Micros int64         `json:"micros"`
+}
+
+func (u *usagePeriod) add(b billing) {
+	if u.Units == nil {
+		u.Units = make(map[SKU]int64)
+	}
+
+	u.Units[b.sku] += b.units
+	u.Micros += b.micros()
+}
+
+func (u usagePeriod) clone() usagePeriod {
+	u.Units = maps.Clone(u.Units)
+	return u
+}
+
+// MeterOption customises a Meter.
+type MeterOption func(*Meter)
+
+// WithDailyBudget caps what Google may cost in a day, in dollars. Zero is no
+// cap.
+func WithDailyBudget(usd float64) MeterOption {
+	return func(m *Meter) { m.dailyMicros = dollarsToMicros(usd) }
+}
+
+// WithMonthlyBudget caps what Google may cost in a month, in dollars. Zero
+// is no cap.
+func WithMonthlyBudget(usd float64) MeterOption {
+	return func(m *Meter) { m.monthlyMicros = dollarsToMicros(usd) }
+}
+
+// WithUsageFile keeps the running totals in a JSON file at path.
+func WithUsageFile(path string) MeterOption {
+	return func(m *Meter) { m.file = &snapshotFile{path: path} }
+}
+
+func NewMeter(opts ...MeterOption) (*Meter, error) {
+	m := &Meter{now: time.Now}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.dailyMicros < 0 || m.monthlyMicros < 0 {
+		return nil, errors.New("a budget cannot be negative")
+	}
+
+	if m.file != nil {
+		if err := loadJSON(m.file.path, &m.usage); err != nil {
+			return nil, fmt.Errorf("reading usage %s: %w", m.file.path, err)
+		}
+	}
+
+	return m, nil
+}
+
+// roll starts a new day or month once the clock has moved into one. The
+// caller holds mu.
+func (m *Meter) roll() {
+	now := m.now().UTC()
+
+	if day := now.Format(time.DateOnly); m.usage.Day.Period != day {
+		m.usage.Day = usagePeriod{Period: day}
+	}
+
+	if month := now.Format("2006-01"); m.usage.Month.Period != month {
+		m.usage.Month = usagePeriod{Period: month}
+	}
+}
+
+// allow refuses b when it would take today or this month past its budget,
+// counting what the calls already allowed may yet cost. Otherwise it holds
+// b's cost until the call is charged or refunded, so every call it allows
+// must be one or the other.
+func (m *Meter) allow(b billing) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll()
+
+	cost := b.micros()
+
+	if m.dailyMicros > 0 && m.usage.Day.Micros+m.held+cost > m.dailyMicros {
+		return fmt.Errorf("%w for today", ErrBudgetExceeded)
+	}
+
+	if m.monthlyMicros > 0 && m.usage.Month.Micros+m.held+cost > m.monthlyMicros {
+		return fmt.Errorf("%w for the month", ErrBudgetExceeded)
+	}
+
+	m.held += cost
+
+	return nil
+}
+
+// refund lets go of what allow held for b, for a call Google did not bill.
+func (m *Meter) refund(b billing) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.held -= b.micros()
+}
+
+// charge adds b to today and this month, in place of what allow held for
+// it. The file is written once the lock is released. A failed save does not
+// undo the charge; the error is kept for Usage to report, and the next save
+// catches up.
+func (m *Meter) charge(b billing) {
+	m.mu.Lock()
+
+	m.held -= b.micros()
+	m.roll()
+	m.usage.Day.add(b)
+	m.usage.Month.add(b)
+
+	if m.file == nil {
+		m.mu.Unlock()
+		return
+	}
+
+	m.seq++
+	seq := m.seq
+	snapshot := meterUsage{Day: m.usage.Day.clone(), Month: m.usage.Month.clone()}
+
+	m.mu.Unlock()
+
+	err := m.file.save(seq, snapshot)
+
+	m.mu.Lock()
+	m.saveErr = err
+	m.mu.Unlock()
+}
+
+// Spent is whether today's or this month's budget is used up, so that a
+// caller with another way to answer can skip Google altogether.
+func (m *Meter) Spent() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll()
+
+	return (m.dailyMicros > 0 && m.usage.Day.Micros >= m.dailyMicros) ||
+		(m.monthlyMicros > 0 && m.usage.Month.Micros >= m.monthlyMicros)
+}
+
+// Usage is what Google has cost today and this month.
+type Usage struct {
+	Today     UsagePeriod `json:"today"`
+	ThisMonth UsagePeriod `json:"thisMonth"`
+
+	// SaveError is why the totals could not last be written to disk.
+	SaveError string `json:"saveError,omitempty"`
+}
+
+// UsagePeriod is a day's or a month's spend. Units are calls, or elements
+// for the route matrix, by SKU.
+type UsagePeriod struct {
+	Period    string        `json:"period"`
+	Units     map[SKU]int64 `json:"units"`
+	CostUsd   float64       `json:"costUsd"`
+	BudgetUsd float64       `json:"budgetUsd,omitempty"`
+}
+
+// Usage reports the running totals.
+func (m *Meter) Usage() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll()
+
+	out := Usage{
+		Today:     m.usage.Day.report(m.dailyMicros),
+		ThisMonth: m.usage.Month.report(m.monthlyMicros),
+	}
+
+	if m.saveErr != nil {
+		out.SaveError = m.saveErr.Error()
+	}
+
+	return out
+}
+
+func (u usagePeriod) report(budget int64) UsagePeriod {
+	units := make(map[SKU]int64, len(u.Units))
+	for k, v := range u.Units {
+		units[k] = v
+	}
+
+	return UsagePeriod{
+		Period:    u.Period,
+		Units:     units,
+		CostUsd:   float64(u.Micros) / 1e6,
+		BudgetUsd: float64(budget) / 1e6,
+	}
+}
+
+func dollarsToMicros(usd float64) int64 {
+	return int64(math.Round(usd * 1e6))
+}
@@ -0,0 +1,301 @@
+package places
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMeter(t *testing.T, opts ...MeterOption) *Meter {
+	t.Helper()
+
+	m, err := NewMeter(opts...)
+	require.NoError(t, err)
+
+	return m
+}
+
+// meteredApi has a Google that answers every search with one place, and
+// counts how often it is asked.
+func meteredApi(t *testing.T, m *Meter) (*PlacesApi, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1"}]}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	api := newTestApi(t, ts)
+	WithMeter(m)(api)
+
+	return api, &calls
+}
+
+func TestTextSearchSKU(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, SKUTextSearchIDsOnly, textSearchSKU([]string{"places.id"}))
+	assert.Equal(t, SKUTextSearchPro, textSearchSKU([]string{"places.id", "places.location"}))
+	assert.Equal(t, SKUTextSearchEnterprise, textSearchSKU([]string{"places.location", "places.rating"}))
+}
+
+func TestComputeRoutesSKU(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, SKUComputeRoutesBasic, computeRoutesSKU(10, false))
+	assert.Equal(t, SKUComputeRoutesAdvanced, computeRoutesSKU(11, false))
+	assert.Equal(t, SKUComputeRoutesAdvanced, computeRoutesSKU(2, true))
+}
+
+func TestMeterChargesEveryCallBySKU(t *testing.T) {
+	t.Parallel()
+
+	m := newMeter(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Goog-FieldMask") {
+		case "originIndex,destinationIndex,status,condition,distanceMeters,duration":
+			_, _ = w.Write([]byte(matrixResponse([][]int64{{0, 1, 1}, {1, 0, 1}, {1, 1, 0}})))
+		default:
+			routesHandler(t, map[string]int64{"BICYCLE": 1000, "DRIVE": 2000})(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	api := newTestApi(t, ts)
+	WithMeter(m)(api)
+
+	opts, err := NewOptimizeRoutePayloadBuilder().
+		WithStart("start", 1, 1).
+		AddStop("a", 2, 2).
+		AddStop("b", 3, 3).
+		WithEnd("end", 4, 4).
+		Build()
+	require.NoError(t, err)
+
+	_, err = api.OptimizeRoute(context.Background(), opts)
+	require.NoError(t, err)
+
+	_, err = api.RouteMatrix(context.Background(), RouteMatrixOptions{Places: []string{"a", "b", "c"}})
+	require.NoError(t, err)
+
+	got := m.Usage()
+
+	// A bike and a car route optimized by Google, and nine matrix elements.
+	assert.Equal(t, map[SKU]int64{SKUComputeRoutesAdvanced: 2, SKURouteMatrixBasic: 9}, got.Today.Units)
+	assert.InDelta(t, 2*0.010+9*0.005, got.Today.CostUsd, 1e-9)
+	assert.Equal(t, got.Today.Units, got.ThisMonth.Units)
+}
+
+func TestMeterDoesNotChargeFailedCalls(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	m := newMeter(t)
+	api := newTestApi(t, ts)
+	WithMeter(m)(api)
+
+	_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	require.Error(t, err)
+	assert.Zero(t, m.Usage().Today.CostUsd)
+}
+
+func TestMeterEnforcesTheDailyBudget(t *testing.T) {
+	t.Parallel()
+
+	// Room for two Pro searches at $0.032, not three.
+	m := newMeter(t, WithDailyBudget(0.07))
+	api, calls := meteredApi(t, m)
+
+	search := func() error {
+		_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+		return err
+	}
+
+	require.NoError(t, search())
+	require.NoError(t, search())
+	assert.False(t, api.OverBudget(), "a cent is left, if not enough for a search")
+
+	assert.ErrorIs(t, search(), ErrBudgetExceeded)
+	assert.Equal(t, int32(2), calls.Load())
+
+	got := m.Usage()
+	assert.InDelta(t, 0.064, got.Today.CostUsd, 1e-9)
+	assert.InDelta(t, 0.07, got.Today.BudgetUsd, 1e-9)
+}
+
+func TestMeterHoldsTheBudgetForCallsInFlight(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	var calls atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		<-release
+		_, _ = w.Write([]byte(`{"places":[{"id":"p1"}]}`))
+	}))
+	defer ts.Close()
+
+	// Room for two Pro searches at $0.032, not three.
+	m := newMeter(t, WithDailyBudget(0.07))
+	api := newTestApi(t, ts)
+	WithMeter(m)(api)
+
+	errs := make(chan error, 5)
+
+	for range 5 {
+		go func() {
+			_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+			errs <- err
+		}()
+	}
+
+	// The two searches Google is still answering hold the budget, so the
+	// rest are refused without waiting for them.
+	for range 3 {
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, ErrBudgetExceeded)
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("every search got past the budget")
+		}
+	}
+
+	close(release)
+
+	for range 2 {
+		assert.NoError(t, <-errs)
+	}
+
+	assert.Equal(t, int32(2), calls.Load())
+	assert.InDelta(t, 0.064, m.Usage().Today.CostUsd, 1e-9)
+}
+
+func TestMeterRefundsWhatFailedCallsHeld(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	// Room for one search, which each failure gives back.
+	m := newMeter(t, WithDailyBudget(0.04))
+	api := newTestApi(t, ts)
+	WithMeter(m)(api)
+
+	for range 3 {
+		_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrBudgetExceeded)
+	}
+
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestMeterStartsEachDayAndMonthAfresh(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 6, 30, 23, 0, 0, 0, time.UTC)
+
+	m := newMeter(t, WithDailyBudget(0.05), WithMonthlyBudget(0.096))
+	m.now = func() time.Time { return now }
+
+	api, _ := meteredApi(t, m)
+
+	search := func() error {
+		_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+		return err
+	}
+
+	require.NoError(t, search())
+	assert.ErrorIs(t, search(), ErrBudgetExceeded)
+
+	// A new day, and a new month with it.
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, search())
+
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, search())
+
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, search())
+
+	now = now.Add(24 * time.Hour)
+	assert.ErrorIs(t, search(), ErrBudgetExceeded, "three searches have spent the month")
+	assert.True(t, api.OverBudget())
+
+	got := m.Usage()
+	assert.Equal(t, "2025-07-04", got.Today.Period)
+	assert.Zero(t, got.Today.CostUsd)
+	assert.Equal(t, "2025-07", got.ThisMonth.Period)
+	assert.Equal(t, int64(3), got.ThisMonth.Units[SKUTextSearchPro])
+}
+
+func TestMeterSurvivesRestarts(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "usage.json")
+
+	api, _ := meteredApi(t, newMeter(t, WithUsageFile(path)))
+
+	_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	require.NoError(t, err)
+
+	restarted := newMeter(t, WithUsageFile(path), WithDailyBudget(0.04))
+	assert.Equal(t, int64(1), restarted.Usage().Today.Units[SKUTextSearchPro])
+
+	api, calls := meteredApi(t, restarted)
+
+	_, err = api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.Zero(t, calls.Load())
+}
+
+func TestMeterReportsWhatItCouldNotSave(t *testing.T) {
+	t.Parallel()
+
+	m := newMeter(t, WithUsageFile(filepath.Join(t.TempDir(), "missing", "usage.json")))
+	api, _ := meteredApi(t, m)
+
+	_, err := api.TextSearch(context.Background(), TextSearchOptions{Query: "city hall"})
+	require.NoError(t, err, "the search still worked")
+
+	got := m.Usage()
+	assert.NotEmpty(t, got.SaveError)
+	assert.Equal(t, int64(1), got.Today.Units[SKUTextSearchPro])
+}
+
+func TestNewMeterValidates(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewMeter(WithDailyBudget(-1))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "usage.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+
+	_, err = NewMeter(WithUsageFile(path))
+	assert.ErrorContains(t, err, "reading usage")
+}
@@ -0,0 +1,181 @@
+package roads
+
+import (
+	"fmt"
+	"math"
+)
+
+// Maneuvers name a cue's turn the way Google's Routes API does, so a cue
+// sheet reads the same whichever router measured it.
+const (
+	Depart          = "DEPART"
+	NameChange      = "NAME_CHANGE"
+	TurnSlightLeft  = "TURN_SLIGHT_LEFT"
+	TurnSlightRight = "TURN_SLIGHT_RIGHT"
+	TurnLeft        = "TURN_LEFT"
+	TurnRight       = "TURN_RIGHT"
+	TurnSharpLeft   = "TURN_SHARP_LEFT"
+	TurnSharpRight  = "TURN_SHARP_RIGHT"
+	UturnLeft       = "UTURN_LEFT"
+	UturnRight      = "UTURN_RIGHT"
+)
+
+// cueTurnDegrees is how far a road has to bend at a junction, staying on
+// the same street, before it is worth a cue of its own.
+const cueTurnDegrees = 45
+
+// Cue is one line of a cue sheet: the turn onto a street, and how far to
+// ride along it before the next cue.
+type Cue struct {
+	Maneuver    string
+	Instruction string
+	Street      string
+	Meters      float64
+}
+
+// cuesTo turns the route the search found to n into a cue sheet. A cue
+// starts wherever the street changes, or where the route turns off at a
+// junction without it changing; bends with no choice of road are not cues.
+func (s *search) cuesTo(n int32) []Cue {
+	var hops []edge
+	var starts []int32
+
+	for s.via[n] >= 0 {
+		e := s.g.edges[s.via[n]]
+		hops = append(hops, e)
+		starts = append(starts, s.from[n])
+		n = s.from[n]
+	}
+
+	var out []Cue
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		e, at := hops[i], starts[i]
+		street := streetOf(s.g.ways[e.way])
+		heading := bearing(s.g.points[at], s.g.points[e.to])
+
+		if len(out) == 0 {
+			out = append(out, Cue{
+				Maneuver:    Depart,
+				Instruction: DepartInstruction(heading, street),
+				Street:      street,
+				Meters:      float64(e.meters),
+			})
+
+			continue
+		}
+
+		prev := hops[i+1]
+		turn := turnDegrees(bearing(s.g.points[starts[i+1]], s.g.points[at]), heading)
+		last := &out[len(out)-1]
+
+		junction := s.g.first[at+1]-s.g.first[at] > 2
+		sameStreet := street == last.Street && (street != "" || e.way == prev.way)
+
+		if sameStreet && (!junction || math.Abs(turn) < cueTurnDegrees) {
+			last.Meters += float64(e.meters)
+			continue
+		}
+
+		m := maneuver(turn)
+		out = append(out, Cue{
+			Maneuver:    m,
+			Instruction: TurnInstruction(m, street),
+			Street:      street,
+			Meters:      float64(e.meters),
+		})
+	}
+
+	return out
+}
+
+// streetOf is what a cue calls w: its name, or its route number when it
+// has none.
+func streetOf(w way) string {
+	if name := w.tags["name"]; name != "" {
+		return name
+	}
+
+	return w.tags["ref"]
+}
+
+// bearing is the compass heading from a to b, in degrees clockwise from
+// north. Flat is plenty over one block.
+func bearing(a, b Point) float64 {
+	dx := (b.Long - a.Long) * math.Cos(a.Lat*math.Pi/180)
+	dy := b.Lat - a.Lat
+
+	return math.Mod(math.Atan2(dx, dy)*180/math.Pi+360, 360)
+}
+
+// turnDegrees is how far a rider heading in turns to head out: positive to
+// the right, negative to the left.
+func turnDegrees(in, out float64) float64 {
+	d := math.Mod(out-in+540, 360) - 180
+	if d == -180 {
+		return 180
+	}
+
+	return d
+}
+
+func maneuver(turn float64) string {
+	right := turn > 0
+
+	pick := func(l, r string) string {
+		if right {
+			return r
+		}
+
+		return l
+	}
+
+	switch a := math.Abs(turn); {
+	case a < 20:
+		return NameChange
+	case a < 60:
+		return pick(TurnSlightLeft, TurnSlightRight)
+	case a < 135:
+		return pick(TurnLeft, TurnRight)
+	case a < 170:
+		return pick(TurnSharpLeft, TurnSharpRight)
+	default:
+		return pick(UturnLeft, UturnRight)
+	}
+}
+
+var compassPoints = []string{"north", "northeast", "east", "southeast", "south", "southwest", "west", "northwest"}
+
+// DepartInstruction is the first line of a cue sheet: which way to set off,
+// by compass heading in degrees, and on which street.
+func DepartInstruction(heading float64, street string) string {
+	dir := compassPoints[int(math.Round(heading/45))%len(compassPoints)]
+
+	if street == "" {
+		return "Head " + dir
+	}
+
+	return fmt.Sprintf("Head %s on %s", dir, street)
+}
+
+var maneuverPhrases = map[string]string{
+	NameChange:      "Continue",
+	TurnSlightLeft:  "Slight left",
+	TurnSlightRight: "Slight right",
+	TurnLeft:        "Turn left",
+	TurnRight:       "Turn right",
+	TurnSharpLeft:   "Sharp left",
+	TurnSharpRight:  "Sharp right",
+	UturnLeft:       "Make a U-turn",
+	UturnRight:      "Make a U-turn",
+}
+
+// TurnInstruction says to make turn m onto street, like "Turn left onto
+// Spring Garden St".
+func TurnInstruction(m, street string) string {
+	if street == "" {
+		return maneuverPhrases[m]
+	}
+
+	return maneuverPhrases[m] + " onto " + street
+}
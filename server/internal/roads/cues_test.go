@@ -0,0 +1,71 @@
+package roads
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteCues(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	// Round Green Street's one-way by the Mount Vernon cycleway.
+	got, err := g.Route(t.Context(), Bike, junction(1, 0), junction(1, 3))
+	require.NoError(t, err)
+
+	require.Len(t, got.Cues, 3)
+
+	assert.Equal(t, Depart, got.Cues[0].Maneuver)
+	assert.Equal(t, "Head north on North 22nd Street", got.Cues[0].Instruction)
+	assert.InDelta(t, block, got.Cues[0].Meters, 5)
+
+	assert.Equal(t, TurnRight, got.Cues[1].Maneuver)
+	assert.Equal(t, "Turn right onto Mount Vernon Street", got.Cues[1].Instruction)
+	assert.InDelta(t, 3*block, got.Cues[1].Meters, 5, "straight through two junctions on the one street")
+
+	assert.Equal(t, TurnRight, got.Cues[2].Maneuver)
+	assert.Equal(t, "North 19th Street", got.Cues[2].Street)
+
+	var meters float64
+	for _, c := range got.Cues {
+		meters += c.Meters
+	}
+
+	assert.InDelta(t, got.Meters, meters, 1e-3)
+}
+
+func TestRouteCuesAreEmptyWhenAlreadyThere(t *testing.T) {
+	t.Parallel()
+
+	got, err := loadFixture(t).Route(t.Context(), Bike, junction(0, 0), junction(0, 0))
+	require.NoError(t, err)
+
+	assert.Empty(t, got.Cues)
+}
+
+func TestManeuver(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in, out float64
+		want    string
+	}{
+		{0, 10, NameChange},
+		{350, 5, NameChange},
+		{0, 45, TurnSlightRight},
+		{0, 90, TurnRight},
+		{90, 0, TurnLeft},
+		{0, 200, TurnSharpLeft},
+		{0, 150, TurnSharpRight},
+		{0, 180, UturnRight},
+		{10, 185, UturnRight},
+		{185, 10, UturnLeft},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, maneuver(turnDegrees(tt.in, tt.out)), "%v→%v", tt.in, tt.out)
+	}
+}
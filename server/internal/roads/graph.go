@@ -0,0 +1,232 @@
+// Package roads routes over a city's road network loaded from an
+// OpenStreetMap extract, so road distances do not depend on Google being
+// reachable or the quota lasting the night.
+//
+// A Graph is built once from the extract and only read afterwards, so one
+// Graph serves any number of concurrent queries.
+package roads
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	// ErrOffNetwork means a point is further from any road the profile can
+	// use than maxSnapMeters.
+	ErrOffNetwork = errors.New("not near a road")
+
+	// ErrNoRoute means no road the profile can use joins two points.
+	ErrNoRoute = errors.New("no route between the points")
+
+	// ErrUnsupportedFormat means Load does not know how to read the file.
+	ErrUnsupportedFormat = errors.New("unsupported extract format")
+)
+
+// maxSnapMeters is how far a point may be from the nearest usable road. A
+// checkpoint is somewhere on a street; anything further off than this is
+// outside the extract or the wrong city.
+const maxSnapMeters = 500
+
+// snapCellDegrees is the side of a cell in the grid that finds the node
+// nearest a point. A cell is wider than maxSnapMeters anywhere a race is
+// likely to be, so the cell a point is in and its neighbours are enough.
+const snapCellDegrees = 0.01
+
+const earthRadiusMeters = 6371008.8
+
+// Point is a position in degrees.
+type Point struct {
+	Lat  float64
+	Long float64
+}
+
+// way is what the profiles need to know about an OSM way.
+type way struct {
+	tags map[string]string
+}
+
+// edge is one hop between consecutive nodes of a way. Every segment is
+// stored in both directions, and the profile decides which it may ride.
+// block is the length of the stretch of the way between junctions that the
+// hop is part of.
+type edge struct {
+	to     int32
+	way    int32
+	meters float32
+	block  float32
+	along  bool // in the way's own node order
+}
+
+// Graph is the road network of one extract. Nodes are numbered densely;
+// the edges leaving node i are edges[first[i]:first[i+1]].
+type Graph struct {
+	points []Point
+	first  []int32
+	edges  []edge
+	ways   []way
+
+	grid map[cell][]int32
+
+	// streets is every node along a street, keyed by streetKey and in node
+	// order, so finding a corner does not scan the whole graph.
+	streets map[string][]int32
+}
+
+type cell struct{ lat, long int32 }
+
+func cellOf(p Point) cell {
+	return cell{int32(math.Floor(p.Lat / snapCellDegrees)), int32(math.Floor(p.Long / snapCellDegrees))}
+}
+
+// Nodes is how many nodes the graph routes over.
+func (g *Graph) Nodes() int {
+	return len(g.points)
+}
+
+// Edges is how many directed hops the graph holds, rideable or not.
+func (g *Graph) Edges() int {
+	return len(g.edges)
+}
+
+// newGraph builds the graph from ways and the OSM node ids along each. Nodes
+// shared between ways are what make junctions, so they are keyed by OSM id.
+func newGraph(nodes map[int64]Point, ways []way, refs [][]int64) *Graph {
+	g := &Graph{ways: ways, grid: make(map[cell][]int32), streets: make(map[string][]int32)}
+
+	index := make(map[int64]int32)
+
+	nodeOf := func(id int64) int32 {
+		if i, ok := index[id]; ok {
+			return i
+		}
+
+		i := int32(len(g.points))
+		index[id] = i
+		g.points = append(g.points, nodes[id])
+		return i
+	}
+
+	type hop struct {
+		from int32
+		edge edge
+	}
+
+	// Hops come in pairs, there and back, so hops[2*i] is a segment along a
+	// way. A way's segments are consecutive, and runs marks where each
+	// way's start.
+	var hops []hop
+	var runs []int
+
+	for w, ids := range refs {
+		runs = append(runs, len(hops)/2)
+
+		for k := 1; k < len(ids); k++ {
+			// A way crossing the edge of a clipped extract has nodes the
+			// extract does not, and no road between the ones either side.
+			_, okA := nodes[ids[k-1]]
+			_, okB := nodes[ids[k]]
+			if !okA || !okB {
+				continue
+			}
+
+			a, b := nodeOf(ids[k-1]), nodeOf(ids[k])
+			m := float32(haversineMeters(g.points[a], g.points[b]))
+
+			hops = append(hops,
+				hop{a, edge{to: b, way: int32(w), meters: m, along: true}},
+				hop{b, edge{to: a, way: int32(w), meters: m, along: false}},
+			)
+		}
+	}
+
+	runs = append(runs, len(hops)/2)
+
+	// A junction is wherever a road does anything but carry straight on:
+	// it meets another, or ends.
+	degree := make([]int, len(g.points))
+	for _, h := range hops {
+		degree[h.from]++
+	}
+
+	for w := 0; w+1 < len(runs); w++ {
+		from := runs[w]
+
+		var length float32
+
+		for i := runs[w]; i < runs[w+1]; i++ {
+			seg := hops[2*i].edge
+			length += seg.meters
+
+			// Segments only chain where the next starts at this one's end;
+			// a gap from clipping ends the block too.
+			last := i+1 == runs[w+1] || hops[2*i+2].from != seg.to
+			if !last && degree[seg.to] == 2 {
+				continue
+			}
+
+			for j := from; j <= i; j++ {
+				hops[2*j].edge.block = length
+				hops[2*j+1].edge.block = length
+			}
+
+			from, length = i+1, 0
+		}
+	}
+
+	g.first = make([]int32, len(g.points)+1)
+	for _, h := range hops {
+		g.first[h.from+1]++
+	}
+
+	for i := 1; i < len(g.first); i++ {
+		g.first[i] += g.first[i-1]
+	}
+
+	g.edges = make([]edge, len(hops))
+	next := append([]int32(nil), g.first[:len(g.points)]...)
+
+	for _, h := range hops {
+		g.edges[next[h.from]] = h.edge
+		next[h.from]++
+	}
+
+	for i, p := range g.points {
+		c := cellOf(p)
+		g.grid[c] = append(g.grid[c], int32(i))
+	}
+
+	keys := make([]string, len(ways))
+	for w := range ways {
+		keys[w] = streetKey(streetOf(ways[w]))
+	}
+
+	for n := range g.points {
+		for _, e := range g.edges[g.first[n]:g.first[n+1]] {
+			k := keys[e.way]
+			if k == "" {
+				continue
+			}
+
+			if on := g.streets[k]; len(on) == 0 || on[len(on)-1] != int32(n) {
+				g.streets[k] = append(on, int32(n))
+			}
+		}
+	}
+
+	return g
+}
+
+// haversineMeters is the great-circle distance between two points, which is
+// plenty for the length of one block.
+func haversineMeters(a, b Point) float64 {
+	const rad = math.Pi / 180
+
+	dLat := (b.Lat - a.Lat) * rad
+	dLong := (b.Long - a.Long) * rad
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,109 @@
+package roads
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrNoIntersection means no node of the graph is on both streets.
+var ErrNoIntersection = errors.New("the streets do not meet")
+
+// Junction is where two streets meet, and what the extract calls them.
+type Junction struct {
+	Point   Point
+	Streets [2]string
+}
+
+// streetWords are the parts of a street's name that say what kind of street
+// it is or which end of it, which sheets leave out: "Broad" is North Broad
+// Street.
+var streetWords = map[string]bool{
+	"n": true, "s": true, "e": true, "w": true,
+	"north": true, "south": true, "east": true, "west": true,
+	"st": true, "street": true, "ave": true, "av": true, "avenue": true,
+	"rd": true, "road": true, "blvd": true, "boulevard": true,
+	"dr": true, "drive": true, "ln": true, "lane": true, "pl": true, "place": true,
+	"ct": true, "court": true, "ter": true, "terrace": true,
+	"pkwy": true, "parkway": true, "hwy": true, "highway": true,
+}
+
+// streetKey is a street name reduced to what tells it apart, so that the
+// way a sheet writes it and the way the extract tags it compare equal.
+// Ordinals lose their suffix: "26th" and "26" are the same street.
+func streetKey(name string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	out := make([]string, 0, len(fields))
+
+	for _, f := range fields {
+		if streetWords[f] {
+			continue
+		}
+
+		if trimmed := strings.TrimRight(f, "stndrh"); trimmed != f && trimmed != "" && strings.Trim(trimmed, "0123456789") == "" {
+			f = trimmed
+		}
+
+		out = append(out, f)
+	}
+
+	return strings.Join(out, " ")
+}
+
+// Intersection finds the node where streets a and b meet, as the extract
+// names them. Streets that meet more than once, or a divided road meeting
+// another, meet at several nodes; the one nearest near wins.
+func (g *Graph) Intersection(a, b string, near Point) (Junction, error) {
+	keyA, keyB := streetKey(a), streetKey(b)
+
+	if keyA == "" || keyB == "" || keyA == keyB {
+		return Junction{}, ErrNoIntersection
+	}
+
+	onA, onB := g.streets[keyA], g.streets[keyB]
+
+	best, bestMeters := Junction{}, -1.0
+
+	// Both lists are in node order, so the nodes on both streets are where
+	// they merge.
+	for i, j := 0, 0; i < len(onA) && j < len(onB); {
+		switch n := onA[i]; {
+		case n < onB[j]:
+			i++
+		case n > onB[j]:
+			j++
+		default:
+			if d := haversineMeters(g.points[n], near); bestMeters < 0 || d < bestMeters {
+				best = Junction{Point: g.points[n], Streets: g.namesAt(n, keyA, keyB)}
+				bestMeters = d
+			}
+
+			i, j = i+1, j+1
+		}
+	}
+
+	if bestMeters < 0 {
+		return Junction{}, ErrNoIntersection
+	}
+
+	return best, nil
+}
+
+// namesAt is what the extract calls the streets keyed a and b at node n.
+func (g *Graph) namesAt(n int32, a, b string) [2]string {
+	var names [2]string
+
+	for _, e := range g.edges[g.first[n]:g.first[n+1]] {
+		switch name := streetOf(g.ways[e.way]); streetKey(name) {
+		case a:
+			names[0] = name
+		case b:
+			names[1] = name
+		}
+	}
+
+	return names
+}
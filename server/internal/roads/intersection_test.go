@@ -0,0 +1,49 @@
+package roads
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreetKey(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "broad", streetKey("N. Broad St"))
+	assert.Equal(t, "26", streetKey("North 26th Street"))
+	assert.Equal(t, streetKey("26th"), streetKey("26 St"))
+	assert.Equal(t, "spring garden", streetKey("Spring Garden Street"))
+	assert.Equal(t, "smith", streetKey("Smith Ave"), "only ordinals lose their suffix")
+}
+
+func TestIntersection(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	got, err := g.Intersection("22nd", "Spring Garden", junction(3, 3))
+	require.NoError(t, err)
+
+	assert.Equal(t, junction(0, 0), got.Point)
+	assert.Equal(t, [2]string{"North 22nd Street", "Spring Garden Street"}, got.Streets)
+
+	got, err = g.Intersection("fairmount ave", "N 19th St", Point{})
+	require.NoError(t, err)
+	assert.Equal(t, junction(3, 3), got.Point)
+}
+
+func TestIntersectionOfStreetsThatDoNotMeet(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	for _, streets := range [][2]string{
+		{"Spring Garden", "Fairmount"},
+		{"22nd", "Broad"},
+		{"22nd", "22nd St"},
+	} {
+		_, err := g.Intersection(streets[0], streets[1], junction(0, 0))
+		assert.ErrorIs(t, err, ErrNoIntersection, "%s & %s", streets[0], streets[1])
+	}
+}
@@ -0,0 +1,157 @@
+package roads
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Load reads an OSM XML extract, optionally gzipped, from path. PBF extracts
+// are not read directly: `osmium cat city.osm.pbf -o city.osm.gz` converts
+// one, and clipping it to the race city first keeps the graph small.
+func Load(path string) (*Graph, error) {
+	name := strings.ToLower(filepath.Base(path))
+
+	gzipped := strings.HasSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, ".gz")
+
+	if !strings.HasSuffix(name, ".osm") && !strings.HasSuffix(name, ".xml") {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, filepath.Base(path))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+
+	if gzipped {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", filepath.Base(path), err)
+		}
+		defer zr.Close()
+
+		r = zr
+	}
+
+	g, err := Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Base(path), err)
+	}
+
+	return g, nil
+}
+
+// Parse builds a graph from OSM XML. Only ways with a highway tag are kept,
+// along with every tag on them so profiles can read whatever they need.
+// Relations, like turn restrictions, are ignored.
+func Parse(r io.Reader) (*Graph, error) {
+	d := xml.NewDecoder(r)
+
+	nodes := make(map[int64]Point)
+
+	var ways []way
+	var refs [][]int64
+
+	// The way being read, if any.
+	var current *way
+	var currentRefs []int64
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "node":
+				id, p, err := parseNode(el)
+				if err != nil {
+					return nil, err
+				}
+
+				nodes[id] = p
+			case "way":
+				current = &way{tags: make(map[string]string)}
+				currentRefs = nil
+			case "nd":
+				if current == nil {
+					continue
+				}
+
+				ref, err := strconv.ParseInt(attr(el, "ref"), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("way node ref: %w", err)
+				}
+
+				currentRefs = append(currentRefs, ref)
+			case "tag":
+				if current != nil {
+					current.tags[attr(el, "k")] = attr(el, "v")
+				}
+			}
+		case xml.EndElement:
+			if el.Name.Local != "way" || current == nil {
+				continue
+			}
+
+			if routable(*current) {
+				ways = append(ways, *current)
+				refs = append(refs, currentRefs)
+			}
+
+			current = nil
+		}
+	}
+
+	return newGraph(nodes, ways, refs), nil
+}
+
+// routable is whether anything could ride w: it has to be a highway, and
+// not a plaza drawn as an area.
+func routable(w way) bool {
+	return w.tags["highway"] != "" && w.tags["area"] != "yes"
+}
+
+func parseNode(el xml.StartElement) (int64, Point, error) {
+	id, err := strconv.ParseInt(attr(el, "id"), 10, 64)
+	if err != nil {
+		return 0, Point{}, fmt.Errorf("node id: %w", err)
+	}
+
+	lat, err := strconv.ParseFloat(attr(el, "lat"), 64)
+	if err != nil {
+		return 0, Point{}, fmt.Errorf("node %d latitude: %w", id, err)
+	}
+
+	long, err := strconv.ParseFloat(attr(el, "lon"), 64)
+	if err != nil {
+		return 0, Point{}, fmt.Errorf("node %d longitude: %w", id, err)
+	}
+
+	return id, Point{Lat: lat, Long: long}, nil
+}
+
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}
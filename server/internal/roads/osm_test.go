@@ -0,0 +1,146 @@
+package roads
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixture = "testdata/fairmount.osm"
+
+// junction is where row r and column c of the fixture's grid cross.
+func junction(r, c int) Point {
+	return Point{Lat: 39.960 + 0.001*float64(r), Long: -75.170 + 0.0013*float64(c)}
+}
+
+func loadFixture(t *testing.T) *Graph {
+	t.Helper()
+
+	g, err := Load(fixture)
+	require.NoError(t, err)
+
+	return g
+}
+
+func TestLoadKeepsOnlyHighways(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	// Sixteen junctions and the two expressway nodes; the building is not a
+	// road.
+	assert.Equal(t, 18, g.Nodes())
+
+	// Eight three-block streets and two expressway hops, both ways.
+	assert.Equal(t, 52, g.Edges())
+}
+
+func TestLoadReadsGzip(t *testing.T) {
+	t.Parallel()
+
+	raw, err := os.ReadFile(fixture)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "fairmount.osm.gz")
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	g, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 18, g.Nodes())
+}
+
+func TestLoadRejectsOtherFormats(t *testing.T) {
+	t.Parallel()
+
+	_, err := Load("philadelphia.osm.pbf")
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestParseRejectsBrokenExtracts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		osm  string
+	}{
+		{"not XML", `<osm><node`},
+		{"a node off the map", `<osm><node id="1" lat="north" lon="-75.1"/></osm>`},
+		{"a way node without an id", `<osm><way id="1"><nd ref="x"/></way></osm>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Parse(strings.NewReader(tt.osm))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseSkipsSegmentsOutsideTheExtract(t *testing.T) {
+	t.Parallel()
+
+	// Node 2 was clipped off, so 1 and 3 are not joined by a road.
+	g, err := Parse(strings.NewReader(`<osm>
+		<node id="1" lat="39.96" lon="-75.17"/>
+		<node id="3" lat="39.96" lon="-75.15"/>
+		<node id="4" lat="39.961" lon="-75.15"/>
+		<way id="1">
+			<nd ref="1"/><nd ref="2"/><nd ref="3"/><nd ref="4"/>
+			<tag k="highway" v="residential"/>
+		</way>
+	</osm>`))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, g.Nodes())
+	assert.Equal(t, 2, g.Edges())
+}
+
+func TestParseMeasuresBlocksBetweenJunctions(t *testing.T) {
+	t.Parallel()
+
+	// 1–2–3 is one block: 2 only carries the road on. 3 is where the side
+	// street meets it, so 3–4 is a block of its own.
+	g, err := Parse(strings.NewReader(`<osm>
+		<node id="1" lat="39.960" lon="-75.170"/>
+		<node id="2" lat="39.961" lon="-75.170"/>
+		<node id="3" lat="39.962" lon="-75.170"/>
+		<node id="4" lat="39.963" lon="-75.170"/>
+		<node id="5" lat="39.962" lon="-75.169"/>
+		<way id="1">
+			<nd ref="1"/><nd ref="2"/><nd ref="3"/><nd ref="4"/>
+			<tag k="highway" v="residential"/>
+		</way>
+		<way id="2">
+			<nd ref="3"/><nd ref="5"/>
+			<tag k="highway" v="residential"/>
+		</way>
+	</osm>`))
+	require.NoError(t, err)
+
+	var blocks []float64
+	for _, e := range g.edges {
+		if e.along && e.way == 0 {
+			blocks = append(blocks, float64(e.block))
+		}
+	}
+
+	require.Len(t, blocks, 3)
+	assert.InDelta(t, 222, blocks[0], 1)
+	assert.InDelta(t, 222, blocks[1], 1)
+	assert.InDelta(t, 111, blocks[2], 1)
+}
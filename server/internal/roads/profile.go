@@ -0,0 +1,371 @@
+package roads
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A Profile is how one kind of vehicle uses the road network: which ways it
+// may ride, which way along them, how fast, and which it would rather not.
+//
+// Routes are the cheapest under the profile, where a way's cost is the time
+// it takes times its weight. Weights default to 1, so a profile without any
+// is the quickest route; a weight of 2 means a way is only worth taking over
+// one of weight 1 when it is more than twice as quick. Paths still report
+// the real time, not the cost.
+type Profile struct {
+	Name string `json:"name"`
+
+	// Speeds is the usual pace on each highway type, in metres per second.
+	// Highway types missing from it are never ridden.
+	Speeds map[string]float64 `json:"speeds"`
+
+	// Restricted are highway types in Speeds that are only ridden where an
+	// access tag says so, like footways for bikes.
+	Restricted []string `json:"restricted,omitempty"`
+
+	// AccessTags are checked from least to most specific; the last one a
+	// way has decides whether the vehicle may use it.
+	AccessTags []string `json:"accessTags"`
+
+	// OnewayTags work the same way for which way along a way is allowed.
+	OnewayTags []string `json:"onewayTags"`
+
+	// OppositeLanes is whether a cycleway=opposite lane makes a one-way
+	// street two-way, which it does for bikes.
+	OppositeLanes bool `json:"oppositeLanes,omitempty"`
+
+	// MaxSpeed is whether a way's maxspeed tag, where lower than its
+	// highway type's pace, is the pace instead.
+	MaxSpeed bool `json:"maxSpeed,omitempty"`
+
+	// HighwayWeights, CyclewayWeights and SurfaceWeights weight a way by its
+	// highway, cycleway and surface tags, and multiply together. Cycleway
+	// tags are read from either side of the road, and the best one counts.
+	HighwayWeights  map[string]float64 `json:"highwayWeights,omitempty"`
+	CyclewayWeights map[string]float64 `json:"cyclewayWeights,omitempty"`
+	SurfaceWeights  map[string]float64 `json:"surfaceWeights,omitempty"`
+
+	// ContraflowMeters is the longest block the profile rides the wrong way
+	// down a one-way, or 0 for never. ContraflowWeight weights doing it.
+	ContraflowMeters float64 `json:"contraflowMeters,omitempty"`
+	ContraflowWeight float64 `json:"contraflowWeight,omitempty"`
+}
+
+// cyclewayTags are where a way's bike lanes are tagged.
+var cyclewayTags = []string{"cycleway", "cycleway:both", "cycleway:left", "cycleway:right"}
+
+var bikeSpeeds = map[string]float64{
+	"primary":        4.5,
+	"primary_link":   4.5,
+	"secondary":      4.5,
+	"secondary_link": 4.5,
+	"tertiary":       5,
+	"tertiary_link":  5,
+	"unclassified":   5,
+	"residential":    5,
+	"living_street":  4,
+	"service":        4.5,
+	"cycleway":       5.5,
+	"path":           4,
+	"track":          3.5,
+	"footway":        3,
+	"pedestrian":     3,
+}
+
+var (
+	// Bike rides anything but motorways, at a pace that barely depends on
+	// the road, and takes the quickest legal route.
+	Bike = Profile{
+		Name:          "bike",
+		Speeds:        bikeSpeeds,
+		Restricted:    []string{"footway", "pedestrian"},
+		AccessTags:    []string{"access", "vehicle", "bicycle"},
+		OnewayTags:    []string{"oneway", "oneway:bicycle"},
+		OppositeLanes: true,
+	}
+
+	// Calm is a bike that will go well out of its way for a protected lane
+	// and to stay off arterials and cobbles.
+	Calm = Profile{
+		Name:          "calm",
+		Speeds:        bikeSpeeds,
+		Restricted:    []string{"footway", "pedestrian"},
+		AccessTags:    []string{"access", "vehicle", "bicycle"},
+		OnewayTags:    []string{"oneway", "oneway:bicycle"},
+		OppositeLanes: true,
+		HighwayWeights: map[string]float64{
+			"primary":        2.5,
+			"primary_link":   2.5,
+			"secondary":      1.8,
+			"secondary_link": 1.8,
+			"tertiary":       1.3,
+			"tertiary_link":  1.3,
+			"service":        1.2,
+			"track":          1.5,
+			"living_street":  0.9,
+			"path":           0.9,
+			"cycleway":       0.6,
+		},
+		CyclewayWeights: map[string]float64{
+			"track":         0.5,
+			"separate":      0.5,
+			"buffered_lane": 0.6,
+			"lane":          0.7,
+			"shared_lane":   0.9,
+			"share_busway":  0.9,
+		},
+		SurfaceWeights: map[string]float64{
+			"paving_stones":      1.1,
+			"compacted":          1.2,
+			"fine_gravel":        1.2,
+			"gravel":             1.5,
+			"unpaved":            1.6,
+			"ground":             1.7,
+			"sett":               1.8,
+			"dirt":               1.8,
+			"cobblestone":        2,
+			"unhewn_cobblestone": 2.5,
+			"grass":              3,
+			"sand":               3,
+		},
+	}
+
+	// Alleycat is the quickest bike, that knowingly rides the wrong way down
+	// a one-way where the block is short enough.
+	Alleycat = Profile{
+		Name:          "alleycat",
+		Speeds:        bikeSpeeds,
+		Restricted:    []string{"footway", "pedestrian"},
+		AccessTags:    []string{"access", "vehicle", "bicycle"},
+		OnewayTags:    []string{"oneway", "oneway:bicycle"},
+		OppositeLanes: true,
+		SurfaceWeights: map[string]float64{
+			"sett":               1.3,
+			"cobblestone":        1.3,
+			"unhewn_cobblestone": 1.5,
+		},
+		ContraflowMeters: 150,
+		ContraflowWeight: 1.5,
+	}
+
+	// Car keeps to roads, at their speed limits where they are tagged.
+	Car = Profile{
+		Name: "car",
+		Speeds: map[string]float64{
+			"motorway":       27,
+			"motorway_link":  16,
+			"trunk":          22,
+			"trunk_link":     14,
+			"primary":        15,
+			"primary_link":   12,
+			"secondary":      13,
+			"secondary_link": 11,
+			"tertiary":       11,
+			"tertiary_link":  9,
+			"unclassified":   9,
+			"residential":    8,
+			"living_street":  3,
+			"service":        5,
+		},
+		AccessTags: []string{"access", "vehicle", "motor_vehicle", "motorcar"},
+		OnewayTags: []string{"oneway"},
+		MaxSpeed:   true,
+	}
+)
+
+// ProfileByName looks up a built-in profile, so callers outside the package
+// can pick one from a request.
+func ProfileByName(name string) (Profile, bool) {
+	for _, p := range []Profile{Bike, Calm, Alleycat, Car} {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return Profile{}, false
+}
+
+// ReadProfiles reads a JSON array of profiles, for riders who want to tune
+// their own.
+func ReadProfiles(r io.Reader) ([]Profile, error) {
+	var out []Profile
+
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return nil, fmt.Errorf("reading profiles: %w", err)
+	}
+
+	for i, p := range out {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("profile at index %d: %w", i, err)
+		}
+	}
+
+	return out, nil
+}
+
+func (p Profile) validate() error {
+	if p.Name == "" {
+		return errors.New("'name' is required")
+	}
+
+	if len(p.Speeds) == 0 {
+		return errors.New("'speeds' is required")
+	}
+
+	for h, s := range p.Speeds {
+		if s <= 0 {
+			return fmt.Errorf("speed for %q must be positive", h)
+		}
+	}
+
+	// A weight of zero would make a way free, and a search could not tell
+	// how far from done it was.
+	for _, weights := range []map[string]float64{p.HighwayWeights, p.CyclewayWeights, p.SurfaceWeights} {
+		for k, w := range weights {
+			if w <= 0 {
+				return fmt.Errorf("weight for %q must be positive", k)
+			}
+		}
+	}
+
+	if p.ContraflowMeters < 0 || p.ContraflowWeight < 0 {
+		return errors.New("contraflow cannot be negative")
+	}
+
+	return nil
+}
+
+// rule is how a profile rides one way in one direction. speed is 0 where it
+// may not; maxBlock, when set, only allows blocks no longer than it.
+type rule struct {
+	speed    float64
+	weight   float64
+	maxBlock float64
+}
+
+// rules is how p rides w along its nodes and against them.
+func (p Profile) rules(w way) (along, against rule) {
+	speed, ok := p.Speeds[w.tags["highway"]]
+	if !ok || !p.allowed(w) {
+		return rule{}, rule{}
+	}
+
+	if p.MaxSpeed {
+		if limit, ok := parseMaxSpeed(w.tags["maxspeed"]); ok {
+			speed = min(speed, limit)
+		}
+	}
+
+	open := rule{speed: speed, weight: p.weight(w)}
+
+	wrongWay := rule{}
+	if p.ContraflowMeters > 0 {
+		wrongWay = open
+		wrongWay.weight *= max(1, p.ContraflowWeight)
+		wrongWay.maxBlock = p.ContraflowMeters
+	}
+
+	switch p.oneway(w) {
+	case 1:
+		return open, wrongWay
+	case -1:
+		return wrongWay, open
+	default:
+		return open, open
+	}
+}
+
+func (p Profile) weight(w way) float64 {
+	weight := weightOr1(p.HighwayWeights, w.tags["highway"]) * weightOr1(p.SurfaceWeights, w.tags["surface"])
+
+	lane := 1.0
+	for _, tag := range cyclewayTags {
+		if v, ok := w.tags[tag]; ok {
+			lane = min(lane, weightOr1(p.CyclewayWeights, v))
+		}
+	}
+
+	return weight * lane
+}
+
+func weightOr1(weights map[string]float64, key string) float64 {
+	if w, ok := weights[key]; ok {
+		return w
+	}
+
+	return 1
+}
+
+func (p Profile) allowed(w way) bool {
+	allowed := !slices.Contains(p.Restricted, w.tags["highway"])
+
+	for _, tag := range p.AccessTags {
+		switch w.tags[tag] {
+		case "":
+		case "no", "private", "agricultural", "forestry", "delivery", "dismount":
+			allowed = false
+		default:
+			allowed = true
+		}
+	}
+
+	return allowed
+}
+
+// oneway is 1 when w may only be ridden along its nodes, -1 when only
+// against them, and 0 when both ways.
+func (p Profile) oneway(w way) int {
+	dir := 0
+
+	// Motorways and roundabouts are one-way without saying so.
+	if h := w.tags["highway"]; h == "motorway" || h == "motorway_link" || w.tags["junction"] == "roundabout" {
+		dir = 1
+	}
+
+	for _, tag := range p.OnewayTags {
+		switch w.tags[tag] {
+		case "":
+		case "yes", "true", "1":
+			dir = 1
+		case "-1", "reverse":
+			dir = -1
+		default:
+			dir = 0
+		}
+	}
+
+	if p.OppositeLanes {
+		for _, tag := range cyclewayTags {
+			if strings.HasPrefix(w.tags[tag], "opposite") {
+				dir = 0
+			}
+		}
+	}
+
+	return dir
+}
+
+// parseMaxSpeed reads a maxspeed tag into metres per second. Bare numbers
+// are km/h; "mph" says otherwise. Anything else, like "signals", is not a
+// number it can use.
+func parseMaxSpeed(tag string) (float64, bool) {
+	tag = strings.TrimSpace(tag)
+
+	perHour := 1000.0
+	if n, ok := strings.CutSuffix(tag, "mph"); ok {
+		tag, perHour = strings.TrimSpace(n), 1609.344
+	}
+
+	v, err := strconv.ParseFloat(tag, 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+
+	return v * perHour / 3600, true
+}
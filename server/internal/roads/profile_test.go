@@ -0,0 +1,185 @@
+package roads
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tagged(kv ...string) way {
+	w := way{tags: make(map[string]string)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		w.tags[kv[i]] = kv[i+1]
+	}
+
+	return w
+}
+
+func TestProfileSpeeds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		profile        Profile
+		way            way
+		along, against float64
+	}{
+		{"two-way street", Bike, tagged("highway", "residential"), 5, 5},
+		{"one-way street", Car, tagged("highway", "residential", "oneway", "yes"), 8, 0},
+		{"one-way against its nodes", Car, tagged("highway", "residential", "oneway", "-1"), 0, 8},
+		{"contraflow for bikes", Bike, tagged("highway", "residential", "oneway", "yes", "oneway:bicycle", "no"), 5, 5},
+		{"contraflow is not for cars", Car, tagged("highway", "residential", "oneway", "yes", "oneway:bicycle", "no"), 8, 0},
+		{"roundabout", Car, tagged("highway", "tertiary", "junction", "roundabout"), 11, 0},
+		{"motorway by car", Car, tagged("highway", "motorway"), 27, 0},
+		{"motorway by bike", Bike, tagged("highway", "motorway"), 0, 0},
+		{"cycleway by car", Car, tagged("highway", "cycleway"), 0, 0},
+		{"footway by bike", Bike, tagged("highway", "footway"), 0, 0},
+		{"footway signed for bikes", Bike, tagged("highway", "footway", "bicycle", "designated"), 3, 3},
+		{"private road", Car, tagged("highway", "service", "access", "private"), 0, 0},
+		{"closed except to bikes", Bike, tagged("highway", "residential", "access", "no", "bicycle", "yes"), 5, 5},
+		{"closed to bikes", Bike, tagged("highway", "residential", "bicycle", "no"), 0, 0},
+		{"speed limit", Car, tagged("highway", "primary", "maxspeed", "25 mph"), 11.176, 11.176},
+		{"speed limit above the pace", Car, tagged("highway", "residential", "maxspeed", "100"), 8, 8},
+		{"speed limits do not slow bikes", Bike, tagged("highway", "residential", "maxspeed", "10"), 5, 5},
+		{"not a road", Car, tagged("highway", "proposed"), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			along, against := tt.profile.rules(tt.way)
+
+			assert.InDelta(t, tt.along, along.speed, 1e-9)
+			assert.InDelta(t, tt.against, against.speed, 1e-9)
+		})
+	}
+}
+
+func TestProfileWeights(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		profile Profile
+		way     way
+		want    float64
+	}{
+		{"quickest by default", Bike, tagged("highway", "primary", "surface", "sett"), 1},
+		{"arterial", Calm, tagged("highway", "primary"), 2.5},
+		{"painted lane", Calm, tagged("highway", "primary", "cycleway:right", "lane"), 2.5 * 0.7},
+		{"best lane counts", Calm, tagged("highway", "secondary", "cycleway:left", "lane", "cycleway:right", "track"), 1.8 * 0.5},
+		{"cobbles", Calm, tagged("highway", "residential", "surface", "cobblestone"), 2},
+		{"untagged", Calm, tagged("highway", "residential"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			along, against := tt.profile.rules(tt.way)
+
+			assert.InDelta(t, tt.want, along.weight, 1e-9)
+			assert.InDelta(t, tt.want, against.weight, 1e-9)
+		})
+	}
+}
+
+func TestProfileContraflow(t *testing.T) {
+	t.Parallel()
+
+	oneway := tagged("highway", "residential", "oneway", "yes")
+
+	along, against := Alleycat.rules(oneway)
+	assert.Equal(t, rule{speed: 5, weight: 1}, along)
+	assert.Equal(t, rule{speed: 5, weight: 1.5, maxBlock: 150}, against)
+
+	_, against = Bike.rules(oneway)
+	assert.Zero(t, against.speed, "only alleycats ride the wrong way")
+
+	_, against = Bike.rules(tagged("highway", "residential", "oneway", "yes", "cycleway", "opposite_lane"))
+	assert.Equal(t, rule{speed: 5, weight: 1}, against, "a contraflow lane makes it legal")
+
+	_, against = Alleycat.rules(tagged("highway", "residential", "oneway", "yes", "bicycle", "no"))
+	assert.Zero(t, against.speed, "not where bikes are banned outright")
+
+	short, long := edge{meters: 100, block: 100}, edge{meters: 100, block: 200}
+	rules := []rule{along, {speed: 5, weight: 1.5, maxBlock: 150}}
+
+	assert.NotNil(t, ruleFor(rules, short))
+	assert.Nil(t, ruleFor(rules, long), "too long a block to chance it")
+}
+
+func TestReadProfiles(t *testing.T) {
+	t.Parallel()
+
+	got, err := ReadProfiles(strings.NewReader(`[{
+		"name": "cargo",
+		"speeds": {"residential": 4, "cycleway": 4.5},
+		"accessTags": ["access", "bicycle"],
+		"onewayTags": ["oneway"],
+		"highwayWeights": {"cycleway": 0.5}
+	}]`))
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, "cargo", got[0].Name)
+	assert.Equal(t, 4.0, got[0].Speeds["residential"])
+	assert.Equal(t, 0.5, got[0].HighwayWeights["cycleway"])
+
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"not JSON", `{`, "reading profiles"},
+		{"no name", `[{"speeds": {"residential": 4}}]`, "'name' is required"},
+		{"no speeds", `[{"name": "x"}]`, "'speeds' is required"},
+		{"stopped", `[{"name": "x", "speeds": {"residential": 0}}]`, "must be positive"},
+		{"free", `[{"name": "x", "speeds": {"residential": 4}, "surfaceWeights": {"asphalt": 0}}]`, "must be positive"},
+		{"negative contraflow", `[{"name": "x", "speeds": {"residential": 4}, "contraflowMeters": -1}]`, "contraflow"},
+		{"index", `[{"name": "x", "speeds": {"residential": 4}}, {}]`, "profile at index 1"},
+	}
+
+	for _, tt := range tests {
+		_, err := ReadProfiles(strings.NewReader(tt.json))
+		assert.ErrorContains(t, err, tt.want, tt.name)
+	}
+}
+
+func TestParseMaxSpeed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		tag  string
+		want float64
+		ok   bool
+	}{
+		{"36", 10, true},
+		{"25 mph", 11.176, true},
+		{"25mph", 11.176, true},
+		{"signals", 0, false},
+		{"", 0, false},
+		{"0", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseMaxSpeed(tt.tag)
+
+		assert.Equal(t, tt.ok, ok, tt.tag)
+		assert.InDelta(t, tt.want, got, 1e-9, tt.tag)
+	}
+}
+
+func TestProfileByName(t *testing.T) {
+	t.Parallel()
+
+	p, ok := ProfileByName("car")
+	assert.True(t, ok)
+	assert.Equal(t, "car", p.Name)
+
+	_, ok = ProfileByName("tandem")
+	assert.False(t, ok)
+}
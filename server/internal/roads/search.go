@@ -0,0 +1,365 @@
+package roads
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// A city graph answers a single route with A* in milliseconds, so there is
+// no preprocessing to keep in step with the profiles: a profile is applied
+// to the ways afresh at every query. A matrix is one Dijkstra per origin,
+// which settles every destination in the one search. Both minimise the
+// profile's cost, and keep the time and distance alongside.
+
+// searchCheckEvery is how many nodes a search settles between looks at its
+// context.
+const searchCheckEvery = 1 << 10
+
+// Path is a route between two points: how long it is, how long it takes at
+// the profile's pace, the road nodes ridden and the cues to ride them by.
+// It starts and ends at the nodes nearest the points asked about. Profile
+// names the profile it was found under.
+type Path struct {
+	Profile string
+	Meters  float64
+	Seconds float64
+	Points  []Point
+	Cues    []Cue
+}
+
+// Matrix holds the route between every ordered pair of points, indexed the
+// way the points were given. Pairs with no route are +Inf.
+type Matrix struct {
+	Meters  [][]float64
+	Seconds [][]float64
+}
+
+// Route finds the quickest route from one point to another under p.
+func (g *Graph) Route(ctx context.Context, p Profile, from, to Point) (Path, error) {
+	rules := g.rules(p)
+
+	a, err := g.snap(rules, from)
+	if err != nil {
+		return Path{}, err
+	}
+
+	b, err := g.snap(rules, to)
+	if err != nil {
+		return Path{}, err
+	}
+
+	perMeter := cheapestPerMeter(rules)
+	target := g.points[b]
+
+	s := g.newSearch(rules)
+	err = s.run(ctx, a, func(n int32) bool { return n == b }, func(n int32) float64 {
+		return haversineMeters(g.points[n], target) * perMeter
+	})
+
+	if err != nil {
+		return Path{}, err
+	}
+
+	if math.IsInf(s.cost[b], 1) {
+		return Path{}, ErrNoRoute
+	}
+
+	return Path{
+		Profile: p.Name,
+		Meters:  s.meters[b],
+		Seconds: s.seconds[b],
+		Points:  s.pointsTo(b),
+		Cues:    s.cuesTo(b),
+	}, nil
+}
+
+// Matrix finds the quickest route between every ordered pair of points
+// under p, searching from each origin in parallel.
+func (g *Graph) Matrix(ctx context.Context, p Profile, points []Point) (Matrix, error) {
+	rules := g.rules(p)
+
+	nodes := make([]int32, len(points))
+
+	for i, pt := range points {
+		n, err := g.snap(rules, pt)
+		if err != nil {
+			return Matrix{}, fmt.Errorf("point at index %d: %w", i, err)
+		}
+
+		nodes[i] = n
+	}
+
+	out := Matrix{
+		Meters:  make([][]float64, len(points)),
+		Seconds: make([][]float64, len(points)),
+	}
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(runtime.NumCPU())
+
+	for i := range points {
+		eg.Go(func() error {
+			left := make(map[int32]bool, len(nodes))
+			for _, n := range nodes {
+				left[n] = true
+			}
+
+			s := g.newSearch(rules)
+			err := s.run(egCtx, nodes[i], func(n int32) bool {
+				delete(left, n)
+				return len(left) == 0
+			}, nil)
+
+			if err != nil {
+				return err
+			}
+
+			out.Meters[i] = make([]float64, len(points))
+			out.Seconds[i] = make([]float64, len(points))
+
+			for j, n := range nodes {
+				out.Meters[i][j] = s.meters[n]
+				out.Seconds[i][j] = s.seconds[n]
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return Matrix{}, err
+	}
+
+	return out, nil
+}
+
+// rules is how p rides each way: rules[2*w] along way w's nodes and
+// rules[2*w+1] against them.
+func (g *Graph) rules(p Profile) []rule {
+	out := make([]rule, 2*len(g.ways))
+
+	for i, w := range g.ways {
+		out[2*i], out[2*i+1] = p.rules(w)
+	}
+
+	return out
+}
+
+// ruleFor is how an edge is ridden, or nil when it may not be.
+func ruleFor(rules []rule, e edge) *rule {
+	r := &rules[2*e.way+1]
+	if e.along {
+		r = &rules[2*e.way]
+	}
+
+	if r.speed <= 0 || (r.maxBlock > 0 && float64(e.block) > r.maxBlock) {
+		return nil
+	}
+
+	return r
+}
+
+// cheapestPerMeter is the least any metre can cost under rules, which keeps
+// the A* estimate from ever overshooting.
+func cheapestPerMeter(rules []rule) float64 {
+	out := math.Inf(1)
+
+	for _, r := range rules {
+		if r.speed > 0 {
+			out = min(out, r.weight/r.speed)
+		}
+	}
+
+	if math.IsInf(out, 1) {
+		return 0
+	}
+
+	return out
+}
+
+// snap finds the node nearest pt that the profile can ride to or from.
+func (g *Graph) snap(rules []rule, pt Point) (int32, error) {
+	c := cellOf(pt)
+
+	best, bestMeters := int32(-1), math.Inf(1)
+
+	for dLat := int32(-1); dLat <= 1; dLat++ {
+		for dLong := int32(-1); dLong <= 1; dLong++ {
+			for _, n := range g.grid[cell{c.lat + dLat, c.long + dLong}] {
+				if d := haversineMeters(pt, g.points[n]); d < bestMeters && g.rideable(rules, n) {
+					best, bestMeters = n, d
+				}
+			}
+		}
+	}
+
+	if best < 0 || bestMeters > maxSnapMeters {
+		return 0, ErrOffNetwork
+	}
+
+	return best, nil
+}
+
+// rideable is whether any road the profile uses meets at n. Every segment
+// is stored from both of its nodes, so n's own edges show both the ways in
+// and the ways out.
+func (g *Graph) rideable(rules []rule, n int32) bool {
+	for _, e := range g.edges[g.first[n]:g.first[n+1]] {
+		back := e
+		back.along = !e.along
+
+		if ruleFor(rules, e) != nil || ruleFor(rules, back) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// search is one run of Dijkstra, or A* when given an estimate, over the
+// graph. cost is the cheapest way to each node found so far, seconds and
+// meters how long that way takes and how far it is, from the node before
+// it on that way, or -1, and via the edge from there.
+type search struct {
+	g       *Graph
+	rules   []rule
+	cost    []float64
+	seconds []float64
+	meters  []float64
+	from    []int32
+	via     []int32
+}
+
+func (g *Graph) newSearch(rules []rule) *search {
+	n := len(g.points)
+
+	s := &search{
+		g:       g,
+		rules:   rules,
+		cost:    make([]float64, n),
+		seconds: make([]float64, n),
+		meters:  make([]float64, n),
+		from:    make([]int32, n),
+		via:     make([]int32, n),
+	}
+
+	for i := range s.cost {
+		s.cost[i] = math.Inf(1)
+		s.seconds[i] = math.Inf(1)
+		s.meters[i] = math.Inf(1)
+		s.from[i] = -1
+		s.via[i] = -1
+	}
+
+	return s
+}
+
+// run searches outward from start until done says to stop or nothing is
+// left to reach. done is told about each node as it is settled. estimate,
+// if given, must never be more than the true cost left.
+func (s *search) run(ctx context.Context, start int32, done func(int32) bool, estimate func(int32) float64) error {
+	g := s.g
+	settled := make([]bool, len(g.points))
+
+	s.cost[start] = 0
+	s.seconds[start] = 0
+	s.meters[start] = 0
+
+	q := &queue{{node: start}}
+
+	for pops := 0; q.Len() > 0; pops++ {
+		if pops%searchCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		n := heap.Pop(q).(queued).node
+		if settled[n] {
+			continue
+		}
+
+		settled[n] = true
+
+		if done(n) {
+			return nil
+		}
+
+		for i := g.first[n]; i < g.first[n+1]; i++ {
+			e := g.edges[i]
+
+			r := ruleFor(s.rules, e)
+			if r == nil || settled[e.to] {
+				continue
+			}
+
+			t := float64(e.meters) / r.speed
+
+			c := s.cost[n] + t*r.weight
+			if c >= s.cost[e.to] {
+				continue
+			}
+
+			s.cost[e.to] = c
+			s.seconds[e.to] = s.seconds[n] + t
+			s.meters[e.to] = s.meters[n] + float64(e.meters)
+			s.from[e.to] = n
+			s.via[e.to] = i
+
+			priority := c
+			if estimate != nil {
+				priority += estimate(e.to)
+			}
+
+			heap.Push(q, queued{node: e.to, priority: priority})
+		}
+	}
+
+	return nil
+}
+
+// pointsTo walks back from n to where the search began.
+func (s *search) pointsTo(n int32) []Point {
+	var out []Point
+
+	for {
+		out = append(out, s.g.points[n])
+
+		if s.from[n] < 0 {
+			break
+		}
+
+		n = s.from[n]
+	}
+
+	slices.Reverse(out)
+
+	return out
+}
+
+type queued struct {
+	node     int32
+	priority float64
+}
+
+// queue is a min-heap of nodes by priority. A node is pushed again each time
+// a quicker way to it is found, and the stale entries skipped once settled.
+type queue []queued
+
+func (q queue) Len() int           { return len(q) }
+func (q queue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+func (q queue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x any)        { *q = append(*q, x.(queued)) }
+
+func (q *queue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,194 @@
+package roads
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// block is the distance between neighbouring junctions of the fixture.
+const block = 111.0
+
+var inf = math.Inf(1)
+
+func TestRouteTakesTheCycleway(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	// Green Street only runs west, so heading east along it means going
+	// round: bikes by the Mount Vernon cycleway, cars by Spring Garden.
+	bike, err := g.Route(t.Context(), Bike, junction(1, 0), junction(1, 3))
+	require.NoError(t, err)
+
+	assert.InDelta(t, 5*block, bike.Meters, 5)
+	assert.Contains(t, bike.Points, junction(2, 1))
+	assert.Equal(t, junction(1, 0), bike.Points[0])
+	assert.Equal(t, junction(1, 3), bike.Points[len(bike.Points)-1])
+
+	car, err := g.Route(t.Context(), Car, junction(1, 0), junction(1, 3))
+	require.NoError(t, err)
+
+	assert.InDelta(t, 5*block, car.Meters, 5)
+	assert.Contains(t, car.Points, junction(0, 1))
+	assert.NotContains(t, car.Points, junction(2, 1))
+}
+
+func TestRouteRidesContraflow(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	// 21st Street runs north, except for bikes.
+	bike, err := g.Route(t.Context(), Bike, junction(3, 1), junction(0, 1))
+	require.NoError(t, err)
+	assert.InDelta(t, 3*block, bike.Meters, 5)
+
+	car, err := g.Route(t.Context(), Car, junction(3, 1), junction(0, 1))
+	require.NoError(t, err)
+	assert.InDelta(t, 5*block, car.Meters, 5)
+}
+
+func TestRouteKeepsOffPrivateRoads(t *testing.T) {
+	t.Parallel()
+
+	got, err := loadFixture(t).Route(t.Context(), Car, junction(0, 2), junction(3, 2))
+	require.NoError(t, err)
+
+	assert.InDelta(t, 5*block, got.Meters, 5, "20th Street is private, so round by 21st")
+}
+
+func TestRouteSnapsToTheNearestRoad(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	// Twenty metres or so off the corner of 22nd and Green.
+	near := Point{Lat: 39.9612, Long: -75.1702}
+
+	got, err := g.Route(t.Context(), Bike, near, junction(0, 0))
+	require.NoError(t, err)
+
+	assert.Equal(t, junction(1, 0), got.Points[0])
+	assert.InDelta(t, block, got.Meters, 5)
+	assert.InDelta(t, block/5, got.Seconds, 1)
+}
+
+func TestRouteOnlyUsesRoadsTheProfileCan(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+	end := Point{Lat: 39.96, Long: -75.15}
+
+	car, err := g.Route(t.Context(), Car, junction(0, 0), end)
+	require.NoError(t, err)
+	assert.InDelta(t, 3*block+1371, car.Meters, 10, "along Spring Garden and onto the expressway")
+
+	_, err = g.Route(t.Context(), Car, end, junction(0, 0))
+	assert.ErrorIs(t, err, ErrNoRoute, "the expressway only runs east")
+
+	_, err = g.Route(t.Context(), Bike, junction(0, 0), end)
+	assert.ErrorIs(t, err, ErrOffNetwork, "no bike goes on the expressway")
+}
+
+func TestRouteStopsWithTheContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, err := loadFixture(t).Route(ctx, Bike, junction(0, 0), junction(3, 3))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMatrixAgreesWithRoute(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	points := []Point{junction(1, 0), junction(1, 3), junction(3, 1), junction(0, 1), junction(2, 2)}
+
+	for _, p := range []Profile{Bike, Car} {
+		m, err := g.Matrix(t.Context(), p, points)
+		require.NoError(t, err)
+
+		for i := range points {
+			for j := range points {
+				want, err := g.Route(t.Context(), p, points[i], points[j])
+				require.NoError(t, err)
+
+				assert.InDelta(t, want.Meters, m.Meters[i][j], 1e-6, "%s %d→%d", p.Name, i, j)
+				assert.InDelta(t, want.Seconds, m.Seconds[i][j], 1e-6, "%s %d→%d", p.Name, i, j)
+			}
+		}
+	}
+}
+
+func TestMatrixIsNotSymmetric(t *testing.T) {
+	t.Parallel()
+
+	m, err := loadFixture(t).Matrix(t.Context(), Car, []Point{junction(0, 1), junction(3, 1)})
+	require.NoError(t, err)
+
+	assert.Zero(t, m.Meters[0][0])
+	assert.InDelta(t, 3*block, m.Meters[0][1], 5, "north up 21st")
+	assert.InDelta(t, 5*block, m.Meters[1][0], 5, "round by 22nd")
+}
+
+func TestMatrixMarksUnreachablePairs(t *testing.T) {
+	t.Parallel()
+
+	m, err := loadFixture(t).Matrix(t.Context(), Car, []Point{junction(0, 0), {Lat: 39.96, Long: -75.15}})
+	require.NoError(t, err)
+
+	assert.Positive(t, m.Meters[0][1])
+	assert.Equal(t, inf, m.Meters[1][0])
+	assert.Equal(t, inf, m.Seconds[1][0])
+}
+
+func TestMatrixRejectsPointsOffTheNetwork(t *testing.T) {
+	t.Parallel()
+
+	_, err := loadFixture(t).Matrix(t.Context(), Bike, []Point{junction(0, 0), {Lat: 40.5, Long: -75.17}})
+
+	assert.ErrorIs(t, err, ErrOffNetwork)
+	assert.ErrorContains(t, err, "point at index 1")
+}
+
+func TestRouteWeighsBusyRoads(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	bike, err := g.Route(t.Context(), Bike, junction(3, 0), junction(3, 3))
+	require.NoError(t, err)
+	assert.InDelta(t, 3*block, bike.Meters, 5, "straight along Fairmount")
+	assert.Equal(t, "bike", bike.Profile)
+
+	calm, err := g.Route(t.Context(), Calm, junction(3, 0), junction(3, 3))
+	require.NoError(t, err)
+	assert.Equal(t, "calm", calm.Profile)
+	assert.InDelta(t, 5*block, calm.Meters, 5, "down to the Mount Vernon cycleway")
+	assert.Contains(t, calm.Points, junction(2, 1))
+
+	// The detour costs less, but takes longer, and that is what is reported.
+	assert.Greater(t, calm.Seconds, bike.Seconds)
+	assert.InDelta(t, block/5+3*block/5.5+block/4.5, calm.Seconds, 1)
+}
+
+func TestRouteRidesShortBlocksTheWrongWay(t *testing.T) {
+	t.Parallel()
+
+	g := loadFixture(t)
+
+	bike, err := g.Route(t.Context(), Bike, junction(1, 0), junction(1, 3))
+	require.NoError(t, err)
+	assert.InDelta(t, 5*block, bike.Meters, 5)
+
+	alleycat, err := g.Route(t.Context(), Alleycat, junction(1, 0), junction(1, 3))
+	require.NoError(t, err)
+	assert.InDelta(t, 3*block, alleycat.Meters, 5, "east along Green Street")
+}
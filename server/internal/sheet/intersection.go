@@ -0,0 +1,61 @@
+package sheet
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Intersection is a checkpoint at a corner, like "Broad & Spring Garden".
+type Intersection struct {
+	Streets [2]string `json:"streets"`
+}
+
+// String writes the corner the way sheets usually do.
+func (x Intersection) String() string {
+	return x.Streets[0] + " & " + x.Streets[1]
+}
+
+var (
+	// cornerOf is wording in front of a corner that names no street.
+	cornerOf = regexp.MustCompile(`(?i)^(?:the\s+)?(?:(?:ne|nw|se|sw|north|south|east|west)\w*\s+)?corner\s+of\s+`)
+
+	// crossing is what sheets write between the two streets of a corner.
+	// "at" is left out: "The Porch at 30th St" is a place, not a corner.
+	crossing = regexp.MustCompile(`(?i)\s*(?:&|\+|/|@|\band\b|\bx\b)\s*`)
+
+	// placeWords mark a name as a place rather than a street, so "Barnes &
+	// Noble", "Bob and Barbara's Lounge" and "Studio X Philadelphia" are not
+	// corners.
+	placeWords = regexp.MustCompile(`(?i)(?:\b(?:noble|club|rink|park|bar|lounge|cafe|café|shop|store|museum|library|hall|church|school|station|pizza|deli|bakery|brewery|tavern|pub|banks?|plaza|center|centre|stadium|pier|studio|gallery|theat(?:er|re)|gym|hotel|home)\b|'s\b|’s\b)`)
+
+	// streetNumber is a house number, which puts a place at an address on
+	// one street rather than on a corner.
+	streetNumber = regexp.MustCompile(`^\d+\s+\S`)
+)
+
+// ParseIntersection reads s as a corner of two streets, if it is one. It
+// takes "&", "+", "/", "@", "and" and "x" between the streets, and a
+// leading "corner of". Each side must be short, free of house numbers, and
+// not sound like a business or landmark.
+func ParseIntersection(s string) (Intersection, bool) {
+	s = cornerOf.ReplaceAllString(strings.TrimSpace(s), "")
+
+	parts := crossing.Split(s, -1)
+	if len(parts) != 2 {
+		return Intersection{}, false
+	}
+
+	var x Intersection
+
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+
+		if p == "" || len(strings.Fields(p)) > 4 || streetNumber.MatchString(p) || placeWords.MatchString(p) {
+			return Intersection{}, false
+		}
+
+		x.Streets[i] = p
+	}
+
+	return x, true
+}
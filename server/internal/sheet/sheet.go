@@ -0,0 +1,341 @@
+// Package sheet reads race manifests, the free text an alleycat hands out at
+// registration, into the stops a route is planned over.
+//
+// Sheets come typed, pasted or transcribed from a photo, so no one format
+// is assumed. A line is a start, a finish or a checkpoint when it says so:
+// "START:", "FINISH -", "CP5:", "Checkpoint 5 -", "#5", "5.", "5)". Whatever
+// follows a spaced dash, or sits in trailing brackets, is a note: the task
+// to do there. Lines that name no stop are notes on the stop before them,
+// unless no line on the sheet is numbered, in which case each is a
+// checkpoint in its own right.
+package sheet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNoStops means nothing on the sheet read as a stop.
+var ErrNoStops = errors.New("no stops found on the sheet")
+
+// Kind is what a stop is to the race.
+type Kind string
+
+const (
+	Start      Kind = "start"
+	Checkpoint Kind = "checkpoint"
+	Finish     Kind = "finish"
+)
+
+// Stop is one place on the sheet.
+type Stop struct {
+	// Id names the stop for the route: "start", "finish", or "cp" and its
+	// place among the checkpoints, from 1, whatever it is numbered.
+	Id   string `json:"id"`
+	Kind Kind   `json:"kind"`
+
+	// Number is the checkpoint's number as written, or 0 when it has none.
+	Number int `json:"number,omitempty"`
+
+	// Location is where the stop is, as written, and Intersection the two
+	// streets when that is a corner.
+	Location     string        `json:"location"`
+	Intersection *Intersection `json:"intersection,omitempty"`
+
+	Notes []string `json:"notes,omitempty"`
+
+	// Line is where on the sheet the stop is, from 1.
+	Line int `json:"line"`
+}
+
+// Query is what to search for to find the stop.
+func (s Stop) Query() string {
+	if s.Intersection != nil {
+		return s.Intersection.String()
+	}
+
+	return s.Location
+}
+
+// Sheet is a parsed manifest. Start and Finish are nil when the sheet does
+// not say; they are the same place on a sheet that starts and finishes
+// together.
+type Sheet struct {
+	Start       *Stop  `json:"start,omitempty"`
+	Checkpoints []Stop `json:"checkpoints"`
+	Finish      *Stop  `json:"finish,omitempty"`
+
+	// Ignored are the lines, from 1, that read as neither a stop nor a
+	// note: titles, rules and the like before the first stop.
+	Ignored []int `json:"ignored,omitempty"`
+}
+
+// Stops is every stop in the order a route takes them: the start, the
+// checkpoints, then the finish.
+func (s Sheet) Stops() []Stop {
+	out := make([]Stop, 0, len(s.Checkpoints)+2)
+
+	if s.Start != nil {
+		out = append(out, *s.Start)
+	}
+
+	out = append(out, s.Checkpoints...)
+
+	if s.Finish != nil {
+		out = append(out, *s.Finish)
+	}
+
+	return out
+}
+
+// Queries is what to search for to find every stop, in the order of Stops,
+// ready for a batch resolve.
+func (s Sheet) Queries() []string {
+	stops := s.Stops()
+	out := make([]string, 0, len(stops))
+
+	for _, st := range stops {
+		out = append(out, st.Query())
+	}
+
+	return out
+}
+
+var (
+	// bullet is list punctuation in front of a line.
+	bullet = regexp.MustCompile(`^[-*•·>]+\s*`)
+
+	// startLine and finishLine are a line naming the start or finish, and
+	// startFinishLine one naming both. The keyword needs punctuation or
+	// "at" after it, or the line to itself, so "End of the pier" is still
+	// a checkpoint.
+	startFinishLine = regexp.MustCompile(`(?i)^(?:start\s*(?:/|&|and)\s*finish|start\s*\+\s*finish)\b\s*(?:[:\-–—]+|\s(?:at|@)\s|@|$)\s*(.*)$`)
+	startLine       = regexp.MustCompile(`(?i)^(?:start(?:ing)?(?:\s+point)?|registration|reg)\b\s*(?:[:\-–—]+|\s(?:at|@)\s|@|$)\s*(.*)$`)
+	finishLine      = regexp.MustCompile(`(?i)^(?:finish(?:\s+line)?|end)\b\s*(?:[:\-–—]+|\s(?:at|@)\s|@|$)\s*(.*)$`)
+
+	// numberedLine is a checkpoint with a number: "CP5:", "CP 5 -",
+	// "Checkpoint 5", "Stop #5", "#5", "5.", "5)" or "5:".
+	numberedLine = regexp.MustCompile(`(?i)^(?:(?:cp|check\s*-?\s*point|stop)\s*#?\s*(\d+)\b|#\s*(\d+)\b|(\d+)\s*[.):])\s*[:.)\-–—]*\s*(.*)$`)
+
+	// heading is a line that only titles the lines after it, like
+	// "Checkpoints:".
+	heading = regexp.MustCompile(`^[^:]{1,40}:$`)
+
+	// noteSplit is the spaced dash between a location and its note.
+	noteSplit = regexp.MustCompile(`\s+(?:--|[-–—])\s+`)
+
+	// trailingNote is a note in brackets at the end of a line.
+	trailingNote = regexp.MustCompile(`\s*[(\[]([^()\[\]]+)[)\]]\s*$`)
+)
+
+// Parse reads a sheet.
+func Parse(r io.Reader) (Sheet, error) {
+	var lines []string
+
+	sc := bufio.NewScanner(r)
+
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+
+	if err := sc.Err(); err != nil {
+		return Sheet{}, fmt.Errorf("reading sheet: %w", err)
+	}
+
+	return parseLines(lines)
+}
+
+// ParseString reads a sheet held in a string.
+func ParseString(s string) (Sheet, error) {
+	return Parse(strings.NewReader(s))
+}
+
+// line is one line of a sheet read for what it says, before it is known
+// whether unmarked lines are checkpoints or notes.
+type line struct {
+	n      int
+	kind   Kind
+	number int
+	text   string
+
+	// marked is whether the line said what it is, bulleted whether it was
+	// a list item, and heading whether it only titles what follows.
+	marked   bool
+	bulleted bool
+	heading  bool
+}
+
+func parseLines(raw []string) (Sheet, error) {
+	var read []line
+
+	// Unmarked lines are notes on a numbered sheet. On one that is not,
+	// they are checkpoints, unless some are bulleted and some not, when
+	// the bullets are notes on the lines above them.
+	numbered, bulleted, plain := false, false, false
+
+	for i, text := range raw {
+		l, ok := readLine(i+1, text)
+		if !ok {
+			continue
+		}
+
+		numbered = numbered || l.number > 0
+
+		if !l.marked && !l.heading {
+			bulleted = bulleted || l.bulleted
+			plain = plain || !l.bulleted
+		}
+
+		read = append(read, l)
+	}
+
+	bulletsAreNotes := bulleted && plain
+
+	var sheet Sheet
+
+	// last is the stop notes go on, once there is one. It is always the
+	// latest stop, so appending checkpoints never leaves it stale for long.
+	var last *Stop
+
+	// pending is a marker on a line of its own, like "START", whose
+	// location is on the next line.
+	var pending *line
+
+	for _, l := range read {
+		if l.heading {
+			sheet.Ignored = append(sheet.Ignored, l.n)
+			continue
+		}
+
+		if l.marked && l.text == "" {
+			pending = &l
+			continue
+		}
+
+		if pending != nil && !l.marked {
+			l.kind, l.number, l.marked = pending.kind, pending.number, true
+		}
+
+		pending = nil
+
+		if !l.marked && (numbered || (bulletsAreNotes && l.bulleted)) {
+			if last == nil {
+				sheet.Ignored = append(sheet.Ignored, l.n)
+				continue
+			}
+
+			last.Notes = append(last.Notes, strings.TrimSpace(l.text))
+
+			continue
+		}
+
+		stop := newStop(l)
+
+		switch l.kind {
+		case Start:
+			sheet.Start = &stop
+			last = sheet.Start
+		case Finish:
+			sheet.Finish = &stop
+			last = sheet.Finish
+		default:
+			stop.Id = "cp" + strconv.Itoa(len(sheet.Checkpoints)+1)
+			sheet.Checkpoints = append(sheet.Checkpoints, stop)
+			last = &sheet.Checkpoints[len(sheet.Checkpoints)-1]
+		}
+
+		// A sheet that starts and finishes in one place says so once.
+		if l.number < 0 {
+			finish := stop
+			finish.Id, finish.Kind = string(Finish), Finish
+			finish.Notes = nil
+			sheet.Finish = &finish
+		}
+	}
+
+	if sheet.Start == nil && sheet.Finish == nil && len(sheet.Checkpoints) == 0 {
+		return Sheet{}, ErrNoStops
+	}
+
+	if sheet.Checkpoints == nil {
+		sheet.Checkpoints = []Stop{}
+	}
+
+	return sheet, nil
+}
+
+// readLine says what one line of a sheet is, if anything. A start that is
+// also the finish has a number of -1.
+func readLine(n int, text string) (line, bool) {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\t", " "))
+	if text == "" {
+		return line{}, false
+	}
+
+	l := line{n: n, kind: Checkpoint, text: text}
+
+	if stripped := bullet.ReplaceAllString(text, ""); stripped != text {
+		l.bulleted = true
+		text = stripped
+	}
+
+	if text == "" {
+		return line{}, false
+	}
+
+	switch {
+	case startFinishLine.MatchString(text):
+		l.kind, l.number, l.marked = Start, -1, true
+		l.text = startFinishLine.FindStringSubmatch(text)[1]
+	case startLine.MatchString(text):
+		l.kind, l.marked = Start, true
+		l.text = startLine.FindStringSubmatch(text)[1]
+	case finishLine.MatchString(text):
+		l.kind, l.marked = Finish, true
+		l.text = finishLine.FindStringSubmatch(text)[1]
+	case numberedLine.MatchString(text):
+		m := numberedLine.FindStringSubmatch(text)
+		l.number, _ = strconv.Atoi(m[1] + m[2] + m[3])
+		l.marked = true
+		l.text = m[4]
+	default:
+		l.text = text
+		l.heading = heading.MatchString(text)
+	}
+
+	return l, true
+}
+
+// newStop splits a stop's line into where it is and what to do there.
+func newStop(l line) Stop {
+	s := Stop{Id: string(l.kind), Kind: l.kind, Line: l.n}
+
+	if l.number > 0 {
+		s.Number = l.number
+	}
+
+	text := l.text
+
+	if parts := noteSplit.Split(text, 2); len(parts) == 2 {
+		text = parts[0]
+		s.Notes = append(s.Notes, strings.TrimSpace(parts[1]))
+	}
+
+	if m := trailingNote.FindStringSubmatchIndex(text); m != nil {
+		s.Notes = append([]string{strings.TrimSpace(text[m[2]:m[3]])}, s.Notes...)
+		text = text[:m[0]]
+	}
+
+	s.Location = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(text), ",;:"))
+
+	if x, ok := ParseIntersection(s.Location); ok {
+		s.Intersection = &x
+	}
+
+	return s
+}
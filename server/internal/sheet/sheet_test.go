@@ -0,0 +1,135 @@
+package sheet
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseCorpus parses every sheet in testdata, each a format seen on a
+// real race, and compares it with the JSON next to it.
+func TestParseCorpus(t *testing.T) {
+	t.Parallel()
+
+	sheets, err := filepath.Glob("testdata/*.txt")
+	require.NoError(t, err)
+	require.NotEmpty(t, sheets)
+
+	for _, path := range sheets {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			defer f.Close()
+
+			got, err := Parse(f)
+			require.NoError(t, err)
+
+			want, err := os.ReadFile(strings.TrimSuffix(path, ".txt") + ".json")
+			require.NoError(t, err)
+
+			gotJSON, err := json.Marshal(got)
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(want), string(gotJSON))
+		})
+	}
+}
+
+func TestParseRejectsASheetWithNoStops(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseString("\n  \n- \n")
+	assert.ErrorIs(t, err, ErrNoStops)
+}
+
+func TestParseTakesAMarkersLocationFromTheNextLine(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseString("START\nLove Park\n1.\nMalta Boat Club\nFINISH:\nPenn Ice Rink")
+	require.NoError(t, err)
+
+	require.NotNil(t, got.Start)
+	assert.Equal(t, "Love Park", got.Start.Location)
+	assert.Equal(t, 2, got.Start.Line)
+
+	require.Len(t, got.Checkpoints, 1)
+	assert.Equal(t, 1, got.Checkpoints[0].Number)
+	assert.Equal(t, "Malta Boat Club", got.Checkpoints[0].Location)
+
+	require.NotNil(t, got.Finish)
+	assert.Equal(t, "Penn Ice Rink", got.Finish.Location)
+}
+
+func TestParseKeepsKeywordsThatAreNotMarkers(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseString("End of the pier\nStarbucks on Walnut\n1500 Broad St")
+	require.NoError(t, err)
+
+	assert.Nil(t, got.Start)
+	assert.Nil(t, got.Finish)
+	assert.Equal(t, []string{"End of the pier", "Starbucks on Walnut", "1500 Broad St"}, got.Queries())
+}
+
+func TestSheetStopsAndQueries(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseString("START: Clark Park\nCP1: Broad and Spring Garden - photo\nCP2: Malta Boat Club\nFINISH: Penn Ice Rink")
+	require.NoError(t, err)
+
+	ids := make([]string, 0, 4)
+	for _, s := range got.Stops() {
+		ids = append(ids, s.Id)
+	}
+
+	assert.Equal(t, []string{"start", "cp1", "cp2", "finish"}, ids)
+	assert.Equal(t, []string{"Clark Park", "Broad & Spring Garden", "Malta Boat Club", "Penn Ice Rink"}, got.Queries(),
+		"corners are searched for the same way whatever joins them")
+}
+
+func TestParseIntersection(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Broad & Spring Garden", []string{"Broad", "Spring Garden"}},
+		{"fletcher and 26th", []string{"fletcher", "26th"}},
+		{"South St / 4th St", []string{"South St", "4th St"}},
+		{"Frankford x Girard", []string{"Frankford", "Girard"}},
+		{"22nd@Market", []string{"22nd", "Market"}},
+		{"SW corner of Broad + Washington", []string{"Broad", "Washington"}},
+		{"Barnes & Noble", nil},
+		{"Bob and Barbara's", nil},
+		{"The Porch at 30th St", nil},
+		{"1500 Broad St & Race", nil},
+		{"Malta Boat Club", nil},
+		{"Studio X Philadelphia", nil},
+		{"Pizza @ Home", nil},
+		{"Broad & Spring Garden & 15th", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := ParseIntersection(tt.in)
+
+			if tt.want == nil {
+				assert.False(t, ok, "%q is not a corner", tt.in)
+				return
+			}
+
+			require.True(t, ok)
+			assert.Equal(t, [2]string{tt.want[0], tt.want[1]}, got.Streets)
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package tsp
+
+import (
+	"container/heap"
+	"context"
+	"math"
+)
+
+// Past the exact limit the heuristic's answer used to come with no guarantee
+// at all. A lower bound gives it one: no order is shorter than the bound, so
+// the heuristic's distance over the bound is the most it can be off by. The
+// bound is Held-Karp's: a minimum spanning tree over the places left, with a
+// penalty per place tuned by subgradient ascent until the tree looks as much
+// like a path as it can. With few enough stops, a best-first branch-and-bound
+// search then closes the gap from both sides, finding shorter orders above
+// and raising the bound below, until the two meet or the budget runs out.
+
+const (
+	// maxBranchStops is the most stops the search runs for. Past it, only the
+	// bound at the root is worked out.
+	maxBranchStops = 60
+
+	// branchBoundBudget caps how many subproblem bounds one search computes,
+	// which keeps its running time in the same range as Held-Karp's.
+	branchBoundBudget = 50_000
+
+	// subgradientRounds caps the ascent that tunes the root bound.
+	subgradientRounds = 200
+)
+
+// bnbResult is the best path found and a bound no path can beat.
+type bnbResult struct {
+	path       []int
+	cost       float64
+	lowerBound float64
+}
+
+// gap is how much longer than optimal the path can be, as a fraction of the
+// optimum.
+func (b bnbResult) gap() float64 {
+	if b.cost <= b.lowerBound+improvementEpsilon {
+		return 0
+	}
+
+	if b.lowerBound <= 0 {
+		return math.Inf(1)
+	}
+
+	return (b.cost - b.lowerBound) / b.lowerBound
+}
+
+// pathBounder works out lower bounds on Hamiltonian paths over dist that
+// finish at end, or anywhere with end < 0.
+type pathBounder struct {
+	// sym is the cheaper direction between each pair: every leg of a path
+	// costs at least that, whichever way it is ridden.
+	sym [][]float64
+	end int
+	pi  []float64
+
+	// Prim's scratch space, reused between bounds.
+	key    []float64
+	parent []int
+	in     []bool
+	deg    []int
+}
+
+func newPathBounder(dist [][]float64, end int) *pathBounder {
+	n := len(dist)
+
+	sym := make([][]float64, n)
+	for i := range sym {
+		sym[i] = make([]float64, n)
+		for j := range sym[i] {
+			sym[i][j] = math.Min(dist[i][j], dist[j][i])
+		}
+	}
+
+	return &pathBounder{
+		sym:    sym,
+		end:    end,
+		pi:     make([]float64, n),
+		key:    make([]float64, n),
+		parent: make([]int, n),
+		in:     make([]bool, n),
+		deg:    make([]int, n),
+	}
+}
+
+// bound is a lower bound on every path that leaves cur, visits all of rest
+// and then finishes. It also returns the nodes it spanned, cur first, and
+// the degree each has in the penalised spanning tree.
+//
+// A path is a spanning tree whose ends have degree one and whose middle has
+// degree two, so adding pi to each node's edges and taking off what that adds
+// to any path leaves a bound that holds for every choice of pi.
+func (b *pathBounder) bound(cur int, rest []int) (float64, []int, []int) {
+	nodes := make([]int, 0, len(rest)+2)
+	nodes = append(nodes, cur)
+	nodes = append(nodes, rest...)
+
+	if b.end >= 0 {
+		nodes = append(nodes, b.end)
+	}
+
+	k := len(nodes)
+
+	for i := range k {
+		b.key[i], b.parent[i], b.in[i], b.deg[i] = math.Inf(1), -1, false, 0
+	}
+
+	b.key[0] = 0
+	tree := 0.0
+
+	for range k {
+		u := -1
+		for i := range k {
+			if !b.in[i] && (u < 0 || b.key[i] < b.key[u]) {
+				u = i
+			}
+		}
+
+		b.in[u] = true
+		tree += b.key[u]
+
+		if b.parent[u] >= 0 {
+			b.deg[u]++
+			b.deg[b.parent[u]]++
+		}
+
+		for v := range k {
+			if b.in[v] {
+				continue
+			}
+
+			if w := b.sym[nodes[u]][nodes[v]] + b.pi[nodes[u]] + b.pi[nodes[v]]; w < b.key[v] {
+				b.key[v], b.parent[v] = w, u
+			}
+		}
+	}
+
+	// What the penalties add to any path: once for each end, twice for each
+	// place in the middle. Without a fixed end one place in rest is the
+	// finish, and the cheapest one to assume is the one with least penalty.
+	added := b.pi[cur]
+	for _, v := range rest {
+		added += 2 * b.pi[v]
+	}
+
+	if b.end >= 0 {
+		added += b.pi[b.end]
+	} else if len(rest) > 0 {
+		added -= b.pi[rest[b.leastPenalty(rest)]]
+	}
+
+	return tree - added, nodes, b.deg[:k]
+}
+
+func (b *pathBounder) leastPenalty(rest []int) int {
+	least := 0
+	for i, v := range rest {
+		if b.pi[v] < b.pi[rest[least]] {
+			least = i
+		}
+	}
+
+	return least
+}
+
+// ascend tunes pi by subgradient ascent for the paths from cur through rest,
+// nudging each place's penalty up when the tree gives it too many edges and
+// down when too few. It keeps the penalties that gave the best bound, and
+// returns that bound. upper is any known path cost, which sizes the steps.
+func (b *pathBounder) ascend(ctx context.Context, cur int, rest []int, upper float64) float64 {
+	best := math.Inf(-1)
+	bestPi := make([]float64, len(b.pi))
+	step := 2.0
+	stale := 0
+
+	for round := 0; round < subgradientRounds && step > 1e-4 && ctx.Err() == nil; round++ {
+		lb, nodes, deg := b.bound(cur, rest)
+
+		if lb > best+improvementEpsilon {
+			best = lb
+			copy(bestPi, b.pi)
+			stale = 0
+		} else if stale++; stale >= 10 {
+			step /= 2
+			stale = 0
+		}
+
+		// The subgradient is how far each degree is from what a path needs.
+		g := make([]float64, len(nodes))
+		for i := range nodes {
+			want := 2
+			if i == 0 || (b.end >= 0 && i == len(nodes)-1) {
+				want = 1
+			}
+
+			g[i] = float64(deg[i] - want)
+		}
+
+		if b.end < 0 && len(rest) > 0 {
+			g[1+b.leastPenalty(rest)]++
+		}
+
+		norm := 0.0
+		for _, x := range g {
+			norm += x * x
+		}
+
+		// Every degree is right, so the tree is itself a path and nothing
+		// can beat it.
+		if norm == 0 {
+			break
+		}
+
+		t := step * math.Max(upper-lb, improvementEpsilon) / norm
+		for i, v := range nodes {
+			b.pi[v] += t * g[i]
+		}
+	}
+
+	copy(b.pi, bestPi)
+
+	return best
+}
+
+// bnbNode is a partial path in the search: start, then the chain of cities
+// back through parent.
+type bnbNode struct {
+	parent *bnbNode
+	city   int
+	depth  int
+	cost   float64
+	bound  float64
+}
+
+// bnbQueue puts the lowest bound first, and the deepest path among equal
+// bounds, which finds complete paths to prune with sooner.
+type bnbQueue []*bnbNode
+
+func (q bnbQueue) Len() int { return len(q) }
+
+func (q bnbQueue) Less(i, j int) bool {
+	if q[i].bound != q[j].bound {
+		return q[i].bound < q[j].bound
+	}
+
+	return q[i].depth > q[j].depth
+}
+
+func (q bnbQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *bnbQueue) Push(x any) { *q = append(*q, x.(*bnbNode)) }
+
+func (q *bnbQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return n
+}
+
+// solveBranchAndBound improves on seed, a path from start over dist that
+// keeps to p and finishes at end (anywhere with end < 0), and bounds how far
+// from optimal the result is. report, when set, hears about every shorter
+// path and every rise in the bound as they happen. Once ctx is done it stops
+// and returns what it has.
+func solveBranchAndBound(ctx context.Context, dist [][]float64, start, end int, p precedence, seed []int, report func(bnbResult)) bnbResult {
+	n := len(dist)
+
+	var rest []int
+	for i := range n {
+		if i != start && i != end {
+			rest = append(rest, i)
+		}
+	}
+
+	best := bnbResult{path: seed, cost: pathDistance(dist, seed)}
+
+	b := newPathBounder(dist, end)
+	root := b.ascend(ctx, start, rest, best.cost)
+	best.lowerBound = math.Min(root, best.cost)
+
+	if report != nil {
+		report(best)
+	}
+
+	if len(rest) > maxBranchStops || best.gap() == 0 {
+		return best
+	}
+
+	placed := make([]bool, n)
+	q := &bnbQueue{{city: start, bound: root}}
+	budget := branchBoundBudget
+
+	for q.Len() > 0 && budget > 0 && ctx.Err() == nil {
+		node := heap.Pop(q).(*bnbNode)
+
+		if node.bound >= best.cost-improvementEpsilon {
+			// Everything left is at least this long, so nothing left can
+			// beat what is already in hand.
+			q = &bnbQueue{}
+			break
+		}
+
+		// Nodes come off in bound order, so this one's bound holds for
+		// everything not yet searched.
+		if node.bound > best.lowerBound+improvementEpsilon {
+			best.lowerBound = node.bound
+
+			if report != nil {
+				report(best)
+			}
+		}
+
+		for i := range placed {
+			placed[i] = false
+		}
+
+		for at := node; at != nil; at = at.parent {
+			placed[at.city] = true
+		}
+
+		left := make([]int, 0, len(rest)-node.depth)
+		for _, v := range rest {
+			if !placed[v] {
+				left = append(left, v)
+			}
+		}
+
+		for i, next := range left {
+			if !p.ready(placed, next) {
+				continue
+			}
+
+			child := &bnbNode{parent: node, city: next, depth: node.depth + 1, cost: node.cost + dist[node.city][next]}
+
+			if len(left) == 1 {
+				total := child.cost
+				if end >= 0 {
+					total += dist[next][end]
+				}
+
+				if total < best.cost-improvementEpsilon {
+					best.path, best.cost = child.path(end), total
+
+					if report != nil {
+						report(best)
+					}
+				}
+
+				continue
+			}
+
+			others := append(append(make([]int, 0, len(left)-1), left[:i]...), left[i+1:]...)
+
+			lb, _, _ := b.bound(next, others)
+			budget--
+
+			// The child's paths are some of its parent's, so the parent's
+			// bound holds for them too.
+			child.bound = math.Max(child.cost+lb, node.bound)
+
+			if child.bound < best.cost-improvementEpsilon {
+				heap.Push(q, child)
+			}
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+	case q.Len() == 0:
+		// Searched to the end: nothing is shorter than the best path.
+		best.lowerBound = best.cost
+	default:
+		best.lowerBound = math.Max(best.lowerBound, math.Min((*q)[0].bound, best.cost))
+	}
+
+	if report != nil {
+		report(best)
+	}
+
+	return best
+}
+
+// path lists the cities from the start to this node, then end if there is
+// one.
+func (n *bnbNode) path(end int) []int {
+	out := make([]int, 0, n.depth+2)
+	if end >= 0 {
+		out = append(out, end)
+	}
+
+	for at := n; at != nil; at = at.parent {
+		out = append(out, at.city)
+	}
+
+	reverse(out)
+
+	return out
+}
+
+// pathDistance sums the legs of an ordered index path.
+func pathDistance(dist [][]float64, path []int) float64 {
+	var total float64
+	for i := 0; i < len(path)-1; i++ {
+		total += dist[path[i]][path[i+1]]
+	}
+
+	return total
+}
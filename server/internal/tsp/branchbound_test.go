@@ -0,0 +1,190 @@
+package tsp
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// skewed makes dist asymmetric, as road distances are: one way round each
+// pair costs up to half as much again as the other.
+func skewed(seed int64, dist [][]float64) [][]float64 {
+	rng := rand.New(rand.NewSource(seed))
+
+	out := make([][]float64, len(dist))
+	for i := range dist {
+		out[i] = append([]float64(nil), dist[i]...)
+		for j := range out[i] {
+			if i < j {
+				out[i][j] *= 1 + rng.Float64()/2
+			}
+		}
+	}
+
+	return out
+}
+
+func TestSolveBranchAndBoundProvesTheOptimum(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		for _, end := range []int{11, -1} {
+			dist := distanceMatrix(scatter(seed, 12))
+			if seed%2 == 1 {
+				dist = skewed(seed, dist)
+			}
+
+			seed := solveHeuristicFrom(t.Context(), dist, 0, end >= 0, nil)
+			got := solveBranchAndBound(t.Context(), dist, 0, end, nil, seed, nil)
+
+			assertVisitsEachOnce(t, got.path, len(dist))
+			assert.InDelta(t, pathDistance(dist, solveTSPExact(t.Context(), dist, 0, end, nil)), got.cost, 1e-3, "end %d", end)
+			assert.Zero(t, got.gap(), "end %d: the search should finish well inside its budget", end)
+		}
+	}
+}
+
+func TestSolveBranchAndBoundKeepsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		points := scatter(seed, 10)
+		dist := distanceMatrix(points)
+		end := len(points) - 1
+		p := randomPrecedence(seed, len(points), 4)
+
+		got := solveBranchAndBound(t.Context(), dist, 0, end, p, solveTSPHeuristic(t.Context(), dist, 0, end, p), nil)
+
+		assert.True(t, p.allows(got.path), "seed %d: %v", seed, got.path)
+		assert.InDelta(t, bruteForceAllowed(dist, 0, end, p), got.cost, 1e-6, "seed %d", seed)
+	}
+}
+
+func TestRootBoundNeverExceedsTheOptimum(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 8; seed++ {
+		for _, end := range []int{9, -1} {
+			dist := skewed(seed, distanceMatrix(scatter(seed, 10)))
+			optimum := pathDistance(dist, solveTSPExact(t.Context(), dist, 0, end, nil))
+
+			var rest []int
+			for i := 1; i < len(dist); i++ {
+				if i != end {
+					rest = append(rest, i)
+				}
+			}
+
+			lb := newPathBounder(dist, end).ascend(t.Context(), 0, rest, optimum*1.1)
+
+			assert.LessOrEqual(t, lb, optimum+1e-6, "seed %d end %d", seed, end)
+			assert.Greater(t, lb, optimum*0.7, "seed %d end %d: the bound should be worth having", seed, end)
+		}
+	}
+}
+
+func TestSolveBranchAndBoundReportsAsItGoes(t *testing.T) {
+	t.Parallel()
+
+	// A deliberately poor seed leaves the search something to improve.
+	dist := distanceMatrix(scatter(4, 14))
+	seed := make([]int, len(dist))
+	for i := range seed {
+		seed[i] = i
+	}
+
+	var reports []bnbResult
+	got := solveBranchAndBound(t.Context(), dist, 0, len(dist)-1, nil, seed, func(b bnbResult) {
+		reports = append(reports, b)
+	})
+
+	require.Greater(t, len(reports), 2)
+	assert.Equal(t, got, reports[len(reports)-1])
+
+	for i := 1; i < len(reports); i++ {
+		assert.LessOrEqual(t, reports[i].cost, reports[i-1].cost, "the best path only gets shorter")
+		assert.GreaterOrEqual(t, reports[i].lowerBound, reports[i-1].lowerBound, "the bound only rises")
+		assert.LessOrEqual(t, reports[i].lowerBound, reports[i].cost+1e-9)
+	}
+}
+
+func TestSolveBranchAndBoundBoundsLargeRoutes(t *testing.T) {
+	t.Parallel()
+
+	// Too many stops to search, but the root bound alone still says how
+	// good the heuristic is.
+	dist := distanceMatrix(scatter(5, maxBranchStops+20))
+	end := len(dist) - 1
+
+	began := time.Now()
+	got := solveBranchAndBound(t.Context(), dist, 0, end, nil, solveTSPHeuristic(t.Context(), dist, 0, end, nil), nil)
+
+	assert.Positive(t, got.gap())
+	assert.Less(t, got.gap(), 0.2)
+	assert.Less(t, time.Since(began), 2*time.Second)
+}
+
+func TestSolveBranchAndBoundStopsWhenTheContextIsDone(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	dist := distanceMatrix(scatter(6, 20))
+	seed := solveTSPHeuristic(t.Context(), dist, 0, 19, nil)
+
+	got := solveBranchAndBound(ctx, dist, 0, 19, nil, seed, nil)
+
+	assert.Equal(t, seed, got.path, "the seed is all there was time for")
+}
+
+func TestSolveBoundsTheHeuristic(t *testing.T) {
+	t.Parallel()
+
+	points := scatter(7, 14)
+	r := tspRoute{start: points[0], stops: points[1:], exactStopLimit: 3}
+
+	var reports []optimalRoute
+	got, err := SolveAnytime(t.Context(), r, func(or optimalRoute) {
+		reports = append(reports, or)
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, AlgorithmHeuristic, got.Algorithm)
+	assert.True(t, got.Bounded)
+	assert.Zero(t, got.OptimalityGap, "thirteen stops is well inside the search's budget")
+	assert.InDelta(t, tspRoute{start: points[0], stops: points[1:]}.mustSolve(t).Meters, got.Meters, 1e-3)
+
+	require.NotEmpty(t, reports)
+	assert.Equal(t, got, reports[len(reports)-1])
+
+	for i := 1; i < len(reports); i++ {
+		assert.LessOrEqual(t, reports[i].Meters, reports[i-1].Meters+1e-6)
+	}
+}
+
+func TestSolveExactIsOptimal(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	got, err := SolveAnytime(t.Context(), lineRoute().Build(), func(optimalRoute) { calls++ })
+	require.NoError(t, err)
+
+	assert.Equal(t, AlgorithmExact, got.Algorithm)
+	assert.True(t, got.Bounded)
+	assert.Zero(t, got.OptimalityGap)
+	assert.Equal(t, 1, calls, "there is nothing to report until it is done")
+}
+
+func TestSolveLeavesTimedHeuristicsUnbounded(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().WithTimeWindow("a", raceStart, time.Time{}).WithExactStopLimit(1).Build().mustSolve(t)
+
+	assert.Equal(t, AlgorithmHeuristic, got.Algorithm)
+	assert.False(t, got.Bounded)
+}
@@ -0,0 +1,285 @@
+package tsp
+
+import (
+	"context"
+	"math"
+	"math/bits"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Held-Karp's table has 2^m * m states for m stops in between. Kept as rows
+// of float64 costs and int parents, that is 16 bytes a state plus a heap
+// allocation per row, filled on one core. Flat float32 costs and one-byte
+// parents bring a state down to 5 bytes. Filling the table one popcount
+// layer at a time lets every core share the work, because a state only ever
+// reads states with one stop fewer: no worker writes where another reads.
+// Each layer enumerates only its own masks, so the whole fill is one pass
+// over the table rather than one per layer.
+
+// heldKarpStateBytes is what one state of the table takes: a float32 cost
+// and a one-byte parent.
+const heldKarpStateBytes = 5
+
+// maxExactTableBytes is how much memory the exact solvers' tables may take
+// between them, whatever the number of requests solving at once. It holds
+// two of the largest tables Held-Karp is allowed.
+const maxExactTableBytes = 256 << 20
+
+// exactTables is what is left of maxExactTableBytes. A solve reserves its
+// table from it before allocating and hands it back once done, so a burst
+// of large solves takes turns, or runs out of time, rather than taking the
+// server's memory between them.
+var exactTables = semaphore.NewWeighted(maxExactTableBytes)
+
+// reserveTable waits until an exact table over n nodes, at stateBytes a
+// state, fits inside maxExactTableBytes, or until ctx is done. end is the
+// fixed end, or < 0 for none. The caller calls release once it has
+// finished with the table.
+func reserveTable(ctx context.Context, n, end int, stateBytes int64) (release func(), err error) {
+	// Every node but the start, and the end if there is one, is in the
+	// table's masks.
+	m := n - 1
+	if end >= 0 {
+		m--
+	}
+
+	if m <= 0 {
+		return func() {}, nil
+	}
+
+	size := min(int64(1)<<m*int64(m)*stateBytes, maxExactTableBytes)
+
+	if err := exactTables.Acquire(ctx, size); err != nil {
+		return nil, err
+	}
+
+	return func() { exactTables.Release(size) }, nil
+}
+
+// heldKarpTie is how far apart, relative to their size, two of the table's
+// costs can be and still be the same length: a few float32 roundings.
+const heldKarpTie = 1e-6
+
+// noParent marks a state reached straight from the start.
+const noParent = math.MaxUint8
+
+// heldKarpChunk is how many consecutive masks a worker takes at a time, and
+// how often it looks at the context. Tables smaller than one chunk are
+// filled on the calling goroutine.
+const heldKarpChunk = 1 << 10
+
+// heldKarpTable is the Held-Karp DP over every subset of the nodes between
+// start and end, not just the full one, which is what lets prize mode ask
+// about subsets without solving again.
+type heldKarpTable struct {
+	intermediate []int
+	m            int
+
+	// cost[mask*m+last] is the shortest path from start through exactly the
+	// intermediate nodes in mask, finishing at intermediate[last]; +Inf if
+	// there is none. parent at the same index is the position of the node
+	// before last, or noParent.
+	cost   []float32
+	parent []uint8
+}
+
+// at is the cost of the state (mask, last).
+func (t heldKarpTable) at(mask, last int) float64 {
+	return float64(t.cost[mask*t.m+last])
+}
+
+// fillHeldKarp builds the table for paths from start over dist, leaving out
+// end, or nothing when end < 0. It stops once ctx is done, leaving the rest
+// of the table unfilled; callers check ctx before trusting it.
+func fillHeldKarp(ctx context.Context, dist [][]float64, start, end int, p precedence) heldKarpTable {
+	return fillHeldKarpOn(ctx, runtime.NumCPU(), dist, start, end, p)
+}
+
+// fillHeldKarpOn is fillHeldKarp split across the given number of workers.
+func fillHeldKarpOn(ctx context.Context, workers int, dist [][]float64, start, end int, p precedence) heldKarpTable {
+	n := len(dist)
+
+	intermediate := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if i != start && i != end {
+			intermediate = append(intermediate, i)
+		}
+	}
+
+	m := len(intermediate)
+	size := 1 << m
+
+	t := heldKarpTable{
+		intermediate: intermediate,
+		m:            m,
+		cost:         make([]float32, size*m),
+		parent:       make([]uint8, size*m),
+	}
+
+	// The legs the fill needs, flattened and narrowed once up front.
+	// into[last*m+q] is the leg from intermediate[q] to intermediate[last],
+	// so the legs into one node sit together.
+	first := make([]float32, m)
+	into := make([]float32, m*m)
+
+	for i, a := range intermediate {
+		first[i] = float32(dist[start][a])
+
+		for j, b := range intermediate {
+			into[j*m+i] = float32(dist[a][b])
+		}
+	}
+
+	// must[i] is the set of intermediate nodes that have to be in the mask
+	// before node i may join it.
+	must := p.masks(intermediate, start)
+
+	inf := float32(math.Inf(1))
+
+	fill := func(mask int) {
+		row := t.cost[mask*m : mask*m+m]
+		parents := t.parent[mask*m : mask*m+m]
+
+		for last := range row {
+			row[last], parents[last] = inf, noParent
+		}
+
+		// Only the nodes in mask can be last, and only the ones in prev
+		// before it, so both loops walk set bits rather than every node.
+		for rest := mask; rest != 0; rest &= rest - 1 {
+			last := bits.TrailingZeros(uint(rest))
+
+			if mask&must[last] != must[last] {
+				continue
+			}
+
+			prev := mask ^ 1<<last
+			if prev == 0 {
+				row[last] = first[last]
+				continue
+			}
+
+			from := t.cost[prev*m : prev*m+m]
+			legs := into[last*m : last*m+m]
+
+			// Ties go to the lowest position, so the result does not depend
+			// on how the work was split.
+			for q := prev; q != 0; q &= q - 1 {
+				j := bits.TrailingZeros(uint(q))
+
+				if c := from[j] + legs[j]; c < row[last] {
+					row[last], parents[last] = c, uint8(j)
+				}
+			}
+		}
+	}
+
+	if size <= heldKarpChunk {
+		workers = 1
+	}
+
+	// One layer's masks at a time, so no layer rescans the whole table for
+	// the few masks of its size.
+	masks := make([]int, 0, widestLayer(m))
+
+	for layer := 0; layer <= m; layer++ {
+		masks = appendLayer(masks[:0], layer, size)
+		fillLayer(ctx, masks, workers, fill)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return t
+}
+
+// appendLayer appends every mask below size with exactly layer bits set, in
+// increasing order. Gosper's hack steps from one to the next.
+func appendLayer(masks []int, layer, size int) []int {
+	if layer == 0 {
+		return append(masks, 0)
+	}
+
+	for mask := 1<<layer - 1; mask < size; {
+		masks = append(masks, mask)
+
+		low := mask & -mask
+		ripple := mask + low
+		mask = ((ripple^mask)>>2)/low | ripple
+	}
+
+	return masks
+}
+
+// widestLayer is the number of masks in the biggest popcount layer over m
+// bits, m choose m/2.
+func widestLayer(m int) int {
+	n := 1
+	for i := 1; i <= m/2; i++ {
+		n = n * (m - m/2 + i) / i
+	}
+
+	return n
+}
+
+// fillLayer calls fill for every mask, handing out chunks to workers in turn
+// so each gets a similar share of the layer.
+func fillLayer(ctx context.Context, masks []int, workers int, fill func(mask int)) {
+	run := func(w int) {
+		for chunk := w * heldKarpChunk; chunk < len(masks); chunk += workers * heldKarpChunk {
+			if ctx.Err() != nil {
+				return
+			}
+
+			for _, mask := range masks[chunk:min(chunk+heldKarpChunk, len(masks))] {
+				fill(mask)
+			}
+		}
+	}
+
+	if workers == 1 {
+		run(0)
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for w := range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			run(w)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// path walks the parents back from (mask, last) and returns the node path
+// from start, without an end.
+func (t heldKarpTable) path(start, mask, last int) []int {
+	reversed := make([]int, 0, t.m+1)
+
+	for curr := last; ; {
+		reversed = append(reversed, t.intermediate[curr])
+
+		prev := t.parent[mask*t.m+curr]
+		mask ^= 1 << curr
+
+		if prev == noParent {
+			break
+		}
+
+		curr = int(prev)
+	}
+
+	reversed = append(reversed, start)
+	reverse(reversed)
+
+	return reversed
+}
@@ -0,0 +1,235 @@
+package tsp
+
+import "context"
+
+// Held-Karp needs a 2^n * n table, which stops being practical in the low
+// twenties. Past that, a nearest-neighbour path polished with 2-opt and
+// Or-opt gets within a few percent of optimal on city layouts in well under a
+// second — but it is a local optimum, not a proof.
+
+const (
+	// defaultExactStopLimit is the largest stop count handed to Held-Karp
+	// unless the route says otherwise. At 18 the table is about 24MB and
+	// fills in well under a second; each stop past it doubles both.
+	defaultExactStopLimit = 18
+
+	// MaxExactStopLimit is the most stops a route may allow Held-Karp, at
+	// which the table alone is about 100MB.
+	MaxExactStopLimit = 20
+
+	// maxOrOptSegment is the longest run of consecutive stops Or-opt will
+	// try to relocate in one move.
+	maxOrOptSegment = 3
+
+	// improvementEpsilon keeps float noise from being mistaken for progress,
+	// which would otherwise let two moves undo each other forever.
+	improvementEpsilon = 1e-9
+)
+
+const (
+	AlgorithmExact     = "exact"
+	AlgorithmHeuristic = "heuristic"
+)
+
+// solveTSPHeuristic returns a short path from start to end through every
+// other node that keeps to p. dist may be asymmetric. Once ctx is done it
+// stops polishing and returns the path as it stands.
+func solveTSPHeuristic(ctx context.Context, dist [][]float64, start, end int, p precedence) []int {
+	path := nearestNeighbourPath(dist, start, end, p)
+
+	for ctx.Err() == nil {
+		improved := twoOpt(dist, path, p)
+
+		if orOpt(dist, path, p) {
+			improved = true
+		}
+
+		if !improved {
+			break
+		}
+	}
+
+	return path
+}
+
+// nearestNeighbourPath greedily walks to the closest unvisited node whose
+// predecessors are all behind it, leaving end for last.
+func nearestNeighbourPath(dist [][]float64, start, end int, p precedence) []int {
+	n := len(dist)
+
+	// end is visited up front only to keep it out of the walk; placed is
+	// what precedence cares about.
+	visited := make([]bool, n)
+	visited[start] = true
+	visited[end] = true
+
+	placed := make([]bool, n)
+	placed[start] = true
+
+	path := make([]int, 0, n)
+	path = append(path, start)
+
+	curr := start
+
+	for len(path) < n-1 {
+		next := -1
+
+		for i := 0; i < n; i++ {
+			if visited[i] || !p.ready(placed, i) {
+				continue
+			}
+
+			if next == -1 || dist[curr][i] < dist[curr][next] {
+				next = i
+			}
+		}
+
+		visited[next] = true
+		placed[next] = true
+		path = append(path, next)
+		curr = next
+	}
+
+	if end != start {
+		path = append(path, end)
+	}
+
+	return path
+}
+
+// twoOpt reverses path[i..j] wherever doing so shortens the path without
+// breaking p, keeping both endpoints fixed. Reports whether anything changed.
+func twoOpt(dist [][]float64, path []int, p precedence) bool {
+	improved := false
+	last := len(path) - 1
+
+	for i := 1; i < last-1; i++ {
+		// Costs of path[i..j] walked forwards and backwards, grown one node
+		// at a time so asymmetric matrices are measured correctly.
+		var forward, backward float64
+
+		for j := i + 1; j < last; j++ {
+			forward += dist[path[j-1]][path[j]]
+			backward += dist[path[j]][path[j-1]]
+
+			before := dist[path[i-1]][path[i]] + forward + dist[path[j]][path[j+1]]
+			after := dist[path[i-1]][path[j]] + backward + dist[path[i]][path[j+1]]
+
+			if after < before-improvementEpsilon {
+				reverse(path[i : j+1])
+
+				if !p.allows(path) {
+					reverse(path[i : j+1])
+					continue
+				}
+
+				improved = true
+
+				// The segment just flipped, so the running sums describe
+				// it the wrong way round.
+				forward, backward = backward, forward
+			}
+		}
+	}
+
+	return improved
+}
+
+// orOpt moves runs of up to maxOrOptSegment consecutive nodes to wherever
+// they are cheapest to visit without breaking p, keeping both endpoints
+// fixed. Reports whether anything changed.
+func orOpt(dist [][]float64, path []int, p precedence) bool {
+	improved := false
+
+	// allowed tries a move on a scratch copy. Only moves that already beat
+	// the best so far get this far, so the check stays off the hot path.
+	scratch := make([]int, len(path))
+	allowed := func(i, size, at int, reversed bool) bool {
+		if len(p) == 0 {
+			return true
+		}
+
+		copy(scratch, path)
+		if reversed {
+			reverse(scratch[i : i+size])
+		}
+		moveSegment(scratch, i, size, at)
+
+		return p.allows(scratch)
+	}
+
+	for size := 1; size <= maxOrOptSegment; size++ {
+		for i := 1; i+size < len(path); i++ {
+			first, last := path[i], path[i+size-1]
+			prev, next := path[i-1], path[i+size]
+
+			removed := dist[prev][first] + dist[last][next] - dist[prev][next]
+
+			// The segment may also be flipped on the way, which changes its
+			// own cost when dist is asymmetric.
+			inner, innerReversed := 0.0, 0.0
+			for k := i; k < i+size-1; k++ {
+				inner += dist[path[k]][path[k+1]]
+				innerReversed += dist[path[k+1]][path[k]]
+			}
+
+			bestGain := improvementEpsilon
+			bestAt := -1
+			bestReversed := false
+
+			// Try inserting between path[k] and path[k+1], skipping the
+			// edges that touch the segment itself.
+			for k := 0; k < len(path)-1; k++ {
+				if k >= i-1 && k < i+size {
+					continue
+				}
+
+				a, b := path[k], path[k+1]
+
+				added := dist[a][first] + dist[last][b] - dist[a][b]
+				if gain := removed - added; gain > bestGain && allowed(i, size, k, false) {
+					bestGain, bestAt, bestReversed = gain, k, false
+				}
+
+				added = dist[a][last] + innerReversed - inner + dist[first][b] - dist[a][b]
+				if gain := removed - added; gain > bestGain && allowed(i, size, k, true) {
+					bestGain, bestAt, bestReversed = gain, k, true
+				}
+			}
+
+			if bestAt == -1 {
+				continue
+			}
+
+			if bestReversed {
+				reverse(path[i : i+size])
+			}
+
+			moveSegment(path, i, size, bestAt)
+			improved = true
+		}
+	}
+
+	return improved
+}
+
+// moveSegment relocates path[i:i+size] so it sits between what were
+// path[after] and path[after+1].
+func moveSegment(path []int, i, size, after int) {
+	segment := append([]int(nil), path[i:i+size]...)
+	rest := append(append([]int(nil), path[:i]...), path[i+size:]...)
+
+	if after >= i {
+		after -= size
+	}
+
+	copy(path, rest[:after+1])
+	copy(path[after+1:], segment)
+	copy(path[after+1+size:], rest[after+1:])
+}
+
+func reverse(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
@@ -0,0 +1,241 @@
+package tsp
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scatter returns n deterministic pseudo-random points in a 10km square.
+func scatter(seed int64, n int) []place {
+	rng := rand.New(rand.NewSource(seed))
+
+	points := make([]place, n)
+	for i := range points {
+		points[i] = place{
+			Id:   fmt.Sprintf("p%d", i),
+			long: rng.Float64() * 10000,
+			lat:  rng.Float64() * 10000,
+		}
+	}
+
+	return points
+}
+
+func assertVisitsEachOnce(t *testing.T, path []int, n int) {
+	t.Helper()
+
+	require.Len(t, path, n)
+
+	seen := make(map[int]bool, n)
+	for _, idx := range path {
+		assert.False(t, seen[idx], "index %d visited twice", idx)
+		seen[idx] = true
+	}
+}
+
+func TestSolveTSPHeuristicKeepsEndpointsAndVisitsEveryNode(t *testing.T) {
+	t.Parallel()
+
+	points := scatter(1, 30)
+	dist := distanceMatrix(points)
+
+	got := solveTSPHeuristic(t.Context(), dist, 0, len(points)-1, nil)
+
+	assertVisitsEachOnce(t, got, len(points))
+	assert.Equal(t, 0, got[0])
+	assert.Equal(t, len(points)-1, got[len(got)-1])
+}
+
+func TestSolveTSPHeuristicMatchesBruteForceOnSmallLayouts(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 10; seed++ {
+		points := scatter(seed, 7)
+		dist := distanceMatrix(points)
+		end := len(points) - 1
+
+		got := solveTSPHeuristic(t.Context(), dist, 0, end, nil)
+
+		// Not guaranteed in general, but at this size 2-opt and Or-opt
+		// reliably find the optimum; a miss here means a broken move.
+		assert.InDelta(t, bruteForceBest(dist, 0, end), pathDistance(dist, got), 1e-6, "seed %d", seed)
+	}
+}
+
+func TestSolveTSPHeuristicStaysCloseToExact(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		points := scatter(seed, 12)
+		dist := distanceMatrix(points)
+		end := len(points) - 1
+
+		exact := pathDistance(dist, solveTSPExact(t.Context(), dist, 0, end, nil))
+		heuristic := pathDistance(dist, solveTSPHeuristic(t.Context(), dist, 0, end, nil))
+
+		assert.GreaterOrEqual(t, heuristic, exact-1e-6, "the heuristic cannot beat the optimum")
+		assert.LessOrEqual(t, heuristic, exact*1.05, "seed %d: more than 5%% off optimal", seed)
+	}
+}
+
+func TestSolveTSPHeuristicImprovesOnNearestNeighbour(t *testing.T) {
+	t.Parallel()
+
+	points := scatter(7, 40)
+	dist := distanceMatrix(points)
+	end := len(points) - 1
+
+	greedy := pathDistance(dist, nearestNeighbourPath(dist, 0, end, nil))
+	polished := pathDistance(dist, solveTSPHeuristic(t.Context(), dist, 0, end, nil))
+
+	assert.Less(t, polished, greedy)
+}
+
+func TestSolveTSPHeuristicHandlesAsymmetricDistances(t *testing.T) {
+	t.Parallel()
+
+	// Going "east" (to a higher index) is cheap and coming back is dear, so
+	// the only good path runs in index order.
+	n := 8
+	dist := make([][]float64, n)
+	for i := range dist {
+		dist[i] = make([]float64, n)
+		for j := range dist[i] {
+			switch {
+			case j == i+1:
+				dist[i][j] = 1
+			case j > i:
+				dist[i][j] = float64(j - i)
+			default:
+				dist[i][j] = 100 * float64(i-j)
+			}
+		}
+	}
+
+	got := solveTSPHeuristic(t.Context(), dist, 0, n-1, nil)
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, got)
+}
+
+func TestTwoOptUntanglesACrossing(t *testing.T) {
+	t.Parallel()
+
+	// A square walked corner to corner crosses itself; 2-opt should swap
+	// the middle pair.
+	points := []place{
+		{long: 0, lat: 0}, {long: 10, lat: 10}, {long: 0, lat: 10}, {long: 10, lat: 0},
+	}
+	dist := distanceMatrix(points)
+
+	path := []int{0, 1, 2, 3}
+	before := pathDistance(dist, path)
+
+	assert.True(t, twoOpt(dist, path, nil))
+	assert.Less(t, pathDistance(dist, path), before)
+	assert.Equal(t, []int{0, 2, 1, 3}, path)
+}
+
+func TestMoveSegment(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		i, size, at int
+		want        []int
+	}{
+		{name: "forward", i: 1, size: 2, at: 4, want: []int{0, 3, 4, 1, 2, 5}},
+		{name: "backward", i: 3, size: 2, at: 0, want: []int{0, 3, 4, 1, 2, 5}},
+		{name: "single", i: 4, size: 1, at: 1, want: []int{0, 1, 4, 2, 3, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := []int{0, 1, 2, 3, 4, 5}
+			moveSegment(path, tt.i, tt.size, tt.at)
+
+			assert.Equal(t, tt.want, path)
+		})
+	}
+}
+
+// --- Solve switching ------------------------------------------------------
+
+func TestSolveReportsExactBelowLimit(t *testing.T) {
+	t.Parallel()
+
+	start := place{Id: "start", lat: 39.95, long: -75.18}
+	end := place{Id: "end", lat: 39.94, long: -75.17}
+
+	got := routeFrom(start, &end,
+		place{Id: "a", lat: 39.96, long: -75.19},
+		place{Id: "b", lat: 39.97, long: -75.20},
+	).convertDegreesToMeters().mustSolve(t)
+
+	assert.Equal(t, AlgorithmExact, got.Algorithm)
+}
+
+func TestSolveSwitchesToHeuristicAboveLimit(t *testing.T) {
+	t.Parallel()
+
+	points := scatter(3, 25)
+
+	r := tspRoute{start: points[0], end: &points[len(points)-1], stops: points[1 : len(points)-1]}
+
+	got := r.mustSolve(t)
+
+	assert.Equal(t, AlgorithmHeuristic, got.Algorithm)
+	assert.Equal(t, points[len(points)-1].Id, got.End.Id)
+	assert.Len(t, got.Stops, len(points)-2)
+	assert.Positive(t, got.Meters)
+}
+
+func TestSolveHeuristicWithoutEndChoosesAStopAsFinish(t *testing.T) {
+	t.Parallel()
+
+	points := scatter(4, 10)
+
+	r := tspRoute{start: points[0], stops: points[1:], exactStopLimit: 3}
+	got := r.mustSolve(t)
+
+	require.Equal(t, AlgorithmHeuristic, got.Algorithm)
+
+	ids := make([]string, 0, len(got.Stops)+1)
+	for _, s := range got.Stops {
+		ids = append(ids, s.Id)
+	}
+	ids = append(ids, got.End.Id)
+
+	want := make([]string, 0, len(points)-1)
+	for _, p := range points[1:] {
+		want = append(want, p.Id)
+	}
+
+	assert.ElementsMatch(t, want, ids, "every stop once, with the finish taken from among them")
+
+	exact := tspRoute{start: points[0], stops: points[1:]}.mustSolve(t)
+	assert.LessOrEqual(t, got.Meters, exact.Meters*1.05)
+}
+
+func TestBuilderWithExactStopLimit(t *testing.T) {
+	t.Parallel()
+
+	r := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.18).
+		AddStop("a", 39.96, -75.19).
+		AddStop("b", 39.97, -75.20).
+		AddStop("c", 39.98, -75.21).
+		WithExactStopLimit(2).
+		Build()
+
+	assert.Equal(t, 2, r.exactLimit())
+	assert.Equal(t, AlgorithmHeuristic, r.mustSolve(t).Algorithm)
+
+	assert.Equal(t, defaultExactStopLimit, tspRoute{}.exactLimit())
+	assert.Equal(t, MaxExactStopLimit, tspRoute{exactStopLimit: MaxExactStopLimit + 5}.exactLimit())
+}
@@ -1,7 +1,10 @@
 package tsp
 
 import (
+	"context"
 	"math"
+	"slices"
+	"time"
 )
 
 var (
@@ -52,89 +55,155 @@ type tspRoute struct {
 	start place
 	end   *place
 	stops []place
+
+	// loop makes the route a closed tour: Build sets end to a copy of start,
+	// so the ride home is one more leg rather than a special case.
+	loop bool
+
+	// metric measures distances between places; nil means Planar.
+	metric Metric
+
+	// matrix, when set, overrides metric with measured distances — real road
+	// distances, typically, which need not be symmetric.
+	matrix *measuredDistances
+
+	// center is the point coordinates were projected around, set once Build
+	// has flattened them to metres. It is what lets a projected place be read
+	// back as degrees.
+	center *place
+
+	// exactStopLimit is the most stops Held-Karp will be asked to solve
+	// before switching to the heuristic. Zero means defaultExactStopLimit.
+	exactStopLimit int
+
+	// startTime is when the race starts. Without one the route has no clock,
+	// so windows are ignored and no arrival times are given.
+	startTime time.Time
+
+	// speed is the rider's pace in metres per second; zero means the travel
+	// mode's.
+	speed float64
+
+	// windows holds opening and closing times by place id. Places without an
+	// entry are open for the whole race.
+	windows map[string]window
+
+	// orderings are the route's "visit this before that" rules, checked by
+	// ValidatePrecedence.
+	orderings []ordering
+
+	// points scores stops by id for PrizeRoutes. budgetMeters and
+	// budgetSeconds cap what it may ride; zero leaves either uncapped.
+	points        map[string]int
+	budgetMeters  float64
+	budgetSeconds float64
+
+	// riders splits the stops for TeamRoutes. riderStarts are the riders'
+	// own starts, in rider order; riders past the end of it leave from start.
+	riders      int
+	riderStarts []place
+
+	// mode picks the default pace. dwell is how long the rider spends at
+	// each place, by id; defaultDwell covers stops without an entry.
+	mode         TravelMode
+	dwell        map[string]time.Duration
+	defaultDwell time.Duration
+}
+
+// window is a checkpoint's opening hours as given. Either end may be zero to
+// leave it unbounded.
+type window struct {
+	opensAt  time.Time
+	closesAt time.Time
 }
 
 type optimalRoute struct {
 	Stops  []place
 	End    place
 	Meters float64
+
+	// Algorithm says how the order was found: AlgorithmExact is proven
+	// optimal, AlgorithmHeuristic is only likely to be close.
+	Algorithm string
+
+	// Bounded is set when OptimalityGap is known: the route is at most that
+	// fraction longer than the shortest one, as the solver measures it. 0 is
+	// proven optimal, which a heuristic order can be too.
+	Bounded       bool
+	OptimalityGap float64
+
+	// Arrivals has one entry per stop and then the end, in riding order. It
+	// is only filled in when the route has a start time.
+	Arrivals []Arrival
+
+	// Elapsed is how long after leaving the start the rider reaches each
+	// stop and then the end, and Duration how long until they are done at
+	// the end. Both count waits and dwell times as well as riding.
+	Elapsed  []time.Duration
+	Duration time.Duration
+
+	// Infeasible is set when no order found reaches every place inside its
+	// time window. The route is still the best attempt, and Arrivals say
+	// which places it gets to late.
+	Infeasible bool
+
+	// Points and Skipped are only set by PrizeRoutes: what the visited stops
+	// score, and the stops left out to stay within budget.
+	Points  int
+	Skipped []place
+
+	// Rank and Gap place a route among alternatives from RankedRoutes: 1 is
+	// the best, and Gap is how many metres longer than it this one is.
+	Rank int
+	Gap  float64
+
+	// Rider numbers the routes from TeamRoutes, from 1.
+	Rider int
 }
 
-// Exact TSP solver using dynamic programming (Held-Karp)
-func solveTSPExact(dist [][]float64, start, end int) []int {
+// Exact TSP solver using dynamic programming (Held-Karp). With end < 0 the
+// path may finish at whichever node makes it shortest. Orders that break a
+// precedence rule are never built; if that leaves no order at all, or ctx
+// is done before the table is, the result is nil.
+func solveTSPExact(ctx context.Context, dist [][]float64, start, end int, p precedence) []int {
 	n := len(dist)
 
-	// Special case: only start and end
-	if n == 2 {
-		panic("todo")
+	release, err := reserveTable(ctx, n, end, heldKarpStateBytes)
+	if err != nil {
+		return nil
 	}
+	defer release()
 
-	// Create list of intermediate nodes (excluding start and end)
-	intermediate := make([]int, 0, n-2)
-	for i := 0; i < n; i++ {
-		if i != start && i != end {
-			intermediate = append(intermediate, i)
-		}
-	}
+	t := fillHeldKarp(ctx, dist, start, end, p)
 
-	if len(intermediate) == 0 {
-		panic("todo")
+	if ctx.Err() != nil {
+		return nil
 	}
 
-	// DP state: dp[mask][last] = minimum cost to visit nodes in mask, ending at last
-	// mask represents which intermediate nodes have been visited
-	numIntermediate := len(intermediate)
-	dp := make([][]float64, 1<<numIntermediate)
-	parent := make([][]int, 1<<numIntermediate)
-
-	for i := range dp {
-		dp[i] = make([]float64, numIntermediate)
-		parent[i] = make([]int, numIntermediate)
-		for j := range dp[i] {
-			dp[i][j] = math.Inf(1)
-			parent[i][j] = -1
+	// Nothing in between: the only path is straight there.
+	if t.m == 0 {
+		if end < 0 {
+			return []int{start}
 		}
-	}
 
-	// Base case: start from 'start' node to each intermediate node
-	for i := 0; i < numIntermediate; i++ {
-		mask := 1 << i
-		node := intermediate[i]
-		dp[mask][i] = dist[start][node]
-	}
-
-	// Fill DP table
-	for mask := 0; mask < (1 << numIntermediate); mask++ {
-		for last := 0; last < numIntermediate; last++ {
-			if (mask&(1<<last)) == 0 || dp[mask][last] == math.Inf(1) {
-				continue
-			}
-
-			// Try extending to next unvisited node
-			for next := 0; next < numIntermediate; next++ {
-				if (mask & (1 << next)) != 0 {
-					continue // already visited
-				}
-
-				newMask := mask | (1 << next)
-				newCost := dp[mask][last] + dist[intermediate[last]][intermediate[next]]
-
-				if newCost < dp[newMask][next] {
-					dp[newMask][next] = newCost
-					parent[newMask][next] = last
-				}
-			}
-		}
+		return []int{start, end}
 	}
 
 	// Find best way to reach end from any intermediate node
-	allVisited := (1 << numIntermediate) - 1
+	allVisited := (1 << t.m) - 1
 	minCost := math.Inf(1)
 	bestLast := -1
 
-	for last := 0; last < numIntermediate; last++ {
-		cost := dp[allVisited][last] + dist[intermediate[last]][end]
-		if cost < minCost {
+	for last := range t.intermediate {
+		cost := t.at(allVisited, last)
+		if end >= 0 {
+			cost += dist[t.intermediate[last]][end]
+		}
+
+		// The table only holds float32 costs, so finishes within its
+		// rounding of each other are a tie, and go to the lowest position
+		// like the fill's own ties do.
+		if cost < minCost && (bestLast < 0 || minCost-cost > minCost*heldKarpTie) {
 			minCost = cost
 			bestLast = last
 		}
@@ -142,6 +211,10 @@ func solveTSPExact(dist [][]float64, start, end int) []int {
 
 	// Reconstruct path
 	if bestLast == -1 {
+		if len(p) > 0 {
+			return nil
+		}
+
 		// Fallback: direct path
 		result := make([]int, n)
 		for i := range result {
@@ -150,127 +223,316 @@ func solveTSPExact(dist [][]float64, start, end int) []int {
 		return result
 	}
 
-	// Backtrack to build path
-	path := []int{}
-	mask := allVisited
-	curr := bestLast
-
-	for mask != 0 {
-		path = append(path, intermediate[curr])
-		if parent[mask][curr] == -1 {
-			break
-		}
-		newCurr := parent[mask][curr]
-		mask ^= (1 << curr)
-		curr = newCurr
+	path := t.path(start, allVisited, bestLast)
+	if end >= 0 {
+		path = append(path, end)
 	}
 
-	// Reverse path and add start/end
-	result := []int{start}
-	for i := len(path) - 1; i >= 0; i-- {
-		result = append(result, path[i])
-	}
-	result = append(result, end)
+	return path
+}
 
-	return result
+// Solve finds the best order to ride the route in. A route that cannot be
+// solved as given comes back as one of the package's errors rather than a
+// panic. Once ctx is done Solve stops searching and returns the best route
+// it has, with its gap so far; only when it has none yet does it give up
+// with ctx's error.
+func Solve(ctx context.Context, r tspRoute) (optimalRoute, error) {
+	return SolveAnytime(ctx, r, nil)
 }
 
-// Main function to optimize route and return ordered stop indices
-func (r tspRoute) OptimalRoutes() optimalRoute {
-	// Solve TSP
-	or, err := func() (optimalRoute, error) {
-		// TSP solver for fixed start and end points
+// SolveAnytime is Solve that also tells report about each better route, and
+// each tighter bound on how far from optimal it is, while it works. Only
+// routes past the exact solver's limit and without time windows get more
+// than the final report, since those are the ones still improving.
+func SolveAnytime(ctx context.Context, r tspRoute, report func(optimalRoute)) (optimalRoute, error) {
+	if err := r.validate(); err != nil {
+		return optimalRoute{}, err
+	}
 
-		// Create list of all places: [start, stops..., end]
-		var extra int
+	allPlaces, dist := r.distances()
 
-		if r.end == nil {
-			extra = 1
-		} else {
-			extra = 2
+	var shortest []int
+	var algorithm string
+	infeasible := false
+
+	p := r.precedenceFor(allPlaces)
+
+	if r.hasWindows() {
+		shortest, algorithm, infeasible = r.timedOrder(ctx, allPlaces, dist, p)
+	} else {
+		shortest, algorithm = r.shortestOrder(ctx, dist, p)
+	}
+
+	// The heuristic always has a path to show, however little time it had
+	// to polish it; the exact solvers have nothing until they finish.
+	if shortest == nil {
+		if err := ctx.Err(); err != nil {
+			return optimalRoute{}, err
 		}
 
-		n := len(r.stops) + extra
+		return optimalRoute{}, ErrNoRoute
+	}
+
+	along := func(order []int) optimalRoute {
+		or := r.routeAlong(allPlaces, order)
+		or.Algorithm = algorithm
+		or = r.timed(or, allPlaces, dist, order)
+		or.Infeasible = infeasible
 
-		allPlaces := make([]place, 0)
-		allPlaces = append(allPlaces, r.start)
-		allPlaces = append(allPlaces, r.stops...)
+		return or
+	}
 
+	var or optimalRoute
+	searched := false
+
+	switch {
+	case algorithm == AlgorithmExact:
+		or = along(shortest)
+		or.Bounded = true
+	case !r.hasWindows():
+		// The bound knows nothing of opening hours, so only plain routes
+		// get one.
+		end := -1
 		if r.end != nil {
-			allPlaces = append(allPlaces, *r.end)
+			end = len(allPlaces) - 1
 		}
 
-		startIdx := 0
-		// endIdx := n - 1
-
-		// Build distance matrix
-		dist := make([][]float64, n)
-		for i := range dist {
-			dist[i] = make([]float64, n)
-			for j := range dist[i] {
-				dist[i][j] = allPlaces[i].euclideanDistanceTo(allPlaces[j])
+		bounded := func(b bnbResult) optimalRoute {
+			or := along(b.path)
+			if gap := b.gap(); !math.IsInf(gap, 1) {
+				or.Bounded, or.OptimalityGap = true, gap
 			}
+
+			return or
 		}
 
-		shortestPath := struct {
-			minOrder []int
-			distance float64
-		}{minOrder: nil, distance: math.MaxFloat64}
-
-		if r.end == nil {
-			for i := range len(r.stops) {
-				useAsEndIdx := i + 1
-				o := solveTSPExact(dist, startIdx, useAsEndIdx)
-
-				var d float64
-				for i := range n - 1 {
-					idxA := o[i]
-					idxB := o[i+1]
-
-					a := allPlaces[idxA]
-					b := allPlaces[idxB]
-					d += a.euclideanDistanceTo(b)
-				}
-
-				if d < shortestPath.distance {
-					shortestPath.distance = d
-					shortestPath.minOrder = o
-				}
-			}
+		var onBound func(bnbResult)
+		if report != nil {
+			onBound = func(b bnbResult) { report(bounded(b)) }
+		}
+
+		or = bounded(solveBranchAndBound(ctx, dist, 0, end, p, shortest, onBound))
+		searched = true
+	default:
+		or = along(shortest)
+	}
+
+	// The search has already reported where it finished.
+	if report != nil && !searched {
+		report(or)
+	}
+
+	return or, nil
+}
+
+// distances lists every place as [start, stops..., end] and measures the
+// distance between each pair, as the solver sees it.
+func (r tspRoute) distances() ([]place, [][]float64) {
+	// Create list of all places: [start, stops..., end]
+	allPlaces := make([]place, 0, len(r.stops)+2)
+	allPlaces = append(allPlaces, r.start)
+	allPlaces = append(allPlaces, r.stops...)
+
+	if r.end != nil {
+		allPlaces = append(allPlaces, *r.end)
+	}
+
+	// Build distance matrix
+	n := len(allPlaces)
+	dist := make([][]float64, n)
+	for i := range dist {
+		dist[i] = make([]float64, n)
+		for j := range dist[i] {
+			dist[i][j] = r.distance(allPlaces[i], allPlaces[j])
+		}
+	}
+
+	return allPlaces, dist
+}
+
+// routeAlong turns an index path into the places it visits and the distance
+// it covers.
+func (r tspRoute) routeAlong(allPlaces []place, order []int) optimalRoute {
+	end := allPlaces[order[len(order)-1]]
+
+	stops := make([]place, 0, len(order)-2)
+
+	for i := 1; i < len(order)-1; i++ {
+		s := allPlaces[order[i]]
+		stops = append(stops, s)
+	}
+
+	// The solver compares orders under whatever metric it was given, but
+	// the distance reported to the rider is always measured on the
+	// ellipsoid so it means the same thing whichever metric chose it.
+	// Measured road distances are already the real thing.
+	var meters float64
+	for i := 0; i < len(order)-1; i++ {
+		a, b := allPlaces[order[i]], allPlaces[order[i+1]]
+
+		if d, ok := r.matrix.between(a.Id, b.Id); ok {
+			meters += d
 		} else {
-			o := solveTSPExact(dist, startIdx, n-1)
-			shortestPath.minOrder = o
+			meters += r.geodesicMeters(a, b)
+		}
+	}
 
-			shortestPath.distance = 0
+	return optimalRoute{End: end, Meters: meters, Stops: stops}
+}
 
-			for i := range n - 1 {
-				idxA := o[i]
-				idxB := o[i+1]
+// shortestOrder returns the index path over dist, which starts at 0 and ends
+// at the fixed end if there is one, that covers the least distance while
+// keeping to p.
+func (r tspRoute) shortestOrder(ctx context.Context, dist [][]float64, p precedence) ([]int, string) {
+	n := len(dist)
+	startIdx := 0
 
-				shortestPath.distance += dist[idxA][idxB]
-			}
+	if len(r.stops) > r.exactLimit() {
+		return solveHeuristicFrom(ctx, dist, startIdx, r.end != nil, p), AlgorithmHeuristic
+	}
+
+	end := -1
+	if r.end != nil {
+		end = n - 1
+	}
+
+	// Without a fixed end, one table already knows the best way to finish
+	// at every stop, so there is no need to solve once per candidate.
+	return solveTSPExact(ctx, dist, startIdx, end, p), AlgorithmExact
+}
+
+// timedOrder returns the index path that finishes soonest while reaching
+// every place inside its window. When none does, it settles for the order
+// that is least late overall and reports it infeasible.
+func (r tspRoute) timedOrder(ctx context.Context, allPlaces []place, dist [][]float64, p precedence) ([]int, string, bool) {
+	travel := r.travelTimes(allPlaces, dist)
+	windows := r.windowsFor(allPlaces)
 
+	end := -1
+	if r.end != nil {
+		end = len(allPlaces) - 1
+	}
+
+	if len(r.stops) <= min(r.exactLimit(), maxTimedExactStops) {
+		if order, ok := solveTimeWindowsExact(ctx, travel, dist, windows, 0, end, p); ok {
+			return order, AlgorithmExact, false
 		}
+	}
+
+	// Either there are too many stops for the exact solver or it proved that
+	// nothing fits. Both ways the answer is a repaired shortest order.
+	order, _ := r.shortestOrder(ctx, dist, p)
+	if order == nil {
+		return nil, AlgorithmHeuristic, false
+	}
+
+	improveTimedOrder(ctx, travel, windows, order, r.end != nil, p)
+
+	late, _ := windowPenalty(travel, windows, order)
+
+	return order, AlgorithmHeuristic, late > improvementEpsilon
+}
+
+// distance is what the solver minimises between two places: the measured
+// distance when the route has a matrix covering both, otherwise the metric.
+func (r tspRoute) distance(a, b place) float64 {
+	if d, ok := r.matrix.between(a.Id, b.Id); ok {
+		return d
+	}
+
+	return r.distanceMetric().distance(a, b)
+}
 
-		shortest := shortestPath.minOrder
+func (r tspRoute) distanceMetric() Metric {
+	if r.metric != nil {
+		return r.metric
+	}
+
+	return Planar
+}
 
-		end := allPlaces[shortest[len(shortest)-1]]
+// degrees returns p's coordinates as latitude and longitude, undoing the
+// projection if the route has been through one.
+func (r tspRoute) degrees(p place) (lat, long float64) {
+	if r.center == nil {
+		return p.lat, p.long
+	}
+
+	metersPerDegreeLng := 111320.0 * math.Cos(r.center.lat*math.Pi/180.0)
 
-		stops := make([]place, 0, len(shortest)-2)
+	return p.lat/metersPerDegreeLat + r.center.lat, p.long/metersPerDegreeLng + r.center.long
+}
+
+func (r tspRoute) geodesicMeters(a, b place) float64 {
+	aLat, aLong := r.degrees(a)
+	bLat, bLong := r.degrees(b)
+
+	return vincentyMeters(aLat, aLong, bLat, bLong)
+}
+
+func (r tspRoute) hasWindows() bool {
+	return !r.startTime.IsZero() && len(r.windows) > 0
+}
 
-		for i := 1; i < len(shortest)-1; i++ {
-			s := allPlaces[shortest[i]]
-			stops = append(stops, s)
+// windowsFor lines up each place's window, in seconds after the start, with
+// allPlaces.
+func (r tspRoute) windowsFor(allPlaces []place) []timeWindow {
+	out := make([]timeWindow, len(allPlaces))
+
+	for i, p := range allPlaces {
+		out[i] = openAllRace
+
+		// The start is where the clock starts, not somewhere to arrive.
+		w, ok := r.windows[p.Id]
+		if !ok || i == 0 {
+			continue
 		}
 
-		return optimalRoute{End: end, Meters: shortestPath.distance * 1.1, Stops: stops}, nil
-	}()
+		if !w.opensAt.IsZero() {
+			out[i].opens = w.opensAt.Sub(r.startTime).Seconds()
+		}
 
-	if err != nil {
-		panic(err)
+		if !w.closesAt.IsZero() {
+			out[i].closes = w.closesAt.Sub(r.startTime).Seconds()
+		}
+	}
+
+	return out
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Round(s)) * time.Second
+}
+
+func (r tspRoute) exactLimit() int {
+	if r.exactStopLimit > 0 {
+		return min(r.exactStopLimit, MaxExactStopLimit)
 	}
 
-	return or
+	return defaultExactStopLimit
+}
+
+// solveHeuristicFrom runs the heuristic over dist, whose last row is the
+// fixed end when hasEnd is set. Without a fixed end it appends a virtual
+// finish that every node reaches for free, so whichever stop comes right
+// before it is the natural place to stop riding.
+func solveHeuristicFrom(ctx context.Context, dist [][]float64, start int, hasEnd bool, p precedence) []int {
+	n := len(dist)
+
+	if hasEnd {
+		return solveTSPHeuristic(ctx, dist, start, n-1, p)
+	}
+
+	open := make([][]float64, n+1)
+	for i := range dist {
+		open[i] = append(append(make([]float64, 0, n+1), dist[i]...), 0)
+	}
+	open[n] = make([]float64, n+1)
+
+	// The virtual end is past the end of p, so nothing constrains it.
+	path := solveTSPHeuristic(ctx, open, start, n, p)
+
+	return path[:len(path)-1]
 }
 
 func (out tspRoute) convertDegreesToMeters() tspRoute {
@@ -299,8 +561,8 @@ func (out tspRoute) convertDegreesToMeters() tspRoute {
 			}
 		}
 
-		// loop over stops
-		for _, p := range out.stops {
+		// loop over stops, and any riders' own starts
+		for _, p := range slices.Concat(out.stops, out.riderStarts) {
 			if p.lat < minLat {
 				minLat = p.lat
 			}
@@ -325,6 +587,7 @@ func (out tspRoute) convertDegreesToMeters() tspRoute {
 	})()
 
 	out.start = out.start.asRelativeCoords(lt, lng)
+	out.center = &place{lat: lt, long: lng}
 
 	if e := out.end; e != nil {
 		newEnd := e.asRelativeCoords(lt, lng)
@@ -341,28 +604,43 @@ func (out tspRoute) convertDegreesToMeters() tspRoute {
 	}
 	out.stops = stops
 
+	if len(out.riderStarts) > 0 {
+		starts := make([]place, len(out.riderStarts))
+		for i, x := range out.riderStarts {
+			starts[i] = x.asRelativeCoords(lt, lng)
+		}
+		out.riderStarts = starts
+	}
+
 	return out
 }
 
-type tspRouteBuilder struct {
+// TspRouteBuilder assembles a route for the solver.
+type TspRouteBuilder struct {
 	r tspRoute
 }
 
-func NewTspRouteBuilder() tspRouteBuilder {
-	return tspRouteBuilder{tspRoute{}}
+func NewTspRouteBuilder() TspRouteBuilder {
+	return TspRouteBuilder{tspRoute{}}
 }
 
-func (b tspRouteBuilder) WithStart(id string, lat, long float64) tspRouteBuilder {
+func (b TspRouteBuilder) WithStart(id string, lat, long float64) TspRouteBuilder {
 	b.r.start = place{id, long, lat}
 	return b
 }
 
-func (b tspRouteBuilder) WithEnd(id string, lat, long float64) tspRouteBuilder {
+func (b TspRouteBuilder) WithEnd(id string, lat, long float64) TspRouteBuilder {
 	b.r.end = &place{id, long, lat}
 	return b
 }
 
-func (b tspRouteBuilder) AddStop(id string, lat, long float64) tspRouteBuilder {
+// AsLoop makes the route finish back at the start. It replaces any end.
+func (b TspRouteBuilder) AsLoop() TspRouteBuilder {
+	b.r.loop = true
+	return b
+}
+
+func (b TspRouteBuilder) AddStop(id string, lat, long float64) TspRouteBuilder {
 	// The builder is used by value, so two calls branching off the same
 	// receiver must not write into one shared array. Copy before appending.
 	stops := make([]place, len(b.r.stops), len(b.r.stops)+1)
@@ -372,8 +650,123 @@ func (b tspRouteBuilder) AddStop(id string, lat, long float64) tspRouteBuilder {
 	return b
 }
 
-func (b tspRouteBuilder) Build() tspRoute {
-	// First convert to 2D coordinates
+// WithExactStopLimit sets how many stops Held-Karp may take on before the
+// route is handed to the heuristic instead, up to MaxExactStopLimit. Zero
+// leaves the default.
+func (b TspRouteBuilder) WithExactStopLimit(n int) TspRouteBuilder {
+	b.r.exactStopLimit = n
+	return b
+}
+
+// WithMetric picks how distances between places are measured. Without it the
+// route uses Planar.
+func (b TspRouteBuilder) WithMetric(m Metric) TspRouteBuilder {
+	b.r.metric = m
+	return b
+}
+
+// WithDistanceMatrix supplies measured distances, in metres, to use instead of
+// the metric. meters[i][j] is the trip from ids[i] to ids[j]; places the
+// matrix does not name fall back to the metric.
+func (b TspRouteBuilder) WithDistanceMatrix(ids []string, meters [][]float64) TspRouteBuilder {
+	b.r.matrix = newMeasuredDistances(ids, meters)
+	return b
+}
+
+// WithStartTime sets when the race starts, which gives the route a clock:
+// time windows take effect and the result carries arrival times.
+func (b TspRouteBuilder) WithStartTime(t time.Time) TspRouteBuilder {
+	b.r.startTime = t
+	return b
+}
+
+// WithSpeed sets the rider's pace in metres per second, whatever the travel
+// mode.
+func (b TspRouteBuilder) WithSpeed(metersPerSecond float64) TspRouteBuilder {
+	b.r.speed = metersPerSecond
+	return b
+}
+
+// WithTimeWindow restricts when the place with this id can be visited. A zero
+// opensAt or closesAt leaves that side open.
+func (b TspRouteBuilder) WithTimeWindow(id string, opensAt, closesAt time.Time) TspRouteBuilder {
+	// Same reasoning as AddStop: never write into a map another builder
+	// value might share.
+	windows := make(map[string]window, len(b.r.windows)+1)
+	for k, v := range b.r.windows {
+		windows[k] = v
+	}
+
+	windows[id] = window{opensAt: opensAt, closesAt: closesAt}
+	b.r.windows = windows
+	return b
+}
+
+// WithPrecedence requires the place with id before to be visited ahead of
+// the place with id after, as when a manifest item picked up at one
+// checkpoint has to be dropped at another.
+func (b TspRouteBuilder) WithPrecedence(before, after string) TspRouteBuilder {
+	orderings := make([]ordering, len(b.r.orderings), len(b.r.orderings)+1)
+	copy(orderings, b.r.orderings)
+
+	b.r.orderings = append(orderings, ordering{before: before, after: after})
+	return b
+}
+
+// WithPoints sets what visiting the stop with this id scores in prize mode.
+// Stops without points score one each.
+func (b TspRouteBuilder) WithPoints(id string, points int) TspRouteBuilder {
+	all := make(map[string]int, len(b.r.points)+1)
+	for k, v := range b.r.points {
+		all[k] = v
+	}
+
+	all[id] = points
+	b.r.points = all
+	return b
+}
+
+// WithDistanceBudget caps how far a prize-mode route may go.
+func (b TspRouteBuilder) WithDistanceBudget(meters float64) TspRouteBuilder {
+	b.r.budgetMeters = meters
+	return b
+}
+
+// WithTimeBudget caps how long a prize-mode route may take at the rider's
+// pace.
+func (b TspRouteBuilder) WithTimeBudget(d time.Duration) TspRouteBuilder {
+	b.r.budgetSeconds = d.Seconds()
+	return b
+}
+
+// WithRiders splits the stops between n riders for TeamRoutes. Each leaves
+// from the start unless WithRiderStart gave them their own.
+func (b TspRouteBuilder) WithRiders(n int) TspRouteBuilder {
+	b.r.riders = n
+	return b
+}
+
+// WithRiderStart gives the next rider their own start. The id has to be
+// unique on the route, like any other place's.
+func (b TspRouteBuilder) WithRiderStart(id string, lat, long float64) TspRouteBuilder {
+	starts := make([]place, len(b.r.riderStarts), len(b.r.riderStarts)+1)
+	copy(starts, b.r.riderStarts)
+
+	b.r.riderStarts = append(starts, place{id, long, lat})
+	return b
+}
+
+func (b TspRouteBuilder) Build() tspRoute {
+	if b.r.loop {
+		home := b.r.start
+		b.r.end = &home
+	}
+
+	// Geodesic metrics work on degrees directly; only the flat one needs
+	// the route converted to 2D coordinates first.
+	if !b.r.distanceMetric().projected() {
+		return b.r
+	}
 
 	return b.r.convertDegreesToMeters()
 }
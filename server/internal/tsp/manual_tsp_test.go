@@ -121,7 +121,7 @@ func TestCitywideAlleycatRouteWithEnd(t *testing.T) {
 		},
 	}
 
-	o := a.convertDegreesToMeters().OptimalRoutes()
+	o := a.convertDegreesToMeters().mustSolve(t)
 
 	expectedOrder := []place{barnes, fitlerSq, whartonSq, broadMckean, locustBar, roseGarden, the700, tshat, n11th_2400, innYardPark, saundersPark, clarkPark}
 
@@ -144,7 +144,7 @@ func TestCitywideAlleycatRouteWithEnd(t *testing.T) {
 	// }, o)
 
 	assert.Equal(t, expectedIds, actualIds)
-	assert.Equal(t, 35290, int(o.Meters))
+	assert.Equal(t, 32177, int(o.Meters))
 	assert.Equal(t, graysFerSkatePark.Id, o.End.Id)
 }
 
@@ -233,7 +233,7 @@ func TestCitywideAlleycatRouteWithoutEnd(t *testing.T) {
 		},
 	}
 
-	o := a.convertDegreesToMeters().OptimalRoutes()
+	o := a.convertDegreesToMeters().mustSolve(t)
 
 	expectedOrder := []place{barnes, saundersPark, clarkPark, fitlerSq, whartonSq, broadMckean, locustBar, roseGarden, the700, tshat, n11th_2400}
 
@@ -250,7 +250,7 @@ func TestCitywideAlleycatRouteWithoutEnd(t *testing.T) {
 	}
 
 	assert.Equal(t, expectedIds, actualIds)
-	assert.Equal(t, 31886, int(o.Meters))
+	assert.Equal(t, 29056, int(o.Meters))
 	assert.Equal(t, innYardPark.Id, o.End.Id)
 }
 
@@ -355,7 +355,7 @@ func TestRandomRouteWithEnd(t *testing.T) {
 		},
 	}
 
-	o := a.convertDegreesToMeters().OptimalRoutes()
+	o := a.convertDegreesToMeters().mustSolve(t)
 
 	expectedOrder := []place{everyThai,
 		lanierPlayground,
@@ -382,6 +382,6 @@ func TestRandomRouteWithEnd(t *testing.T) {
 	}
 
 	assert.Equal(t, expectedIds, actualIds)
-	assert.Equal(t, 34270, int(o.Meters))
+	assert.Equal(t, 31226, int(o.Meters))
 	assert.Equal(t, cityHall.Id, o.End.Id)
 }
@@ -0,0 +1,38 @@
+package tsp
+
+// measuredDistances looks up measured distances by place id, so a matrix built
+// in whatever order the caller had its places in can serve a route that
+// orders them differently.
+type measuredDistances struct {
+	index  map[string]int
+	meters [][]float64
+}
+
+func newMeasuredDistances(ids []string, meters [][]float64) *measuredDistances {
+	index := make(map[string]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+
+	return &measuredDistances{index: index, meters: meters}
+}
+
+// between reports the measured distance from a to b, and whether the matrix
+// had one. A nil matrix has none.
+func (m *measuredDistances) between(a, b string) (float64, bool) {
+	if m == nil {
+		return 0, false
+	}
+
+	i, ok := m.index[a]
+	if !ok || i >= len(m.meters) {
+		return 0, false
+	}
+
+	j, ok := m.index[b]
+	if !ok || j >= len(m.meters[i]) {
+		return 0, false
+	}
+
+	return m.meters[i][j], true
+}
@@ -0,0 +1,99 @@
+package tsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSolveTSPExactHandlesAsymmetricDistances(t *testing.T) {
+	t.Parallel()
+
+	// Symmetric on the map, but 0->1->2 is all downhill one-ways while the
+	// reverse is a long way round.
+	dist := [][]float64{
+		{0, 1, 5, 9},
+		{9, 0, 1, 5},
+		{9, 9, 0, 1},
+		{9, 9, 9, 0},
+	}
+
+	got := solveTSPExact(t.Context(), dist, 0, 3, nil)
+
+	assert.Equal(t, []int{0, 1, 2, 3}, got)
+	assert.InDelta(t, bruteForceBest(dist, 0, 3), pathDistance(dist, got), 1e-9)
+}
+
+func TestMeasuredDistancesBetween(t *testing.T) {
+	t.Parallel()
+
+	m := newMeasuredDistances([]string{"a", "b"}, [][]float64{{0, 10}, {20, 0}})
+
+	d, ok := m.between("a", "b")
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, d)
+
+	d, ok = m.between("b", "a")
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, d)
+
+	_, ok = m.between("a", "z")
+	assert.False(t, ok)
+
+	var none *measuredDistances
+	_, ok = none.between("a", "b")
+	assert.False(t, ok, "a nil matrix measures nothing")
+}
+
+func TestSolveFollowsMeasuredDistances(t *testing.T) {
+	t.Parallel()
+
+	// As the crow flies the obvious order is a, b, c. The measured matrix
+	// says b is only reachable cheaply from c, so the road order differs.
+	ids := []string{"s", "a", "b", "c", "e"}
+	meters := [][]float64{
+		{0, 100, 900, 900, 900},
+		{900, 0, 900, 100, 900},
+		{900, 900, 0, 900, 100},
+		{900, 900, 100, 0, 900},
+		{900, 900, 900, 900, 0},
+	}
+
+	r := NewTspRouteBuilder().
+		WithStart("s", 39.950, -75.16).
+		AddStop("a", 39.951, -75.16).
+		AddStop("b", 39.952, -75.16).
+		AddStop("c", 39.953, -75.16).
+		WithEnd("e", 39.954, -75.16).
+		WithDistanceMatrix(ids, meters).
+		Build()
+
+	got := r.mustSolve(t)
+
+	require.Len(t, got.Stops, 3)
+	assert.Equal(t, []string{"a", "c", "b"}, []string{got.Stops[0].Id, got.Stops[1].Id, got.Stops[2].Id})
+	assert.Equal(t, 400.0, got.Meters, "measured distances are reported as-is")
+}
+
+func TestSolveWithMatrixAndNoEnd(t *testing.T) {
+	t.Parallel()
+
+	ids := []string{"s", "a", "b"}
+	meters := [][]float64{
+		{0, 100, 10},
+		{100, 0, 1000},
+		{10, 5, 0},
+	}
+
+	got := NewTspRouteBuilder().
+		WithStart("s", 39.950, -75.16).
+		AddStop("a", 39.951, -75.16).
+		AddStop("b", 39.952, -75.16).
+		WithDistanceMatrix(ids, meters).
+		Build().
+		mustSolve(t)
+
+	assert.Equal(t, "a", got.End.Id)
+	assert.Equal(t, 15.0, got.Meters)
+}
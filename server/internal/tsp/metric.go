@@ -0,0 +1,171 @@
+package tsp
+
+import (
+	"fmt"
+	"math"
+)
+
+// The solver only ever asks "how far is a from b", so how that is answered is
+// swappable. The flat projection is fast and fine inside one city; the
+// geodesic metrics stay honest when a race spans a region.
+
+const (
+	// meanEarthRadius is the IUGG mean radius, the usual choice for haversine.
+	meanEarthRadius = 6371008.8
+
+	// WGS-84 ellipsoid, as used by GPS and every maps API.
+	wgs84SemiMajor   = 6378137.0
+	wgs84Flattening  = 1 / 298.257223563
+	wgs84SemiMinor   = wgs84SemiMajor * (1 - wgs84Flattening)
+	vincentyMaxSteps = 200
+	vincentyEpsilon  = 1e-12
+)
+
+// Metric measures the distance in metres between two places.
+type Metric interface {
+	Name() string
+
+	distance(a, b place) float64
+
+	// projected reports whether the metric expects Build to have flattened
+	// coordinates to metres around the route's centre, rather than reading
+	// them as degrees.
+	projected() bool
+}
+
+var (
+	// Planar projects the route onto a flat plane around its bounding-box
+	// centre and measures straight lines. It drifts as the route grows.
+	Planar Metric = planarMetric{}
+
+	// Haversine measures great-circle distance on a spherical earth, within
+	// about 0.5% of the true figure anywhere.
+	Haversine Metric = haversineMetric{}
+
+	// Vincenty measures geodesic distance on the WGS-84 ellipsoid, accurate to
+	// well under a metre.
+	Vincenty Metric = vincentyMetric{}
+)
+
+// MetricByName looks up a metric by the name it reports, so callers outside
+// the package can pick one from configuration or a request.
+func MetricByName(name string) (Metric, error) {
+	for _, m := range []Metric{Planar, Haversine, Vincenty} {
+		if m.Name() == name {
+			return m, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown distance metric %q", name)
+}
+
+type planarMetric struct{}
+
+func (planarMetric) Name() string { return "planar" }
+
+func (planarMetric) distance(a, b place) float64 { return a.euclideanDistanceTo(b) }
+
+func (planarMetric) projected() bool { return true }
+
+type haversineMetric struct{}
+
+func (haversineMetric) Name() string { return "haversine" }
+
+func (haversineMetric) distance(a, b place) float64 {
+	return haversineMeters(a.lat, a.long, b.lat, b.long)
+}
+
+func (haversineMetric) projected() bool { return false }
+
+type vincentyMetric struct{}
+
+func (vincentyMetric) Name() string { return "vincenty" }
+
+func (vincentyMetric) distance(a, b place) float64 {
+	return vincentyMeters(a.lat, a.long, b.lat, b.long)
+}
+
+func (vincentyMetric) projected() bool { return false }
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func haversineMeters(lat1, long1, lat2, long2 float64) float64 {
+	phi1, phi2 := radians(lat1), radians(lat2)
+	dPhi := radians(lat2 - lat1)
+	dLambda := radians(long2 - long1)
+
+	h := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+
+	return 2 * meanEarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// vincentyMeters solves the inverse geodesic problem on the WGS-84 ellipsoid.
+// The iteration fails to converge only for nearly antipodal points, which no
+// race will ever have, but it falls back to haversine rather than looping.
+func vincentyMeters(lat1, long1, lat2, long2 float64) float64 {
+	if lat1 == lat2 && long1 == long2 {
+		return 0
+	}
+
+	const f = wgs84Flattening
+
+	L := radians(long2 - long1)
+	U1 := math.Atan((1 - f) * math.Tan(radians(lat1)))
+	U2 := math.Atan((1 - f) * math.Tan(radians(lat2)))
+
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+
+	for range vincentyMaxSteps {
+		sinLambda, cosLambda := math.Sincos(lambda)
+
+		sinSigma := math.Sqrt(
+			(cosU2*sinLambda)*(cosU2*sinLambda) +
+				(cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda),
+		)
+
+		if sinSigma == 0 {
+			return 0
+		}
+
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha := 1 - sinAlpha*sinAlpha
+
+		// Both points on the equator.
+		cos2SigmaM := 0.0
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+
+		C := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+
+		prev := lambda
+		lambda = L + (1-C)*f*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+		if math.Abs(lambda-prev) > vincentyEpsilon {
+			continue
+		}
+
+		uSq := cosSqAlpha * (wgs84SemiMajor*wgs84SemiMajor - wgs84SemiMinor*wgs84SemiMinor) /
+			(wgs84SemiMinor * wgs84SemiMinor)
+
+		A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+		B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+		return wgs84SemiMinor * A * (sigma - deltaSigma)
+	}
+
+	return haversineMeters(lat1, long1, lat2, long2)
+}
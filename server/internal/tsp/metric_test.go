@@ -0,0 +1,137 @@
+package tsp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVincentyMatchesReferenceGeodesic(t *testing.T) {
+	t.Parallel()
+
+	// Flinders Peak to Buninyong, the worked example from Vincenty's paper.
+	flindersLat, flindersLong := -(37 + 57/60.0 + 3.72030/3600), 144+25/60.0+29.52440/3600
+	buninyongLat, buninyongLong := -(37 + 39/60.0 + 10.15610/3600), 143+55/60.0+35.38390/3600
+
+	got := vincentyMeters(flindersLat, flindersLong, buninyongLat, buninyongLong)
+
+	assert.InDelta(t, 54972.271, got, 1e-3)
+}
+
+func TestVincentyIsZeroForTheSamePoint(t *testing.T) {
+	t.Parallel()
+
+	assert.Zero(t, vincentyMeters(39.95, -75.16, 39.95, -75.16))
+}
+
+func TestHaversineStaysWithinHalfAPercentOfVincenty(t *testing.T) {
+	t.Parallel()
+
+	pairs := [][4]float64{
+		{39.9528, -75.1635, 40.7128, -74.0060}, // Philadelphia to New York
+		{39.9528, -75.1635, 39.9614, -75.1545}, // across Center City
+		{51.5074, -0.1278, 48.8566, 2.3522},    // London to Paris
+		{0, 0, 0, 1},                           // a degree along the equator
+	}
+
+	for _, p := range pairs {
+		v := vincentyMeters(p[0], p[1], p[2], p[3])
+		h := haversineMeters(p[0], p[1], p[2], p[3])
+
+		assert.InEpsilon(t, v, h, 0.005, "%v", p)
+	}
+}
+
+func TestPlanarDriftsOverRegionalDistances(t *testing.T) {
+	t.Parallel()
+
+	// Philadelphia to Pittsburgh: far enough that flattening around the
+	// midpoint is visibly wrong, which is the reason the geodesic metrics
+	// exist at all.
+	r := tspRoute{
+		start: place{Id: "phl", lat: 39.9528, long: -75.1635},
+		stops: []place{{Id: "pit", lat: 40.4406, long: -79.9959}},
+	}.convertDegreesToMeters()
+
+	planar := Planar.distance(r.start, r.stops[0])
+	geodesic := vincentyMeters(39.9528, -75.1635, 40.4406, -79.9959)
+
+	assert.Greater(t, math.Abs(planar-geodesic), 250.0, "a quarter-kilometre off over one leg")
+}
+
+func TestMetricByName(t *testing.T) {
+	t.Parallel()
+
+	for _, m := range []Metric{Planar, Haversine, Vincenty} {
+		got, err := MetricByName(m.Name())
+
+		require.NoError(t, err)
+		assert.Equal(t, m, got)
+	}
+
+	_, err := MetricByName("manhattan")
+	assert.ErrorContains(t, err, "manhattan")
+}
+
+func TestDegreesUndoesProjection(t *testing.T) {
+	t.Parallel()
+
+	r := tspRoute{
+		start: place{Id: "s", lat: 39.95, long: -75.18},
+		stops: []place{{Id: "a", lat: 39.99, long: -75.10}},
+	}.convertDegreesToMeters()
+
+	lat, long := r.degrees(r.stops[0])
+
+	assert.InDelta(t, 39.99, lat, 1e-9)
+	assert.InDelta(t, -75.10, long, 1e-9)
+}
+
+func TestBuilderWithGeodesicMetricKeepsDegrees(t *testing.T) {
+	t.Parallel()
+
+	r := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.18).
+		AddStop("a", 39.96, -75.19).
+		AddStop("b", 39.97, -75.20).
+		WithMetric(Haversine).
+		Build()
+
+	assert.Equal(t, 39.95, r.start.lat, "haversine reads degrees, so nothing is projected")
+	assert.Nil(t, r.center)
+}
+
+func TestSolveAgreesAcrossMetricsInsideACity(t *testing.T) {
+	t.Parallel()
+
+	build := func(m Metric) optimalRoute {
+		return NewTspRouteBuilder().
+			WithStart("start", 39.9614, -75.1545).
+			AddStop("a", 39.9606, -75.1728).
+			AddStop("b", 39.9891, -75.1507).
+			AddStop("c", 39.9472, -75.1575).
+			AddStop("d", 39.9252, -75.1675).
+			AddStop("e", 39.9360, -75.1838).
+			WithEnd("end", 39.9410, -75.2042).
+			WithMetric(m).
+			Build().
+			mustSolve(t)
+	}
+
+	planar := build(Planar)
+
+	for _, m := range []Metric{Haversine, Vincenty} {
+		got := build(m)
+
+		require.Len(t, got.Stops, len(planar.Stops))
+		for i := range got.Stops {
+			assert.Equal(t, planar.Stops[i].Id, got.Stops[i].Id, "%s disagrees at %d", m.Name(), i)
+		}
+
+		// Meters is always geodesic, so it should not depend on the metric
+		// beyond rounding through the projection.
+		assert.InDelta(t, planar.Meters, got.Meters, 1e-3)
+	}
+}
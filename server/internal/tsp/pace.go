@@ -0,0 +1,179 @@
+package tsp
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// A route's clock used to count riding time and nothing else, at one pace
+// whatever the rider was on. Riders also stop: signing a manifest, queueing
+// at a checkpoint. Dwell times put that on the clock at each stop, and the
+// pace comes from the travel mode unless the route sets its own.
+
+// TravelMode is how the route is ridden, which sets its default pace.
+type TravelMode string
+
+const (
+	Bike TravelMode = "bike"
+	Car  TravelMode = "car"
+	Foot TravelMode = "foot"
+)
+
+// modeSpeeds are the default paces, in metres per second, counting lights
+// but not stops.
+var modeSpeeds = map[TravelMode]float64{
+	Bike: defaultSpeed, // 18 km/h
+	Car:  7.0,          // 25 km/h through city traffic
+	Foot: 1.4,          // 5 km/h
+}
+
+// TravelModeByName looks up a travel mode by name, so callers outside the
+// package can pick one from a request.
+func TravelModeByName(name string) (TravelMode, error) {
+	if _, ok := modeSpeeds[TravelMode(name)]; ok {
+		return TravelMode(name), nil
+	}
+
+	return "", fmt.Errorf("unknown travel mode %q", name)
+}
+
+// WithTravelMode sets how the route is ridden. Without it the route is
+// ridden by bike.
+func (b TspRouteBuilder) WithTravelMode(m TravelMode) TspRouteBuilder {
+	b.r.mode = m
+	return b
+}
+
+// WithDwell sets how long the rider spends at the place with this id. It
+// overrides WithDefaultDwell, and is the only way to give the end one.
+func (b TspRouteBuilder) WithDwell(id string, d time.Duration) TspRouteBuilder {
+	dwell := make(map[string]time.Duration, len(b.r.dwell)+1)
+	for k, v := range b.r.dwell {
+		dwell[k] = v
+	}
+
+	dwell[id] = d
+	b.r.dwell = dwell
+	return b
+}
+
+// WithDefaultDwell sets how long the rider spends at each stop without a
+// dwell of its own.
+func (b TspRouteBuilder) WithDefaultDwell(d time.Duration) TspRouteBuilder {
+	b.r.defaultDwell = d
+	return b
+}
+
+func (r tspRoute) travelMode() TravelMode {
+	if r.mode != "" {
+		return r.mode
+	}
+
+	return Bike
+}
+
+func (r tspRoute) metersPerSecond() float64 {
+	if r.speed > 0 {
+		return r.speed
+	}
+
+	if s, ok := modeSpeeds[r.travelMode()]; ok {
+		return s
+	}
+
+	return defaultSpeed
+}
+
+// dwellsFor lines up how long the rider spends at each place, in seconds,
+// with allPlaces. The clock starts as the rider leaves the start, so the
+// start never dwells.
+func (r tspRoute) dwellsFor(allPlaces []place) []float64 {
+	out := make([]float64, len(allPlaces))
+
+	for i, p := range allPlaces {
+		if i == 0 {
+			continue
+		}
+
+		if d, ok := r.dwell[p.Id]; ok {
+			out[i] = d.Seconds()
+		} else if r.end == nil || i < len(allPlaces)-1 {
+			out[i] = r.defaultDwell.Seconds()
+		}
+	}
+
+	return out
+}
+
+// travelTimes turns a distance matrix into seconds at the rider's pace,
+// with the time spent at each place added to the legs leaving it. That is
+// all the timed solvers need to count dwell: a rider who arrives at t can
+// leave for the next place at t plus its dwell, as long as they did not
+// have to wait for it to open first.
+func (r tspRoute) travelTimes(allPlaces []place, dist [][]float64) [][]float64 {
+	speed := r.metersPerSecond()
+	dwell := r.dwellsFor(allPlaces)
+
+	travel := make([][]float64, len(dist))
+	for i := range dist {
+		travel[i] = make([]float64, len(dist[i]))
+		for j := range dist[i] {
+			travel[i][j] = dwell[i] + dist[i][j]/speed
+		}
+	}
+
+	return travel
+}
+
+// timed fills in when the rider gets to each place along order and how
+// long the whole route takes: Elapsed and Duration always, and Arrivals
+// when the route has a start time.
+func (r tspRoute) timed(or optimalRoute, allPlaces []place, dist [][]float64, order []int) optimalRoute {
+	travel := r.travelTimes(allPlaces, dist)
+	dwell := r.dwellsFor(allPlaces)
+
+	// Windows are times of day, which mean nothing without a start time.
+	windows := make([]timeWindow, len(allPlaces))
+	for i := range windows {
+		windows[i] = openAllRace
+	}
+
+	if !r.startTime.IsZero() {
+		windows = r.windowsFor(allPlaces)
+	}
+
+	or.Elapsed = make([]time.Duration, 0, len(order)-1)
+	or.Arrivals = nil
+
+	var t float64
+
+	for i := 1; i < len(order); i++ {
+		a, b := order[i-1], order[i]
+		t += travel[a][b]
+
+		wait := math.Max(0, windows[b].opens-t)
+
+		or.Elapsed = append(or.Elapsed, secondsToDuration(t))
+
+		if !r.startTime.IsZero() {
+			or.Arrivals = append(or.Arrivals, Arrival{
+				Id:   allPlaces[b].Id,
+				At:   r.startTime.Add(secondsToDuration(t)),
+				Wait: secondsToDuration(wait),
+				Late: t > windows[b].closes,
+			})
+		}
+
+		t += wait
+	}
+
+	// Nothing leaves the last place, so its dwell is not on any leg yet.
+	if len(order) > 1 {
+		t += dwell[order[len(order)-1]]
+	}
+
+	or.Duration = secondsToDuration(t)
+
+	return or
+}
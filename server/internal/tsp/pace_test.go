@@ -0,0 +1,116 @@
+package tsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSolveCountsDwellTimes(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().
+		WithDefaultDwell(time.Minute).
+		WithDwell("e", 30*time.Second).
+		Build().
+		mustSolve(t)
+
+	assert.Equal(t, []string{"a", "b"}, stopIds(got))
+
+	// 100s to each place, and a minute at each stop before leaving it.
+	assert.Equal(t, []time.Duration{100 * time.Second, 260 * time.Second, 420 * time.Second}, got.Elapsed)
+	assert.Equal(t, 450*time.Second, got.Duration, "done once signed in at the end")
+
+	require.Len(t, got.Arrivals, 3)
+	assert.Equal(t, raceStart.Add(260*time.Second), got.Arrivals[1].At)
+}
+
+func TestSolveTimesRoutesWithoutAStartTime(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().WithStartTime(time.Time{}).WithDefaultDwell(time.Minute).Build().mustSolve(t)
+
+	assert.Nil(t, got.Arrivals)
+	assert.Equal(t, []time.Duration{100 * time.Second, 260 * time.Second, 420 * time.Second}, got.Elapsed)
+	assert.Equal(t, 420*time.Second, got.Duration, "the end only dwells when asked to")
+}
+
+func TestSolveDwellCanCloseAWindow(t *testing.T) {
+	t.Parallel()
+
+	// b closes at 250s: reached at 200s straight through a, but not once a
+	// minute at a is added.
+	base := lineRoute().WithTimeWindow("b", time.Time{}, raceStart.Add(250*time.Second))
+
+	assert.Equal(t, []string{"a", "b"}, stopIds(base.Build().mustSolve(t)))
+
+	got := base.WithDwell("a", time.Minute).Build().mustSolve(t)
+
+	assert.False(t, got.Infeasible)
+	assert.Equal(t, []string{"b", "a"}, stopIds(got))
+}
+
+func TestSolvePaceFollowsTheTravelMode(t *testing.T) {
+	t.Parallel()
+
+	// lineRoute is 3km end to end.
+	base := lineRoute().WithSpeed(0)
+
+	tests := []struct {
+		name    string
+		builder TspRouteBuilder
+		want    time.Duration
+	}{
+		{"bike by default", base, 600 * time.Second},
+		{"on foot", base.WithTravelMode(Foot), 2143 * time.Second},
+		{"by car", base.WithTravelMode(Car), 429 * time.Second},
+		{"the rider's pace wins", base.WithTravelMode(Foot).WithSpeed(10), 300 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tt.builder.Build().mustSolve(t).Duration)
+		})
+	}
+}
+
+func TestWithDwellDoesNotShareState(t *testing.T) {
+	t.Parallel()
+
+	base := lineRoute().WithDwell("a", time.Minute)
+	_ = base.WithDwell("b", time.Hour)
+
+	assert.Equal(t, 360*time.Second, base.Build().mustSolve(t).Duration)
+}
+
+func TestPrizeRoutesTimeBudgetCountsDwell(t *testing.T) {
+	t.Parallel()
+
+	// a then b rides exactly the 200s budget, which leaves no time to stop.
+	got, err := prizeLine().
+		WithSpeed(10).
+		WithTimeBudget(200*time.Second).
+		WithDefaultDwell(30*time.Second).
+		Build().
+		PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"c"}, visited(got[0]))
+	assert.Equal(t, 180*time.Second, got[0].Duration)
+}
+
+func TestTravelModeByName(t *testing.T) {
+	t.Parallel()
+
+	m, err := TravelModeByName("foot")
+	require.NoError(t, err)
+	assert.Equal(t, Foot, m)
+
+	_, err = TravelModeByName("unicycle")
+	assert.Error(t, err)
+}
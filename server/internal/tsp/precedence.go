@@ -0,0 +1,223 @@
+package tsp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Delivery races hand out a manifest item at one checkpoint that has to be
+// dropped at another, so some orders are simply not allowed. Held-Karp only
+// ever grows a path one node at a time, so it can refuse to add a node until
+// everything that must come before it is already in the mask; the heuristic
+// moves are checked against the same rules before they are kept.
+
+// ErrInvalidPrecedence is returned for ordering rules no route could satisfy:
+// an unknown stop, a stop before itself, something before the start or after
+// the end, or a cycle.
+var ErrInvalidPrecedence = errors.New("invalid precedence constraint")
+
+// ordering is one "before must be visited before after" rule, by place id.
+type ordering struct {
+	before string
+	after  string
+}
+
+// precedence lists, for each node index, the nodes that must be visited
+// before it. A nil precedence allows every order, and nodes past its end
+// are unconstrained.
+type precedence [][]int
+
+func (p precedence) of(node int) []int {
+	if node >= len(p) {
+		return nil
+	}
+
+	return p[node]
+}
+
+// ready reports whether every node that must precede node has been placed.
+func (p precedence) ready(placed []bool, node int) bool {
+	for _, q := range p.of(node) {
+		if !placed[q] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allows reports whether path, a permutation of node indexes, visits every
+// node after all the nodes that must precede it.
+func (p precedence) allows(path []int) bool {
+	if len(p) == 0 {
+		return true
+	}
+
+	pos := make([]int, len(path))
+	for i, node := range path {
+		pos[node] = i
+	}
+
+	for _, node := range path {
+		for _, q := range p.of(node) {
+			if pos[q] > pos[node] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// masks turns the rules into Held-Karp's terms: for each intermediate node,
+// the bitmask of intermediate positions that must already be visited. The
+// start is always visited, so rules on it drop out. A node that needs the
+// end first can never be reached, so it gets a bit no mask will ever have.
+func (p precedence) masks(intermediate []int, start int) []int {
+	m := len(intermediate)
+	bit := make(map[int]int, m)
+
+	for i, node := range intermediate {
+		bit[node] = i
+	}
+
+	out := make([]int, m)
+
+	for i, node := range intermediate {
+		for _, q := range p.of(node) {
+			if q == start {
+				continue
+			}
+
+			if b, ok := bit[q]; ok {
+				out[i] |= 1 << b
+			} else {
+				out[i] |= 1 << m
+			}
+		}
+	}
+
+	return out
+}
+
+// precedenceFor indexes the route's rules the same way as allPlaces. It
+// assumes ValidatePrecedence has passed.
+func (r tspRoute) precedenceFor(allPlaces []place) precedence {
+	if len(r.orderings) == 0 {
+		return nil
+	}
+
+	// A loop lists the start again as the end; rules about it mean the
+	// start, where it first appears.
+	index := make(map[string]int, len(allPlaces))
+	for i, p := range allPlaces {
+		if _, ok := index[p.Id]; !ok {
+			index[p.Id] = i
+		}
+	}
+
+	out := make(precedence, len(allPlaces))
+
+	for _, o := range r.orderings {
+		after := index[o.after]
+		out[after] = append(out[after], index[o.before])
+	}
+
+	return out
+}
+
+// ValidatePrecedence reports, wrapping ErrInvalidPrecedence, the first
+// ordering rule that makes the route impossible.
+func (r tspRoute) ValidatePrecedence() error {
+	known := make(map[string]bool, len(r.stops)+2)
+	known[r.start.Id] = true
+
+	for _, s := range r.stops {
+		known[s.Id] = true
+	}
+
+	if r.end != nil {
+		known[r.end.Id] = true
+	}
+
+	after := make(map[string][]string)
+
+	for _, o := range r.orderings {
+		for _, id := range []string{o.before, o.after} {
+			if !known[id] {
+				return fmt.Errorf("%w: %q before %q: no place %q on the route", ErrInvalidPrecedence, o.before, o.after, id)
+			}
+		}
+
+		switch {
+		case o.before == o.after:
+			return fmt.Errorf("%w: %q cannot come before itself", ErrInvalidPrecedence, o.before)
+		case o.after == r.start.Id:
+			return fmt.Errorf("%w: %q before %q: nothing comes before the start", ErrInvalidPrecedence, o.before, o.after)
+		case r.loop && o.before == r.start.Id:
+			// Leaving the start comes first, even when the start is also
+			// where the loop ends.
+		case r.end != nil && o.before == r.end.Id:
+			return fmt.Errorf("%w: %q before %q: nothing comes after the end", ErrInvalidPrecedence, o.before, o.after)
+		}
+
+		after[o.before] = append(after[o.before], o.after)
+	}
+
+	if cycle := findCycle(r.orderings, after); cycle != nil {
+		return fmt.Errorf("%w: cycle %s", ErrInvalidPrecedence, strings.Join(cycle, " -> "))
+	}
+
+	return nil
+}
+
+// findCycle returns the ids along one cycle in the rules, first id repeated
+// at the end, or nil if there is none. Searching from each rule in the order
+// given keeps the answer stable between requests.
+func findCycle(orderings []ordering, after map[string][]string) []string {
+	const (
+		unseen = iota
+		onPath
+		done
+	)
+
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		state[id] = onPath
+		path = append(path, id)
+
+		for _, next := range after[id] {
+			switch state[next] {
+			case onPath:
+				for i, p := range path {
+					if p == next {
+						return append(append([]string(nil), path[i:]...), next)
+					}
+				}
+			case unseen:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		state[id] = done
+		path = path[:len(path)-1]
+
+		return nil
+	}
+
+	for _, o := range orderings {
+		if state[o.before] == unseen {
+			if cycle := visit(o.before); cycle != nil {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package tsp
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// randomPrecedence draws rules between intermediate nodes of an n-node
+// problem, always from a lower index to a higher one so there is never a
+// cycle.
+func randomPrecedence(seed int64, n, rules int) precedence {
+	rng := rand.New(rand.NewSource(seed))
+	p := make(precedence, n)
+
+	for range rules {
+		a := 1 + rng.Intn(n-2)
+		b := 1 + rng.Intn(n-2)
+
+		if a == b {
+			continue
+		}
+
+		if a > b {
+			a, b = b, a
+		}
+
+		p[b] = append(p[b], a)
+	}
+
+	return p
+}
+
+// bruteForceAllowed is bruteForceBest restricted to orders p allows.
+func bruteForceAllowed(dist [][]float64, start, end int, p precedence) float64 {
+	n := len(dist)
+
+	intermediate := make([]int, 0, n-2)
+	for i := 0; i < n; i++ {
+		if i != start && i != end {
+			intermediate = append(intermediate, i)
+		}
+	}
+
+	best := math.Inf(1)
+
+	var permute func(cur []int, remaining []int)
+	permute = func(cur []int, remaining []int) {
+		if len(remaining) == 0 {
+			path := append([]int{start}, cur...)
+			path = append(path, end)
+			if c := pathDistance(dist, path); c < best && p.allows(path) {
+				best = c
+			}
+			return
+		}
+
+		for i := range remaining {
+			next := append([]int{}, remaining[:i]...)
+			next = append(next, remaining[i+1:]...)
+			permute(append(cur, remaining[i]), next)
+		}
+	}
+
+	permute([]int{}, intermediate)
+
+	return best
+}
+
+func TestPrecedenceAllows(t *testing.T) {
+	t.Parallel()
+
+	p := precedence{nil, nil, {1}, {2}}
+
+	assert.True(t, p.allows([]int{0, 1, 2, 3}))
+	assert.False(t, p.allows([]int{0, 2, 1, 3}))
+	assert.False(t, p.allows([]int{0, 1, 3, 2}))
+	assert.True(t, precedence(nil).allows([]int{0, 3, 2, 1}), "no rules allow anything")
+}
+
+func TestSolveTSPExactRespectsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 10; seed++ {
+		points := scatter(seed, 8)
+		dist := distanceMatrix(points)
+		end := len(points) - 1
+		p := randomPrecedence(seed, len(points), 4)
+
+		got := solveTSPExact(t.Context(), dist, 0, end, p)
+
+		require.NotNil(t, got)
+		assertVisitsEachOnce(t, got, len(points))
+		assert.True(t, p.allows(got), "seed %d: %v", seed, got)
+		assert.InDelta(t, bruteForceAllowed(dist, 0, end, p), pathDistance(dist, got), 1e-6, "seed %d", seed)
+	}
+}
+
+func TestSolveTSPExactReturnsNilWhenTheEndMustComeFirst(t *testing.T) {
+	t.Parallel()
+
+	dist := distanceMatrix(scatter(1, 4))
+
+	// Node 1 has to follow node 3, but 3 is the end.
+	p := precedence{nil, {3}, nil, nil}
+
+	assert.Nil(t, solveTSPExact(t.Context(), dist, 0, 3, p))
+}
+
+func TestSolveTSPHeuristicRespectsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		points := scatter(seed, 40)
+		dist := distanceMatrix(points)
+		p := randomPrecedence(seed, len(points), 30)
+
+		got := solveTSPHeuristic(t.Context(), dist, 0, len(points)-1, p)
+
+		assertVisitsEachOnce(t, got, len(points))
+		assert.True(t, p.allows(got), "seed %d", seed)
+	}
+}
+
+func TestSolveTSPHeuristicWithPrecedenceStaysCloseToExact(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		points := scatter(seed, 10)
+		dist := distanceMatrix(points)
+		end := len(points) - 1
+		p := randomPrecedence(seed, len(points), 5)
+
+		exact := pathDistance(dist, solveTSPExact(t.Context(), dist, 0, end, p))
+		heuristic := pathDistance(dist, solveTSPHeuristic(t.Context(), dist, 0, end, p))
+
+		assert.LessOrEqual(t, heuristic, exact*1.1, "seed %d", seed)
+	}
+}
+
+func TestSolveTimeWindowsExactRespectsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	travel := [][]float64{
+		{0, 10, 20},
+		{10, 0, 10},
+		{20, 10, 0},
+	}
+	windows := []timeWindow{openAllRace, openAllRace, openAllRace}
+
+	// Without the rule the open finish would be 0, 1, 2.
+	got, ok := solveTimeWindowsExact(t.Context(), travel, travel, windows, 0, -1, precedence{nil, {2}, nil})
+
+	require.True(t, ok)
+	assert.Equal(t, []int{0, 2, 1}, got)
+}
+
+func TestValidatePrecedence(t *testing.T) {
+	t.Parallel()
+
+	base := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		AddStop("b", 39.97, -75.16).
+		AddStop("c", 39.98, -75.16).
+		WithEnd("e", 39.99, -75.16)
+
+	tests := []struct {
+		name    string
+		builder TspRouteBuilder
+		wantErr string
+	}{
+		{
+			name:    "pickup then dropoff",
+			builder: base.WithPrecedence("a", "b"),
+		},
+		{
+			name:    "rules on the endpoints that always hold",
+			builder: base.WithPrecedence("s", "a").WithPrecedence("c", "e"),
+		},
+		{
+			name:    "unknown stop",
+			builder: base.WithPrecedence("a", "z"),
+			wantErr: `"a" before "z": no place "z" on the route`,
+		},
+		{
+			name:    "before itself",
+			builder: base.WithPrecedence("b", "b"),
+			wantErr: `"b" cannot come before itself`,
+		},
+		{
+			name:    "before the start",
+			builder: base.WithPrecedence("a", "s"),
+			wantErr: `"a" before "s": nothing comes before the start`,
+		},
+		{
+			name:    "after the end",
+			builder: base.WithPrecedence("e", "c"),
+			wantErr: `"e" before "c": nothing comes after the end`,
+		},
+		{
+			name:    "cycle",
+			builder: base.WithPrecedence("a", "b").WithPrecedence("b", "c").WithPrecedence("c", "a"),
+			wantErr: "cycle a -> b -> c -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.builder.Build().ValidatePrecedence()
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrInvalidPrecedence))
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoopPrecedenceMeansLeavingTheStart(t *testing.T) {
+	t.Parallel()
+
+	// a, then c on the other side, then b back east: the rules force the
+	// loop across the start twice more than it would otherwise go.
+	r := prizeLine().
+		AsLoop().
+		WithPrecedence("s", "a").
+		WithPrecedence("a", "c").
+		WithPrecedence("c", "b").
+		Build()
+
+	require.NoError(t, r.ValidatePrecedence())
+
+	got := r.mustSolve(t)
+
+	assert.Equal(t, []string{"a", "c", "b"}, stopIds(got))
+	assert.Equal(t, "s", got.End.Id)
+	assert.Equal(t, 9000.0, got.Meters)
+
+	err := prizeLine().AsLoop().WithPrecedence("a", "s").Build().ValidatePrecedence()
+	assert.ErrorContains(t, err, "nothing comes before the start")
+}
+
+func TestSolveKeepsPickupBeforeDropoff(t *testing.T) {
+	t.Parallel()
+
+	// Along a line the obvious order is a, b, c; the manifest item for a is
+	// only picked up at c.
+	got := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		AddStop("b", 39.97, -75.16).
+		AddStop("c", 39.98, -75.16).
+		WithEnd("e", 39.99, -75.16).
+		WithPrecedence("c", "a").
+		Build().
+		mustSolve(t)
+
+	// Out to c and back to a: whether b is passed on the way out or back is
+	// a tie, but a has to be last.
+	require.Len(t, got.Stops, 3)
+	assert.Equal(t, "a", got.Stops[2].Id)
+	assert.InDelta(t, 8*1112, got.Meters, 50)
+}
+
+func TestSolvePrecedenceDecidesTheFinish(t *testing.T) {
+	t.Parallel()
+
+	// Without an end the ride would stop at c, the far end of the line.
+	got := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		AddStop("b", 39.97, -75.16).
+		AddStop("c", 39.98, -75.16).
+		WithPrecedence("c", "b").
+		Build().
+		mustSolve(t)
+
+	assert.Equal(t, "b", got.End.Id)
+	assert.Equal(t, []string{"a", "c"}, stopIds(got))
+}
+
+func TestSolveHeuristicKeepsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	b := NewTspRouteBuilder().WithStart("s", 39.95, -75.16).WithExactStopLimit(2)
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		b = b.AddStop(id, 39.96+float64(i)*0.01, -75.16)
+	}
+
+	got := b.WithPrecedence("e", "a").WithPrecedence("d", "b").Build().mustSolve(t)
+
+	assert.Equal(t, AlgorithmHeuristic, got.Algorithm)
+
+	order := append(stopIds(got), got.End.Id)
+	pos := map[string]int{}
+	for i, id := range order {
+		pos[id] = i
+	}
+
+	assert.Less(t, pos["e"], pos["a"], "%v", order)
+	assert.Less(t, pos["d"], pos["b"], "%v", order)
+}
+
+func TestSolveWindowsAndPrecedenceTogether(t *testing.T) {
+	t.Parallel()
+
+	// b has to come before a, and a only opens late, so waiting at a last
+	// is the only way to satisfy both.
+	got := lineRoute().
+		WithTimeWindow("a", raceStart.Add(600*time.Second), time.Time{}).
+		WithPrecedence("b", "a").
+		Build().
+		mustSolve(t)
+
+	assert.False(t, got.Infeasible)
+	assert.Equal(t, []string{"b", "a"}, stopIds(got))
+}
+
+func TestBuilderWithPrecedenceIsValueSemantic(t *testing.T) {
+	t.Parallel()
+
+	base := NewTspRouteBuilder().WithPrecedence("a", "b")
+	_ = base.WithPrecedence("c", "d")
+	withE := base.WithPrecedence("e", "f")
+
+	require.Len(t, withE.Build().orderings, 2)
+	assert.Equal(t, ordering{before: "e", after: "f"}, withE.Build().orderings[1])
+	assert.Len(t, base.Build().orderings, 1)
+}
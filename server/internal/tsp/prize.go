@@ -0,0 +1,218 @@
+package tsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Some races score each checkpoint and stop the clock at a hard cutoff, so
+// the question is which stops to ride to at all. Held-Karp already knows the
+// shortest way through every subset of stops, so the answer is a scan over
+// the table it fills anyway: keep the subsets that fit in the budget and rank
+// them by points.
+
+// ErrPrizeTooManyStops is returned when prize mode is asked to choose among
+// more stops than the exact solver takes on. There is no heuristic fallback:
+// a subset choice that is only probably right is not worth a rider's race.
+var ErrPrizeTooManyStops = errors.New("too many stops for prize mode")
+
+// defaultStopPoints is what a stop scores when no points were given for it,
+// so that without any points prize mode simply visits as many as it can.
+const defaultStopPoints = 1
+
+// prizeCandidate is one subset of stops that fits in the budget, with the
+// cheapest way through it.
+type prizeCandidate struct {
+	mask   int
+	last   int // -1 when the subset is empty
+	points int
+	cost   float64
+}
+
+// PrizeRoutes chooses the stops worth visiting when there is not budget for
+// all of them. The first route scores the most points within budget; up to
+// alternatives more follow, each scoring fewer points than the one before
+// but also covering less distance, so every entry is a real trade-off. With
+// a fixed end, skipping every stop is a valid answer. Nil means nothing fits.
+// Routes Solve would refuse are refused here too.
+func (r tspRoute) PrizeRoutes(ctx context.Context, alternatives int) ([]optimalRoute, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(r.stops) > r.exactLimit() {
+		return nil, fmt.Errorf("%w: at most %d, got %d", ErrPrizeTooManyStops, r.exactLimit(), len(r.stops))
+	}
+
+	allPlaces, dist := r.distances()
+
+	end := -1
+	if r.end != nil {
+		end = len(allPlaces) - 1
+	}
+
+	release, err := reserveTable(ctx, len(allPlaces), end, heldKarpStateBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	t := fillHeldKarp(ctx, dist, 0, end, r.precedenceFor(allPlaces))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m := len(t.intermediate)
+
+	scores := make([]int, m)
+	for i, node := range t.intermediate {
+		scores[i] = r.pointsFor(allPlaces[node].Id)
+	}
+
+	limit := r.budget()
+	speed := r.metersPerSecond()
+	dwell := r.dwellsFor(allPlaces)
+
+	finishDwell := 0.0
+	if end >= 0 {
+		finishDwell = dwell[end]
+	}
+
+	var candidates []prizeCandidate
+
+	for mask := 0; mask < 1<<m; mask++ {
+		c := prizeCandidate{mask: mask, last: -1, cost: math.Inf(1)}
+
+		if mask == 0 {
+			if end < 0 {
+				continue // an open finish has to finish somewhere
+			}
+
+			c.cost = dist[0][end]
+		}
+
+		for last := 0; last < m; last++ {
+			if mask&(1<<last) == 0 {
+				continue
+			}
+
+			cost := t.at(mask, last)
+			if end >= 0 {
+				cost += dist[t.intermediate[last]][end]
+			}
+
+			if cost < c.cost {
+				c.cost, c.last = cost, last
+			}
+		}
+
+		if c.cost > limit+improvementEpsilon {
+			continue
+		}
+
+		stopped := finishDwell
+
+		for rest := mask; rest != 0; rest &= rest - 1 {
+			i := bits.TrailingZeros(uint(rest))
+			c.points += scores[i]
+			stopped += dwell[t.intermediate[i]]
+		}
+
+		// Time at the checkpoints depends on which ones are visited, so a
+		// time budget is checked per subset rather than turned into
+		// distance.
+		if r.budgetSeconds > 0 && c.cost/speed+stopped > r.budgetSeconds+improvementEpsilon {
+			continue
+		}
+
+		candidates = append(candidates, c)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		if a.points != b.points {
+			return a.points > b.points
+		}
+
+		if a.cost != b.cost {
+			return a.cost < b.cost
+		}
+
+		return a.mask < b.mask
+	})
+
+	var out []optimalRoute
+	shortest := math.Inf(1)
+
+	for _, c := range candidates {
+		if len(out) > alternatives {
+			break
+		}
+
+		// Fewer points for as far or further is never worth showing.
+		if c.cost >= shortest-improvementEpsilon {
+			continue
+		}
+
+		shortest = c.cost
+		out = append(out, r.prizeRoute(allPlaces, dist, t, c, end))
+	}
+
+	return out, nil
+}
+
+func (r tspRoute) prizeRoute(allPlaces []place, dist [][]float64, t heldKarpTable, c prizeCandidate, end int) optimalRoute {
+	order := []int{0}
+	if c.last >= 0 {
+		order = t.path(0, c.mask, c.last)
+	}
+
+	if end >= 0 {
+		order = append(order, end)
+	}
+
+	or := r.timed(r.routeAlong(allPlaces, order), allPlaces, dist, order)
+	or.Algorithm = AlgorithmExact
+	or.Points = c.points
+
+	// No shorter order rides the same checkpoints.
+	or.Bounded = true
+
+	for i, node := range t.intermediate {
+		if c.mask&(1<<i) == 0 {
+			or.Skipped = append(or.Skipped, allPlaces[node])
+		}
+	}
+
+	return or
+}
+
+func (r tspRoute) pointsFor(id string) int {
+	if p, ok := r.points[id]; ok {
+		return p
+	}
+
+	return defaultStopPoints
+}
+
+// budget is the longest distance prize mode may cover, as the solver
+// measures it. No route rides further than a time budget at full pace, but
+// the stops take time too, so each candidate is checked against it as well.
+func (r tspRoute) budget() float64 {
+	limit := math.Inf(1)
+
+	if r.budgetMeters > 0 {
+		limit = r.budgetMeters
+	}
+
+	if r.budgetSeconds > 0 {
+		limit = math.Min(limit, r.budgetSeconds*r.metersPerSecond())
+	}
+
+	return limit
+}
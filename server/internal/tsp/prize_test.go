@@ -0,0 +1,197 @@
+package tsp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// prizeLine puts a 1km and b 2km east of the start, and c 1.5km west of it,
+// measured along one road. b is worth the most, a the least.
+func prizeLine() TspRouteBuilder {
+	ids := []string{"s", "a", "b", "c"}
+	at := []float64{0, 1000, 2000, -1500}
+
+	meters := make([][]float64, len(ids))
+	for i := range meters {
+		meters[i] = make([]float64, len(ids))
+		for j := range meters[i] {
+			meters[i][j] = math.Abs(at[i] - at[j])
+		}
+	}
+
+	return NewTspRouteBuilder().
+		WithStart("s", 39.950, -75.16).
+		AddStop("a", 39.951, -75.16).
+		AddStop("b", 39.952, -75.16).
+		AddStop("c", 39.949, -75.16).
+		WithDistanceMatrix(ids, meters).
+		WithPoints("a", 1).
+		WithPoints("b", 5).
+		WithPoints("c", 3)
+}
+
+func visited(r optimalRoute) []string {
+	return append(stopIds(r), r.End.Id)
+}
+
+func skipped(r optimalRoute) []string {
+	ids := make([]string, len(r.Skipped))
+	for i, s := range r.Skipped {
+		ids[i] = s.Id
+	}
+
+	return ids
+}
+
+func TestPrizeRoutesRanksSubsetsByPoints(t *testing.T) {
+	t.Parallel()
+
+	got, err := prizeLine().WithDistanceBudget(3500).Build().PrizeRoutes(t.Context(), 5)
+	require.NoError(t, err)
+
+	// a and c together fit too, but for more distance than a and b, so they
+	// are never the better choice.
+	require.Len(t, got, 3)
+
+	assert.Equal(t, []string{"a", "b"}, visited(got[0]))
+	assert.Equal(t, 6, got[0].Points)
+	assert.Equal(t, 2000.0, got[0].Meters)
+	assert.Equal(t, []string{"c"}, skipped(got[0]))
+
+	assert.Equal(t, []string{"c"}, visited(got[1]))
+	assert.Equal(t, 3, got[1].Points)
+	assert.Equal(t, 1500.0, got[1].Meters)
+
+	assert.Equal(t, []string{"a"}, visited(got[2]))
+	assert.Equal(t, 1, got[2].Points)
+
+	for _, r := range got {
+		assert.Equal(t, AlgorithmExact, r.Algorithm)
+	}
+}
+
+func TestPrizeRoutesRespectsTheBudget(t *testing.T) {
+	t.Parallel()
+
+	got, err := prizeLine().WithDistanceBudget(1800).Build().PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1, "no alternatives asked for")
+	assert.Equal(t, []string{"c"}, visited(got[0]))
+	assert.ElementsMatch(t, []string{"a", "b"}, skipped(got[0]))
+}
+
+func TestPrizeRoutesTimeBudgetUsesRiderPace(t *testing.T) {
+	t.Parallel()
+
+	got, err := prizeLine().
+		WithSpeed(10).
+		WithTimeBudget(200*time.Second).
+		Build().
+		PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"a", "b"}, visited(got[0]), "200s at 10m/s is 2km")
+}
+
+func TestPrizeRoutesTighterOfTwoBudgetsWins(t *testing.T) {
+	t.Parallel()
+
+	got, err := prizeLine().
+		WithSpeed(10).
+		WithTimeBudget(time.Hour).
+		WithDistanceBudget(1000).
+		Build().
+		PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"a"}, visited(got[0]))
+}
+
+func TestPrizeRoutesNothingFits(t *testing.T) {
+	t.Parallel()
+
+	got, err := prizeLine().WithDistanceBudget(500).Build().PrizeRoutes(t.Context(), 2)
+
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestPrizeRoutesWithFixedEndMaySkipEverything(t *testing.T) {
+	t.Parallel()
+
+	got, err := lineRoute().WithDistanceBudget(3000).Build().PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"a", "b"}, stopIds(got[0]), "both are on the way")
+
+	got, err = lineRoute().WithDistanceBudget(3000).WithPoints("a", 0).WithPoints("b", 0).Build().PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	// Nothing scores and every subset is the same distance, so the tie goes
+	// to the smallest.
+	require.Len(t, got, 1)
+	assert.Equal(t, 0, got[0].Points)
+	assert.Empty(t, got[0].Stops)
+	assert.Equal(t, "e", got[0].End.Id)
+}
+
+func TestPrizeRoutesKeepsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	// b is only worth anything once c has been visited, and c is the other
+	// way, so the pair is out of reach.
+	got, err := prizeLine().WithDistanceBudget(3500).WithPrecedence("c", "b").Build().PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"a", "c"}, visited(got[0]))
+	assert.Equal(t, 4, got[0].Points)
+}
+
+func TestPrizeRoutesWithoutBudgetMatchesSolve(t *testing.T) {
+	t.Parallel()
+
+	b := NewTspRouteBuilder().WithStart("s", 39.95, -75.16)
+	for i, p := range scatter(3, 8) {
+		b = b.AddStop(fmt.Sprintf("p%d", i), 39.95+p.lat/1e6, -75.16+p.long/1e6)
+	}
+
+	r := b.Build()
+
+	got, err := r.PrizeRoutes(t.Context(), 0)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Empty(t, got[0].Skipped)
+	assert.Equal(t, 8, got[0].Points)
+	assert.InDelta(t, r.mustSolve(t).Meters, got[0].Meters, 1e-6)
+}
+
+func TestPrizeRoutesRefusesTooManyStops(t *testing.T) {
+	t.Parallel()
+
+	_, err := prizeLine().WithExactStopLimit(2).Build().PrizeRoutes(t.Context(), 0)
+
+	assert.True(t, errors.Is(err, ErrPrizeTooManyStops))
+	assert.ErrorContains(t, err, "at most 2, got 3")
+}
+
+func TestBuilderWithPointsIsValueSemantic(t *testing.T) {
+	t.Parallel()
+
+	base := NewTspRouteBuilder().WithPoints("a", 2)
+	withB := base.WithPoints("b", 3)
+
+	assert.Equal(t, map[string]int{"a": 2}, base.Build().points)
+	assert.Equal(t, map[string]int{"a": 2, "b": 3}, withB.Build().points)
+}
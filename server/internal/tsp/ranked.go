@@ -0,0 +1,235 @@
+package tsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// The best order sometimes runs down a street the rider would rather avoid,
+// so they want to see what else is nearly as short. Held-Karp keeps one
+// cost per (subset, last stop); keeping the k cheapest instead, each pointing
+// back at the label it grew from, yields the k best distinct orders. That
+// multiplies the table by k, so it stays well inside the exact limit.
+
+// maxRankedStops is the most stops RankedRoutes will find alternatives for.
+const maxRankedStops = 14
+
+var (
+	// ErrRankedTooManyStops is returned when alternatives are asked for over
+	// more stops than RankedRoutes ranks, rather than quietly answering with
+	// one.
+	ErrRankedTooManyStops = errors.New("too many stops for alternatives")
+
+	// ErrRankedWithWindows is returned when alternatives are asked for on a
+	// route with opening hours, which RankedRoutes cannot rank.
+	ErrRankedWithWindows = errors.New("alternatives cannot be combined with opening hours")
+)
+
+// kLabel is one of the k cheapest ways to reach a DP state. prevLast is -1
+// for a path straight from the start.
+type kLabel struct {
+	cost     float64
+	prevLast int
+	prevRank int
+}
+
+// insertKBest adds l to labels, kept sorted and no longer than k.
+func insertKBest(labels []kLabel, l kLabel, k int) []kLabel {
+	if len(labels) == k && l.cost >= labels[k-1].cost {
+		return labels
+	}
+
+	i := sort.Search(len(labels), func(i int) bool { return labels[i].cost > l.cost })
+
+	if len(labels) < k {
+		labels = append(labels, kLabel{})
+	}
+
+	copy(labels[i+1:], labels[i:len(labels)-1])
+	labels[i] = l
+
+	return labels
+}
+
+// solveTSPKBest returns up to k distinct paths over dist from start through
+// every other node, cheapest first. With end < 0 a path may finish at any
+// node.
+func solveTSPKBest(ctx context.Context, dist [][]float64, start, end, k int, p precedence) [][]int {
+	n := len(dist)
+
+	intermediate := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if i != start && i != end {
+			intermediate = append(intermediate, i)
+		}
+	}
+
+	m := len(intermediate)
+	if m == 0 {
+		return nil
+	}
+
+	must := p.masks(intermediate, start)
+	labels := make([][]kLabel, (1<<m)*m)
+
+	for i, node := range intermediate {
+		if must[i] == 0 {
+			labels[(1<<i)*m+i] = []kLabel{{cost: dist[start][node], prevLast: -1}}
+		}
+	}
+
+	for mask := 1; mask < 1<<m; mask++ {
+		if cancelled(ctx, mask) {
+			return nil
+		}
+
+		for last := 0; last < m; last++ {
+			from := labels[mask*m+last]
+
+			if len(from) == 0 {
+				continue
+			}
+
+			for next := 0; next < m; next++ {
+				if mask&(1<<next) != 0 || mask&must[next] != must[next] {
+					continue
+				}
+
+				at := (mask|1<<next)*m + next
+				leg := dist[intermediate[last]][intermediate[next]]
+
+				for rank, l := range from {
+					labels[at] = insertKBest(labels[at], kLabel{cost: l.cost + leg, prevLast: last, prevRank: rank}, k)
+				}
+			}
+		}
+	}
+
+	// The finishing candidates are themselves labels: cost to the end, and
+	// which full-mask label they close.
+	full := 1<<m - 1
+	var finishes []kLabel
+
+	for last := 0; last < m; last++ {
+		for rank, l := range labels[full*m+last] {
+			cost := l.cost
+			if end >= 0 {
+				cost += dist[intermediate[last]][end]
+			}
+
+			finishes = insertKBest(finishes, kLabel{cost: cost, prevLast: last, prevRank: rank}, k)
+		}
+	}
+
+	paths := make([][]int, 0, len(finishes))
+
+	for _, f := range finishes {
+		reversed := make([]int, 0, n)
+		if end >= 0 {
+			reversed = append(reversed, end)
+		}
+
+		for mask, last, rank := full, f.prevLast, f.prevRank; last != -1; {
+			reversed = append(reversed, intermediate[last])
+
+			l := labels[mask*m+last][rank]
+			mask ^= 1 << last
+			last, rank = l.prevLast, l.prevRank
+		}
+
+		reversed = append(reversed, start)
+		reverse(reversed)
+
+		paths = append(paths, reversed)
+	}
+
+	return paths
+}
+
+// RankedRoutes returns up to k of the shortest distinct routes, best first,
+// each with its Rank and its Gap to the best. More than one route on a route
+// with opening hours fails with ErrRankedWithWindows, and past
+// maxRankedStops, or the exact limit, with ErrRankedTooManyStops; otherwise
+// it fails the same way Solve does.
+func (r tspRoute) RankedRoutes(ctx context.Context, k int) ([]optimalRoute, error) {
+	if k > 1 && r.hasWindows() {
+		return nil, ErrRankedWithWindows
+	}
+
+	if limit := min(maxRankedStops, r.exactLimit()); k > 1 && len(r.stops) > limit {
+		return nil, fmt.Errorf("%w: at most %d, got %d", ErrRankedTooManyStops, limit, len(r.stops))
+	}
+
+	if k <= 1 {
+		best, err := Solve(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+
+		best.Rank = 1
+
+		return []optimalRoute{best}, nil
+	}
+
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	allPlaces, dist := r.distances()
+
+	end := -1
+	if r.end != nil {
+		end = len(allPlaces) - 1
+	}
+
+	paths := solveTSPKBest(ctx, dist, 0, end, k, r.precedenceFor(allPlaces))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, ErrNoRoute
+	}
+
+	out := make([]optimalRoute, 0, len(paths))
+
+	// The solver's first path is the shortest there is by its own metric,
+	// so it bounds the rest, measured the same way.
+	shortest := pathCost(dist, paths[0])
+
+	for _, path := range paths {
+		or := r.routeAlong(allPlaces, path)
+		or.Algorithm = AlgorithmExact
+		or = r.timed(or, allPlaces, dist, path)
+
+		if shortest > 0 {
+			or.Bounded, or.OptimalityGap = true, pathCost(dist, path)/shortest-1
+		}
+
+		out = append(out, or)
+	}
+
+	// The solver ranked by its own metric; rank again by what the rider is
+	// told, so a longer route never sits above a shorter one.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Meters < out[j].Meters })
+
+	for i := range out {
+		out[i].Rank = i + 1
+		out[i].Gap = out[i].Meters - out[0].Meters
+	}
+
+	return out, nil
+}
+
+// pathCost is the length of path over dist.
+func pathCost(dist [][]float64, path []int) float64 {
+	var cost float64
+	for i := 1; i < len(path); i++ {
+		cost += dist[path[i-1]][path[i]]
+	}
+
+	return cost
+}
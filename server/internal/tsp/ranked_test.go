@@ -0,0 +1,196 @@
+package tsp
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// allPathCosts lists the cost of every path from start through all other
+// nodes, ending at end or, with end < 0, anywhere; cheapest first.
+func allPathCosts(dist [][]float64, start, end int) []float64 {
+	var rest []int
+	for i := range dist {
+		if i != start && i != end {
+			rest = append(rest, i)
+		}
+	}
+
+	var costs []float64
+
+	var permute func(cur, remaining []int)
+	permute = func(cur, remaining []int) {
+		if len(remaining) == 0 {
+			path := append([]int{start}, cur...)
+			if end >= 0 {
+				path = append(path, end)
+			}
+
+			costs = append(costs, pathDistance(dist, path))
+			return
+		}
+
+		for i := range remaining {
+			next := append(append([]int{}, remaining[:i]...), remaining[i+1:]...)
+			permute(append(cur, remaining[i]), next)
+		}
+	}
+
+	permute(nil, rest)
+	sort.Float64s(costs)
+
+	return costs
+}
+
+func TestInsertKBest(t *testing.T) {
+	t.Parallel()
+
+	var labels []kLabel
+	for _, c := range []float64{5, 1, 4, 2, 3} {
+		labels = insertKBest(labels, kLabel{cost: c}, 3)
+	}
+
+	require.Len(t, labels, 3)
+	assert.Equal(t, []float64{1, 2, 3}, []float64{labels[0].cost, labels[1].cost, labels[2].cost})
+}
+
+func TestSolveTSPKBestMatchesBruteForce(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		for _, end := range []int{6, -1} {
+			points := scatter(seed, 7)
+			dist := distanceMatrix(points)
+
+			want := allPathCosts(dist, 0, end)[:5]
+			got := solveTSPKBest(t.Context(), dist, 0, end, 5, nil)
+
+			require.Len(t, got, 5)
+
+			seen := map[string]bool{}
+			for i, path := range got {
+				assertVisitsEachOnce(t, path, len(points))
+				assert.InDelta(t, want[i], pathDistance(dist, path), 1e-6, "seed %d end %d rank %d", seed, end, i+1)
+
+				key := fmt.Sprint(path)
+				assert.False(t, seen[key], "%v returned twice", path)
+				seen[key] = true
+			}
+		}
+	}
+}
+
+func TestSolveTSPKBestAgreesWithExactOnTheBest(t *testing.T) {
+	t.Parallel()
+
+	points := scatter(9, 10)
+	dist := distanceMatrix(points)
+	end := len(points) - 1
+
+	got := solveTSPKBest(t.Context(), dist, 0, end, 3, nil)
+
+	require.NotEmpty(t, got)
+	assert.InDelta(t, pathDistance(dist, solveTSPExact(t.Context(), dist, 0, end, nil)), pathDistance(dist, got[0]), 1e-6)
+}
+
+func TestSolveTSPKBestReturnsFewerWhenThereAreFewer(t *testing.T) {
+	t.Parallel()
+
+	// Two stops between a fixed start and end can only go two ways.
+	got := solveTSPKBest(t.Context(), distanceMatrix(scatter(2, 4)), 0, 3, 5, nil)
+
+	assert.Len(t, got, 2)
+}
+
+func TestSolveTSPKBestRespectsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	points := scatter(4, 7)
+	dist := distanceMatrix(points)
+	p := randomPrecedence(4, len(points), 3)
+
+	for _, path := range solveTSPKBest(t.Context(), dist, 0, len(points)-1, 10, p) {
+		assert.True(t, p.allows(path), "%v", path)
+	}
+}
+
+func TestRankedRoutesRanksByGap(t *testing.T) {
+	t.Parallel()
+
+	b := NewTspRouteBuilder().WithStart("s", 39.95, -75.16).WithEnd("e", 39.96, -75.17)
+	for i, p := range scatter(5, 6) {
+		b = b.AddStop(fmt.Sprintf("p%d", i), 39.95+p.lat/1e6, -75.16+p.long/1e6)
+	}
+
+	r := b.Build()
+	got, err := r.RankedRoutes(t.Context(), 3)
+	require.NoError(t, err)
+
+	require.Len(t, got, 3)
+	assert.InDelta(t, r.mustSolve(t).Meters, got[0].Meters, 1e-6)
+
+	for i, or := range got {
+		assert.Equal(t, i+1, or.Rank)
+		assert.Equal(t, AlgorithmExact, or.Algorithm)
+		assert.InDelta(t, or.Meters-got[0].Meters, or.Gap, 1e-9)
+		assert.GreaterOrEqual(t, or.Gap, 0.0)
+		assert.True(t, or.Bounded)
+		assert.GreaterOrEqual(t, or.OptimalityGap, 0.0)
+	}
+
+	// The bound is the solver's own best, whichever route the rider's
+	// metres put first.
+	assert.True(t, slices.ContainsFunc(got, func(or optimalRoute) bool { return or.OptimalityGap == 0 }))
+
+	assert.NotEqual(t, stopIds(got[0]), stopIds(got[1]), "alternatives are distinct orders")
+}
+
+func TestRankedRoutesFallsBackToOneRoute(t *testing.T) {
+	t.Parallel()
+
+	t.Run("one asked for", func(t *testing.T) {
+		t.Parallel()
+
+		got, err := lineRoute().Build().RankedRoutes(t.Context(), 1)
+		require.NoError(t, err)
+
+		require.Len(t, got, 1)
+		assert.Equal(t, 1, got[0].Rank)
+	})
+
+	t.Run("opening hours", func(t *testing.T) {
+		t.Parallel()
+
+		r := lineRoute().WithTimeWindow("a", raceStart, time.Time{}).Build()
+
+		_, err := r.RankedRoutes(t.Context(), 3)
+		assert.ErrorIs(t, err, ErrRankedWithWindows, "rather than one route where three were asked for")
+
+		got, err := r.RankedRoutes(t.Context(), 1)
+		require.NoError(t, err)
+
+		require.Len(t, got, 1)
+		assert.NotEmpty(t, got[0].Arrivals)
+	})
+
+	t.Run("too many stops", func(t *testing.T) {
+		t.Parallel()
+
+		b := NewTspRouteBuilder().WithStart("s", 39.95, -75.16)
+		for i, p := range scatter(6, maxRankedStops+1) {
+			b = b.AddStop(fmt.Sprintf("p%d", i), 39.95+p.lat/1e6, -75.16+p.long/1e6)
+		}
+
+		_, err := b.Build().RankedRoutes(t.Context(), 3)
+		assert.ErrorIs(t, err, ErrRankedTooManyStops, "rather than one route where three were asked for")
+
+		got, err := b.Build().RankedRoutes(t.Context(), 1)
+		require.NoError(t, err)
+		assert.Len(t, got, 1)
+	})
+}
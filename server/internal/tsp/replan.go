@@ -0,0 +1,62 @@
+package tsp
+
+// FromPosition turns the route into what is left of it for a rider partway
+// round: the ride now starts at lat, long under positionId, and the visited
+// stops are done. A loop still finishes where it began, and a fixed end
+// stays put.
+//
+// Rules about the original start or a visited stop are dropped. One whose
+// first place has been visited is already kept; one whose second has been
+// visited first is already broken, and no order of what is left can mend
+// it. Opening hours of visited stops are dropped with them.
+func (b TspRouteBuilder) FromPosition(positionId string, lat, long float64, visited ...string) TspRouteBuilder {
+	done := make(map[string]bool, len(visited)+1)
+	for _, id := range visited {
+		done[id] = true
+	}
+
+	done[b.r.start.Id] = true
+
+	if b.r.loop {
+		home := b.r.start
+		b.r.end = &home
+		b.r.loop = false
+	}
+
+	b.r.start = place{positionId, long, lat}
+
+	stops := make([]place, 0, len(b.r.stops))
+	for _, s := range b.r.stops {
+		if !done[s.Id] {
+			stops = append(stops, s)
+		}
+	}
+
+	orderings := make([]ordering, 0, len(b.r.orderings))
+	for _, o := range b.r.orderings {
+		if !done[o.before] && !done[o.after] {
+			orderings = append(orderings, o)
+		}
+	}
+
+	// With every stop visited the ride only has its finish left, which the
+	// solver takes as a route with one stop and nowhere after it.
+	if len(stops) == 0 && b.r.end != nil {
+		stops = append(stops, *b.r.end)
+		b.r.end = nil
+	}
+
+	// Opening hours only matter for the places still to come.
+	windows := make(map[string]window, len(b.r.windows))
+	for id, w := range b.r.windows {
+		if !done[id] {
+			windows[id] = w
+		}
+	}
+
+	b.r.stops = stops
+	b.r.orderings = orderings
+	b.r.windows = windows
+
+	return b
+}
@@ -0,0 +1,105 @@
+package tsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// manifest is a ride north from s through a, b and c, one hundredth of a
+// degree apart.
+func manifest() TspRouteBuilder {
+	return NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		AddStop("b", 39.97, -75.16).
+		AddStop("c", 39.98, -75.16)
+}
+
+func TestFromPositionLeavesOutVisitedStops(t *testing.T) {
+	t.Parallel()
+
+	// Between a and b, with a done.
+	got := manifest().FromPosition("here", 39.965, -75.16, "a").Build().mustSolve(t)
+
+	assert.Equal(t, []string{"b"}, stopIds(got))
+	assert.Equal(t, "c", got.End.Id)
+	assert.InDelta(t, 1665, got.Meters, 20, "from halfway to b, then on to c")
+}
+
+func TestFromPositionDropsVisitedOpeningHours(t *testing.T) {
+	t.Parallel()
+
+	r := manifest().
+		WithStartTime(raceStart).
+		WithTimeWindow("a", time.Time{}, raceStart.Add(time.Minute)).
+		FromPosition("here", 39.965, -75.16, "a").
+		Build()
+
+	assert.False(t, r.hasWindows(), "a's hours went with it")
+}
+
+func TestFromPositionKeepsTheFinish(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fixed end", func(t *testing.T) {
+		t.Parallel()
+
+		got := manifest().WithEnd("e", 39.99, -75.16).FromPosition("here", 39.965, -75.16, "a").Build().mustSolve(t)
+
+		assert.Equal(t, []string{"b", "c"}, stopIds(got))
+		assert.Equal(t, "e", got.End.Id)
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		t.Parallel()
+
+		got := manifest().AsLoop().FromPosition("here", 39.975, -75.16, "a", "b").Build().mustSolve(t)
+
+		assert.Equal(t, []string{"c"}, stopIds(got))
+		assert.Equal(t, "s", got.End.Id, "a loop still goes home to the origin")
+	})
+
+	t.Run("nothing left but the finish", func(t *testing.T) {
+		t.Parallel()
+
+		got := manifest().AsLoop().FromPosition("here", 39.985, -75.16, "a", "b", "c").Build().mustSolve(t)
+
+		assert.Empty(t, got.Stops)
+		assert.Equal(t, "s", got.End.Id)
+	})
+}
+
+func TestFromPositionDropsRulesItCannotApply(t *testing.T) {
+	t.Parallel()
+
+	b := manifest().
+		WithPrecedence("s", "c").
+		WithPrecedence("a", "c").
+		WithPrecedence("b", "a").
+		WithPrecedence("c", "b").
+		FromPosition("here", 39.975, -75.16, "a")
+
+	// s and a are behind the rider; c before b still stands.
+	r := b.Build()
+	require.NoError(t, r.validate())
+	assert.Equal(t, []ordering{{before: "c", after: "b"}}, r.orderings)
+
+	got := r.mustSolve(t)
+	assert.Equal(t, []string{"c"}, stopIds(got))
+	assert.Equal(t, "b", got.End.Id)
+}
+
+func TestFromPositionDoesNotChangeTheManifest(t *testing.T) {
+	t.Parallel()
+
+	base := manifest().WithPrecedence("a", "b").AsLoop()
+	_ = base.FromPosition("here", 39.965, -75.16, "a")
+
+	assert.Len(t, base.r.stops, 3)
+	assert.Len(t, base.r.orderings, 1)
+	assert.True(t, base.r.loop)
+	assert.Equal(t, "s", base.r.start.Id)
+}
@@ -1,8 +1,13 @@
 package tsp
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"math/bits"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -115,17 +120,18 @@ func TestConvertDegreesToMetersHandlesNilEnd(t *testing.T) {
 	assert.Len(t, out.stops, 2)
 }
 
-// --- solveTSPExact --------------------------------------------------------
+// mustSolve is Solve for routes a test knows are valid.
+func (r tspRoute) mustSolve(t *testing.T) optimalRoute {
+	t.Helper()
 
-// pathCost sums the legs of an ordered index path.
-func pathCost(dist [][]float64, path []int) float64 {
-	var total float64
-	for i := 0; i < len(path)-1; i++ {
-		total += dist[path[i]][path[i+1]]
-	}
-	return total
+	or, err := Solve(t.Context(), r)
+	require.NoError(t, err)
+
+	return or
 }
 
+// --- solveTSPExact --------------------------------------------------------
+
 // bruteForceBest exhaustively permutes the intermediate nodes.
 func bruteForceBest(dist [][]float64, start, end int) float64 {
 	n := len(dist)
@@ -144,7 +150,7 @@ func bruteForceBest(dist [][]float64, start, end int) float64 {
 		if len(remaining) == 0 {
 			path := append([]int{start}, cur...)
 			path = append(path, end)
-			if c := pathCost(dist, path); c < best {
+			if c := pathDistance(dist, path); c < best {
 				best = c
 			}
 			return
@@ -192,13 +198,13 @@ func TestSolveTSPExactFindsOptimalPath(t *testing.T) {
 
 	dist := distanceMatrix(points)
 
-	got := solveTSPExact(dist, 0, len(points)-1)
+	got := solveTSPExact(t.Context(), dist, 0, len(points)-1, nil)
 
 	require.Len(t, got, len(points))
 	assert.Equal(t, 0, got[0], "path must begin at the start index")
 	assert.Equal(t, len(points)-1, got[len(got)-1], "path must end at the end index")
 
-	assert.InDelta(t, bruteForceBest(dist, 0, len(points)-1), pathCost(dist, got), 1e-9)
+	assert.InDelta(t, bruteForceBest(dist, 0, len(points)-1), pathDistance(dist, got), 1e-9)
 }
 
 func TestSolveTSPExactMatchesBruteForceAcrossLayouts(t *testing.T) {
@@ -227,17 +233,116 @@ func TestSolveTSPExactMatchesBruteForceAcrossLayouts(t *testing.T) {
 		dist := distanceMatrix(points)
 		end := len(points) - 1
 
-		got := solveTSPExact(dist, 0, end)
+		got := solveTSPExact(t.Context(), dist, 0, end, nil)
 
 		assert.InDelta(t,
 			bruteForceBest(dist, 0, end),
-			pathCost(dist, got),
+			pathDistance(dist, got),
 			1e-9,
 			"layout %d should be solved optimally", i,
 		)
 	}
 }
 
+func TestFillHeldKarpIsTheSameOnAnyNumberOfWorkers(t *testing.T) {
+	t.Parallel()
+
+	// Fourteen intermediates make the middle layers several chunks' worth
+	// of masks, so four workers really do split them between them.
+	points := scatter(3, 16)
+	dist := distanceMatrix(points)
+	p := randomPrecedence(3, len(points), 4)
+
+	one := fillHeldKarpOn(t.Context(), 1, dist, 0, len(points)-1, p)
+	four := fillHeldKarpOn(t.Context(), 4, dist, 0, len(points)-1, p)
+
+	require.Greater(t, widestLayer(one.m), 2*heldKarpChunk)
+	assert.Equal(t, one.cost, four.cost)
+	assert.Equal(t, one.parent, four.parent)
+}
+
+func TestExactSolvesWaitForTableMemory(t *testing.T) {
+	// Not parallel: it holds all the memory the exact solvers share.
+	require.NoError(t, exactTables.Acquire(t.Context(), maxExactTableBytes))
+
+	held := true
+	defer func() {
+		if held {
+			exactTables.Release(maxExactTableBytes)
+		}
+	}()
+
+	r := prizeLine().WithSpeed(10).WithTimeBudget(200 * time.Second).Build()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := r.PrizeRoutes(ctx, 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "no room for its table")
+
+	exactTables.Release(maxExactTableBytes)
+	held = false
+
+	_, err = r.PrizeRoutes(t.Context(), 0)
+	assert.NoError(t, err)
+}
+
+func TestAppendLayer(t *testing.T) {
+	t.Parallel()
+
+	const m = 10
+
+	total := 0
+
+	for layer := 0; layer <= m; layer++ {
+		masks := appendLayer(nil, layer, 1<<m)
+
+		assert.True(t, slices.IsSorted(masks), "layer %d", layer)
+
+		for _, mask := range masks {
+			assert.Equal(t, layer, bits.OnesCount(uint(mask)), "mask %b", mask)
+		}
+
+		total += len(masks)
+	}
+
+	assert.Equal(t, 1<<m, total, "every mask exactly once")
+	assert.Equal(t, 252, widestLayer(m))
+	assert.Len(t, appendLayer(nil, m/2, 1<<m), widestLayer(m))
+}
+
+func TestSolveTSPExactOpenFinishIsTheBestFixedFinish(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		dist := distanceMatrix(scatter(seed, 8))
+
+		best := math.Inf(1)
+		for end := 1; end < len(dist); end++ {
+			best = math.Min(best, pathDistance(dist, solveTSPExact(t.Context(), dist, 0, end, nil)))
+		}
+
+		got := solveTSPExact(t.Context(), dist, 0, -1, nil)
+
+		assertVisitsEachOnce(t, got, len(dist))
+		assert.InDelta(t, best, pathDistance(dist, got), 1e-3, "seed %d", seed)
+	}
+}
+
+func BenchmarkSolveTSPExact(b *testing.B) {
+	for _, stops := range []int{12, 16, 18, 20} {
+		dist := distanceMatrix(scatter(1, stops+2))
+
+		b.Run(fmt.Sprintf("%d stops", stops), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for range b.N {
+				solveTSPExact(context.Background(), dist, 0, stops+1, nil)
+			}
+		})
+	}
+}
+
 func TestSolveTSPExactVisitsEveryNodeExactlyOnce(t *testing.T) {
 	t.Parallel()
 
@@ -246,7 +351,7 @@ func TestSolveTSPExactVisitsEveryNodeExactlyOnce(t *testing.T) {
 		{long: 8, lat: 1}, {long: 5, lat: -3}, {long: 9, lat: 9},
 	}
 
-	got := solveTSPExact(distanceMatrix(points), 0, len(points)-1)
+	got := solveTSPExact(t.Context(), distanceMatrix(points), 0, len(points)-1, nil)
 
 	require.Len(t, got, len(points))
 
@@ -268,10 +373,10 @@ func TestSolveTSPExactIsDeterministic(t *testing.T) {
 	}
 	dist := distanceMatrix(points)
 
-	first := solveTSPExact(dist, 0, 3)
+	first := solveTSPExact(t.Context(), dist, 0, 3, nil)
 
 	for range 20 {
-		assert.Equal(t, first, solveTSPExact(dist, 0, 3))
+		assert.Equal(t, first, solveTSPExact(t.Context(), dist, 0, 3, nil))
 	}
 }
 
@@ -284,18 +389,18 @@ func TestSolveTSPExactSingleIntermediate(t *testing.T) {
 		{Id: "e", long: 2, lat: 2},
 	}
 
-	got := solveTSPExact(distanceMatrix(points), 0, 2)
+	got := solveTSPExact(t.Context(), distanceMatrix(points), 0, 2, nil)
 
 	assert.Equal(t, []int{0, 1, 2}, got)
 }
 
-// --- OptimalRoutes --------------------------------------------------------
+// --- Solve ----------------------------------------------------------------
 
 func routeFrom(start place, end *place, stops ...place) tspRoute {
 	return tspRoute{start: start, end: end, stops: stops}
 }
 
-func TestOptimalRoutesWithFixedEnd(t *testing.T) {
+func TestSolveWithFixedEnd(t *testing.T) {
 	t.Parallel()
 
 	start := place{Id: "start", lat: 39.95, long: -75.18}
@@ -307,7 +412,7 @@ func TestOptimalRoutesWithFixedEnd(t *testing.T) {
 		{Id: "c", lat: 39.98, long: -75.21},
 	}
 
-	got := routeFrom(start, &end, stops...).convertDegreesToMeters().OptimalRoutes()
+	got := routeFrom(start, &end, stops...).convertDegreesToMeters().mustSolve(t)
 
 	assert.Equal(t, "end", got.End.Id, "a fixed end must be honoured")
 	require.Len(t, got.Stops, len(stops))
@@ -320,7 +425,7 @@ func TestOptimalRoutesWithFixedEnd(t *testing.T) {
 	assert.Positive(t, got.Meters)
 }
 
-func TestOptimalRoutesWithoutEndChoosesAStopAsFinish(t *testing.T) {
+func TestSolveWithoutEndChoosesAStopAsFinish(t *testing.T) {
 	t.Parallel()
 
 	start := place{Id: "start", lat: 39.95, long: -75.18}
@@ -331,7 +436,7 @@ func TestOptimalRoutesWithoutEndChoosesAStopAsFinish(t *testing.T) {
 		{Id: "c", lat: 39.98, long: -75.21},
 	}
 
-	got := routeFrom(start, nil, stops...).convertDegreesToMeters().OptimalRoutes()
+	got := routeFrom(start, nil, stops...).convertDegreesToMeters().mustSolve(t)
 
 	assert.Contains(t, []string{"a", "b", "c"}, got.End.Id)
 
@@ -344,7 +449,7 @@ func TestOptimalRoutesWithoutEndChoosesAStopAsFinish(t *testing.T) {
 	}
 }
 
-func TestOptimalRoutesNeverIncludesStartAsAStop(t *testing.T) {
+func TestSolveNeverIncludesStartAsAStop(t *testing.T) {
 	t.Parallel()
 
 	start := place{Id: "start", lat: 39.95, long: -75.18}
@@ -353,7 +458,7 @@ func TestOptimalRoutesNeverIncludesStartAsAStop(t *testing.T) {
 	got := routeFrom(start, &end,
 		place{Id: "a", lat: 39.96, long: -75.19},
 		place{Id: "b", lat: 39.97, long: -75.20},
-	).convertDegreesToMeters().OptimalRoutes()
+	).convertDegreesToMeters().mustSolve(t)
 
 	for _, s := range got.Stops {
 		assert.NotEqual(t, "start", s.Id)
@@ -361,11 +466,11 @@ func TestOptimalRoutesNeverIncludesStartAsAStop(t *testing.T) {
 	}
 }
 
-func TestOptimalRoutesAppliesDistanceFudgeFactor(t *testing.T) {
+func TestSolveReportsGeodesicMeters(t *testing.T) {
 	t.Parallel()
 
-	// Straight-line distance underestimates road distance, so the solver pads
-	// its estimate by 10%.
+	// Whatever metric picked the order, the distance reported to the rider
+	// is measured on the ellipsoid rather than padded from a projection.
 	start := place{Id: "start", lat: 0, long: 0}
 	end := place{Id: "end", lat: 0, long: 0.03}
 
@@ -374,21 +479,13 @@ func TestOptimalRoutesAppliesDistanceFudgeFactor(t *testing.T) {
 		place{Id: "b", lat: 0, long: 0.02},
 	).convertDegreesToMeters()
 
-	got := r.OptimalRoutes()
-
-	// Recompute the raw path length the solver would have measured.
-	var raw float64
-	prev := r.start
-	for _, s := range got.Stops {
-		raw += prev.euclideanDistanceTo(s)
-		prev = s
-	}
-	raw += prev.euclideanDistanceTo(got.End)
+	got := r.mustSolve(t)
 
-	assert.InDelta(t, raw*1.1, got.Meters, 1e-6)
+	// 0.03 degrees of longitude along the equator.
+	assert.InDelta(t, vincentyMeters(0, 0, 0, 0.03), got.Meters, 1e-3)
 }
 
-func TestOptimalRoutesIsDeterministic(t *testing.T) {
+func TestSolveIsDeterministic(t *testing.T) {
 	t.Parallel()
 
 	start := place{Id: "start", lat: 39.95, long: -75.18}
@@ -401,10 +498,10 @@ func TestOptimalRoutesIsDeterministic(t *testing.T) {
 		{Id: "d", lat: 39.99, long: -75.22},
 	}
 
-	first := routeFrom(start, &end, stops...).convertDegreesToMeters().OptimalRoutes()
+	first := routeFrom(start, &end, stops...).convertDegreesToMeters().mustSolve(t)
 
 	for range 10 {
-		again := routeFrom(start, &end, stops...).convertDegreesToMeters().OptimalRoutes()
+		again := routeFrom(start, &end, stops...).convertDegreesToMeters().mustSolve(t)
 
 		assert.Equal(t, first.End.Id, again.End.Id)
 		assert.InDelta(t, first.Meters, again.Meters, 1e-9)
@@ -416,7 +513,7 @@ func TestOptimalRoutesIsDeterministic(t *testing.T) {
 	}
 }
 
-func TestOptimalRoutesPicksShorterOfTwoObviousFinishes(t *testing.T) {
+func TestSolvePicksShorterOfTwoObviousFinishes(t *testing.T) {
 	t.Parallel()
 
 	// Two stops sit close to the start and one is far away; finishing at the
@@ -427,11 +524,40 @@ func TestOptimalRoutesPicksShorterOfTwoObviousFinishes(t *testing.T) {
 		place{Id: "near1", lat: 0, long: 0.001},
 		place{Id: "near2", lat: 0, long: 0.002},
 		place{Id: "far", lat: 0, long: 0.05},
-	).convertDegreesToMeters().OptimalRoutes()
+	).convertDegreesToMeters().mustSolve(t)
 
 	assert.Equal(t, "far", got.End.Id)
 }
 
+func TestSolveLoopReturnsToStart(t *testing.T) {
+	t.Parallel()
+
+	for _, limit := range []int{0, 1} {
+		// Out west to c and east to b, whichever way round: each side of the
+		// start is ridden twice and no further.
+		got := prizeLine().AsLoop().WithExactStopLimit(limit).Build().mustSolve(t)
+
+		assert.Equal(t, "s", got.End.Id, "limit %d", limit)
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, stopIds(got), "the start is not a stop")
+		assert.Equal(t, 7000.0, got.Meters, "the ride home is counted once")
+	}
+}
+
+func TestBuilderAsLoopReplacesTheEnd(t *testing.T) {
+	t.Parallel()
+
+	r := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		AddStop("b", 39.97, -75.16).
+		WithEnd("e", 39.99, -75.16).
+		AsLoop().
+		Build()
+
+	require.NotNil(t, r.end)
+	assert.Equal(t, r.start, *r.end)
+}
+
 func TestBuilderProducesProjectedRoute(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,542 @@
+package tsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// Team alleycats split the manifest between a few riders who meet at the
+// finish, and the team is only done when its last rider is. So the split
+// wants the longest single route as short as possible, not the least riding
+// overall. Stops are first handed out to whichever rider they leave shortest,
+// then moved or swapped off the longest route for as long as that shortens
+// it. Each rider's share is ordered by the same solvers as a solo route.
+
+var (
+	// ErrTooManyRiders is returned past maxRiders.
+	ErrTooManyRiders = errors.New("too many riders")
+
+	// ErrTeamUnsupported is returned for a team route that also asks for
+	// something TeamRoutes cannot honour across riders.
+	ErrTeamUnsupported = errors.New("not supported for a team")
+)
+
+const (
+	// maxRiders is the most riders TeamRoutes splits a route between.
+	// Alleycat teams are two or three.
+	maxRiders = 6
+
+	// teamSearchExactStops is the largest share the search orders exactly
+	// after a move. Past it the heuristic does, which is quick enough to run
+	// hundreds of times.
+	teamSearchExactStops = 8
+
+	// maxTeamExactStops caps Held-Karp when each share is ordered at the
+	// end, since a team solve runs it once per rider.
+	maxTeamExactStops = 16
+
+	// teamMoves caps how many moves the search makes.
+	teamMoves = 500
+)
+
+// riderCount is how many riders the route is split between.
+func (r tspRoute) riderCount() int {
+	return max(r.riders, len(r.riderStarts), 1)
+}
+
+// riderStart is where rider i leaves from.
+func (r tspRoute) riderStart(i int) place {
+	if i < len(r.riderStarts) {
+		return r.riderStarts[i]
+	}
+
+	return r.start
+}
+
+// validateTeam reports the first thing that keeps the route from being
+// split, after validate has passed.
+func (r tspRoute) validateTeam() error {
+	if n := r.riderCount(); n > maxRiders {
+		return fmt.Errorf("%w: at most %d, got %d", ErrTooManyRiders, maxRiders, n)
+	}
+
+	if r.hasWindows() {
+		return fmt.Errorf("%w: opening hours", ErrTeamUnsupported)
+	}
+
+	if r.budgetMeters > 0 || r.budgetSeconds > 0 {
+		return fmt.Errorf("%w: a budget", ErrTeamUnsupported)
+	}
+
+	seen := make(map[string]bool, len(r.stops)+len(r.riderStarts)+2)
+	seen[r.start.Id] = true
+
+	for _, s := range r.stops {
+		seen[s.Id] = true
+	}
+
+	if r.end != nil {
+		seen[r.end.Id] = true
+	}
+
+	for _, p := range r.riderStarts {
+		if seen[p.Id] {
+			return fmt.Errorf("%w: %q", ErrDuplicateId, p.Id)
+		}
+
+		seen[p.Id] = true
+
+		if lat, long := r.degrees(p); !finite(lat) || !finite(long) {
+			return fmt.Errorf("%w: place %q", ErrInvalidCoordinates, p.Id)
+		}
+	}
+
+	return nil
+}
+
+// TeamRoutes splits the stops between the route's riders so that the
+// longest of their routes is as short as it can find, and returns one route
+// per rider, in rider order. Everyone finishes at the route's end, or back
+// at the start for a loop; without either, each rider stops at their last
+// stop. A rider may be given no stops at all when that is what keeps the
+// longest route down. Stops tied by a precedence rule always go to the same
+// rider. It fails the same way Solve does.
+func (r tspRoute) TeamRoutes(ctx context.Context) ([]optimalRoute, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := r.validateTeam(); err != nil {
+		return nil, err
+	}
+
+	t := r.newTeam()
+	t.split(ctx)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]optimalRoute, 0, len(t.shares))
+
+	for i, share := range t.shares {
+		start := r.riderStart(i)
+
+		stops := make([]place, len(share))
+		for j, s := range share {
+			stops[j] = r.stops[s]
+		}
+
+		or, err := r.forRider(ctx, start, stops)
+		if err != nil {
+			return nil, err
+		}
+
+		or.Rider = i + 1
+		out = append(out, or)
+	}
+
+	return out, nil
+}
+
+// forRider orders one rider's share with Solve.
+func (r tspRoute) forRider(ctx context.Context, start place, stops []place) (optimalRoute, error) {
+	if len(stops) == 0 {
+		// Straight to the finish, or nowhere at all.
+		if r.end == nil {
+			return optimalRoute{End: start, Algorithm: AlgorithmExact, Bounded: true}, nil
+		}
+
+		both := []place{start, *r.end}
+		dist := [][]float64{{0, r.distance(start, *r.end)}, {r.distance(*r.end, start), 0}}
+
+		or := r.timed(r.routeAlong(both, []int{0, 1}), both, dist, []int{0, 1})
+		or.Algorithm, or.Bounded = AlgorithmExact, true
+
+		return or, nil
+	}
+
+	sub := r
+	sub.start, sub.stops = start, stops
+	sub.riders, sub.riderStarts = 0, nil
+	sub.exactStopLimit = min(r.exactLimit(), maxTeamExactStops)
+
+	// A rider leaving from the team's start finishes a loop where they
+	// began; anyone else rides to it as a fixed end.
+	sub.loop = r.loop && start.Id == r.start.Id
+
+	mine := make(map[string]bool, len(stops))
+	for _, s := range stops {
+		mine[s.Id] = true
+	}
+
+	sub.orderings = nil
+	for _, o := range r.orderings {
+		if mine[o.before] && mine[o.after] {
+			sub.orderings = append(sub.orderings, o)
+		}
+	}
+
+	return Solve(ctx, sub)
+}
+
+// team is a split of the stops being searched. Nodes index one distance
+// matrix: the stops first, then each rider's start, then the finish.
+type team struct {
+	dist   [][]float64
+	starts []int
+	end    int // -1 without a finish
+
+	// before lists, for each stop, the stops that must come ahead of it.
+	// groups are the stops the rules tie together, which move as one, and
+	// groupOf is each stop's group.
+	before  [][]int
+	groups  [][]int
+	groupOf []int
+
+	// shares are each rider's stops in riding order, and lengths what each
+	// rides.
+	shares  [][]int
+	lengths []float64
+}
+
+func (r tspRoute) newTeam() *team {
+	n, k := len(r.stops), r.riderCount()
+
+	nodes := make([]place, 0, n+k+1)
+	nodes = append(nodes, r.stops...)
+
+	t := &team{end: -1}
+
+	for i := range k {
+		t.starts = append(t.starts, len(nodes))
+		nodes = append(nodes, r.riderStart(i))
+	}
+
+	if r.end != nil {
+		t.end = len(nodes)
+		nodes = append(nodes, *r.end)
+	}
+
+	t.dist = make([][]float64, len(nodes))
+	for i := range nodes {
+		t.dist[i] = make([]float64, len(nodes))
+		for j := range nodes {
+			t.dist[i][j] = r.distance(nodes[i], nodes[j])
+		}
+	}
+
+	index := make(map[string]int, n)
+	for i, s := range r.stops {
+		index[s.Id] = i
+	}
+
+	// Rules about the start or the end hold for every rider whatever the
+	// split, so only rules between two stops tie anything together.
+	t.before = make([][]int, n)
+	root := make([]int, n)
+
+	for i := range root {
+		root[i] = i
+	}
+
+	var find func(int) int
+	find = func(i int) int {
+		if root[i] != i {
+			root[i] = find(root[i])
+		}
+
+		return root[i]
+	}
+
+	for _, o := range r.orderings {
+		a, okA := index[o.before]
+		b, okB := index[o.after]
+
+		if okA && okB {
+			t.before[b] = append(t.before[b], a)
+			root[find(a)] = find(b)
+		}
+	}
+
+	t.groupOf = make([]int, n)
+	byRoot := make(map[int]int, n)
+
+	for i := range n {
+		g, ok := byRoot[find(i)]
+		if !ok {
+			g = len(t.groups)
+			byRoot[find(i)] = g
+			t.groups = append(t.groups, nil)
+		}
+
+		t.groups[g] = append(t.groups[g], i)
+		t.groupOf[i] = g
+	}
+
+	t.shares = make([][]int, k)
+	t.lengths = make([]float64, k)
+
+	return t
+}
+
+// length is what rider rides through order.
+func (t *team) length(rider int, order []int) float64 {
+	prev := t.starts[rider]
+	total := 0.0
+
+	for _, s := range order {
+		total += t.dist[prev][s]
+		prev = s
+	}
+
+	if t.end >= 0 {
+		total += t.dist[prev][t.end]
+	}
+
+	return total
+}
+
+// insert returns order with each stop in g put wherever it adds least.
+func (t *team) insert(rider int, order, g []int) []int {
+	out := append(make([]int, 0, len(order)+len(g)), order...)
+
+	for _, s := range g {
+		best, at := math.Inf(1), 0
+
+		for i := 0; i <= len(out); i++ {
+			prev := t.starts[rider]
+			if i > 0 {
+				prev = out[i-1]
+			}
+
+			var added float64
+
+			switch {
+			case i < len(out):
+				added = t.dist[prev][s] + t.dist[s][out[i]] - t.dist[prev][out[i]]
+			case t.end >= 0:
+				added = t.dist[prev][s] + t.dist[s][t.end] - t.dist[prev][t.end]
+			default:
+				added = t.dist[prev][s]
+			}
+
+			if added < best-improvementEpsilon {
+				best, at = added, i
+			}
+		}
+
+		out = append(out[:at], append([]int{s}, out[at:]...)...)
+	}
+
+	return out
+}
+
+// without returns order less the stops in g.
+func without(order, g []int) []int {
+	out := make([]int, 0, len(order))
+
+	for _, s := range order {
+		if !slices.Contains(g, s) {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+// reorder finds a short order for rider through share that keeps to the
+// rules, the way Solve would for a solo route of that size.
+func (t *team) reorder(ctx context.Context, rider int, share []int) []int {
+	if len(share) == 0 {
+		return nil
+	}
+
+	nodes := make([]int, 0, len(share)+2)
+	nodes = append(nodes, t.starts[rider])
+	nodes = append(nodes, share...)
+
+	end := -1
+	if t.end >= 0 {
+		end = len(nodes)
+		nodes = append(nodes, t.end)
+	}
+
+	sub := make([][]float64, len(nodes))
+	for i, a := range nodes {
+		sub[i] = make([]float64, len(nodes))
+		for j, b := range nodes {
+			sub[i][j] = t.dist[a][b]
+		}
+	}
+
+	at := make(map[int]int, len(share))
+	for i, s := range share {
+		at[s] = i + 1
+	}
+
+	var p precedence
+
+	for i, s := range share {
+		for _, q := range t.before[s] {
+			if j, ok := at[q]; ok {
+				if p == nil {
+					p = make(precedence, len(nodes))
+				}
+
+				p[i+1] = append(p[i+1], j)
+			}
+		}
+	}
+
+	var path []int
+	if len(share) <= teamSearchExactStops {
+		path = solveTSPExact(ctx, sub, 0, end, p)
+	} else {
+		path = solveHeuristicFrom(ctx, sub, 0, end >= 0, p)
+	}
+
+	if path == nil {
+		return share
+	}
+
+	out := make([]int, 0, len(share))
+	for _, i := range path[1:] {
+		if i != end {
+			out = append(out, nodes[i])
+		}
+	}
+
+	return out
+}
+
+// longest is the rider who rides furthest.
+func (t *team) longest() int {
+	long := 0
+	for i, l := range t.lengths {
+		if l > t.lengths[long] {
+			long = i
+		}
+	}
+
+	return long
+}
+
+// split hands out the stops, then shortens the longest route until no move
+// does, the move budget is spent or ctx is done.
+func (t *team) split(ctx context.Context) {
+	// The stops furthest from the first start go first, while every rider
+	// is still free to take them; nearer ones fill in around them.
+	order := make([]int, len(t.groups))
+	for i := range order {
+		order[i] = i
+	}
+
+	far := func(g int) float64 { return t.dist[t.starts[0]][t.groups[g][0]] }
+	sort.SliceStable(order, func(i, j int) bool { return far(order[i]) > far(order[j]) })
+
+	for _, g := range order {
+		best, to := math.Inf(1), 0
+		var share []int
+
+		for rider := range t.shares {
+			s := t.insert(rider, t.shares[rider], t.groups[g])
+
+			if l := t.length(rider, s); l < best-improvementEpsilon {
+				best, to, share = l, rider, s
+			}
+		}
+
+		t.shares[to], t.lengths[to] = share, best
+	}
+
+	for rider, share := range t.shares {
+		t.shares[rider] = t.reorder(ctx, rider, share)
+		t.lengths[rider] = t.length(rider, t.shares[rider])
+	}
+
+	for range teamMoves {
+		if ctx.Err() != nil || !t.improve(ctx) {
+			return
+		}
+	}
+}
+
+// teamMove gives give from the longest route to rider to, and takes take
+// back in exchange, if anything.
+type teamMove struct {
+	to       int
+	give     []int
+	take     []int
+	estimate float64
+}
+
+// improve makes one move that shortens the longest route without leaving
+// the other rider involved any longer than it was, and reports whether it
+// found one.
+func (t *team) improve(ctx context.Context) bool {
+	long := t.longest()
+
+	var mine []int
+	for _, s := range t.shares[long] {
+		if g := t.groupOf[s]; !slices.Contains(mine, g) {
+			mine = append(mine, g)
+		}
+	}
+
+	var moves []teamMove
+
+	consider := func(to int, give, take []int) {
+		l := t.length(long, t.insert(long, without(t.shares[long], give), take))
+		o := t.length(to, t.insert(to, without(t.shares[to], take), give))
+
+		if e := math.Max(l, o); e < t.lengths[long]-improvementEpsilon {
+			moves = append(moves, teamMove{to: to, give: give, take: take, estimate: e})
+		}
+	}
+
+	for to := range t.shares {
+		if to == long {
+			continue
+		}
+
+		var theirs []int
+		for _, s := range t.shares[to] {
+			if g := t.groupOf[s]; !slices.Contains(theirs, g) {
+				theirs = append(theirs, g)
+			}
+		}
+
+		for _, g := range mine {
+			consider(to, t.groups[g], nil)
+
+			for _, h := range theirs {
+				consider(to, t.groups[g], t.groups[h])
+			}
+		}
+	}
+
+	// Estimates come from cheapest insertion; the real test is ordering
+	// both routes again, so try the most promising few that way.
+	sort.SliceStable(moves, func(i, j int) bool { return moves[i].estimate < moves[j].estimate })
+
+	for _, m := range moves[:min(len(moves), 5)] {
+		l := t.reorder(ctx, long, t.insert(long, without(t.shares[long], m.give), m.take))
+		o := t.reorder(ctx, m.to, t.insert(m.to, without(t.shares[m.to], m.take), m.give))
+
+		ll, ol := t.length(long, l), t.length(m.to, o)
+
+		if math.Max(ll, ol) < t.lengths[long]-improvementEpsilon {
+			t.shares[long], t.lengths[long] = l, ll
+			t.shares[m.to], t.lengths[m.to] = o, ol
+
+			return true
+		}
+	}
+
+	return false
+}
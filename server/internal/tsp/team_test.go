@@ -0,0 +1,237 @@
+package tsp
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bruteForceTeam is the best makespan over every split of the stops between
+// two riders who both leave from start, each share ordered exactly.
+func bruteForceTeam(t *testing.T, r tspRoute) float64 {
+	t.Helper()
+
+	tm := r.newTeam()
+	n := len(r.stops)
+	best := math.Inf(1)
+
+	for mask := 0; mask < 1<<n; mask++ {
+		var a, b []int
+		for i := range n {
+			if mask&(1<<i) != 0 {
+				a = append(a, i)
+			} else {
+				b = append(b, i)
+			}
+		}
+
+		worst := math.Max(tm.length(0, tm.reorder(t.Context(), 0, a)), tm.length(1, tm.reorder(t.Context(), 1, b)))
+		best = math.Min(best, worst)
+	}
+
+	return best
+}
+
+// teamIds lists each rider's stops by id.
+func teamIds(routes []optimalRoute) [][]string {
+	out := make([][]string, len(routes))
+	for i, or := range routes {
+		out[i] = stopIds(or)
+	}
+
+	return out
+}
+
+func TestTeamSplitIsCloseToTheBestSplit(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 6; seed++ {
+		points := scatter(seed, 10)
+
+		r := tspRoute{start: points[0], stops: points[1:9], riders: 2}
+		if seed%2 == 0 {
+			r.end = &points[9]
+		}
+
+		tm := r.newTeam()
+		tm.split(t.Context())
+
+		best := bruteForceTeam(t, r)
+
+		assert.LessOrEqual(t, slices.Max(tm.lengths), best*1.05, "seed %d", seed)
+		assert.GreaterOrEqual(t, slices.Max(tm.lengths), best-1e-6, "seed %d", seed)
+	}
+}
+
+func TestTeamRoutesSplitsClustersBetweenRiders(t *testing.T) {
+	t.Parallel()
+
+	// Three stops a kilometre or so east of the start and three west; the
+	// team meets back at the start.
+	r := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("e1", 39.95, -75.148).
+		AddStop("w1", 39.95, -75.172).
+		AddStop("e2", 39.951, -75.147).
+		AddStop("w2", 39.951, -75.173).
+		AddStop("e3", 39.949, -75.146).
+		AddStop("w3", 39.949, -75.174).
+		AsLoop().
+		WithRiders(2).
+		Build()
+
+	got, err := r.TeamRoutes(t.Context())
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+
+	for i, or := range got {
+		assert.Equal(t, i+1, or.Rider)
+		assert.Equal(t, "s", or.End.Id, "everyone meets back at the start")
+		assert.Len(t, or.Stops, 3)
+
+		side := or.Stops[0].Id[:1]
+		for _, s := range or.Stops {
+			assert.Equal(t, side, s.Id[:1], "one rider per side: %v", teamIds(got))
+		}
+	}
+
+	assert.InDelta(t, got[0].Meters, got[1].Meters, 200, "the sides are mirror images")
+}
+
+func TestTeamRoutesVisitsEveryStopOnce(t *testing.T) {
+	t.Parallel()
+
+	b := NewTspRouteBuilder().WithStart("s", 39.95, -75.16).WithEnd("f", 39.96, -75.15).WithRiders(3)
+	for i, p := range scatter(3, 40) {
+		b = b.AddStop(fmt.Sprintf("p%d", i), 39.95+p.lat/1e6, -75.16+p.long/1e6)
+	}
+
+	began := time.Now()
+	got, err := b.Build().TeamRoutes(t.Context())
+	require.NoError(t, err)
+	assert.Less(t, time.Since(began), 5*time.Second)
+
+	require.Len(t, got, 3)
+
+	var all []string
+	for _, or := range got {
+		assert.Equal(t, "f", or.End.Id)
+		all = append(all, stopIds(or)...)
+	}
+
+	assert.Len(t, all, 40)
+	assert.Len(t, slices.Compact(slices.Sorted(slices.Values(all))), 40)
+}
+
+func TestTeamRoutesKeepsDeliveriesWithOneRider(t *testing.T) {
+	t.Parallel()
+
+	r := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("pickup", 39.95, -75.148).
+		AddStop("w1", 39.95, -75.172).
+		AddStop("e1", 39.951, -75.147).
+		AddStop("dropoff", 39.951, -75.173).
+		WithPrecedence("pickup", "dropoff").
+		AsLoop().
+		WithRiders(2).
+		Build()
+
+	got, err := r.TeamRoutes(t.Context())
+	require.NoError(t, err)
+
+	for _, or := range got {
+		ids := stopIds(or)
+		p, d := slices.Index(ids, "pickup"), slices.Index(ids, "dropoff")
+
+		assert.Equal(t, p < 0, d < 0, "a pickup and its dropoff ride together: %v", teamIds(got))
+
+		if p >= 0 {
+			assert.Less(t, p, d)
+		}
+	}
+}
+
+func TestTeamRoutesUsesEachRidersStart(t *testing.T) {
+	t.Parallel()
+
+	// One rider waits at each end of a line of stops.
+	r := NewTspRouteBuilder().
+		WithStart("hq", 39.95, -75.16).
+		AddStop("a", 39.95, -75.15).
+		AddStop("b", 39.95, -75.14).
+		AddStop("c", 39.95, -75.12).
+		AddStop("d", 39.95, -75.11).
+		WithRiderStart("west", 39.95, -75.16).
+		WithRiderStart("east", 39.95, -75.10).
+		WithEnd("finish", 39.95, -75.13).
+		Build()
+
+	got, err := r.TeamRoutes(t.Context())
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+
+	assert.Equal(t, []string{"a", "b"}, stopIds(got[0]))
+	assert.Equal(t, []string{"d", "c"}, stopIds(got[1]))
+	assert.Equal(t, "finish", got[0].End.Id)
+	assert.Equal(t, "finish", got[1].End.Id)
+}
+
+func TestTeamRoutesCanLeaveARiderIdle(t *testing.T) {
+	t.Parallel()
+
+	r := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		WithEnd("f", 39.97, -75.16).
+		WithRiders(2).
+		Build()
+
+	got, err := r.TeamRoutes(t.Context())
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+
+	assert.ElementsMatch(t, [][]string{{"a"}, {}}, teamIds(got))
+
+	for _, or := range got {
+		assert.Equal(t, "f", or.End.Id)
+		assert.InDelta(t, 2220, or.Meters, 20, "stop or not, a is on the way")
+	}
+}
+
+func TestTeamRoutesRejectsWhatItCannotSplit(t *testing.T) {
+	t.Parallel()
+
+	base := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		AddStop("b", 39.97, -75.16).
+		WithRiders(2)
+
+	tests := []struct {
+		name    string
+		builder TspRouteBuilder
+		want    error
+	}{
+		{"too many riders", base.WithRiders(maxRiders + 1), ErrTooManyRiders},
+		{"opening hours", base.WithStartTime(raceStart).WithTimeWindow("a", raceStart, time.Time{}), ErrTeamUnsupported},
+		{"a budget", base.WithDistanceBudget(1000), ErrTeamUnsupported},
+		{"a rider start reuses an id", base.WithRiderStart("a", 39.98, -75.16), ErrDuplicateId},
+		{"a rider start off the map", base.WithRiderStart("r", math.NaN(), -75.16), ErrInvalidCoordinates},
+		{"no stops", NewTspRouteBuilder().WithStart("s", 39.95, -75.16).WithRiders(2), ErrTooFewStops},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tt.builder.Build().TeamRoutes(t.Context())
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
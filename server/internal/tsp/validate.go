@@ -0,0 +1,87 @@
+package tsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The solver used to trust its input and panic on anything it had not
+// planned for, which took the whole request down with it. Everything a
+// caller can get wrong is now checked up front and comes back as one of
+// these errors, so a handler can tell a bad route from a broken server.
+
+var (
+	// ErrTooFewStops is returned for a route with nothing to visit.
+	ErrTooFewStops = errors.New("too few stops")
+
+	// ErrTooManyStops is returned past maxStops, where even the heuristic
+	// would keep a request busy for longer than anyone waits.
+	ErrTooManyStops = errors.New("too many stops")
+
+	// ErrDuplicateId is returned when two places share an id, since every
+	// result and rule refers to places by id.
+	ErrDuplicateId = errors.New("duplicate place id")
+
+	// ErrInvalidCoordinates is returned for a place whose latitude or
+	// longitude is not a finite number.
+	ErrInvalidCoordinates = errors.New("invalid coordinates")
+
+	// ErrNoRoute is returned when a route passed validation and still no
+	// order came out of the solver. It is a bug, not bad input.
+	ErrNoRoute = errors.New("no route found")
+)
+
+// maxStops is the most stops Solve takes on.
+const maxStops = 250
+
+// checkEvery is how many DP masks go by between looks at the context: often
+// enough to stop within milliseconds, rare enough not to slow the loop.
+const checkEvery = 1 << 10
+
+// cancelled reports, every checkEvery masks, whether ctx is done.
+func cancelled(ctx context.Context, mask int) bool {
+	return mask%checkEvery == 0 && ctx.Err() != nil
+}
+
+// validate reports the first thing about the route that keeps it from being
+// solved, wrapping one of the package's errors.
+func (r tspRoute) validate() error {
+	if len(r.stops) == 0 {
+		return fmt.Errorf("%w: a route needs at least one stop", ErrTooFewStops)
+	}
+
+	if len(r.stops) > maxStops {
+		return fmt.Errorf("%w: at most %d, got %d", ErrTooManyStops, maxStops, len(r.stops))
+	}
+
+	all := append([]place{r.start}, r.stops...)
+
+	// A loop's end is the start again, which is not a duplicate.
+	if r.end != nil && !r.loop {
+		all = append(all, *r.end)
+	}
+
+	seen := make(map[string]bool, len(all))
+
+	for _, p := range all {
+		if seen[p.Id] {
+			return fmt.Errorf("%w: %q", ErrDuplicateId, p.Id)
+		}
+
+		seen[p.Id] = true
+
+		lat, long := r.degrees(p)
+
+		if !finite(lat) || !finite(long) {
+			return fmt.Errorf("%w: place %q", ErrInvalidCoordinates, p.Id)
+		}
+	}
+
+	return r.ValidatePrecedence()
+}
+
+func finite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
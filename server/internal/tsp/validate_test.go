@@ -0,0 +1,184 @@
+package tsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSolveRejectsRoutesItCannotSolve(t *testing.T) {
+	t.Parallel()
+
+	base := NewTspRouteBuilder().
+		WithStart("s", 39.95, -75.16).
+		AddStop("a", 39.96, -75.16).
+		AddStop("b", 39.97, -75.16)
+
+	tooMany := base
+	for i := range maxStops {
+		tooMany = tooMany.AddStop(fmt.Sprintf("p%d", i), 39.95, -75.16)
+	}
+
+	tests := []struct {
+		name    string
+		builder TspRouteBuilder
+		want    error
+		wantMsg string
+	}{
+		{
+			name:    "no stops",
+			builder: NewTspRouteBuilder().WithStart("s", 39.95, -75.16).WithEnd("e", 39.96, -75.16),
+			want:    ErrTooFewStops,
+		},
+		{
+			name:    "too many stops",
+			builder: tooMany,
+			want:    ErrTooManyStops,
+			wantMsg: fmt.Sprintf("at most %d, got %d", maxStops, maxStops+2),
+		},
+		{
+			name:    "two stops share an id",
+			builder: base.AddStop("a", 39.98, -75.16),
+			want:    ErrDuplicateId,
+			wantMsg: `"a"`,
+		},
+		{
+			name:    "the end reuses the start's id",
+			builder: base.WithEnd("s", 39.99, -75.16),
+			want:    ErrDuplicateId,
+			wantMsg: `"s"`,
+		},
+		{
+			name:    "NaN latitude",
+			builder: base.AddStop("c", math.NaN(), -75.16),
+			want:    ErrInvalidCoordinates,
+			wantMsg: `place "c"`,
+		},
+		{
+			name:    "infinite longitude on a geodesic route",
+			builder: base.AddStop("c", 39.98, math.Inf(1)).WithMetric(Haversine),
+			want:    ErrInvalidCoordinates,
+		},
+		{
+			name:    "impossible precedence",
+			builder: base.WithPrecedence("a", "b").WithPrecedence("b", "a"),
+			want:    ErrInvalidPrecedence,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Solve(t.Context(), tt.builder.Build())
+
+			assert.True(t, errors.Is(err, tt.want), "got %v", err)
+			assert.ErrorContains(t, err, tt.wantMsg)
+		})
+	}
+}
+
+func TestSolveAcceptsTheSmallestRoutes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("one stop, open finish", func(t *testing.T) {
+		t.Parallel()
+
+		got, err := Solve(t.Context(), NewTspRouteBuilder().WithStart("s", 39.95, -75.16).AddStop("a", 39.96, -75.16).Build())
+		require.NoError(t, err)
+
+		assert.Equal(t, "a", got.End.Id)
+		assert.Empty(t, got.Stops)
+	})
+
+	t.Run("one stop, fixed end", func(t *testing.T) {
+		t.Parallel()
+
+		got, err := Solve(t.Context(), NewTspRouteBuilder().
+			WithStart("s", 39.95, -75.16).
+			AddStop("a", 39.96, -75.16).
+			WithEnd("e", 39.97, -75.16).
+			Build())
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a"}, stopIds(got))
+		assert.Equal(t, "e", got.End.Id)
+	})
+}
+
+func TestSolveTSPExactWithNothingInBetween(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []int{0, 1}, solveTSPExact(t.Context(), distanceMatrix(scatter(1, 2)), 0, 1, nil))
+}
+
+func TestSolveStopsWhenTheContextIsDone(t *testing.T) {
+	t.Parallel()
+
+	b := NewTspRouteBuilder().WithStart("s", 39.95, -75.16)
+	for i, p := range scatter(8, 16) {
+		b = b.AddStop(fmt.Sprintf("p%d", i), 39.95+p.lat/1e6, -75.16+p.long/1e6)
+	}
+
+	r := b.Build()
+
+	t.Run("cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, err := Solve(ctx, r)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		// Alternatives are only ranked over fewer stops.
+		ranked := NewTspRouteBuilder().WithStart("s", 39.95, -75.16)
+		for i, p := range scatter(8, maxRankedStops) {
+			ranked = ranked.AddStop(fmt.Sprintf("p%d", i), 39.95+p.lat/1e6, -75.16+p.long/1e6)
+		}
+
+		_, err = ranked.Build().RankedRoutes(ctx, 3)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = r.PrizeRoutes(ctx, 0)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("deadline mid-solve", func(t *testing.T) {
+		t.Parallel()
+
+		// Sixteen stops with an open finish is sixteen Held-Karp runs, far
+		// more than a millisecond's work.
+		ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond)
+		defer cancel()
+
+		began := time.Now()
+		_, err := Solve(ctx, r)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(began), time.Second)
+	})
+
+	t.Run("deadline with a tour in hand", func(t *testing.T) {
+		t.Parallel()
+
+		// Past the exact limit the heuristic has a tour from the start, so
+		// running out of time costs polish rather than the answer.
+		ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+
+		got, err := Solve(ctx, b.WithExactStopLimit(3).Build())
+		require.NoError(t, err)
+
+		assert.Equal(t, AlgorithmHeuristic, got.Algorithm)
+		assert.Len(t, got.Stops, 15, "sixteen stops, one of them the finish")
+		assert.Positive(t, got.Meters)
+	})
+}
@@ -0,0 +1,294 @@
+package tsp
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Checkpoints that open late or close early turn the problem into a TSP with
+// time windows. Riding faster never hurts and waiting outside a checkpoint is
+// always allowed, so the earliest time a rider can be standing at a stop,
+// having visited a given set, is all the Held-Karp state needs to carry: an
+// earlier arrival can do anything a later one can.
+
+const (
+	// defaultSpeed is a steady alleycat pace, in metres per second (18 km/h),
+	// counting lights but not checkpoint stops.
+	defaultSpeed = 5.0
+
+	// maxTimedExactStops caps the time-window DP below the plain exact
+	// limit: its labels are 24 bytes a state against Held-Karp's 5, so at
+	// 20 stops the table alone would be half a gigabyte.
+	maxTimedExactStops = 18
+
+	// timedStateBytes is what one state of that table takes: a timedLabel
+	// and an int parent.
+	timedStateBytes = 24
+)
+
+// timeWindow bounds when a checkpoint can be signed, in seconds after the race
+// starts. closes is +Inf for a checkpoint that never closes.
+type timeWindow struct {
+	opens  float64
+	closes float64
+}
+
+var openAllRace = timeWindow{opens: 0, closes: math.Inf(1)}
+
+// Arrival is when the rider reaches one place on a route.
+type Arrival struct {
+	Id string
+	At time.Time
+
+	// Wait is how long the rider stands outside before the place opens.
+	Wait time.Duration
+
+	// Late is set when the rider gets there after the place closed, which
+	// only happens on a route flagged Infeasible.
+	Late bool
+}
+
+// timedLabel is what Held-Karp keeps per state: the earliest the rider can be
+// ready to leave, with distance ridden to break ties between equally early
+// arrivals.
+type timedLabel struct {
+	ready  float64
+	meters float64
+}
+
+func (l timedLabel) betterThan(o timedLabel) bool {
+	if l.ready < o.ready-improvementEpsilon {
+		return true
+	}
+
+	return l.ready <= o.ready+improvementEpsilon && l.meters < o.meters-improvementEpsilon
+}
+
+// solveTimeWindowsExact finds the order that reaches the end soonest without
+// arriving anywhere after it closes or breaking p. travel holds seconds, dist
+// holds metres. With end < 0 the path may finish at any node. Reports false
+// when no order meets every window, or when ctx is done first.
+func solveTimeWindowsExact(ctx context.Context, travel, dist [][]float64, windows []timeWindow, start, end int, p precedence) ([]int, bool) {
+	n := len(travel)
+
+	intermediate := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if i != start && i != end {
+			intermediate = append(intermediate, i)
+		}
+	}
+
+	m := len(intermediate)
+	unreached := timedLabel{ready: math.Inf(1), meters: math.Inf(1)}
+
+	// arrive moves the rider from a label along one leg, or reports that the
+	// leg lands after the destination has closed.
+	arrive := func(from timedLabel, a, b int) (timedLabel, bool) {
+		at := from.ready + travel[a][b]
+
+		if at > windows[b].closes {
+			return unreached, false
+		}
+
+		return timedLabel{ready: math.Max(at, windows[b].opens), meters: from.meters + dist[a][b]}, true
+	}
+
+	if m == 0 {
+		if end < 0 {
+			return []int{start}, true
+		}
+
+		_, ok := arrive(timedLabel{}, start, end)
+		return []int{start, end}, ok
+	}
+
+	release, err := reserveTable(ctx, n, end, timedStateBytes)
+	if err != nil {
+		return nil, false
+	}
+	defer release()
+
+	must := p.masks(intermediate, start)
+
+	size := 1 << m
+	labels := make([]timedLabel, size*m)
+	parent := make([]int, size*m)
+
+	for i := range labels {
+		labels[i] = unreached
+		parent[i] = -1
+	}
+
+	for i, node := range intermediate {
+		if must[i] != 0 {
+			continue
+		}
+
+		if l, ok := arrive(timedLabel{}, start, node); ok {
+			labels[(1<<i)*m+i] = l
+		}
+	}
+
+	for mask := 1; mask < size; mask++ {
+		if cancelled(ctx, mask) {
+			return nil, false
+		}
+
+		for last := 0; last < m; last++ {
+			from := labels[mask*m+last]
+
+			if mask&(1<<last) == 0 || math.IsInf(from.ready, 1) {
+				continue
+			}
+
+			for next := 0; next < m; next++ {
+				if mask&(1<<next) != 0 || mask&must[next] != must[next] {
+					continue
+				}
+
+				l, ok := arrive(from, intermediate[last], intermediate[next])
+				if !ok {
+					continue
+				}
+
+				at := (mask|1<<next)*m + next
+				if l.betterThan(labels[at]) {
+					labels[at] = l
+					parent[at] = last
+				}
+			}
+		}
+	}
+
+	full := size - 1
+	best := unreached
+	bestLast := -1
+
+	for last := 0; last < m; last++ {
+		l := labels[full*m+last]
+
+		if end >= 0 && !math.IsInf(l.ready, 1) {
+			l, _ = arrive(l, intermediate[last], end)
+		}
+
+		if l.betterThan(best) {
+			best = l
+			bestLast = last
+		}
+	}
+
+	if bestLast == -1 {
+		return nil, false
+	}
+
+	reversed := make([]int, 0, n)
+	if end >= 0 {
+		reversed = append(reversed, end)
+	}
+
+	for mask, curr := full, bestLast; curr != -1; {
+		reversed = append(reversed, intermediate[curr])
+		prev := parent[mask*m+curr]
+		mask ^= 1 << curr
+		curr = prev
+	}
+
+	reversed = append(reversed, start)
+	reverse(reversed)
+
+	return reversed, true
+}
+
+// windowPenalty walks path from the race start and returns how many seconds
+// it arrives late in total, and when it finishes.
+func windowPenalty(travel [][]float64, windows []timeWindow, path []int) (late, finish float64) {
+	var t float64
+
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		t += travel[a][b]
+
+		if t > windows[b].closes {
+			late += t - windows[b].closes
+		}
+
+		t = math.Max(t, windows[b].opens)
+	}
+
+	return late, t
+}
+
+// improveTimedOrder is the heuristic's answer to time windows: starting from
+// a short path, it keeps relocating short runs of stops and reversing
+// segments while that cuts lateness, or finishes sooner without adding any.
+// The first node always stays put, and so does the last when fixedEnd is set.
+// Moves that break p are never kept, and no more are tried once ctx is done.
+func improveTimedOrder(ctx context.Context, travel [][]float64, windows []timeWindow, path []int, fixedEnd bool, p precedence) {
+	last := len(path)
+	if fixedEnd {
+		last--
+	}
+
+	bestLate, bestFinish := windowPenalty(travel, windows, path)
+	candidate := make([]int, len(path))
+
+	better := func() bool {
+		if !p.allows(candidate) {
+			return false
+		}
+
+		late, finish := windowPenalty(travel, windows, candidate)
+
+		if late < bestLate-improvementEpsilon ||
+			(late <= bestLate+improvementEpsilon && finish < bestFinish-improvementEpsilon) {
+			bestLate, bestFinish = late, finish
+			copy(path, candidate)
+			return true
+		}
+
+		return false
+	}
+
+	for improved := true; improved && ctx.Err() == nil; {
+		improved = false
+
+		for size := 1; size <= maxOrOptSegment; size++ {
+			for i := 1; i+size <= last; i++ {
+				for to := 1; to+size <= last; to++ {
+					if to == i {
+						continue
+					}
+
+					copy(candidate, path)
+					relocate(candidate[:last], i, size, to)
+
+					if better() {
+						improved = true
+					}
+				}
+			}
+		}
+
+		for i := 1; i < last-1; i++ {
+			for j := i + 1; j < last; j++ {
+				copy(candidate, path)
+				reverse(candidate[i : j+1])
+
+				if better() {
+					improved = true
+				}
+			}
+		}
+	}
+}
+
+// relocate moves path[i:i+size] so that it starts at index to.
+func relocate(path []int, i, size, to int) {
+	segment := append([]int(nil), path[i:i+size]...)
+	rest := append(append([]int(nil), path[:i]...), path[i+size:]...)
+
+	copy(path, rest[:to])
+	copy(path[to:], segment)
+	copy(path[to+size:], rest[to:])
+}
@@ -0,0 +1,235 @@
+package tsp
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var raceStart = time.Date(2026, 5, 2, 18, 0, 0, 0, time.UTC)
+
+// lineRoute puts s, a, b and e 1km apart on a straight road, ridden at 10m/s
+// so that every kilometre takes 100 seconds.
+func lineRoute() TspRouteBuilder {
+	ids := []string{"s", "a", "b", "e"}
+	meters := make([][]float64, len(ids))
+
+	for i := range meters {
+		meters[i] = make([]float64, len(ids))
+		for j := range meters[i] {
+			meters[i][j] = math.Abs(float64(i-j)) * 1000
+		}
+	}
+
+	return NewTspRouteBuilder().
+		WithStart("s", 39.950, -75.16).
+		AddStop("a", 39.951, -75.16).
+		AddStop("b", 39.952, -75.16).
+		WithEnd("e", 39.953, -75.16).
+		WithDistanceMatrix(ids, meters).
+		WithStartTime(raceStart).
+		WithSpeed(10)
+}
+
+func stopIds(r optimalRoute) []string {
+	ids := make([]string, len(r.Stops))
+	for i, s := range r.Stops {
+		ids[i] = s.Id
+	}
+
+	return ids
+}
+
+func TestSolveTimeWindowsExactWithoutWindowsMatchesShortestPath(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(0); seed < 5; seed++ {
+		points := scatter(seed, 8)
+		dist := distanceMatrix(points)
+		end := len(points) - 1
+
+		windows := make([]timeWindow, len(points))
+		for i := range windows {
+			windows[i] = openAllRace
+		}
+
+		got, ok := solveTimeWindowsExact(t.Context(), dist, dist, windows, 0, end, nil)
+
+		require.True(t, ok)
+		assert.InDelta(t, bruteForceBest(dist, 0, end), pathDistance(dist, got), 1e-6, "seed %d", seed)
+	}
+}
+
+func TestSolveTimeWindowsExactReportsInfeasible(t *testing.T) {
+	t.Parallel()
+
+	travel := [][]float64{
+		{0, 10, 10},
+		{10, 0, 10},
+		{10, 10, 0},
+	}
+	windows := []timeWindow{openAllRace, {opens: 0, closes: 5}, openAllRace}
+
+	_, ok := solveTimeWindowsExact(t.Context(), travel, travel, windows, 0, 2, nil)
+
+	assert.False(t, ok, "nothing reaches a place that closes before anyone can get there")
+}
+
+func TestSolveTimeWindowsExactOpenFinish(t *testing.T) {
+	t.Parallel()
+
+	// From s, a is nearer, but b closes before a round trip through a
+	// could reach it.
+	travel := [][]float64{
+		{0, 10, 20},
+		{10, 0, 30},
+		{20, 30, 0},
+	}
+	windows := []timeWindow{openAllRace, openAllRace, {opens: 0, closes: 25}}
+
+	got, ok := solveTimeWindowsExact(t.Context(), travel, travel, windows, 0, -1, nil)
+
+	require.True(t, ok)
+	assert.Equal(t, []int{0, 2, 1}, got)
+}
+
+func TestRelocate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		i, size, to int
+		want        []int
+	}{
+		{name: "forward", i: 1, size: 2, to: 3, want: []int{0, 3, 4, 1, 2, 5}},
+		{name: "backward", i: 3, size: 1, to: 1, want: []int{0, 3, 1, 2, 4, 5}},
+		{name: "to the end", i: 1, size: 1, to: 5, want: []int{0, 2, 3, 4, 5, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := []int{0, 1, 2, 3, 4, 5}
+			relocate(path, tt.i, tt.size, tt.to)
+
+			assert.Equal(t, tt.want, path)
+		})
+	}
+}
+
+func TestSolveReportsArrivalsWithAStartTime(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().Build().mustSolve(t)
+
+	assert.Equal(t, []string{"a", "b"}, stopIds(got))
+	require.Len(t, got.Arrivals, 3, "one per stop and the end")
+
+	for i, want := range []struct {
+		id string
+		at time.Duration
+	}{{"a", 100 * time.Second}, {"b", 200 * time.Second}, {"e", 300 * time.Second}} {
+		assert.Equal(t, want.id, got.Arrivals[i].Id)
+		assert.Equal(t, raceStart.Add(want.at), got.Arrivals[i].At)
+		assert.False(t, got.Arrivals[i].Late)
+	}
+}
+
+func TestSolveWithoutStartTimeHasNoArrivals(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().
+		WithStartTime(time.Time{}).
+		WithTimeWindow("b", time.Time{}, raceStart.Add(time.Second)).
+		Build().
+		mustSolve(t)
+
+	assert.Nil(t, got.Arrivals)
+	assert.False(t, got.Infeasible, "windows mean nothing without a clock")
+	assert.Equal(t, []string{"a", "b"}, stopIds(got))
+}
+
+func TestSolveHonoursAnEarlyClose(t *testing.T) {
+	t.Parallel()
+
+	// Riding past b to wait for a to open would reach b after it closes.
+	got := lineRoute().
+		WithTimeWindow("a", raceStart.Add(600*time.Second), time.Time{}).
+		WithTimeWindow("b", time.Time{}, raceStart.Add(400*time.Second)).
+		Build().
+		mustSolve(t)
+
+	assert.False(t, got.Infeasible)
+	assert.Equal(t, []string{"b", "a"}, stopIds(got), "b first, even though it doubles back")
+	assert.Equal(t, 5000.0, got.Meters)
+	assert.Equal(t, AlgorithmExact, got.Algorithm)
+}
+
+func TestSolveWaitsForALateOpening(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().
+		WithTimeWindow("a", raceStart.Add(10*time.Minute), time.Time{}).
+		Build().
+		mustSolve(t)
+
+	require.Len(t, got.Arrivals, 3)
+	assert.Equal(t, []string{"a", "b"}, stopIds(got))
+
+	a := got.Arrivals[0]
+	assert.Equal(t, raceStart.Add(100*time.Second), a.At)
+	assert.Equal(t, 500*time.Second, a.Wait)
+
+	// Waiting at a is cheaper than riding to b and back.
+	assert.Equal(t, raceStart.Add(800*time.Second), got.Arrivals[2].At)
+}
+
+func TestSolveFlagsInfeasibleWindows(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().
+		WithTimeWindow("b", time.Time{}, raceStart.Add(150*time.Second)).
+		Build().
+		mustSolve(t)
+
+	// Every order reaches b 50 seconds late, so the best attempt is the one
+	// that finishes first.
+	assert.True(t, got.Infeasible)
+	assert.Equal(t, []string{"a", "b"}, stopIds(got))
+
+	late := map[string]bool{}
+	for _, a := range got.Arrivals {
+		late[a.Id] = a.Late
+	}
+
+	assert.Equal(t, map[string]bool{"b": true, "a": false, "e": false}, late)
+}
+
+func TestSolveHeuristicHonoursWindows(t *testing.T) {
+	t.Parallel()
+
+	got := lineRoute().
+		WithTimeWindow("a", raceStart.Add(600*time.Second), time.Time{}).
+		WithTimeWindow("b", time.Time{}, raceStart.Add(400*time.Second)).
+		WithExactStopLimit(1).
+		Build().
+		mustSolve(t)
+
+	assert.False(t, got.Infeasible)
+	assert.Equal(t, AlgorithmHeuristic, got.Algorithm)
+	assert.Equal(t, []string{"b", "a"}, stopIds(got))
+}
+
+func TestBuilderWithTimeWindowIsValueSemantic(t *testing.T) {
+	t.Parallel()
+
+	base := lineRoute().WithTimeWindow("a", raceStart, time.Time{})
+	withB := base.WithTimeWindow("b", raceStart, time.Time{})
+
+	assert.Len(t, base.Build().windows, 1)
+	assert.Len(t, withB.Build().windows, 2)
+}
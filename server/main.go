@@ -1,13 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	server "github.com/nguyen/allycat/internal/http_server"
 	"github.com/nguyen/allycat/internal/http_server/handlers"
 	"github.com/nguyen/allycat/internal/places"
+	"github.com/nguyen/allycat/internal/roads"
+	"github.com/nguyen/allycat/internal/tsp"
 )
 
 func main() {
@@ -24,12 +29,6 @@ func main() {
 		}
 	}
 
-	key, ok := os.LookupEnv("MAPS_API_KEY")
-
-	if !ok {
-		panic("MAPS_API_KEY required")
-	}
-
 	pw, ok := os.LookupEnv("API_PW")
 
 	if !ok {
@@ -54,23 +53,236 @@ func main() {
 
 	srv := server.NewServer()
 
-	api, err := places.NewPlacesApi(key)
+	// PROVIDER picks the geocoding and routing backend: Google, the
+	// default; a self-hosted OSRM and Nominatim; or the in-memory fake.
+	var api places.Provider
+	var adminOpts []handlers.AdminHandlerOption
 
-	if err != nil {
-		panic(err)
+	switch provider := os.Getenv("PROVIDER"); provider {
+	case "", "google":
+		key, ok := os.LookupEnv("MAPS_API_KEY")
+
+		if !ok {
+			panic("MAPS_API_KEY required")
+		}
+
+		api, adminOpts = newGoogle(key)
+	case "selfhosted":
+		api = newSelfHosted()
+	case "fake":
+		api = newFake()
+	default:
+		panic(fmt.Sprintf("PROVIDER must be google, selfhosted or fake, not %q", provider))
+	}
+
+	fmt.Println("answering with provider", api.Name())
+
+	var placesOpts []handlers.PlacesHandlerOption
+	var graph *roads.Graph
+
+	// An OpenStreetMap extract of the race city keeps road routing going
+	// when Google cannot answer.
+	if extract, ok := os.LookupEnv("OSM_EXTRACT"); ok && extract != "" {
+		g, err := roads.Load(extract)
+
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("loaded %d road nodes from %s\n", g.Nodes(), extract)
+
+		placesOpts = append(placesOpts, handlers.WithRoadGraph(g))
+		graph = g
+	}
+
+	// Cross-street checkpoints are found at the corner itself, from the
+	// extract when there is one.
+	api = places.NewIntersectionGeocoder(api, graph)
+
+	// EXACT_STOP_LIMIT trades memory for proven best orders: Held-Karp's
+	// table doubles with every stop past the default.
+	if raw, ok := os.LookupEnv("EXACT_STOP_LIMIT"); ok && raw != "" {
+		n, err := strconv.Atoi(raw)
+
+		if err != nil || n < 1 || n > tsp.MaxExactStopLimit {
+			panic(fmt.Sprintf("EXACT_STOP_LIMIT must be between 1 and %d, not %q", tsp.MaxExactStopLimit, raw))
+		}
+
+		placesOpts = append(placesOpts, handlers.WithExactStopLimit(n))
+	}
+
+	// Riders can tune their own bike profiles in a JSON file, next to the
+	// built-in ones.
+	if path, ok := os.LookupEnv("ROAD_PROFILES"); ok && path != "" {
+		f, err := os.Open(path)
+
+		if err != nil {
+			panic(err)
+		}
+
+		profiles, err := roads.ReadProfiles(f)
+		f.Close()
+
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("loaded %d road profiles from %s\n", len(profiles), path)
+
+		placesOpts = append(placesOpts, handlers.WithRoadProfiles(profiles...))
 	}
 
 	handlers := handlers.Handlers{
-		Places: handlers.NewPlacesHandler(api),
+		Places: handlers.NewPlacesHandler(api, placesOpts...),
+		Admin:  handlers.NewAdminHandler(adminOpts...),
 	}
 
 	srv.RegisterRoutes(handlers, pw)
 
+	// The admin endpoints only exist with a password of their own.
+	if adminPw, ok := os.LookupEnv("ADMIN_PW"); ok && adminPw != "" {
+		srv.RegisterAdminRoutes(handlers, adminPw)
+	}
+
 	fmt.Println("starting server on port", port)
 
-	err = srv.Start(":"+port, origin)
+	err := srv.Start(":"+port, origin)
 
 	if err != nil {
 		fmt.Printf("Error starting server: %v\n", err)
 	}
 }
+
+func parseDollars(name, raw string) float64 {
+	usd, err := strconv.ParseFloat(raw, 64)
+
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+
+	return usd
+}
+
+// newGoogle builds the Google client, with the caches and the meter that
+// only it needs, and the admin options that report on them.
+func newGoogle(key string) (*places.PlacesApi, []handlers.AdminHandlerOption) {
+	// Race sheets reuse the same addresses, so searches are cached. With
+	// SEARCH_CACHE_FILE the cache survives restarts too.
+	var cacheOpts []places.TextSearchCacheOption
+
+	if path, ok := os.LookupEnv("SEARCH_CACHE_FILE"); ok && path != "" {
+		cacheOpts = append(cacheOpts, places.WithSearchCacheFile(path))
+	}
+
+	searchCache, err := places.NewTextSearchCache(cacheOpts...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// Legs between the same checkpoints come up route after route, so they
+	// are cached too, for LEG_CACHE_TTL (a Go duration, like "72h") when set.
+	var legOpts []places.LegCacheOption
+
+	if path, ok := os.LookupEnv("LEG_CACHE_FILE"); ok && path != "" {
+		legOpts = append(legOpts, places.WithLegCacheFile(path))
+	}
+
+	if raw, ok := os.LookupEnv("LEG_CACHE_TTL"); ok && raw != "" {
+		ttl, err := time.ParseDuration(raw)
+
+		if err != nil {
+			panic(fmt.Sprintf("LEG_CACHE_TTL: %v", err))
+		}
+
+		legOpts = append(legOpts, places.WithLegCacheTTL(ttl))
+	}
+
+	legCache, err := places.NewLegCache(legOpts...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// Every call to Google is metered. GOOGLE_DAILY_BUDGET and
+	// GOOGLE_MONTHLY_BUDGET, in dollars, stop calling it once spent, and
+	// with USAGE_FILE a restart does not forget what has been.
+	var meterOpts []places.MeterOption
+
+	if raw, ok := os.LookupEnv("GOOGLE_DAILY_BUDGET"); ok && raw != "" {
+		meterOpts = append(meterOpts, places.WithDailyBudget(parseDollars("GOOGLE_DAILY_BUDGET", raw)))
+	}
+
+	if raw, ok := os.LookupEnv("GOOGLE_MONTHLY_BUDGET"); ok && raw != "" {
+		meterOpts = append(meterOpts, places.WithMonthlyBudget(parseDollars("GOOGLE_MONTHLY_BUDGET", raw)))
+	}
+
+	if path, ok := os.LookupEnv("USAGE_FILE"); ok && path != "" {
+		meterOpts = append(meterOpts, places.WithUsageFile(path))
+	}
+
+	meter, err := places.NewMeter(meterOpts...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	api, err := places.NewPlacesApi(key,
+		places.WithTextSearchCache(searchCache),
+		places.WithLegCache(legCache),
+		places.WithMeter(meter),
+	)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return api, []handlers.AdminHandlerOption{
+		handlers.WithAdminSearchCache(searchCache),
+		handlers.WithAdminLegCache(legCache),
+		handlers.WithAdminMeter(meter),
+	}
+}
+
+// newSelfHosted points at an OSRM routing by bike at OSRM_URL, optionally a
+// second routing by car at OSRM_CAR_URL, and a Nominatim at NOMINATIM_URL.
+func newSelfHosted() *places.SelfHostedApi {
+	var opts []places.SelfHostedOption
+
+	if url, ok := os.LookupEnv("OSRM_CAR_URL"); ok && url != "" {
+		opts = append(opts, places.WithOSRMCarURL(url))
+	}
+
+	api, err := places.NewSelfHostedApi(os.Getenv("OSRM_URL"), os.Getenv("NOMINATIM_URL"), opts...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return api
+}
+
+// newFake knows the places in the JSON file at FAKE_PLACES, if any.
+func newFake() *places.Fake {
+	path, ok := os.LookupEnv("FAKE_PLACES")
+
+	if !ok || path == "" {
+		return places.NewFake()
+	}
+
+	raw, err := os.ReadFile(path)
+
+	if err != nil {
+		panic(err)
+	}
+
+	var known []places.FakePlace
+
+	if err := json.Unmarshal(raw, &known); err != nil {
+		panic(fmt.Sprintf("FAKE_PLACES: %v", err))
+	}
+
+	fmt.Printf("loaded %d fake places from %s\n", len(known), path)
+
+	return places.NewFake(known...)
+}